@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// checkpointMessage is a persisted message of the conversation, of any role.
+type checkpointMessage struct {
+	Role string `json:"role"`
+	// Content is text, or text parts as sent for messages created as parameters.
+	Content    json.RawMessage `json:"content"`
+	ToolCallID string          `json:"tool_call_id"`
+	ToolCalls  []struct {
+		ID       string `json:"id"`
+		Function struct {
+			Name      string `json:"name"`
+			Arguments string `json:"arguments"`
+		} `json:"function"`
+	} `json:"tool_calls"`
+}
+
+// text returns the text content of the message.
+func (m checkpointMessage) text() (string, error) {
+	if len(m.Content) == 0 || string(m.Content) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("unsupported content of %s message: %w", m.Role, err)
+	}
+	var sb strings.Builder
+	for _, p := range parts {
+		sb.WriteString(p.Text)
+	}
+	return sb.String(), nil
+}
+
+// saveCheckpoint persists the messages of the conversation and the workflow state of the session, so the session can
+// be resumed from this turn if the process dies. Failures are logged, the session continues regardless.
+func saveCheckpoint(ctx context.Context, checkpoints *vector.CheckpointService, sid string, wf *workflow.Workflow, messages []openai.ChatCompletionMessageParamUnion) {
+	if checkpoints == nil {
+		return
+	}
+	encoded, err := json.Marshal(messages)
+	if err != nil {
+		log.Err(err).Msg("Failed to encode messages of checkpoint")
+		return
+	}
+	state, err := wf.Checkpoint()
+	if err != nil {
+		log.Err(err).Msg("Failed to encode workflow state of checkpoint")
+		return
+	}
+	err = checkpoints.Save(context.WithoutCancel(ctx), vector.Checkpoint{
+		SessionID: sid,
+		Messages:  encoded,
+		Workflow:  state,
+		UpdatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to save checkpoint")
+	}
+}
+
+// checkpointMessages returns the messages of the conversation from the last checkpoint of the session, or nil when it
+// has none.
+func checkpointMessages(ctx context.Context, ts *tooling.Service, sid string) []openai.ChatCompletionMessageParamUnion {
+	cp, err := ts.Checkpoints.Load(ctx, sid)
+	if err != nil {
+		log.Err(err).Msg("Failed to load checkpoint of the resumed session")
+		return nil
+	}
+	if cp == nil {
+		return nil
+	}
+	messages, err := decodeMessages(cp.Messages)
+	if err != nil {
+		log.Err(err).Msg("Failed to restore conversation from checkpoint")
+		return nil
+	}
+	return messages
+}
+
+// memoryMessages reconstructs the conversation of the session from its memory, for sessions without a checkpoint, like
+// sessions of older versions or whose checkpoints failed to save. Memories of tool calls lack the calls they responded
+// to, so only the messages of the user and the model are restored, in the order they occurred, after a system message
+// to be replaced and a summary of the workflow state and the generated artifacts standing in for the tool calls. It
+// returns nil when the session has no messages.
+func memoryMessages(ctx context.Context, ts *tooling.Service, wf *workflow.Workflow) []openai.ChatCompletionMessageParamUnion {
+	mem, err := ts.Mem.Messages(ctx)
+	if err != nil {
+		log.Err(err).Msg("Failed to load memory of the resumed session")
+		return nil
+	}
+	var messages []openai.ChatCompletionMessageParamUnion
+	for _, m := range mem {
+		switch {
+		case m.Role == vector.RoleUser:
+			messages = append(messages, openai.UserMessage(m.Content))
+		case m.Role == vector.RoleAssistant && m.Content != "":
+			messages = append(messages, openai.AssistantMessage(m.Content))
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("The conversation below was restored from the memory of the session, without the tool calls. The state it left is:\n\n")
+	sb.WriteString("Workflow:\n" + wf.Prompt())
+	artifacts, err := tooling.GeneratedArtifacts()
+	if err != nil {
+		log.Err(err).Msg("Failed to read generated artifacts of the resumed session")
+	}
+	if artifacts != "" {
+		sb.WriteString("\nGenerated artifacts:\n" + artifacts)
+	}
+	return append([]openai.ChatCompletionMessageParamUnion{openai.SystemMessage(""), openai.SystemMessage(sb.String())}, messages...)
+}
+
+// decodeMessages restores the messages of a checkpoint. An assistant message at the end whose tool calls have no
+// responses is dropped, as the model can't continue from it.
+func decodeMessages(encoded []byte) ([]openai.ChatCompletionMessageParamUnion, error) {
+	var stored []checkpointMessage
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		return nil, fmt.Errorf("failed to parse messages of checkpoint: %w", err)
+	}
+	if n := len(stored); n > 0 && stored[n-1].Role == "assistant" && len(stored[n-1].ToolCalls) > 0 {
+		stored = stored[:n-1]
+	}
+
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(stored))
+	for _, m := range stored {
+		text, err := m.text()
+		if err != nil {
+			return nil, err
+		}
+		switch m.Role {
+		case "system":
+			messages = append(messages, openai.SystemMessage(text))
+		case "user":
+			messages = append(messages, openai.UserMessage(text))
+		case "tool":
+			messages = append(messages, openai.ToolMessage(m.ToolCallID, text))
+		case "assistant":
+			msg := openai.ChatCompletionMessage{Role: openai.ChatCompletionMessageRoleAssistant, Content: text}
+			for _, call := range m.ToolCalls {
+				msg.ToolCalls = append(msg.ToolCalls, openai.ChatCompletionMessageToolCall{
+					ID:   call.ID,
+					Type: openai.ChatCompletionMessageToolCallTypeFunction,
+					Function: openai.ChatCompletionMessageToolCallFunction{
+						Name:      call.Function.Name,
+						Arguments: call.Function.Arguments,
+					},
+				})
+			}
+			messages = append(messages, msg)
+		default:
+			return nil, fmt.Errorf("unsupported role of checkpoint message: %s", m.Role)
+		}
+	}
+	return messages, nil
+}
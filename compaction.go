@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+)
+
+const (
+	compactionPrompt = `You summarize a conversation between a developer and an AI assistant building a backend
+application with tools. The summary replaces the conversation, so keep everything needed to continue it: the entities
+and fields agreed on, the decisions and preferences of the user, the steps done and their results, the files generated,
+and the open issues. Leave out code and full tool outputs. Respond with the summary only.`
+	// summaryPrefix starts the message replacing the compacted messages, so it's summarized again by later compactions.
+	summaryPrefix = "Summary of the earlier conversation:\n"
+	// maxCompactedToolOutput limits the tool outputs sent to be summarized, as they're mostly generated code.
+	maxCompactedToolOutput = 2000
+	// charsPerToken estimates the tokens of the messages by their length, without a tokenizer of every model.
+	charsPerToken = 4
+)
+
+// compactor keeps conversations within the context of the model, replacing older messages with a summary generated by
+// the model once the conversation grows past the limit.
+type compactor struct {
+	llm   llm.Provider
+	model string
+	// maxTokens is the estimated size of the conversation compacted, or 0 when conversations aren't compacted.
+	maxTokens int
+	// keep is the number of recent messages kept verbatim.
+	keep int
+}
+
+func newCompactor(cfg *config.Config, provider llm.Provider, model string) *compactor {
+	return &compactor{llm: provider, model: model, maxTokens: cfg.CompactTokens, keep: cfg.CompactKeepMessages}
+}
+
+// compact returns the messages with the messages between the system message and the recent messages replaced with
+// their summary, once the messages are estimated to exceed the limit. The recent messages start with a message of the
+// user or the model, never with responses of tools separated from the call. It reports whether the messages were
+// compacted. Failures are logged and the messages are returned unchanged, so the conversation continues regardless.
+func (c *compactor) compact(ctx context.Context, messages []openai.ChatCompletionMessageParamUnion) ([]openai.ChatCompletionMessageParamUnion, bool) {
+	if c.maxTokens == 0 {
+		return messages, false
+	}
+	encoded, err := json.Marshal(messages)
+	if err != nil {
+		log.Err(err).Msg("Failed to encode messages to compact")
+		return messages, false
+	}
+	if len(encoded)/charsPerToken <= c.maxTokens {
+		return messages, false
+	}
+	var stored []checkpointMessage
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		log.Err(err).Msg("Failed to decode messages to compact")
+		return messages, false
+	}
+	cut := max(len(stored)-c.keep, 1)
+	for cut < len(stored) && stored[cut].Role == "tool" {
+		cut++
+	}
+	// Compacting a single message, e.g. the summary of the previous compaction, doesn't make the conversation shorter.
+	if cut <= 2 || cut >= len(stored) {
+		return messages, false
+	}
+
+	transcript, err := compactionTranscript(stored[1:cut])
+	if err != nil {
+		log.Err(err).Msg("Failed to render messages to compact")
+		return messages, false
+	}
+	completion, err := c.llm.Complete(ctx, openai.ChatCompletionNewParams{
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(compactionPrompt),
+			openai.UserMessage(transcript),
+		}),
+		Model: openai.String(c.model),
+		Seed:  openai.Int(1),
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to summarize the conversation")
+		return messages, false
+	}
+	if len(completion.Choices) == 0 || completion.Choices[0].Message.Content == "" {
+		log.Error().Msg("Failed to summarize the conversation: empty summary")
+		return messages, false
+	}
+
+	compacted := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages)-cut+2)
+	compacted = append(compacted, messages[0], openai.SystemMessage(summaryPrefix+completion.Choices[0].Message.Content))
+	compacted = append(compacted, messages[cut:]...)
+	log.Debug().Msgf("Compacted %d messages of the conversation into a summary", cut-1)
+	return compacted, true
+}
+
+// compactionTranscript renders the messages as text for the model to summarize.
+func compactionTranscript(messages []checkpointMessage) (string, error) {
+	var sb strings.Builder
+	for _, m := range messages {
+		text, err := m.text()
+		if err != nil {
+			return "", err
+		}
+		if m.Role == "tool" && len(text) > maxCompactedToolOutput {
+			text = text[:maxCompactedToolOutput] + "\n[truncated]"
+		}
+		if text != "" {
+			fmt.Fprintf(&sb, "%s: %s\n\n", m.Role, text)
+		}
+		for _, call := range m.ToolCalls {
+			fmt.Fprintf(&sb, "%s called %s with %s\n\n", m.Role, call.Function.Name, call.Function.Arguments)
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/telemetry"
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// errSessionBusy is returned for messages sent to a session while it's still answering the previous one.
+var errSessionBusy = errors.New("the session is still answering the previous message")
+
+// turnEvents receive the progress of a turn of a hosted session. Any of them may be nil. The tool events are called
+// concurrently, as tools run in parallel.
+type turnEvents struct {
+	// delta is called with every chunk of the text the model responds with.
+	delta func(text string)
+	// toolStarted and toolFinished are called around every tool call, the latter with the response of the tool.
+	toolStarted  func(call openai.ChatCompletionMessageToolCall)
+	toolFinished func(call openai.ChatCompletionMessageToolCall, resp string)
+	// toolProgress is called with the progress of a tool while it runs, like the tools its agent called.
+	toolProgress func(call openai.ChatCompletionMessageToolCall, message string)
+	// filesChanged is called with the files the tools changed, once all tools the model called finished.
+	filesChanged func(changes []tooling.FileChange)
+	// confirm asks the user to approve tools requiring approval, or confirmLater without waiting for the answer, so
+	// the turns of other sessions don't wait for it. Without either, they're rejected.
+	confirm      func(question string) bool
+	confirmLater func(question string) bool
+}
+
+// sessionHost hosts sessions of the main workflow driven by messages instead of the terminal, e.g. by the API of the
+// serve command. The sessions share the tooling service of the project, so their turns run one at a time.
+type sessionHost struct {
+	ts     *tooling.Service
+	def    workflow.Definition
+	vs     *vector.Service
+	llm    llm.Provider
+	prompt string
+	tools  []openai.ChatCompletionToolParam
+	// compactor summarizes older messages of long conversations.
+	compactor *compactor
+
+	// mu serializes the turns of all sessions.
+	mu       sync.Mutex
+	sessions sync.Map
+}
+
+// hostedSession is a session of a session host, with its conversation so far.
+type hostedSession struct {
+	id       string
+	mem      *vector.MemoryService
+	wf       *workflow.Workflow
+	messages []openai.ChatCompletionMessageParamUnion
+	// busy is set while the session answers a message.
+	busy atomic.Bool
+}
+
+func newSessionHost(cfg *config.Config, ts *tooling.Service, def workflow.Definition, vs *vector.Service, provider llm.Provider) *sessionHost {
+	prompt, tools := mainWorkflow(cfg, ts, def)
+	return &sessionHost{ts: ts, def: def, vs: vs, llm: provider, prompt: prompt, tools: tools, compactor: newCompactor(cfg, provider, ts.ChatModel)}
+}
+
+// create starts a new session of the project.
+func (h *sessionHost) create(ctx context.Context) (*hostedSession, error) {
+	sid := uuid.NewString()
+	mem, err := vector.NewMemory(ctx, h.vs, sid)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := workflow.New(h.def, os.Getenv("PROJECT_ROOT"), sid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize workflow: %w", err)
+	}
+	s := &hostedSession{id: sid, mem: mem, wf: wf}
+	s.messages = []openai.ChatCompletionMessageParamUnion{openai.SystemMessage(h.systemPrompt(s))}
+	if err := mem.Store(ctx, vector.RoleSystem, h.systemPrompt(s)); err != nil {
+		log.Err(err).Msg("Failed to store system message")
+	}
+	h.sessions.Store(sid, s)
+	return s, nil
+}
+
+// session returns the session with the ID, or nil when the host doesn't have it.
+func (h *sessionHost) session(sid string) *hostedSession {
+	s, ok := h.sessions.Load(sid)
+	if !ok {
+		return nil
+	}
+	return s.(*hostedSession)
+}
+
+// systemPrompt returns the system message of the session, with the current statuses of its steps.
+func (h *sessionHost) systemPrompt(s *hostedSession) string {
+	return fmt.Sprintf(h.prompt, s.wf.Prompt())
+}
+
+// send sends the message to the model and runs the tools it calls until it replies, which is returned. Tools requiring
+// approval are rejected, unless the events can ask the user. The conversation is checkpointed after every completion, so the
+// session can be resumed in the terminal as well. On failure, the conversation is kept as far as it got, so sending
+// another message continues it.
+func (h *sessionHost) send(ctx context.Context, s *hostedSession, message string, events turnEvents) (string, error) {
+	if !s.busy.CompareAndSwap(false, true) {
+		return "", errSessionBusy
+	}
+	defer s.busy.Store(false)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ctx, span := telemetry.Tracer().Start(ctx, "turn", trace.WithAttributes(attribute.String("session.id", s.id)))
+	defer span.End()
+	h.ts.Mem = s.mem
+	h.ts.Workflow = s.wf
+	h.ts.Confirm = events.confirm
+	h.ts.ConfirmLater = events.confirmLater
+	if err := h.ts.Lock.SetSession(s.id); err != nil {
+		log.Err(err).Msg("Failed to record session in project lock")
+	}
+	if err := s.mem.Store(ctx, vector.RoleUser, message); err != nil {
+		log.Err(err).Msg("Failed to store user message")
+	}
+	s.messages = append(s.messages, openai.UserMessage(message))
+
+	for {
+		s.messages[0] = openai.SystemMessage(h.systemPrompt(s))
+		if messages, ok := h.compactor.compact(ctx, s.messages); ok {
+			s.messages = messages
+			saveCheckpoint(ctx, h.ts.Checkpoints, s.id, s.wf, s.messages)
+		}
+		stream := h.llm.Stream(ctx, openai.ChatCompletionNewParams{
+			Messages:      openai.F(s.messages),
+			Tools:         openai.F(h.tools),
+			Model:         openai.String(h.ts.ChatModel),
+			Seed:          openai.Int(1),
+			StreamOptions: openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}),
+		})
+		acc := openai.ChatCompletionAccumulator{}
+		for stream.Next() {
+			chunk := stream.Current()
+			acc.AddChunk(chunk)
+			if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" && events.delta != nil {
+				events.delta(chunk.Choices[0].Delta.Content)
+			}
+		}
+		// Closing the stream ends the response, recording its usage.
+		stream.Close()
+		err := stream.Err()
+		if err == nil && len(acc.Choices) == 0 {
+			err = errors.New("the model responded without a completion")
+		}
+		if err != nil {
+			return "", err
+		}
+
+		reply := acc.Choices[0].Message
+		s.messages = append(s.messages, reply)
+		if len(reply.ToolCalls) == 0 {
+			if err := s.mem.Store(ctx, vector.RoleAssistant, reply.Content); err != nil {
+				log.Err(err).Msg("Failed to store assistant message")
+			}
+			saveCheckpoint(ctx, h.ts.Checkpoints, s.id, s.wf, s.messages)
+			return reply.Content, nil
+		}
+
+		// Spinners of the tools have no terminal to be shown in.
+		multi := pterm.DefaultMultiPrinter.WithWriter(io.Discard)
+		var before tooling.FileContents
+		if events.filesChanged != nil {
+			before = tooling.ReadFileContents()
+		}
+		responses := make([]string, len(reply.ToolCalls))
+		waits, done := scheduleTools(s.wf, reply.ToolCalls)
+		var wg sync.WaitGroup
+		for i, call := range reply.ToolCalls {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer close(done[i])
+				for _, j := range waits[i] {
+					<-done[j]
+				}
+				if events.toolStarted != nil {
+					events.toolStarted(call)
+				}
+				callCtx := ctx
+				if events.toolProgress != nil {
+					callCtx = tooling.WithProgress(ctx, func(message string) { events.toolProgress(call, message) })
+				}
+				responses[i] = runTool(callCtx, h.ts, s.wf, multi, call.Function)
+				if err := s.mem.Store(ctx, vector.RoleTool, responses[i]); err != nil {
+					log.Err(err).Msg("Failed to store tool message")
+				}
+				if events.toolFinished != nil {
+					events.toolFinished(call, responses[i])
+				}
+			}()
+		}
+		wg.Wait()
+		if events.filesChanged != nil {
+			if changes := before.Changes(); len(changes) > 0 {
+				events.filesChanged(changes)
+			}
+		}
+		for i, call := range reply.ToolCalls {
+			s.messages = append(s.messages, openai.ToolMessage(call.ID, responses[i]))
+		}
+		saveCheckpoint(ctx, h.ts.Checkpoints, s.id, s.wf, s.messages)
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+	}
+}
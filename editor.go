@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// Error codes of JSON-RPC 2.0, and of the editor protocol.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+	// rpcTurnFailed is the code of failed turns, e.g. of sessions still answering the previous message.
+	rpcTurnFailed = -32000
+)
+
+// rpcMessage is a request, response, or notification of JSON-RPC 2.0.
+type rpcMessage struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// rpcConn is a JSON-RPC 2.0 connection over a stream, whose messages are framed with a Content-Length header, like in
+// the Language Server Protocol. Both sides send requests.
+type rpcConn struct {
+	r *bufio.Reader
+	// wmu serializes writes, as messages are sent concurrently.
+	wmu sync.Mutex
+	w   io.Writer
+
+	mu     sync.Mutex
+	nextID int
+	// pending are the channels of the responses to the requests sent, by ID.
+	pending map[string]chan rpcMessage
+}
+
+func newRPCConn(r io.Reader, w io.Writer) *rpcConn {
+	return &rpcConn{r: bufio.NewReader(r), w: w, pending: make(map[string]chan rpcMessage)}
+}
+
+// read returns the next message. Responses to requests sent are passed to their callers instead.
+func (c *rpcConn) read() (rpcMessage, error) {
+	for {
+		header, err := textproto.NewReader(c.r).ReadMIMEHeader()
+		if err != nil {
+			return rpcMessage{}, err
+		}
+		length, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil || length < 0 {
+			return rpcMessage{}, fmt.Errorf("invalid Content-Length: %q", header.Get("Content-Length"))
+		}
+		body := make([]byte, length)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			return rpcMessage{}, err
+		}
+		var msg rpcMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.respond(json.RawMessage("null"), nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+			continue
+		}
+		if msg.Method != "" {
+			return msg, nil
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[string(msg.ID)]
+		delete(c.pending, string(msg.ID))
+		c.mu.Unlock()
+		if ok {
+			ch <- msg
+		}
+	}
+}
+
+// call sends the request and decodes the result of its response into the result.
+func (c *rpcConn) call(ctx context.Context, method string, params, result any) error {
+	c.mu.Lock()
+	c.nextID++
+	id := json.RawMessage(strconv.Itoa(c.nextID))
+	ch := make(chan rpcMessage, 1)
+	c.pending[string(id)] = ch
+	c.mu.Unlock()
+
+	if err := c.send(rpcMessage{ID: id, Method: method}, params); err != nil {
+		return err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, string(id))
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// notify sends the notification.
+func (c *rpcConn) notify(method string, params any) {
+	if err := c.send(rpcMessage{Method: method}, params); err != nil {
+		log.Err(err).Msgf("Failed to send %s notification", method)
+	}
+}
+
+// respond responds to the request with the ID with the result, or the error when it's not nil.
+func (c *rpcConn) respond(id json.RawMessage, result any, rpcErr *rpcError) {
+	msg := rpcMessage{ID: id, Error: rpcErr}
+	if rpcErr == nil {
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			msg.Error = &rpcError{Code: rpcInternalError, Message: err.Error()}
+		} else {
+			msg.Result = encoded
+		}
+	}
+	if err := c.send(msg, nil); err != nil {
+		log.Err(err).Msg("Failed to send response")
+	}
+}
+
+func (c *rpcConn) send(msg rpcMessage, params any) error {
+	msg.JSONRPC = "2.0"
+	if params != nil {
+		encoded, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s params: %w", msg.Method, err)
+		}
+		msg.Params = encoded
+	}
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err = fmt.Fprintf(c.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// editorServer drives sessions of the session host from an editor extension, over JSON-RPC on stdio. The editor sends
+// requests:
+//   - initialize, with {"applyEdits": true} when the editor writes the generated files itself, returning the name and
+//     version of DoubleTab.
+//   - session/open, returning the new session: {"id", "steps"}.
+//   - session/send, with {"session", "content"}, returning the reply of the turn: {"reply"}. The progress of the turn
+//     is sent as session/event notifications meanwhile: {"session", "event"}, with events of the API of the serve
+//     command.
+//   - session/steps, with {"session"}, returning the session with the statuses of its steps.
+//   - session/approve, with {"session"}, approving the step awaiting approval and continuing with the next one,
+//     returning the reply of the turn like session/send.
+//   - shutdown, followed by the exit notification.
+//
+// DoubleTab sends requests to the editor:
+//   - workspace/applyEdit, with {"label", "path", "content"}, to write a generated file when the editor writes them,
+//     returning {"applied", "failureReason"}. The file must be saved before responding.
+//   - window/confirm, with {"message"}, asking the user to approve a tool requiring approval, returning {"approved"}.
+type editorServer struct {
+	host *sessionHost
+	conn *rpcConn
+	// ctx is canceled once the editor exits. Turns run in it.
+	ctx context.Context
+}
+
+// runEditor serves the editor protocol on stdin and the output, stdout of the editor, until the editor exits.
+func runEditor(ctx context.Context, cfg *config.Config, ts *tooling.Service, def workflow.Definition, vs *vector.Service, provider llm.Provider, out io.Writer) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	e := &editorServer{host: newSessionHost(cfg, ts, def, vs, provider), conn: newRPCConn(os.Stdin, out), ctx: ctx}
+	for {
+		msg, err := e.conn.read()
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Err(err).Msg("Failed to read editor message")
+			}
+			return
+		}
+		if msg.ID == nil {
+			if msg.Method == "exit" {
+				return
+			}
+			continue
+		}
+		// Turns take long, requests are handled concurrently.
+		go func() {
+			result, rpcErr := e.handle(msg)
+			e.conn.respond(msg.ID, result, rpcErr)
+		}()
+	}
+}
+
+func (e *editorServer) handle(msg rpcMessage) (any, *rpcError) {
+	var params struct {
+		ApplyEdits bool   `json:"applyEdits"`
+		Session    string `json:"session"`
+		Content    string `json:"content"`
+	}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+	switch msg.Method {
+	case "initialize":
+		if params.ApplyEdits {
+			e.host.ts.EditFile = e.applyEdit
+		}
+		version := "(devel)"
+		if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+			version = info.Main.Version
+		}
+		return map[string]string{"name": "doubletab", "version": version}, nil
+	case "shutdown":
+		return nil, nil
+	case "session/open":
+		sess, err := e.host.create(e.ctx)
+		if err != nil {
+			return nil, &rpcError{Code: rpcInternalError, Message: fmt.Sprintf("failed to create session: %v", err)}
+		}
+		return viewSession(sess), nil
+	}
+
+	sess := e.host.session(params.Session)
+	if sess == nil && strings.HasPrefix(msg.Method, "session/") {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("session %s doesn't exist", params.Session)}
+	}
+	switch msg.Method {
+	case "session/steps":
+		return viewSession(sess), nil
+	case "session/send":
+		if strings.TrimSpace(params.Content) == "" {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: "the message has no content"}
+		}
+		return e.send(sess, params.Content)
+	case "session/approve":
+		step, err := sess.wf.Approve()
+		if err != nil {
+			return nil, &rpcError{Code: rpcInvalidRequest, Message: err.Error()}
+		}
+		e.host.ts.NotifyStepCompleted(sess.wf, step)
+		// The model continues with the next step, as if the user told it.
+		return e.send(sess, fmt.Sprintf("I approve the result of step %q, continue with the next step.", step.Description))
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %s", msg.Method)}
+	}
+}
+
+// send sends the message to the session, notifying the editor of the progress of the turn.
+func (e *editorServer) send(sess *hostedSession, message string) (any, *rpcError) {
+	events := streamEvents(func(event apiEvent) {
+		e.conn.notify("session/event", map[string]any{"session": sess.id, "event": event})
+	})
+	events.confirm = func(question string) bool {
+		var result struct {
+			Approved bool `json:"approved"`
+		}
+		if err := e.conn.call(e.ctx, "window/confirm", map[string]string{"message": question}, &result); err != nil {
+			log.Err(err).Msg("Failed to ask editor for approval")
+			return false
+		}
+		return result.Approved
+	}
+	reply, err := e.host.send(e.ctx, sess, message, events)
+	if err != nil {
+		return nil, &rpcError{Code: rpcTurnFailed, Message: err.Error()}
+	}
+	return map[string]string{"reply": reply}, nil
+}
+
+// applyEdit writes the generated file through the workspace API of the editor.
+func (e *editorServer) applyEdit(name, content string) error {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return err
+	}
+	var result struct {
+		Applied       bool   `json:"applied"`
+		FailureReason string `json:"failureReason"`
+	}
+	params := map[string]string{"label": "DoubleTab: " + filepath.Base(abs), "path": abs, "content": content}
+	if err := e.conn.call(e.ctx, "workspace/applyEdit", params, &result); err != nil {
+		return fmt.Errorf("editor failed to apply the edit: %w", err)
+	}
+	if !result.Applied {
+		return fmt.Errorf("editor didn't apply the edit: %s", result.FailureReason)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/pterm/pterm"
+
+	"github.com/doubletabai/doubletab/pkg/telemetry"
+)
+
+// turnFailed reports the failed request of a turn to the model in the conversation, and asks the user whether to retry
+// it. It returns true to retry the turn, or false to end the session, which can be resumed from its last checkpoint.
+// Headless runs end with the failure instead, as nobody can answer.
+func turnFailed(err error, sid string, headless *headlessRun) bool {
+	if headless != nil {
+		headless.err = err
+		return false
+	}
+	if errors.Is(err, telemetry.ErrBudgetExceeded) {
+		pterm.Error.Printfln("Session %s exceeded its budget, resume it with --resume %s and a higher budget", sid, sid)
+		return false
+	}
+	pterm.Error.Printfln("Request to the model failed: %v", err)
+	retry, confirmErr := pterm.DefaultInteractiveConfirm.WithDefaultValue(true).Show("Retry the request?")
+	if confirmErr == nil && retry {
+		return true
+	}
+	pterm.Info.Printfln("Resume the session with --resume %s", sid)
+	return false
+}
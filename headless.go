@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/requirements"
+	"github.com/doubletabai/doubletab/pkg/telemetry"
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// maxIdleReplies limits how often in a row the model is told to continue without the workflow progressing, so a stuck
+// headless run fails instead of looping forever.
+const maxIdleReplies = 3
+
+// headlessRun answers the model in place of the user, from the requirements, until all steps of the workflow are
+// completed.
+type headlessRun struct {
+	// progress is the state of the workflow when the model stopped last.
+	progress string
+	idle     int
+	// stuck is set when the run ended without completing the workflow.
+	stuck bool
+	// err is the failure of the model the run ended on.
+	err error
+}
+
+// reply returns the message telling the model to continue with the next step, approving results awaiting approval. It
+// returns false once the workflow is completed or the model stopped maxIdleReplies times without progressing.
+func (h *headlessRun) reply(ts *tooling.Service, wf *workflow.Workflow) (string, bool) {
+	next := wf.Next()
+	if next == nil {
+		return "", false
+	}
+	if wf.Status(next.Name) == workflow.StatusAwaitingApproval {
+		step, err := wf.Approve()
+		if err != nil {
+			log.Err(err).Msg("Failed to approve step")
+		} else {
+			ts.NotifyStepCompleted(wf, step)
+			pterm.Info.Printfln("Approved step %q", step.Description)
+			return fmt.Sprintf("I approve the result of step %q, continue with the next step.", step.Description), true
+		}
+	}
+
+	progress := wf.Prompt()
+	if progress == h.progress {
+		h.idle++
+	} else {
+		h.idle = 0
+	}
+	h.progress = progress
+	if h.idle >= maxIdleReplies {
+		h.stuck = true
+		return "", false
+	}
+	return fmt.Sprintf("Continue with step %q without asking, the requirements are final. If it failed, fix the cause and run it again.",
+		next.Description), true
+}
+
+// runHeadless runs the workflow non-interactively from the requirements, exiting with an error unless all its steps
+// are completed.
+func runHeadless(ctx context.Context, cfg *config.Config, sid string, reqs requirements.Requirements, ts *tooling.Service, def workflow.Definition, wf *workflow.Workflow, provider llm.Provider, budget *telemetry.Budget) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	pterm.DefaultBasicText.Printfln("Generating %s from %s", reqs.Name, cfg.Requirements)
+	headless := &headlessRun{}
+	runMainWorkflow(ctx, cfg, sid, reqs.Prompt(), ts, def, wf, provider, budget, headless)
+	printUsage(budget)
+	telemetry.ReportUsage(ctx, cfg)
+
+	if ctx.Err() != nil {
+		log.Fatal().Msgf("Run of session %s was interrupted, resume it with --resume %s", sid, sid)
+	}
+	if errors.Is(headless.err, telemetry.ErrBudgetExceeded) {
+		log.Fatal().Msgf("Run of session %s exceeded its budget, resume it with --resume %s and a higher budget", sid, sid)
+	}
+	if headless.err != nil {
+		log.Fatal().Err(headless.err).Msgf("Run of session %s failed, resume it with --resume %s", sid, sid)
+	}
+	if headless.stuck {
+		log.Fatal().Msgf("Run of session %s stopped progressing, the workflow is:\n%s", sid, wf.Prompt())
+	}
+	pterm.Success.Printfln("Generated %s in session %s", reqs.Name, sid)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+)
+
+// setupLogging sets the log level, and with a log file, routes logs to it, keeping the terminal for the conversation.
+// Fatal errors are still written to the terminal too, as the user has to know why DoubleTab exited.
+func setupLogging(cfg *config.Config) {
+	lvl, err := zerolog.ParseLevel(cfg.LogLevel)
+	if err != nil || lvl == zerolog.NoLevel {
+		lvl = zerolog.InfoLevel
+	}
+	zerolog.SetGlobalLevel(lvl)
+
+	if cfg.LogFile == "" {
+		return
+	}
+	file := &lumberjack.Logger{
+		Filename:   cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxBackups: cfg.LogMaxBackups,
+	}
+	w := zerolog.MultiLevelWriter(file, minLevelWriter{Writer: os.Stderr, min: zerolog.FatalLevel})
+	log.Logger = zerolog.New(w).With().Timestamp().Logger()
+}
+
+// minLevelWriter writes only logs of the minimum level or above.
+type minLevelWriter struct {
+	io.Writer
+	min zerolog.Level
+}
+
+func (w minLevelWriter) WriteLevel(l zerolog.Level, p []byte) (int, error) {
+	if l < w.min {
+		return len(p), nil
+	}
+	return w.Write(p)
+}
@@ -1,48 +1,149 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/pterm/pterm"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/doubletabai/doubletab/pkg/config"
 	"github.com/doubletabai/doubletab/pkg/knowledgebase"
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/redact"
+	"github.com/doubletabai/doubletab/pkg/requirements"
+	"github.com/doubletabai/doubletab/pkg/telemetry"
 	"github.com/doubletabai/doubletab/pkg/tooling"
 	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+const (
+	// branchCommand continues the conversation in a copy of the session, keeping the original session to resume later.
+	branchCommand = "/branch"
+	// rollbackCommand rolls back the workflow step given after it.
+	rollbackCommand = "/rollback"
+	// approveCommand approves the result of the step awaiting approval, e.g. the plan.
+	approveCommand = "/approve"
+	// usageCommand prints the tokens used by the session and their estimated cost.
+	usageCommand = "/usage"
 )
 
 const (
 	mainWorkflowPrompt = `You are an AI assistant that helps developers build backend applications step by step. Your
 workflow is as follow:
 
-1. Agree with user on the entities and fields.
-2. Generate an OpenAPI 3.0 yaml specification.
-3. Generate PostgreSQL schema for the OpenAPI spec.
-4. Generate Go code implementing handlers.
-5. Generate Go code implementing server.
-
+%s
 Important notes:
 - Always use provided tools to generate OpenAPI spec, schema, and code. Those tools are storing files on disk and
   updating memory with relevant information.
-- Generating PostgreSQL schema and generating Go code implementing handlers should be done **at the same time** rather
-  than sequentially.
+- Call tools of steps which don't require each other in the same response, e.g. generating PostgreSQL schema and
+  generating Go code implementing handlers, rather than sequentially. Calls of steps requiring steps of other calls in
+  the same response wait for them, so they may be made together as well.
+- When there are more than three entities, build the project entity by entity rather than in one pass: for every
+  entity, generate its OpenAPI spec with "entity" set, its PostgreSQL schema, handlers, and server code with "entity"
+  set, then continue with the next entity. The spec of every entity is merged into the spec of the project and its
+  server code is saved to its own file, so entities generated before are kept.
+- Generated OpenAPI specs are merged into the spec of the project. When entities were renamed or removed, regenerate
+  the whole spec with "replace" set.
+- When user changes an existing entity, regenerate only that entity: its OpenAPI spec with "entity" set, the schema of
+  its table, handlers, and its server code with "entity" set. Stored tables are altered to their new schema, and files
+  whose inputs didn't change are left untouched.
+- When the project already has code, e.g. user wants to add a resource to an existing service, use "analyze_project"
+  tool first, then build the new resources entity by entity. The generated spec and code follow the existing routes,
+  handlers, and models.
+- When user asks to fix something, redo current step with fixed instructions.
+- Confirm each step with the user before proceeding to the next one.
+- Statuses of the steps are tracked for you. When a tool is rejected because a step isn't completed, complete that step
+  first.
+- When user asks for live updates of an entity, use "generate_live_updates" tool once its table is created.
+- When entities have file fields, use "generate_file_storage" tool before generating Go code implementing server.
+- When user mentions read-heavy endpoints, offer caching them in Redis and, if accepted, use "generate_cache_layer" tool
+  before generating Go code implementing server.
+- When user says other services need to react to changes, offer publishing events with Kafka or NATS and, if
+  accepted, use "generate_event_publishing" tool before generating Go code implementing server.
+- When user describes payment-like or retry-prone clients, offer Idempotency-Key handling of create requests and, if
+  accepted, use "generate_idempotency" tool.
+- When user deploys to managed PostgreSQL on AWS or Google Cloud, offer Terraform of the database and, if accepted, use
+  "generate_terraform" tool.
+- When entities were renamed or removed and the code was regenerated, use "reconcile_artifacts" tool to find tables and
+  files left behind, and delete them only after the user confirmed it.
+- When a step has to be redone from scratch, e.g. verification failed because of its design, use "rollback_step" tool
+  to remove the tables and files it created before redoing it.
+- When user wants a visual of the data model, use "generate_erd" tool once the schema is stored.
+- When user asks about the data in the database, e.g. what's in a table, use "run_query" tool with a SELECT query.
+- When user edited the spec or the tables by hand, use "verify_consistency" tool to find the drift between them. It runs
+  before generating Go code implementing server as well, which is rejected until the drift is fixed.
+- When user asks for something that doesn't fit the workflow, consult the knowledge base or ask clarifying questions.
+`
+	graphqlWorkflowPrompt = `You are an AI assistant that helps developers build backend applications step by step. Your
+workflow is as follow:
+
+%s
+Important notes:
+- Always use provided tools to generate GraphQL schema, PostgreSQL schema, and code. Those tools are storing files on
+  disk and updating memory with relevant information.
+- Call tools of steps which don't require each other in the same response rather than sequentially. Calls of steps
+  requiring steps of other calls in the same response, like generating Go code implementing resolvers after the
+  PostgreSQL schema, wait for them, so they may be made together as well.
+- When user changes an existing entity, regenerate the GraphQL schema and then only the PostgreSQL schema of its table
+  and the resolvers. Stored tables are altered to their new schema, and files whose inputs didn't change are left
+  untouched.
 - When user asks to fix something, redo current step with fixed instructions.
 - Confirm each step with the user before proceeding to the next one.
+- Statuses of the steps are tracked for you. When a tool is rejected because a step isn't completed, complete that step
+  first.
+- When user asks for live updates of an entity, use "generate_live_updates" tool once its table is created.
+- When user mentions read-heavy queries, offer caching them in Redis and, if accepted, use "generate_cache_layer" tool
+  before generating Go code implementing resolvers.
+- When user says other services need to react to changes, offer publishing events with Kafka or NATS and, if
+  accepted, use "generate_event_publishing" tool before generating Go code implementing resolvers.
+- When user describes payment-like or retry-prone clients, offer Idempotency-Key handling of create requests and, if
+  accepted, use "generate_idempotency" tool.
+- When user deploys to managed PostgreSQL on AWS or Google Cloud, offer Terraform of the database and, if accepted, use
+  "generate_terraform" tool.
+- When entities were renamed or removed and the code was regenerated, use "reconcile_artifacts" tool to find tables and
+  files left behind, and delete them only after the user confirmed it.
+- When a step has to be redone from scratch, e.g. verification failed because of its design, use "rollback_step" tool
+  to remove the tables and files it created before redoing it.
+- When user wants a visual of the data model, use "generate_erd" tool once the schema is stored.
+- When user asks about the data in the database, e.g. what's in a table, use "run_query" tool with a SELECT query.
 - When user asks for something that doesn't fit the workflow, consult the knowledge base or ask clarifying questions.
 `
+	// customWorkflowPrompt follows the introduction of a workflow defined with --workflow.
+	customWorkflowPrompt = `Your workflow is as follow:
+
+%s
+Important notes:
+- Always use provided tools to generate files. Those tools are storing files on disk and updating memory with relevant
+  information.
+- When user asks to fix something, redo current step with fixed instructions.
+- Confirm each step with the user before proceeding to the next one.
+- Statuses of the steps are tracked for you. When a tool is rejected because a step isn't completed, complete that step
+  first.
+- When user asks for something that doesn't fit the workflow, consult the knowledge base or ask clarifying questions.
+`
+	defaultWorkflowIntro = "You are an AI assistant that helps developers build backend applications step by step."
 )
 
 func main() {
@@ -50,35 +151,133 @@ func main() {
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load config")
 	}
-	lvl, err := zerolog.ParseLevel(cfg.LogLevel)
-	if err != nil || lvl == zerolog.NoLevel {
-		lvl = zerolog.InfoLevel
+	setupLogging(cfg)
+	for _, warning := range cfg.Warnings {
+		log.Warn().Msg(warning)
+	}
+	// Editors talk to DoubleTab over stdout, everything else is printed to stderr.
+	rpcOut := os.Stdout
+	if cfg.Command == config.CommandEditor {
+		os.Stdout = os.Stderr
+		pterm.SetDefaultOutput(os.Stderr)
+	}
+	if cfg.Command == config.CommandTelemetry {
+		printTelemetryStatus(cfg)
+		return
+	}
+	if cfg.Command == config.CommandStats {
+		printRuntimeStats(cfg)
+		return
+	}
+
+	var reqs requirements.Requirements
+	if cfg.Command == config.CommandRun {
+		reqs, err = requirements.Load(cfg.Requirements)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to load requirements")
+		}
+		reqs.Apply(cfg)
 	}
-	zerolog.SetGlobalLevel(lvl)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	conn := fmt.Sprintf("host='%s' port='%d' dbname='%s' user='%s' password='%s' sslmode='%s'",
-		cfg.PGHost, cfg.PGPort, cfg.PGDatabase, cfg.PGUser, cfg.PGPassword, cfg.PGSSLMode)
-
-	db, err := sqlx.ConnectContext(ctx, "postgres", conn)
+	// Headless runs, the API, and editors have no one to ask in the terminal, they stop once a budget is exceeded and
+	// reject tools requiring approval, unless their sessions ask the user.
+	terminal := cfg.Command != config.CommandRun && cfg.Command != config.CommandServe && cfg.Command != config.CommandEditor
+	var confirm func(string) bool
+	if terminal {
+		confirm = confirmTool()
+	}
+	prices, err := telemetry.ParsePrices(cfg.LLMPrices)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to connect to project database")
+		log.Fatal().Err(err).Msg("Failed to load LLM prices")
+	}
+	budget := telemetry.NewBudget(cfg.BudgetUSD, cfg.BudgetTokens, prices)
+	budget.Confirm = confirm
+	if cfg.BudgetUSD > 0 {
+		for _, model := range []string{cfg.LLMChatModel, cfg.LLMCodeModel, cfg.LLMEmbeddingModel} {
+			if _, ok := prices.Lookup(model); !ok {
+				log.Warn().Msgf("Price of model %s is unknown, its usage doesn't count toward the budget. Set it with --llm-prices", model)
+			}
+		}
 	}
-	defer db.Close()
 
-	var opts []option.RequestOption
-	if cfg.LLMBaseURL != "" {
-		opts = append(opts, option.WithBaseURL(cfg.LLMBaseURL))
+	// Requests to the LLM API are held back once the budget is exceeded, and traced, when tracing is enabled.
+	llmOpts := llm.Options{
+		BaseURL:    cfg.LLMBaseURL,
+		APIKey:     cfg.OpenAIAPIKey,
+		Middleware: []option.Middleware{budget.Middleware, telemetry.Middleware},
+	}
+	if cfg.LLMLog != "" {
+		redactor, err := redact.New(cfg.Secrets(), cfg.Redact)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to set up LLM log")
+		}
+		llmOpts.Middleware = append(llmOpts.Middleware, telemetry.NewLLMLog(cfg.LLMLog, redactor).Middleware)
 	}
-	llmCli := openai.NewClient(opts...)
-	vs, err := vector.New(ctx, cfg, llmCli)
+	llmProvider, err := llm.New(cfg.LLMProvider, llmOpts)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up LLM provider")
+	}
+	vs, err := vector.New(ctx, cfg, llmProvider)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize vector service")
 	}
 	defer vs.Close()
 
+	history, err := vector.NewHistory(ctx, vs, projectRoot())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize history service")
+	}
+	if cfg.Command == config.CommandHistory {
+		printHistory(ctx, history)
+		return
+	}
+	audit, err := vector.NewAudit(ctx, vs, projectRoot())
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize audit service")
+	}
+	if cfg.Command == config.CommandAudit {
+		printAudit(ctx, cfg, audit)
+		return
+	}
+	if cfg.Command == config.CommandDebugBundle {
+		sid := cfg.CommandArgs[0]
+		name := tooling.DebugBundleName(sid)
+		if err := tooling.ExportDebugBundle(ctx, cfg, vs, history, audit, sid, name); err != nil {
+			log.Fatal().Err(err).Msg("Failed to write debug bundle")
+		}
+		pterm.Success.Printfln("Debug bundle of session %s written to %s, check it before sharing", sid, name)
+		return
+	}
+	if cfg.Command == config.CommandSessions {
+		replaySession(ctx, cfg, vs, audit, cfg.CommandArgs[1])
+		return
+	}
+
+	if cfg.Export != "" {
+		if err := tooling.ExportSession(ctx, vs, cfg.Resume, cfg.Export); err != nil {
+			log.Fatal().Err(err).Msg("Failed to export session")
+		}
+		pterm.Success.Printfln("Session %s exported to %s", cfg.Resume, cfg.Export)
+		return
+	}
+
+	lock, err := tooling.LockProject(cfg.Force)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to lock project")
+	}
+	defer lock.Release()
+
+	if cfg.Import != "" {
+		importSession(ctx, cfg, vs)
+	}
+
+	if err := preflightLLM(ctx, cfg, llmProvider); err != nil {
+		log.Fatal().Err(err).Msg("LLM preflight check failed")
+	}
+
 	ks, err := vector.NewKnowledge(ctx, vs)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize knowledge service")
@@ -86,89 +285,697 @@ func main() {
 	if err := knowledgebase.Populate(ctx, ks); err != nil {
 		log.Fatal().Err(err).Msg("Failed to populate knowledge base")
 	}
+	if _, err := ks.LoadCache(ctx, cfg.KnowledgeCacheLimit); err != nil {
+		log.Err(err).Msg("Failed to load knowledge base into memory, it's searched in the database")
+	}
 
-	sid := uuid.NewString()
+	sid := cfg.Resume
+	if sid == "" {
+		sid = uuid.NewString()
+	}
+	if err := lock.SetSession(sid); err != nil {
+		log.Err(err).Msg("Failed to record session in project lock")
+	}
+	shutdownTracing, err := telemetry.Setup(ctx, cfg, sid)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to set up tracing")
+	}
+	defer func() {
+		// The context may be canceled already, the remaining spans are flushed regardless.
+		if err := shutdownTracing(context.WithoutCancel(ctx)); err != nil {
+			log.Err(err).Msg("Failed to flush traces")
+		}
+	}()
+	if cfg.MetricsAddr != "" {
+		telemetry.ServeMetrics(cfg.MetricsAddr)
+	}
 
 	mem, err := vector.NewMemory(ctx, vs, sid)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize memory service")
 	}
 
-	ts, err := tooling.New(cfg, db, ks, mem, llmCli)
+	conn := fmt.Sprintf("host='%s' port='%d' dbname='%s' user='%s' password='%s' sslmode='%s'",
+		cfg.PGHost, cfg.PGPort, cfg.PGDatabase, cfg.PGUser, cfg.PGPassword, cfg.PGSSLMode)
+
+	pool := vector.Pool{MaxOpenConns: cfg.PGMaxOpenConns, MaxIdleConns: cfg.PGMaxIdleConns, ConnMaxLifetime: cfg.PGConnMaxLifetime}
+	db, err := vector.Connect(ctx, cfg.PGDriver, conn, pool, nil)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to connect to project database")
+	}
+	defer db.Close()
+
+	if cfg.DiagnosticsAddr != "" {
+		telemetry.ServeDiagnostics(cfg.DiagnosticsAddr, map[string]*sql.DB{"project": db.DB, "doubletab": vs.DB.DB})
+	}
+
+	ts, err := tooling.New(cfg, db, ks, mem, llmProvider)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to initialize tooling service")
 	}
 	defer ts.Clear()
+	ts.History = history
+	ts.Audit = audit
+	ts.Checkpoints, err = vector.NewCheckpoints(ctx, vs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize checkpoint service")
+	}
+	ts.Lock = lock
+	ts.Confirm = confirm
+
+	if ts.GitCommits {
+		if err := ts.InitGit(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize git repository")
+		}
+	}
 
-	pterm.DefaultBasicText.Println("Welcome to the" + pterm.LightMagenta(" DoubleTab ") + "AI assistant for backend development! What would you like to build today?")
-	pterm.DefaultBasicText.Printfln("Session ID: %s", sid)
+	// Without a terminal, the tools are installed right away.
+	installMissingTools(ctx, ts, terminal)
+	warnDrift(ts)
+
+	if cfg.Command == config.CommandWatch {
+		watchSpec(ctx, cfg, ts)
+		return
+	}
+
+	def := ts.WorkflowDefinition()
+	if cfg.Workflow != "" {
+		def = loadWorkflowDefinition(cfg.Workflow, ts)
+	}
+	if cfg.Command == config.CommandServe {
+		serveAPI(ctx, cfg, ts, def, vs, llmProvider)
+		printUsage(budget)
+		return
+	}
+	if cfg.Command == config.CommandEditor {
+		runEditor(ctx, cfg, ts, def, vs, llmProvider, rpcOut)
+		return
+	}
+
+	var wf *workflow.Workflow
 	question := os.Getenv("INITIAL_QUERY")
+	if cfg.Resume != "" {
+		wf = resumeWorkflow(ctx, ts, def, sid, cfg.Force)
+		if next := wf.Next(); next != nil {
+			question = fmt.Sprintf("The session was resumed, steps marked as completed are done. Continue with the next step: %s", next.Description)
+		}
+	} else {
+		wf, err = workflow.New(def, os.Getenv("PROJECT_ROOT"), sid)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize workflow")
+		}
+		pterm.DefaultBasicText.Println("Welcome to the" + pterm.LightMagenta(" DoubleTab ") + "AI assistant for backend development! What would you like to build today?")
+		pterm.DefaultBasicText.Printfln("Session ID: %s", sid)
+	}
+	ts.Workflow = wf
+
+	if cfg.Command == config.CommandRun {
+		runHeadless(ctx, cfg, sid, reqs, ts, def, wf, llmProvider, budget)
+		return
+	}
+
 	if question != "" {
 		question, err = pterm.DefaultInteractiveTextInput.
 			WithDefaultText(">").
 			WithDelimiter(" ").
-			WithOnInterruptFunc(exitFunc(sid)).
+			WithOnInterruptFunc(exitFunc(cfg, vs, sid, budget)).
 			WithDefaultValue(question).
 			Show()
 	} else {
 		question, err = pterm.DefaultInteractiveTextInput.
 			WithDefaultText(">").
 			WithDelimiter(" ").
-			WithOnInterruptFunc(exitFunc(sid)).
+			WithOnInterruptFunc(exitFunc(cfg, vs, sid, budget)).
 			Show()
 	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to get user input")
 	}
 
-	go runMainWorkflow(ctx, cfg, sid, question, ts, llmCli)
+	// The session closes when the user interrupts it, or when it ends on a failure.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runMainWorkflow(ctx, cfg, sid, question, ts, def, wf, llmProvider, budget, nil)
+	}()
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
-	<-sigs
+	select {
+	case <-sigs:
+	case <-done:
+	}
 
+	printUsage(budget)
+	telemetry.ReportUsage(ctx, cfg)
 	pterm.DefaultBasicText.Printf("Closing session %s\n", sid)
 }
 
-func exitFunc(sid string) func() {
+// installMissingTools offers installing the code generation tools which aren't installed, so generation doesn't fail
+// later.
+func installMissingTools(ctx context.Context, ts *tooling.Service, confirm bool) {
+	missing := ts.MissingTools()
+	if len(missing) == 0 {
+		return
+	}
+	names := make([]string, 0, len(missing))
+	for _, tool := range missing {
+		names = append(names, tool.Name+"@"+tool.Version)
+	}
+	if confirm {
+		install, err := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(true).
+			Show(fmt.Sprintf("Missing code generation tools (%s), install them into %s?", strings.Join(names, ", "), ts.ToolsDir))
+		if err != nil || !install {
+			return
+		}
+	}
+
+	spinner := tooling.NewSpinner(nil, "Installing tools...")
+	if err := ts.InstallTools(ctx, missing); err != nil {
+		spinner.Fail(err.Error())
+		return
+	}
+	spinner.Success("Tools installed")
+}
+
+// confirmTool returns the function asking the user to approve tool calls, as their approval policies require, and
+// spending beyond the budget. Tools run concurrently, so the user is asked about one at a time.
+func confirmTool() func(question string) bool {
+	var mu sync.Mutex
+	return func(question string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		pterm.DefaultBasicText.Println(question)
+		approved, err := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(false).
+			Show("Approve?")
+		return err == nil && approved
+	}
+}
+
+// loadWorkflowDefinition loads the custom workflow, checking its tools exist, and applies its models.
+func loadWorkflowDefinition(name string, ts *tooling.Service) workflow.Definition {
+	def, err := workflow.LoadDefinition(name)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load workflow definition")
+	}
+	if _, err := ts.WorkflowTools(def); err != nil {
+		log.Fatal().Err(err).Msgf("Invalid tools of workflow %s", def.Name)
+	}
+	if def.ChatModel != "" {
+		ts.ChatModel = def.ChatModel
+	}
+	if def.CodeModel != "" {
+		ts.CodeModel = def.CodeModel
+	}
+	return def
+}
+
+// importSession imports the session of the bundle into the project and makes it the session to resume.
+func importSession(ctx context.Context, cfg *config.Config, vs *vector.Service) {
+	imported, err := tooling.ImportSession(ctx, vs, cfg.Import)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to import session")
+	}
+	pterm.Success.Printfln("Session %s imported from %s", imported.SessionID, cfg.Import)
+	if imported.LockKept {
+		pterm.Warning.Println("The project has its own doubletab.lock, the lock file of the bundle wasn't restored")
+	}
+	if len(imported.Missing) > 0 {
+		pterm.Warning.Printfln("Generated files of the session are missing in the project, the steps which created them will be run again:\n%s",
+			strings.Join(imported.Missing, "\n"))
+	}
+	cfg.Resume = imported.SessionID
+}
+
+// resumeWorkflow loads the persisted workflow of the session, restoring it from the checkpoint of the session when the
+// project lacks it, resets steps whose artifacts are gone, and shows where the session continues from.
+func resumeWorkflow(ctx context.Context, ts *tooling.Service, def workflow.Definition, sid string, force bool) *workflow.Workflow {
+	// The project may have lost the workflow state the checkpoint of the session still has, e.g. when it's resumed in
+	// a fresh clone.
+	if cp, err := ts.Checkpoints.Load(ctx, sid); err != nil {
+		log.Err(err).Msg("Failed to load checkpoint of the resumed session")
+	} else if cp != nil {
+		if restored, err := workflow.Restore(os.Getenv("PROJECT_ROOT"), sid, cp.Workflow); err != nil {
+			log.Err(err).Msg("Failed to restore workflow state from checkpoint")
+		} else if restored {
+			pterm.Info.Printfln("Workflow state restored from the checkpoint of %s", cp.UpdatedAt.Local().Format(time.DateTime))
+		}
+	}
+	wf, err := workflow.Load(def, os.Getenv("PROJECT_ROOT"), sid)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load workflow of the resumed session")
+	}
+	// Branches share the project directory and the database with the session, so the project may no longer be what
+	// the session left once they ran.
+	branches, err := wf.ChangedBranches()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to check branches of the resumed session")
+	}
+	if len(branches) > 0 && !force {
+		log.Fatal().Msgf("Session %s was branched into %s, which ran since and shares its project directory and database. Resume the branch, or restore the project as the session left it and resume the session with --force",
+			sid, strings.Join(branches, ", "))
+	}
+	reset, err := wf.Verify()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to verify workflow of the resumed session")
+	}
+
+	pterm.DefaultBasicText.Println("Welcome back to the" + pterm.LightMagenta(" DoubleTab ") + "AI assistant for backend development!")
+	pterm.DefaultBasicText.Printfln("Resuming session %s", sid)
+	if len(reset) > 0 {
+		pterm.Warning.Printfln("Artifacts of steps %s are missing, they will be run again", strings.Join(reset, ", "))
+	}
+	pterm.DefaultBasicText.Print(wf.Prompt())
+	return wf
+}
+
+// branchSession copies memory and workflow state of the session into a new session and switches to it. The original
+// session is left as it is, so it can be resumed later. On failure, the original session continues.
+func branchSession(ctx context.Context, ts *tooling.Service, sid string, wf *workflow.Workflow) (string, *workflow.Workflow) {
+	branchSID := uuid.NewString()
+	mem, err := ts.Mem.Branch(ctx, branchSID)
+	if err != nil {
+		pterm.Error.Printfln("Failed to branch session: %v", err)
+		return sid, wf
+	}
+	branchWF, err := wf.Branch(branchSID)
+	if err != nil {
+		pterm.Error.Printfln("Failed to branch session: %v", err)
+		return sid, wf
+	}
+	ts.Mem = mem
+	ts.Workflow = branchWF
+	if err := ts.Lock.SetSession(branchSID); err != nil {
+		log.Err(err).Msg("Failed to record session in project lock")
+	}
+
+	pterm.DefaultBasicText.Printfln("Branched session %s into %s. Resume the original session with --resume %s", sid, branchSID, sid)
+	return branchSID, branchWF
+}
+
+// rollbackStep rolls back the step named in the arguments of the rollback command.
+func rollbackStep(ctx context.Context, ts *tooling.Service, args []string) {
+	if len(args) != 1 {
+		names := make([]string, 0, len(ts.Workflow.Steps()))
+		for _, step := range ts.Workflow.Steps() {
+			names = append(names, step.Name)
+		}
+		pterm.Warning.Printfln("Usage: %s <step>, where step is one of %s", rollbackCommand, strings.Join(names, ", "))
+		return
+	}
+	removed, err := ts.Rollback(ctx, args[0])
+	if err != nil {
+		pterm.Error.Printfln("Failed to roll back step %s: %v", args[0], err)
+		return
+	}
+	pterm.Success.Printfln("Step %s rolled back", args[0])
+	for _, r := range removed {
+		pterm.DefaultBasicText.Printfln("  removed %s", r)
+	}
+}
+
+// watchSpec regenerates the code whenever the OpenAPI spec is edited by hand, previewing the changes of the spec and
+// asking for confirmation first, until interrupted.
+func watchSpec(ctx context.Context, cfg *config.Config, ts *tooling.Service) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	err := ts.Watch(ctx, cfg.WatchInterval, func(diff string) bool {
+		pterm.DefaultSection.Println("The OpenAPI spec changed")
+		for _, line := range strings.Split(strings.TrimSuffix(diff, "\n"), "\n") {
+			switch {
+			case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"), strings.HasPrefix(line, "@@"):
+				pterm.DefaultBasicText.Println(pterm.Cyan(line))
+			case strings.HasPrefix(line, "+"):
+				pterm.DefaultBasicText.Println(pterm.Green(line))
+			case strings.HasPrefix(line, "-"):
+				pterm.DefaultBasicText.Println(pterm.Red(line))
+			default:
+				pterm.DefaultBasicText.Println(line)
+			}
+		}
+		regenerate, err := pterm.DefaultInteractiveConfirm.
+			WithDefaultValue(true).
+			Show("Regenerate handlers, schema, and server code from the spec?")
+		return err == nil && regenerate
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to watch OpenAPI spec")
+	}
+}
+
+// printHistory prints the generation history of the project, oldest first.
+func printHistory(ctx context.Context, history *vector.HistoryService) {
+	entries, err := history.List(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read history")
+	}
+	if len(entries) == 0 {
+		pterm.DefaultBasicText.Printfln("Nothing was generated for %s yet", history.Project)
+		return
+	}
+	data := pterm.TableData{{"Time", "Session", "Step", "Tool", "Models (chat, code)", "Artifacts"}}
+	for _, e := range entries {
+		data = append(data, []string{
+			e.CreatedAt.Local().Format(time.DateTime),
+			e.SessionID,
+			e.Step,
+			e.Tool,
+			e.ChatModel + ", " + e.CodeModel,
+			strings.Join(e.Artifacts, "\n"),
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithRowSeparator("-").WithData(data).Render(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to print history")
+	}
+}
+
+// printAudit prints the latest tool invocations of the project selected by the audit flags, oldest first.
+func printAudit(ctx context.Context, cfg *config.Config, audit *vector.AuditService) {
+	entries, err := audit.Query(ctx, vector.AuditFilter{SessionID: cfg.AuditSession, Tool: cfg.AuditTool, Limit: cfg.AuditLimit})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read audit log")
+	}
+	if len(entries) == 0 {
+		pterm.DefaultBasicText.Printfln("No tool invocations of %s were recorded", audit.Project)
+		return
+	}
+	data := pterm.TableData{{"Time", "Session", "Caller", "Tool", "Arguments", "Duration", "Result"}}
+	for _, e := range entries {
+		result := truncate(e.Result, 80)
+		if !e.Succeeded {
+			result = pterm.Red(result)
+		}
+		data = append(data, []string{
+			e.CreatedAt.Local().Format(time.DateTime),
+			e.SessionID,
+			e.Caller,
+			e.Tool,
+			truncate(e.Arguments, 60),
+			(time.Duration(e.DurationMS) * time.Millisecond).String(),
+			result,
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+		log.Fatal().Err(err).Msg("Failed to print audit log")
+	}
+}
+
+// printTelemetryStatus tells whether anonymous usage telemetry is enabled, how to change it, and what it reports.
+func printTelemetryStatus(cfg *config.Config) {
+	if cfg.Telemetry {
+		pterm.DefaultBasicText.Printfln("Anonymous usage telemetry is %s, reported to %s", pterm.Green("enabled"), cfg.TelemetryEndpoint)
+	} else if dnt := os.Getenv("DO_NOT_TRACK"); dnt != "" && dnt != "0" && dnt != "false" {
+		pterm.DefaultBasicText.Printfln("Anonymous usage telemetry is %s by DO_NOT_TRACK", pterm.Yellow("disabled"))
+	} else {
+		pterm.DefaultBasicText.Printfln("Anonymous usage telemetry is %s, enable it with --telemetry and --telemetry-endpoint",
+			pterm.Yellow("disabled"))
+	}
+	if id, err := telemetry.InstallationID(false); err == nil && id != "" {
+		pterm.DefaultBasicText.Printfln("Installation ID: %s", id)
+	}
+	example, err := json.MarshalIndent(telemetry.NewUsageReport(""), "", "  ")
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to encode usage report")
+	}
+	pterm.DefaultBasicText.Printfln("Reported when a session closes, e.g.:\n%s", example)
+	pterm.DefaultBasicText.Println("Prompts, code, names of the project, and credentials are never reported. DO_NOT_TRACK=1 disables it.")
+}
+
+// printRuntimeStats prints the runtime statistics of the instance serving diagnostics at the configured address.
+func printRuntimeStats(cfg *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stats, err := telemetry.FetchStats(ctx, cfg.DiagnosticsAddr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to fetch runtime statistics")
+	}
+	pterm.DefaultBasicText.Printfln("Uptime: %s, goroutines: %d, GC cycles: %d", stats.Uptime, stats.Goroutines, stats.NumGC)
+	pterm.DefaultBasicText.Printfln("Heap: %.1f MB allocated in %d objects, %.1f MB obtained from the OS",
+		float64(stats.HeapAlloc)/(1<<20), stats.HeapObjects, float64(stats.HeapSys)/(1<<20))
+	data := pterm.TableData{{"Database", "Max open", "Open", "In use", "Idle", "Waits", "Wait time"}}
+	for _, name := range slices.Sorted(maps.Keys(stats.DBs)) {
+		db := stats.DBs[name]
+		data = append(data, []string{name, strconv.Itoa(db.MaxOpen), strconv.Itoa(db.Open), strconv.Itoa(db.InUse),
+			strconv.Itoa(db.Idle), strconv.FormatInt(db.WaitCount, 10), db.WaitTime.Round(time.Millisecond).String()})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+		log.Err(err).Msg("Failed to print runtime statistics")
+	}
+}
+
+// printUsage prints the tokens used by the session so far by model, their estimated cost, and the budget, followed by
+// statistics of the tools, slowest first, telling whether the model or the tools take the time.
+func printUsage(budget *telemetry.Budget) {
+	usage := telemetry.SessionUsage()
+	if len(usage) == 0 {
+		pterm.DefaultBasicText.Println("No tokens were used yet")
+	} else {
+		data := pterm.TableData{{"Model", "Requests", "Prompt tokens", "Completion tokens", "Time", "Cost"}}
+		var total telemetry.ModelUsage
+		var totalCost float64
+		for _, model := range slices.Sorted(maps.Keys(usage)) {
+			u := usage[model]
+			cost := "unknown"
+			if price, ok := budget.Prices.Lookup(model); ok {
+				cost = fmt.Sprintf("$%.4f", u.Cost(price))
+				totalCost += u.Cost(price)
+			}
+			data = append(data, []string{model, strconv.FormatInt(u.Requests, 10), strconv.FormatInt(u.PromptTokens, 10),
+				strconv.FormatInt(u.CompletionTokens, 10), u.Duration.Round(time.Millisecond).String(), cost})
+			total.Requests += u.Requests
+			total.PromptTokens += u.PromptTokens
+			total.CompletionTokens += u.CompletionTokens
+			total.Duration += u.Duration
+		}
+		data = append(data, []string{"Total", strconv.FormatInt(total.Requests, 10), strconv.FormatInt(total.PromptTokens, 10),
+			strconv.FormatInt(total.CompletionTokens, 10), total.Duration.Round(time.Millisecond).String(),
+			fmt.Sprintf("$%.4f", totalCost)})
+		if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+			log.Err(err).Msg("Failed to print usage")
+		}
+	}
+	if limits := budget.Limits(); limits != "" {
+		pterm.DefaultBasicText.Printfln("Budget: %s", limits)
+	}
+
+	stats := telemetry.SessionToolStats()
+	if len(stats) == 0 {
+		return
+	}
+	tools := slices.SortedFunc(maps.Keys(stats), func(a, b string) int {
+		return cmp.Compare(stats[b].Total, stats[a].Total)
+	})
+	data := pterm.TableData{{"Tool", "Calls", "Succeeded", "p50", "p90", "p99", "Total"}}
+	for _, tool := range tools {
+		st := stats[tool]
+		succeeded := fmt.Sprintf("%.0f%%", st.SuccessRate()*100)
+		if st.Failures > 0 {
+			succeeded = pterm.Red(succeeded)
+		}
+		data = append(data, []string{tool, strconv.Itoa(st.Calls), succeeded, st.P50.Round(time.Millisecond).String(),
+			st.P90.Round(time.Millisecond).String(), st.P99.Round(time.Millisecond).String(),
+			st.Total.Round(time.Millisecond).String()})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(data).Render(); err != nil {
+		log.Err(err).Msg("Failed to print tool statistics")
+	}
+}
+
+// printTurnUsage prints a footer with the tokens used by the turn, since the session used the tokens before it, and by
+// the session so far, with its estimated cost.
+func printTurnUsage(before telemetry.ModelUsage, prices telemetry.Prices) {
+	total := telemetry.TotalUsage()
+	tokens, cost := telemetry.Spend(prices)
+	pterm.DefaultBasicText.Println(pterm.Gray(fmt.Sprintf("Turn: %d prompt + %d completion tokens · Session: %d tokens, $%.4f",
+		total.PromptTokens-before.PromptTokens, total.CompletionTokens-before.CompletionTokens, tokens, cost)))
+}
+
+// truncate shortens the text to the number of characters, on a single line.
+func truncate(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
+
+// projectRoot returns the absolute path of the project root, identifying the project in its history.
+func projectRoot() string {
+	root, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return os.Getenv("PROJECT_ROOT")
+	}
+	return root
+}
+
+// warnDrift warns about generated files changed since they were generated, as regenerating them has to merge the
+// changes.
+func warnDrift(ts *tooling.Service) {
+	drifted, err := ts.Drift()
+	if err != nil {
+		log.Err(err).Msg("Failed to check generated files for changes")
+		return
+	}
+	if len(drifted) == 0 {
+		return
+	}
+	pterm.Warning.Printfln("Files changed since they were generated, the changes are merged when they are regenerated:\n%s",
+		strings.Join(drifted, "\n"))
+}
+
+// exitFunc returns the function closing the session when the user interrupts it, summarizing its usage first. The
+// queued memories of the session are stored before it exits.
+func exitFunc(cfg *config.Config, vs *vector.Service, sid string, budget *telemetry.Budget) func() {
 	return func() {
+		vs.Close()
+		printUsage(budget)
+		telemetry.ReportUsage(context.Background(), cfg)
 		pterm.DefaultBasicText.Printf("Closing session %s\n", sid)
 		os.Exit(1)
 	}
 }
 
-func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question string, ts *tooling.Service, openAICli *openai.Client) {
-	params := openai.ChatCompletionNewParams{
-		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(mainWorkflowPrompt),
-			openai.UserMessage(question),
-		}),
-		Tools: openai.F([]openai.ChatCompletionToolParam{
+// mainWorkflow returns the prompt of the main workflow, formatting the steps, and the tools of the model, of the API
+// style or the custom workflow.
+func mainWorkflow(cfg *config.Config, ts *tooling.Service, def workflow.Definition) (string, []openai.ChatCompletionToolParam) {
+	prompt := mainWorkflowPrompt
+	tools := []openai.ChatCompletionToolParam{
+		ts.ListTablesTool(),
+		ts.AnalyzeProjectTool(),
+		ts.GenerateOpenAPISpecTool(),
+		ts.GenerateSchemaTool(),
+		ts.StoreSchemaTool(),
+		ts.GenerateHandlersCodeTool(),
+		ts.GenerateServerCodeTool(),
+		ts.GenerateFileStorageTool(),
+		ts.GenerateCacheLayerTool(),
+		ts.GenerateEventPublishingTool(),
+		ts.GenerateIdempotencyTool(),
+		ts.GenerateTerraformTool(),
+		ts.GenerateLiveUpdatesTool(),
+		ts.CreateAPIVersionTool(),
+		ts.PublishPRTool(),
+		ts.RunAndVerifyTool(),
+		ts.FuzzAPITool(),
+		ts.SecurityScanTool(),
+		ts.ReconcileArtifactsTool(),
+		ts.RollbackStepTool(),
+		ts.VerifyConsistencyTool(),
+		ts.RunQueryTool(),
+		ts.GenerateERDTool(),
+		ts.GenerateReadmeTool(),
+		ts.QueryKnowledgeBaseTool(),
+	}
+	if cfg.APIStyle == tooling.APIStyleGraphQL {
+		prompt = graphqlWorkflowPrompt
+		tools = []openai.ChatCompletionToolParam{
 			ts.ListTablesTool(),
-			ts.GenerateOpenAPISpecTool(),
+			ts.GenerateGraphQLSchemaTool(),
 			ts.GenerateSchemaTool(),
 			ts.StoreSchemaTool(),
-			ts.GenerateHandlersCodeTool(),
-			ts.GenerateServerCodeTool(),
+			ts.GenerateResolversCodeTool(),
+			ts.GenerateCacheLayerTool(),
+			ts.GenerateEventPublishingTool(),
+			ts.GenerateIdempotencyTool(),
+			ts.GenerateTerraformTool(),
+			ts.GenerateLiveUpdatesTool(),
+			ts.RunAndVerifyTool(),
+			ts.SecurityScanTool(),
+			ts.ReconcileArtifactsTool(),
+			ts.RollbackStepTool(),
+			ts.RunQueryTool(),
+			ts.GenerateERDTool(),
+			ts.GenerateReadmeTool(),
 			ts.QueryKnowledgeBaseTool(),
+		}
+	}
+
+	if cfg.PlanFirst {
+		tools = append(tools, ts.WritePlanTool())
+	}
+	if cfg.Workflow != "" {
+		intro := def.Prompt
+		if intro == "" {
+			intro = defaultWorkflowIntro
+		}
+		// The introduction is user-defined, so it mustn't be taken for formatting verbs.
+		prompt = strings.ReplaceAll(strings.TrimSpace(intro), "%", "%%") + "\n\n" + customWorkflowPrompt
+		// Tools were checked when the definition was loaded.
+		tools, _ = ts.WorkflowTools(def)
+	}
+
+	prompt += ts.WorkflowNotes()
+	return prompt, tools
+}
+
+// runMainWorkflow converses with the model until the context is done. Once the model stops, the user answers it, or
+// the headless run, when given, until it ends.
+func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question string, ts *tooling.Service, def workflow.Definition, wf *workflow.Workflow, provider llm.Provider, budget *telemetry.Budget, headless *headlessRun) {
+	prompt, tools := mainWorkflow(cfg, ts, def)
+	// The steps are rendered with their current statuses, so the system message is refreshed before every completion.
+	systemPrompt := func() string {
+		return fmt.Sprintf(prompt, wf.Prompt())
+	}
+
+	params := openai.ChatCompletionNewParams{
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(systemPrompt()),
+			openai.UserMessage(question),
 		}),
-		Model: openai.String(cfg.LLMChatModel),
+		Tools: openai.F(tools),
+		Model: openai.String(ts.ChatModel),
 		Seed:  openai.Int(1),
+		// The usage is reported in the last chunk of the stream, without choices, for the token metrics.
+		StreamOptions: openai.F(openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}),
 	}
 
-	if err := ts.Mem.Store(ctx, vector.RoleSystem, mainWorkflowPrompt); err != nil {
-		log.Fatal().Err(err).Msg("Failed to store system message")
+	// Resumed sessions continue the conversation from their last checkpoint, with the current system message. Without
+	// a checkpoint, the conversation is reconstructed from the memory of the session.
+	if cfg.Resume != "" {
+		messages := checkpointMessages(ctx, ts, sid)
+		if len(messages) <= 1 {
+			if messages = memoryMessages(ctx, ts, wf); messages != nil {
+				pterm.Info.Printfln("Conversation of session %s restored from its memory", sid)
+			}
+		}
+		if len(messages) > 1 {
+			messages[0] = openai.SystemMessage(systemPrompt())
+			params.Messages.Value = append(messages, openai.UserMessage(question))
+		}
+	}
+
+	if err := ts.Mem.Store(ctx, vector.RoleSystem, systemPrompt()); err != nil {
+		log.Err(err).Msg("Failed to store system message")
 	}
 	if err := ts.Mem.Store(ctx, vector.RoleUser, question); err != nil {
-		log.Fatal().Err(err).Msg("Failed to store user message")
+		log.Err(err).Msg("Failed to store user message")
 	}
 
+	compactor := newCompactor(cfg, provider, ts.ChatModel)
+
+	// Every turn of the loop, a completion with the tool calls it requested, is traced as a span. Waiting for the user
+	// ends the span of the turn early.
+	turn := trace.SpanFromContext(ctx)
+	defer func() { turn.End() }()
 	for {
+		turn.End()
 		if ctx.Err() != nil {
 			return
 		}
+		var turnCtx context.Context
+		turnCtx, turn = telemetry.Tracer().Start(ctx, "turn", trace.WithAttributes(attribute.String("session.id", sid)))
+		turnUsage := telemetry.TotalUsage()
+		params.Messages.Value[0] = openai.SystemMessage(systemPrompt())
+		if messages, ok := compactor.compact(turnCtx, params.Messages.Value); ok {
+			params.Messages.Value = messages
+			saveCheckpoint(ctx, ts.Checkpoints, sid, wf, params.Messages.Value)
+		}
 		thinking, _ := pterm.DefaultSpinner.WithRemoveWhenDone(true).WithSequence("⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏").Start("Thinking...")
-		stream := openAICli.Chat.Completions.NewStreaming(ctx, params)
+		stream := provider.Stream(turnCtx, params)
 		acc := openai.ChatCompletionAccumulator{}
 
 		begin := false
@@ -179,6 +986,9 @@ func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question stri
 			}
 			chunk := stream.Current()
 			acc.AddChunk(chunk)
+			if len(chunk.Choices) == 0 {
+				continue
+			}
 			chunkContents := chunk.Choices[0].Delta.Content
 			if !begin && chunkContents != "" {
 				begin = true
@@ -189,12 +999,23 @@ func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question stri
 				pterm.DefaultBasicText.Print(chunk.Choices[0].Delta.Content)
 			}
 		}
-		if stream.Err() != nil {
-			log.Fatal().Err(stream.Err()).Msg("Failed to stream completion")
-		}
+		// Closing the stream ends the response, recording its usage.
+		stream.Close()
 		if begin {
 			pterm.DefaultBasicText.Println()
 		}
+		err := stream.Err()
+		if err == nil && len(acc.Choices) == 0 {
+			err = errors.New("the model responded without a completion")
+		}
+		if err != nil {
+			thinking.Stop()
+			// The messages are unchanged, so the turn is retried by requesting the completion again.
+			if turnFailed(err, sid, headless) {
+				continue
+			}
+			return
+		}
 
 		toolCalls := acc.Choices[0].Message.ToolCalls
 		if len(toolCalls) == 0 && acc.Choices[0].FinishReason == "stop" {
@@ -203,19 +1024,61 @@ func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question stri
 			}
 			params.Messages.Value = append(params.Messages.Value, acc.Choices[0].Message)
 			thinking.Stop()
-			nextStep, err := pterm.DefaultInteractiveTextInput.
-				WithDefaultText(">").
-				WithDelimiter(" ").
-				WithOnInterruptFunc(exitFunc(sid)).
-				Show()
-			if err != nil {
-				log.Fatal().Err(err).Msg("Failed to get user input")
+			turn.End()
+			if cfg.ShowTokens {
+				printTurnUsage(turnUsage, budget.Prices)
+			}
+			var nextStep string
+			if headless != nil {
+				var ok bool
+				if nextStep, ok = headless.reply(ts, wf); !ok {
+					return
+				}
+			}
+		input:
+			for headless == nil {
+				var err error
+				nextStep, err = pterm.DefaultInteractiveTextInput.
+					WithDefaultText(">").
+					WithDelimiter(" ").
+					WithOnInterruptFunc(exitFunc(cfg, ts.Mem.V, sid, budget)).
+					Show()
+				if err != nil {
+					pterm.Error.Printfln("Failed to read input: %v", err)
+					pterm.Info.Printfln("Resume the session with --resume %s", sid)
+					return
+				}
+				var command string
+				fields := strings.Fields(nextStep)
+				if len(fields) > 0 {
+					command = fields[0]
+				}
+				switch command {
+				case branchCommand:
+					sid, wf = branchSession(ctx, ts, sid, wf)
+				case rollbackCommand:
+					rollbackStep(ctx, ts, fields[1:])
+				case usageCommand:
+					printUsage(budget)
+				case approveCommand:
+					step, err := wf.Approve()
+					if err != nil {
+						pterm.Warning.Println(err.Error())
+						continue
+					}
+					ts.NotifyStepCompleted(wf, step)
+					// The model continues with the next step, as if the user told it.
+					nextStep = fmt.Sprintf("I approve the result of step %q, continue with the next step.", step.Description)
+					break input
+				default:
+					break input
+				}
 			}
 			if err := ts.Mem.Store(ctx, vector.RoleUser, nextStep); err != nil {
 				log.Err(err).Msg("Failed to store user message")
 			}
 			params.Messages.Value = append(params.Messages.Value, openai.UserMessage(nextStep))
-			stream.Close()
+			saveCheckpoint(ctx, ts.Checkpoints, sid, wf, params.Messages.Value)
 			continue
 		}
 
@@ -228,15 +1091,21 @@ func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question stri
 		multi := &pterm.MultiPrinter{}
 		multi = multi.WithWriter(os.Stdout).WithUpdateDelay(time.Millisecond * 200)
 		multi.Start()
-		for _, toolCall := range toolCalls {
-			if ctx.Err() != nil {
-				stream.Close()
-				return
-			}
-
+		waits, done := scheduleTools(wf, toolCalls)
+		// Every call is started, so the calls waiting for it finish too, and calls not run before the session was
+		// cancelled are answered as cancelled, keeping a response for every call in the checkpoint.
+		for i, toolCall := range toolCalls {
 			go func(toolCall openai.ChatCompletionMessageToolCall) {
 				defer wg.Done()
-				resp := ts.HandleToolCall(ctx, multi, toolCall.Function)
+				defer close(done[i])
+				for _, j := range waits[i] {
+					<-done[j]
+				}
+				if turnCtx.Err() != nil {
+					responses.Store(toolCall.ID, fmt.Sprintf("Tool %s cancelled before it ran", toolCall.Function.Name))
+					return
+				}
+				resp := runTool(turnCtx, ts, wf, multi, toolCall.Function)
 				responses.Store(toolCall.ID, resp)
 
 				log.Debug().Msgf("Adding message to context from tool %s, resp: %s", toolCall.ID, resp)
@@ -247,13 +1116,60 @@ func runMainWorkflow(ctx context.Context, cfg *config.Config, sid, question stri
 		}
 		wg.Wait()
 		multi.Stop()
+		if cfg.ShowTokens {
+			printTurnUsage(turnUsage, budget.Prices)
+		}
 		responses.Range(func(key, value interface{}) bool {
 			toolID := key.(string)
 			resp := value.(string)
 			params.Messages.Value = append(params.Messages.Value, openai.ToolMessage(toolID, resp))
 			return true
 		})
-		stream.Close()
+		saveCheckpoint(ctx, ts.Checkpoints, sid, wf, params.Messages.Value)
 		thinking.Stop()
 	}
 }
+
+// scheduleTools returns, for every tool call of a turn, the calls it must wait for, and channels closed once the calls
+// finished. Calls of independent steps run in parallel, while calls of steps requiring each other, or writing the same
+// artifacts, run one after another.
+func scheduleTools(wf *workflow.Workflow, toolCalls []openai.ChatCompletionMessageToolCall) ([][]int, []chan struct{}) {
+	tools := make([]string, len(toolCalls))
+	done := make([]chan struct{}, len(toolCalls))
+	for i, toolCall := range toolCalls {
+		tools[i] = toolCall.Function.Name
+		done[i] = make(chan struct{})
+	}
+	return wf.Schedule(tools), done
+}
+
+// runTool handles the tool call as part of its workflow step, rejecting it when the steps it requires aren't completed.
+func runTool(ctx context.Context, ts *tooling.Service, wf *workflow.Workflow, multi *pterm.MultiPrinter, tool openai.ChatCompletionMessageToolCallFunction) string {
+	if err := ts.CheckPlanApproved(tool.Name); err != nil {
+		return fmt.Sprintf("Tool %s rejected: %v", tool.Name, err)
+	}
+	completed := wf.Completed(tool.Name)
+	step, err := wf.Start(tool.Name)
+	if err != nil {
+		return fmt.Sprintf("Tool %s rejected: %v", tool.Name, err)
+	}
+	before := tooling.TakeSnapshot()
+	resp := ts.HandleToolCall(ctx, multi, tool)
+	if err := wf.Finish(tool.Name, tooling.Succeeded(resp), resp); err != nil {
+		log.Err(err).Msg("Failed to save workflow state")
+	}
+	ts.NotifyToolRun(wf, step, completed, tool.Name, resp)
+	if tooling.Succeeded(resp) {
+		var stepName string
+		if step != nil {
+			stepName = step.Name
+		}
+		if err := ts.RecordHistory(ctx, stepName, tool.Name, before); err != nil {
+			log.Err(err).Msg("Failed to record history")
+		}
+		if err := ts.CommitStep(ctx, stepName, tool.Name, before); err != nil {
+			log.Err(err).Msg("Failed to commit step")
+		}
+	}
+	return resp
+}
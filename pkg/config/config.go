@@ -2,12 +2,80 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/redact"
 )
 
+// projectConfigFile is the config file in the project root, with settings of the project.
+const projectConfigFile = "doubletab.yaml"
+
+// CommandWatch regenerates the code whenever the OpenAPI spec is edited by hand, instead of starting a session.
+const CommandWatch = "watch"
+
+// CommandHistory prints the generation history of the project, instead of starting a session.
+const CommandHistory = "history"
+
+// CommandAudit prints the audit log of tool invocations of the project, instead of starting a session.
+const CommandAudit = "audit"
+
+// CommandDebugBundle writes an archive of the session given after it, for reporting failures, instead of starting a
+// session.
+const CommandDebugBundle = "debug-bundle"
+
+// CommandSessions manages the sessions of the project with the subcommand given after it, instead of starting a
+// session. Its only subcommand is SessionsReplay.
+const CommandSessions = "sessions"
+
+// CommandTelemetry prints the status of the anonymous usage telemetry with the subcommand TelemetryStatus, instead of
+// starting a session.
+const CommandTelemetry = "telemetry"
+
+// CommandStats prints the runtime statistics of the instance serving diagnostics at --diagnostics-addr, e.g. of a
+// long-running serve or watch command, instead of starting a session.
+const CommandStats = "stats"
+
+// TelemetryStatus tells whether anonymous usage telemetry is enabled and what it reports.
+const TelemetryStatus = "status"
+
+// SessionsReplay plays the transcript of the session given after it back in the terminal.
+const SessionsReplay = "replay"
+
+// LLM observability platforms traces of sessions are exported to, with prompts and completions.
+const (
+	ObservabilityLangfuse  = "langfuse"
+	ObservabilityLangSmith = "langsmith"
+)
+
+// Forges pull requests of the generated code are opened on.
+const (
+	ForgeGitHub = "github"
+	ForgeGitLab = "gitlab"
+	ForgeGitea  = "gitea"
+)
+
+// CommandServe serves the REST API driving sessions of the project, e.g. from developer portals, instead of starting a
+// session in the terminal.
+const CommandServe = "serve"
+
+// CommandEditor drives sessions of the project from an editor extension, over JSON-RPC on stdin and stdout.
+const CommandEditor = "editor"
+
+// CommandRun runs the whole workflow non-interactively from the requirements file given with --requirements.
+const CommandRun = "run"
+
 type Config struct {
 	LogLevel               string `mapstructure:"log-level"`
 	PGHost                 string `mapstructure:"pg-host"`
@@ -23,6 +91,7 @@ type Config struct {
 	DTPGPassword           string `mapstructure:"dt-pg-password"`
 	DTPGSSLMode            string `mapstructure:"dt-pg-sslmode"`
 	OpenAIAPIKey           string `mapstructure:"openai-api-key"`
+	LLMProvider            string `mapstructure:"llm-provider"`
 	LLMBaseURL             string `mapstructure:"llm-base-url"`
 	LLMChatModel           string `mapstructure:"llm-chat-model"`
 	LLMCodeModel           string `mapstructure:"llm-code-model"`
@@ -30,6 +99,193 @@ type Config struct {
 	LLMEmbeddingDimensions int64  `mapstructure:"llm-embedding-dimensions"`
 	InitialQuery           string `mapstructure:"initial-query"`
 	ProjectRoot            string `mapstructure:"project-root"`
+	APIStyle               string `mapstructure:"api-style"`
+	RepositoryLayer        bool   `mapstructure:"repository-layer"`
+	ServiceLayer           bool   `mapstructure:"service-layer"`
+	FileStorage            string `mapstructure:"file-storage"`
+	BulkEndpoints          bool   `mapstructure:"bulk-endpoints"`
+	RateLimit              bool   `mapstructure:"rate-limit"`
+	CORS                   bool   `mapstructure:"cors"`
+	MultiTenant            bool   `mapstructure:"multi-tenant"`
+	APIVersioning          bool   `mapstructure:"api-versioning"`
+	LintSeverity           string `mapstructure:"lint-severity"`
+	APICollection          string `mapstructure:"api-collection"`
+	ToolsDir               string `mapstructure:"tools-dir"`
+	StrictVerification     bool   `mapstructure:"strict-verification"`
+	GoFormatter            string `mapstructure:"go-formatter"`
+	GoLocalPrefix          string `mapstructure:"go-local-prefix"`
+	Resume                 string `mapstructure:"resume"`
+	Workflow               string `mapstructure:"workflow"`
+	PlanFirst              bool   `mapstructure:"plan-first"`
+	GitCommits             bool   `mapstructure:"git-commits"`
+	Export                 string `mapstructure:"export"`
+	Import                 string `mapstructure:"import"`
+	// Connection pools of the project and the DoubleTab databases, which tools running in parallel share.
+	PGMaxOpenConns      int           `mapstructure:"pg-max-open-conns"`
+	PGMaxIdleConns      int           `mapstructure:"pg-max-idle-conns"`
+	PGConnMaxLifetime   time.Duration `mapstructure:"pg-conn-max-lifetime"`
+	DTPGMaxOpenConns    int           `mapstructure:"dt-pg-max-open-conns"`
+	DTPGMaxIdleConns    int           `mapstructure:"dt-pg-max-idle-conns"`
+	DTPGConnMaxLifetime time.Duration `mapstructure:"dt-pg-conn-max-lifetime"`
+	// PGDriver is the driver (pq, pgx) of the connections to the project and DoubleTab databases and of the database
+	// layer of the generated project.
+	PGDriver string `mapstructure:"pg-driver"`
+	// NamingTables (plural, singular, keep) and NamingIDColumn, where {table} stands for the singular table name, are
+	// the naming conventions generated schemas are renamed to before they're stored. Primary keys keep their names
+	// without NamingIDColumn.
+	NamingTables   string `mapstructure:"naming-tables"`
+	NamingIDColumn string `mapstructure:"naming-id-column"`
+	// FileHeader is a file whose text, e.g. a license, heads every generated file as a comment. GeneratedMarker adds a
+	// line marking the files as generated, with the session which generated them.
+	FileHeader      string `mapstructure:"file-header"`
+	GeneratedMarker bool   `mapstructure:"generated-marker"`
+	// KnowledgeCacheLimit is the number of knowledge entries up to which the knowledge base is searched in memory.
+	KnowledgeCacheLimit int `mapstructure:"knowledge-cache-limit"`
+	// CompactTokens is the estimated size of conversations whose older messages are replaced with a summary, keeping
+	// the last CompactKeepMessages messages.
+	CompactTokens       int `mapstructure:"compact-tokens"`
+	CompactKeepMessages int `mapstructure:"compact-keep-messages"`
+	// GitRemote, PRBase, Forge, and the API URLs and tokens of the forges configure publishing pull requests of the
+	// generated code. The forge is detected by the host of the remote when Forge is empty.
+	GitRemote    string `mapstructure:"git-remote"`
+	PRBase       string `mapstructure:"pr-base"`
+	Forge        string `mapstructure:"forge"`
+	GitHubAPIURL string `mapstructure:"github-api-url"`
+	GitHubToken  string `mapstructure:"github-token"`
+	GitLabAPIURL string `mapstructure:"gitlab-api-url"`
+	GitLabToken  string `mapstructure:"gitlab-token"`
+	GiteaAPIURL  string `mapstructure:"gitea-api-url"`
+	GiteaToken   string `mapstructure:"gitea-token"`
+	// WatchInterval is how often the watch command checks the OpenAPI spec for changes.
+	WatchInterval time.Duration `mapstructure:"watch-interval"`
+	// Hooks are shell commands run after tools complete, given as post-<event>=<command>.
+	Hooks []string `mapstructure:"hook"`
+	// Webhooks are URLs notified of workflow events, given as [<event>=]<URL>. Their payloads are signed with
+	// WebhookSecret, when it's set.
+	Webhooks      []string `mapstructure:"webhook"`
+	WebhookSecret string   `mapstructure:"webhook-secret"`
+	// Force takes the lock of the project over from another instance of DoubleTab, and resumes sessions whose branches
+	// ran since.
+	Force bool `mapstructure:"force"`
+	// Requirements is the YAML file describing the project generated by the run command.
+	Requirements string `mapstructure:"requirements"`
+	// AuditFile is a JSONL file every tool invocation is appended to, in addition to the audit table.
+	AuditFile string `mapstructure:"audit-file"`
+	// AuditSession, AuditTool, and AuditLimit select the entries printed by the audit command.
+	AuditSession string `mapstructure:"audit-session"`
+	AuditTool    string `mapstructure:"audit-tool"`
+	AuditLimit   int    `mapstructure:"audit-limit"`
+	// ReplaySpeed is the speed sessions are replayed at relative to their original timing, zero replays them at once.
+	ReplaySpeed float64 `mapstructure:"replay-speed"`
+	// OTLPEndpoint is the OTLP/HTTP endpoint traces of the session are exported to, tracing is disabled without it.
+	OTLPEndpoint string `mapstructure:"otlp-endpoint"`
+	// MetricsAddr is the address Prometheus metrics of the session are served at, under /metrics, disabled without it.
+	MetricsAddr string `mapstructure:"metrics-addr"`
+	// DiagnosticsAddr is the loopback address pprof profiles and runtime statistics are served at, under /debug/,
+	// disabled without it.
+	DiagnosticsAddr string `mapstructure:"diagnostics-addr"`
+	// ServeAddr is the address the serve command serves the API at. Requests must bear ServeToken, when it's set.
+	ServeAddr  string `mapstructure:"serve-addr"`
+	ServeToken string `mapstructure:"serve-token"`
+	// SlackBotToken and SlackSigningSecret connect the serve command to a Slack app, whose threads become sessions.
+	SlackBotToken      string `mapstructure:"slack-bot-token"`
+	SlackSigningSecret string `mapstructure:"slack-signing-secret"`
+	// Observability is the LLM observability platform (langfuse, langsmith) traces of the session are exported to, with
+	// prompts, completions, and tool inputs and outputs.
+	Observability     string `mapstructure:"observability"`
+	LangfuseHost      string `mapstructure:"langfuse-host"`
+	LangfusePublicKey string `mapstructure:"langfuse-public-key"`
+	LangfuseSecretKey string `mapstructure:"langfuse-secret-key"`
+	LangSmithEndpoint string `mapstructure:"langsmith-endpoint"`
+	LangSmithAPIKey   string `mapstructure:"langsmith-api-key"`
+	LangSmithProject  string `mapstructure:"langsmith-project"`
+	// LLMLog is a JSONL file full requests to the LLM API and their responses are logged to, with credentials redacted.
+	LLMLog string `mapstructure:"llm-log"`
+	// Redact are regular expressions of further text redacted from the LLM log, besides API keys and passwords.
+	Redact []string `mapstructure:"redact"`
+	// BudgetUSD and BudgetTokens are the estimated cost and the tokens after which the session pauses to ask whether to
+	// continue. Zero disables them.
+	BudgetUSD    float64 `mapstructure:"budget-usd"`
+	BudgetTokens int64   `mapstructure:"budget-tokens"`
+	// LLMPrices override the prices of models the cost is estimated with, as prompt/completion USD per million tokens.
+	LLMPrices map[string]string `mapstructure:"llm-prices"`
+	// ShowTokens prints the tokens used by every turn of the model, and by the session so far, after the turn.
+	ShowTokens bool `mapstructure:"show-tokens"`
+	// Telemetry reports anonymous usage of sessions, which tools ran and how often they succeeded, to
+	// TelemetryEndpoint, which has no default. It's disabled by DO_NOT_TRACK, and set only by the user, never by the
+	// config file of the project.
+	Telemetry         bool   `mapstructure:"telemetry"`
+	TelemetryEndpoint string `mapstructure:"telemetry-endpoint"`
+	// LogFile is the file logs are written to instead of the terminal, rotated once it reaches LogMaxSize megabytes,
+	// keeping LogMaxBackups rotated files.
+	LogFile       string `mapstructure:"log-file"`
+	LogMaxSize    int    `mapstructure:"log-max-size"`
+	LogMaxBackups int    `mapstructure:"log-max-backups"`
+	// Approvals are the approval policies of tools (auto, prompt, deny) by tool name, "*" for all other tools.
+	// ProjectApprovals are the ones of the config file of the project, which Approvals override.
+	Approvals        map[string]string `mapstructure:"approvals"`
+	ProjectApprovals map[string]string `mapstructure:"-"`
+	// Warnings are about settings of the config file of the project which were ignored.
+	Warnings []string `mapstructure:"-"`
+	// Command is the command given as the first argument, empty for an interactive session.
+	Command string `mapstructure:"-"`
+	// CommandArgs are the arguments given after the command.
+	CommandArgs []string `mapstructure:"-"`
+}
+
+// projectSettings are the settings read from the config file of the project: options of the generated code and the
+// workflow, which can't leak credentials or run commands. Approval policies are read from it too, separately.
+var projectSettings = []string{
+	"api-style", "repository-layer", "service-layer", "file-storage", "bulk-endpoints", "rate-limit", "cors",
+	"multi-tenant", "api-versioning", "naming-tables", "naming-id-column", "lint-severity", "strict-verification",
+	"api-collection", "generated-marker", "go-formatter", "go-local-prefix", "pg-driver", "plan-first", "git-commits",
+	"pr-base", "workflow", "compact-tokens", "compact-keep-messages", "watch-interval", "redact",
+}
+
+// LoopbackAddr reports whether the address of a server only accepts connections from the machine itself.
+func LoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// idColumnRegexp matches snake_case names of id columns.
+var idColumnRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// secretSettings are the settings holding credentials.
+var secretSettings = []string{"pg-password", "dt-pg-password", "openai-api-key", "langfuse-secret-key", "langsmith-api-key", "github-token",
+	"gitlab-token", "gitea-token", "serve-token", "slack-bot-token", "slack-signing-secret",
+	"webhook-secret"}
+
+// Secrets returns the credentials of the configuration, for redacting them from output shared by the user.
+func (c *Config) Secrets() []string {
+	return []string{c.PGPassword, c.DTPGPassword, c.OpenAIAPIKey, c.LangfuseSecretKey, c.LangSmithAPIKey, c.GitHubToken,
+		c.GitLabToken, c.GiteaToken, c.ServeToken, c.SlackBotToken, c.SlackSigningSecret,
+		c.WebhookSecret}
+}
+
+// Redacted returns the settings of the configuration by name, with credentials replaced by a placeholder.
+func (c *Config) Redacted() map[string]any {
+	settings := make(map[string]any)
+	v := reflect.ValueOf(*c)
+	for i := range v.NumField() {
+		name := v.Type().Field(i).Tag.Get("mapstructure")
+		if name == "" || name == "-" {
+			continue
+		}
+		value := v.Field(i).Interface()
+		if slices.Contains(secretSettings, name) && !v.Field(i).IsZero() {
+			value = redact.Placeholder
+		}
+		settings[name] = value
+	}
+	return settings
 }
 
 func Load() (*Config, error) {
@@ -38,12 +294,19 @@ func Load() (*Config, error) {
 	viper.AutomaticEnv()
 
 	pflag.String("log-level", "warn", "Log level (debug, info, warn, error)")
+	pflag.String("log-file", "", "File logs are written to instead of the terminal, which keeps only fatal errors")
+	pflag.Int("log-max-size", 10, "Size in megabytes after which the log file is rotated")
+	pflag.Int("log-max-backups", 3, "Number of rotated log files kept")
 	pflag.String("pg-host", "localhost", "PostgreSQL host")
 	pflag.Int("pg-port", 5432, "PostgreSQL port")
 	pflag.String("pg-database", "", "PostgreSQL database name")
 	pflag.String("pg-user", "", "PostgreSQL username")
 	pflag.String("pg-password", "", "PostgreSQL password")
 	pflag.String("pg-sslmode", "disable", "PostgreSQL SSL mode")
+	pflag.String("pg-driver", "pq", "PostgreSQL driver of the database connections and the generated project (pq, pgx)")
+	pflag.Int("pg-max-open-conns", 10, "Maximum open connections to the PostgreSQL database (0 for unlimited)")
+	pflag.Int("pg-max-idle-conns", 5, "Maximum idle connections kept to the PostgreSQL database")
+	pflag.Duration("pg-conn-max-lifetime", 30*time.Minute, "Maximum lifetime of connections to the PostgreSQL database (0 for unlimited)")
 
 	pflag.String("dt-pg-host", "localhost", "DoubleTab PostgreSQL host")
 	pflag.Int("dt-pg-port", 5432, "DoubleTab PostgreSQL port")
@@ -51,26 +314,263 @@ func Load() (*Config, error) {
 	pflag.String("dt-pg-user", "", "DoubleTab PostgreSQL username")
 	pflag.String("dt-pg-password", "", "DoubleTab PostgreSQL password")
 	pflag.String("dt-pg-sslmode", "disable", "DoubleTab PostgreSQL SSL mode")
+	pflag.Int("dt-pg-max-open-conns", 10, "Maximum open connections to the DoubleTab PostgreSQL database (0 for unlimited)")
+	pflag.Int("dt-pg-max-idle-conns", 5, "Maximum idle connections kept to the DoubleTab PostgreSQL database")
+	pflag.Duration("dt-pg-conn-max-lifetime", 30*time.Minute, "Maximum lifetime of connections to the DoubleTab PostgreSQL database (0 for unlimited)")
 
 	pflag.String("openai-api-key", "", "OpenAI API key")
-	pflag.String("llm-base-url", "", "Base URL for LLM API")
+	pflag.String("llm-provider", llm.ProviderOpenAI, fmt.Sprintf("LLM provider (%s)", strings.Join(llm.Providers(), ", ")))
+	pflag.String("llm-base-url", "", "Base URL for LLM API, overriding the default endpoint of the provider")
 	pflag.String("llm-chat-model", "gpt-4o", "Chat model for LLM")
 	pflag.String("llm-code-model", "gpt-4o", "Code model for LLM")
 	pflag.String("llm-embedding-model", "text-embedding-ada-002", "Embedding model for LLM")
-	pflag.Int64("llm-embedding-dimensions", 1536, "Embedding dimensions for LLM")
+	pflag.Int64("llm-embedding-dimensions", 0, "Embedding dimensions for LLM, used when they can't be detected from the model (0 requires detecting them)")
+	pflag.Int("compact-tokens", 60000, "Summarize older messages of conversations estimated to exceed this many tokens (0 disables it)")
+	pflag.Int("compact-keep-messages", 20, "Recent messages kept verbatim when conversations are summarized")
+	pflag.Int("knowledge-cache-limit", 5000, "Search knowledge bases of at most this many entries in memory instead of the database (0 disables it)")
 
 	pflag.String("initial-query", "", "Initial query for processing")
 	pflag.String("project-root", "", "Project root directory")
+	pflag.String("api-style", "openapi", "API style of the generated project (openapi, graphql)")
+	pflag.Bool("repository-layer", false, "Generate a repository package used by the handlers instead of inline SQL")
+	pflag.Bool("service-layer", false, "Generate a service layer with business rule stubs (implies --repository-layer)")
+	pflag.String("file-storage", "local", "Storage of files uploaded to the generated project (local, s3)")
+	pflag.Bool("bulk-endpoints", false, "Generate batch create/update endpoints for every resource")
+	pflag.Bool("rate-limit", false, "Generate token bucket rate limiting middleware (per API key or client IP)")
+	pflag.Bool("cors", false, "Generate configurable CORS middleware")
+	pflag.Bool("multi-tenant", false, "Generate tenant-aware schemas and handlers, scoping every query to the tenant of the request")
+	pflag.Bool("api-versioning", false, "Serve the generated API under versioned base paths (/v1), so later versions can be served alongside")
+	pflag.String("naming-tables", "keep", "Naming convention of generated tables (plural, singular, keep)")
+	pflag.String("naming-id-column", "", "Name of the primary key column of generated tables, {table} stands for the singular table name, e.g. {table}_id, kept as generated without it")
+	pflag.String("lint-severity", "error", "Minimum severity of golangci-lint findings the generated code must be fixed for (error, warning, none)")
+	pflag.Bool("strict-verification", false, "Vet the generated code and run its tests with the race detector whenever it's built")
+	pflag.String("api-collection", "postman", "Collection of example requests of every endpoint written with the OpenAPI spec (postman, bruno, none)")
+	pflag.String("tools-dir", "", "Directory missing code generation tools are installed into (default ~/.doubletab/bin)")
+	pflag.String("file-header", "", "File whose text, e.g. a license, is added as a comment to the top of every generated file")
+	pflag.Bool("generated-marker", false, "Mark generated files with a comment naming the session which generated them")
+	pflag.String("go-formatter", "gofmt", "Formatter of the generated Go code (gofmt, gofumpt)")
+	pflag.String("go-local-prefix", "", "Comma separated import path prefixes grouped after third-party imports in the generated Go code")
+	pflag.String("resume", "", "ID of a session to resume from its first incomplete step")
+	pflag.Bool("plan-first", false, "Write a PLAN.md of the project and wait for its approval before generating anything")
+	pflag.Bool("git-commits", false, "Commit the files of every completed step to the git repository of the project, initializing it if needed")
+	pflag.String("git-remote", "origin", "Git remote branches of pull requests are pushed to")
+	pflag.String("pr-base", "", "Branch pull requests are opened against (default the default branch of the repository)")
+	pflag.String("forge", "", "Forge pull requests are opened on (github, gitlab, gitea; default detected by the host of the git remote)")
+	pflag.String("github-api-url", "", "GitHub API URL pull requests are opened with (default https://api.github.com for github.com, else https://<host of the git remote>/api/v3)")
+	pflag.String("github-token", "", "GitHub token pull requests are opened with")
+	pflag.String("gitlab-api-url", "", "GitLab API URL merge requests are opened with (default https://<host of the git remote>/api/v4)")
+	pflag.String("gitlab-token", "", "GitLab token merge requests are opened with")
+	pflag.String("gitea-api-url", "", "Gitea API URL pull requests are opened with (default https://<host of the git remote>/api/v1)")
+	pflag.String("gitea-token", "", "Gitea token pull requests are opened with")
+	pflag.String("workflow", "", "YAML file defining a custom workflow (steps, prompt, tools, models) used instead of the built-in one")
+	pflag.String("export", "", "Export the session given with --resume to a bundle file (transcript, memory, workflow state, lock file) and exit")
+	pflag.String("import", "", "Import the session of a bundle file exported with --export into the project and resume it")
+	pflag.Duration("watch-interval", time.Second, "How often the watch command checks the OpenAPI spec for changes")
+	pflag.StringArray("hook", nil, "Shell command run after tools complete, as post-<event>=<command> (events: post-save, post-spec, post-schema, post-handlers, post-server, post-<tool>); repeatable")
+	pflag.StringArray("webhook", nil, "URL notified of workflow events, as [<event>=]<URL> (events: step.completed, build.failed, workflow.completed); repeatable")
+	pflag.String("webhook-secret", "", "Secret webhook payloads are signed with, in the X-DoubleTab-Signature header")
+	pflag.Bool("force", false, "Start even when another DoubleTab instance holds the lock of the project root, taking it over, or when branches of the resumed session ran since")
+	pflag.String("requirements", "", "YAML file describing the entities and options of the project, generated non-interactively by the run command")
+	pflag.StringToString("approvals", nil, "Approval policies of tools, as tool=policy (auto, prompt, deny), * for all other tools")
+	pflag.String("audit-file", "", "JSONL file every tool invocation is appended to, in addition to the audit table of the DoubleTab database")
+	pflag.String("audit-session", "", "Session whose tool invocations the audit command prints (default all sessions)")
+	pflag.String("audit-tool", "", "Tool whose invocations the audit command prints (default all tools)")
+	pflag.Int("audit-limit", 100, "Number of the latest tool invocations the audit command prints")
+	pflag.Float64("replay-speed", 0, "Speed sessions are replayed at relative to their original timing, e.g. 2 for twice as fast (default without pauses)")
+	pflag.String("otlp-endpoint", "", "OTLP/HTTP endpoint (e.g. http://localhost:4318) traces of the session are exported to, for Jaeger or Tempo")
+	pflag.String("metrics-addr", "", "Address (e.g. :9090) Prometheus metrics of the session are served at, under /metrics")
+	pflag.String("diagnostics-addr", "", "Loopback address (e.g. localhost:6060) pprof profiles and runtime statistics are served at, under /debug/")
+	pflag.String("serve-addr", "localhost:8484", "Address the serve command serves the API at")
+	pflag.String("serve-token", "", "Bearer token requests to the API of the serve command must be authorized with")
+	pflag.String("slack-bot-token", "", "Bot token of the Slack app the serve command drives sessions from")
+	pflag.String("slack-signing-secret", "", "Signing secret of the Slack app, verifying its requests")
+	pflag.String("observability", "", "LLM observability platform (langfuse, langsmith) agent runs are exported to, with prompts, completions, and tool calls")
+	pflag.String("langfuse-host", "https://cloud.langfuse.com", "Langfuse host traces are exported to")
+	pflag.String("langfuse-public-key", "", "Langfuse public key")
+	pflag.String("langfuse-secret-key", "", "Langfuse secret key")
+	pflag.String("langsmith-endpoint", "https://api.smith.langchain.com", "LangSmith API endpoint traces are exported to")
+	pflag.String("langsmith-api-key", "", "LangSmith API key")
+	pflag.String("langsmith-project", "", "LangSmith project traces are exported to (default the default project)")
+	pflag.String("llm-log", "", "JSONL file full prompts and completions are logged to for debugging, with API keys and passwords redacted")
+	pflag.StringArray("redact", nil, "Regular expression of text redacted from the LLM log, besides API keys and passwords; repeatable")
+	pflag.Float64("budget-usd", 0, "Estimated cost in USD after which the session pauses to ask whether to continue (default no limit)")
+	pflag.Int64("budget-tokens", 0, "Tokens after which the session pauses to ask whether to continue (default no limit)")
+	pflag.StringToString("llm-prices", nil, "Prices of models the cost is estimated with, as model=prompt/completion USD per million tokens, e.g. gpt-4o=2.5/10")
+	pflag.Bool("telemetry", false, "Report anonymous usage (which tools ran and how often they succeeded, never content) to help prioritize development")
+	pflag.String("telemetry-endpoint", "", "Endpoint anonymous usage is reported to, required by --telemetry")
+	pflag.Bool("show-tokens", false, "Show the tokens used by every turn of the model, and by the session so far, after the turn")
 	pflag.Parse()
 
 	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
 		return nil, fmt.Errorf("unable to bind pflags: %v", err)
 	}
 
+	// Settings of the project, e.g. its approval policies, are read from the config file in its root. Flags and
+	// environment variables override them. The file comes with the project, which may not be trusted, so only
+	// projectSettings are read from it, never credentials, endpoints, or commands.
+	var warnings []string
+	var projectApprovals map[string]string
+	configFile := filepath.Join(os.Getenv("PROJECT_ROOT"), projectConfigFile)
+	if _, err := os.Stat(configFile); err == nil {
+		project := viper.New()
+		project.SetConfigFile(configFile)
+		if err := project.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("unable to read config file %s: %v", projectConfigFile, err)
+		}
+		settings := make(map[string]interface{})
+		for _, key := range slices.Sorted(maps.Keys(project.AllSettings())) {
+			switch {
+			case key == "approvals":
+				projectApprovals = project.GetStringMapString(key)
+			case slices.Contains(projectSettings, key):
+				settings[key] = project.Get(key)
+			default:
+				warnings = append(warnings, fmt.Sprintf("Ignoring %s of %s, it can only be set with flags or environment variables", key, projectConfigFile))
+			}
+		}
+		if err := viper.MergeConfigMap(settings); err != nil {
+			return nil, fmt.Errorf("unable to read config file %s: %v", projectConfigFile, err)
+		}
+	}
+
 	cfg := Config{}
 	if err := viper.Unmarshal(&cfg); err != nil {
 		return nil, fmt.Errorf("unable to unmarshal config: %v", err)
 	}
+	cfg.ProjectApprovals = projectApprovals
+	cfg.Warnings = warnings
+
+	if cfg.APIStyle != "openapi" && cfg.APIStyle != "graphql" {
+		return nil, fmt.Errorf("unsupported api style: %s", cfg.APIStyle)
+	}
+	if cfg.FileStorage != "local" && cfg.FileStorage != "s3" {
+		return nil, fmt.Errorf("unsupported file storage: %s", cfg.FileStorage)
+	}
+	if cfg.LintSeverity != "error" && cfg.LintSeverity != "warning" && cfg.LintSeverity != "none" {
+		return nil, fmt.Errorf("unsupported lint severity: %s", cfg.LintSeverity)
+	}
+	if !slices.Contains(llm.Providers(), cfg.LLMProvider) {
+		return nil, fmt.Errorf("unsupported LLM provider: %s", cfg.LLMProvider)
+	}
+	if cfg.NamingTables != "plural" && cfg.NamingTables != "singular" && cfg.NamingTables != "keep" {
+		return nil, fmt.Errorf("unsupported table naming convention: %s", cfg.NamingTables)
+	}
+	if !idColumnRegexp.MatchString(strings.ReplaceAll(cfg.NamingIDColumn, "{table}", "t")) {
+		return nil, fmt.Errorf("invalid id column name %q, it must be a snake_case name", cfg.NamingIDColumn)
+	}
+	if cfg.PGDriver != "pq" && cfg.PGDriver != "pgx" {
+		return nil, fmt.Errorf("unsupported PostgreSQL driver: %s", cfg.PGDriver)
+	}
+	if cfg.APICollection != "postman" && cfg.APICollection != "bruno" && cfg.APICollection != "none" {
+		return nil, fmt.Errorf("unsupported API collection: %s", cfg.APICollection)
+	}
+	if cfg.GoFormatter != "gofmt" && cfg.GoFormatter != "gofumpt" {
+		return nil, fmt.Errorf("unsupported go formatter: %s", cfg.GoFormatter)
+	}
+	for _, approvals := range []map[string]string{cfg.Approvals, cfg.ProjectApprovals} {
+		for tool, policy := range approvals {
+			if policy != "auto" && policy != "prompt" && policy != "deny" {
+				return nil, fmt.Errorf("unsupported approval policy of %s: %s", tool, policy)
+			}
+		}
+	}
+
+	cfg.Command = pflag.Arg(0)
+	if pflag.NArg() > 1 {
+		cfg.CommandArgs = pflag.Args()[1:]
+	}
+	switch cfg.Command {
+	case "", CommandWatch, CommandHistory, CommandAudit, CommandRun, CommandServe, CommandEditor:
+	case CommandStats:
+		if cfg.DiagnosticsAddr == "" {
+			return nil, fmt.Errorf("the %s command requires --diagnostics-addr of the instance", CommandStats)
+		}
+	case CommandDebugBundle:
+		if len(cfg.CommandArgs) != 1 {
+			return nil, fmt.Errorf("the %s command requires the session ID", CommandDebugBundle)
+		}
+	case CommandTelemetry:
+		if len(cfg.CommandArgs) != 1 || cfg.CommandArgs[0] != TelemetryStatus {
+			return nil, fmt.Errorf("usage: %s %s", CommandTelemetry, TelemetryStatus)
+		}
+	case CommandSessions:
+		if len(cfg.CommandArgs) != 2 || cfg.CommandArgs[0] != SessionsReplay {
+			return nil, fmt.Errorf("usage: %s %s <session ID>", CommandSessions, SessionsReplay)
+		}
+	default:
+		return nil, fmt.Errorf("unknown command: %s", cfg.Command)
+	}
+	if cfg.Command != CommandStats && cfg.DiagnosticsAddr != "" && !LoopbackAddr(cfg.DiagnosticsAddr) {
+		return nil, fmt.Errorf("diagnostics can only be served at loopback addresses, not at %s", cfg.DiagnosticsAddr)
+	}
+	if cfg.Command == CommandServe && cfg.ServeToken == "" && !LoopbackAddr(cfg.ServeAddr) {
+		return nil, fmt.Errorf("the %s command requires --serve-token when serving at %s, which isn't a loopback address", CommandServe, cfg.ServeAddr)
+	}
+	if (cfg.Command == CommandRun) != (cfg.Requirements != "") {
+		return nil, fmt.Errorf("the %s command requires --requirements, which is only used by it", CommandRun)
+	}
+	if (cfg.SlackBotToken != "") != (cfg.SlackSigningSecret != "") {
+		return nil, fmt.Errorf("--slack-bot-token and --slack-signing-secret are required together")
+	}
+	if dnt := os.Getenv("DO_NOT_TRACK"); dnt != "" && dnt != "0" && dnt != "false" {
+		cfg.Telemetry = false
+	}
+	if cfg.Telemetry && cfg.TelemetryEndpoint == "" {
+		return nil, fmt.Errorf("--telemetry requires --telemetry-endpoint")
+	}
+	switch cfg.Forge {
+	case "", ForgeGitHub, ForgeGitLab, ForgeGitea:
+	default:
+		return nil, fmt.Errorf("unsupported forge: %s", cfg.Forge)
+	}
+	if cfg.ReplaySpeed < 0 {
+		return nil, fmt.Errorf("invalid replay speed: %v", cfg.ReplaySpeed)
+	}
+	if cfg.AuditLimit <= 0 {
+		return nil, fmt.Errorf("invalid audit limit: %d", cfg.AuditLimit)
+	}
+	switch cfg.Observability {
+	case "":
+	case ObservabilityLangfuse:
+		if cfg.LangfusePublicKey == "" || cfg.LangfuseSecretKey == "" {
+			return nil, fmt.Errorf("%s requires --langfuse-public-key and --langfuse-secret-key", ObservabilityLangfuse)
+		}
+	case ObservabilityLangSmith:
+		if cfg.LangSmithAPIKey == "" {
+			return nil, fmt.Errorf("%s requires --langsmith-api-key", ObservabilityLangSmith)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LLM observability platform: %s", cfg.Observability)
+	}
+	if cfg.BudgetUSD < 0 || cfg.BudgetTokens < 0 {
+		return nil, fmt.Errorf("invalid budget: $%.2f, %d tokens", cfg.BudgetUSD, cfg.BudgetTokens)
+	}
+	if cfg.LogMaxSize <= 0 || cfg.LogMaxBackups < 0 {
+		return nil, fmt.Errorf("invalid log rotation: %d MB, %d backups", cfg.LogMaxSize, cfg.LogMaxBackups)
+	}
+	if cfg.PGMaxOpenConns < 0 || cfg.PGMaxIdleConns < 0 || cfg.PGConnMaxLifetime < 0 {
+		return nil, fmt.Errorf("invalid PostgreSQL connection pool: %d open, %d idle, %s lifetime",
+			cfg.PGMaxOpenConns, cfg.PGMaxIdleConns, cfg.PGConnMaxLifetime)
+	}
+	if cfg.DTPGMaxOpenConns < 0 || cfg.DTPGMaxIdleConns < 0 || cfg.DTPGConnMaxLifetime < 0 {
+		return nil, fmt.Errorf("invalid DoubleTab PostgreSQL connection pool: %d open, %d idle, %s lifetime",
+			cfg.DTPGMaxOpenConns, cfg.DTPGMaxIdleConns, cfg.DTPGConnMaxLifetime)
+	}
+	if cfg.CompactTokens < 0 || cfg.CompactKeepMessages <= 0 {
+		return nil, fmt.Errorf("invalid conversation compaction: %d tokens, %d messages kept", cfg.CompactTokens, cfg.CompactKeepMessages)
+	}
+	if cfg.KnowledgeCacheLimit < 0 {
+		return nil, fmt.Errorf("invalid knowledge cache limit: %d", cfg.KnowledgeCacheLimit)
+	}
+	if cfg.WatchInterval <= 0 {
+		return nil, fmt.Errorf("invalid watch interval: %s", cfg.WatchInterval)
+	}
+	if cfg.Export != "" && cfg.Resume == "" {
+		return nil, fmt.Errorf("--export requires the session to export given with --resume")
+	}
+	if cfg.Export != "" && cfg.Import != "" {
+		return nil, fmt.Errorf("--export and --import can't be used together")
+	}
 
 	return &cfg, nil
 }
@@ -17,28 +17,56 @@ https://github.com/doubletabai/doubletab/discussions/categories/ideas.
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
+// queryTimeout bounds every database call made by a handler.
+const queryTimeout = 5 * time.Second
+
 type Server struct {
 	DB *sqlx.DB
 }
 
+// resourceRow maps nullable columns to sql.Null types before converting them to the API model.
+type resourceRow struct {
+	Id    openapi_types.UUID ` + "`json:\"id\"`" + `
+	Name  string             ` + "`json:\"name\"`" + `
+	Email sql.NullString     ` + "`json:\"email\"`" + `
+}
+
+func (row resourceRow) toResource() Resource {
+	resource := Resource{Id: row.Id, Name: row.Name}
+	if row.Email.Valid {
+		resource.Email = &row.Email.String
+	}
+	return resource
+}
+
 func (s Server) ListResources(w http.ResponseWriter, r *http.Request) {
-	resources := []Resource{}
-	err := s.DB.SelectContext(r.Context(), &resources, "SELECT * FROM resources")
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	rows := []resourceRow{}
+	err := s.DB.SelectContext(ctx, &rows, "SELECT id, name, email FROM resources")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	resources := make([]Resource, 0, len(rows))
+	for _, row := range rows {
+		resources = append(resources, row.toResource())
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	if err = json.NewEncoder(w).Encode(resources); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -47,8 +75,11 @@ func (s Server) ListResources(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s Server) GetResource(w http.ResponseWriter, r *http.Request, id openapi_types.UUID) {
-	resource := Resource{}
-	err := s.DB.GetContext(r.Context(), &resource, "SELECT * FROM resources WHERE id = $1", id)
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	row := resourceRow{}
+	err := s.DB.GetContext(ctx, &row, "SELECT id, name, email FROM resources WHERE id = $1", id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			http.Error(w, err.Error(), http.StatusNotFound)
@@ -59,7 +90,7 @@ func (s Server) GetResource(w http.ResponseWriter, r *http.Request, id openapi_t
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	if err = json.NewEncoder(w).Encode(resource); err != nil {
+	if err = json.NewEncoder(w).Encode(row.toResource()); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -74,11 +105,20 @@ func (s Server) CreateResource(w http.ResponseWriter, r *http.Request) {
 
 	resource.Id = uuid.New()
 
-	_, err := s.DB.NamedExecContext(r.Context(), "INSERT INTO resources (id, name, email) VALUES (:id, name, :email)", &resource)
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	stmt, err := s.DB.PrepareNamedContext(ctx, "INSERT INTO resources (id, name, email) VALUES (:id, :name, :email)")
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	defer stmt.Close()
+
+	if _, err := stmt.ExecContext(ctx, resource); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
 	w.WriteHeader(http.StatusCreated)
 }
@@ -91,7 +131,17 @@ func (s Server) UpdateResource(w http.ResponseWriter, r *http.Request, id openap
 	}
 	resource.Id = id
 
-	res, err := s.DB.NamedExecContext(r.Context(), "UPDATE resources SET name = :name, email = :email WHERE id = :id", resource)
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	stmt, err := s.DB.PrepareNamedContext(ctx, "UPDATE resources SET name = :name, email = :email WHERE id = :id")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, resource)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -112,7 +162,10 @@ func (s Server) UpdateResource(w http.ResponseWriter, r *http.Request, id openap
 }
 
 func (s Server) DeleteResource(w http.ResponseWriter, r *http.Request, id openapi_types.UUID) {
-	_, err := s.DB.ExecContext(r.Context(), "DELETE FROM resources WHERE id = $1", id)
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	_, err := s.DB.ExecContext(ctx, "DELETE FROM resources WHERE id = $1", id)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -120,16 +173,629 @@ func (s Server) DeleteResource(w http.ResponseWriter, r *http.Request, id openap
 
 	w.WriteHeader(http.StatusNoContent)
 }`
+	sampleResolversGo = `Example of gqlgen resolvers implementation in Go based on GraphQL schema.
+
+package graph
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
 )
 
-func Populate(ctx context.Context, db *vector.KnowledgeService) error {
-	if err := db.Store(ctx, sampleOtherDB); err != nil {
-		return err
+// queryTimeout bounds every database call made by a resolver.
+const queryTimeout = 5 * time.Second
+
+func (r *mutationResolver) CreateResource(ctx context.Context, input ResourceInput) (*Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resource := &Resource{
+		ID:    uuid.NewString(),
+		Name:  input.Name,
+		Email: input.Email,
+	}
+	_, err := r.DB.NamedExecContext(ctx, "INSERT INTO resources (id, name, email) VALUES (:id, :name, :email)", resource)
+	if err != nil {
+		return nil, err
+	}
+	return resource, nil
+}
+
+func (r *mutationResolver) UpdateResource(ctx context.Context, id string, input ResourceInput) (*Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resource := &Resource{
+		ID:    id,
+		Name:  input.Name,
+		Email: input.Email,
+	}
+	res, err := r.DB.NamedExecContext(ctx, "UPDATE resources SET name = :name, email = :email WHERE id = :id", resource)
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("resource %s not found", id)
+	}
+	return resource, nil
+}
+
+func (r *mutationResolver) DeleteResource(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, "DELETE FROM resources WHERE id = $1", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (r *queryResolver) Resources(ctx context.Context) ([]*Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resources := []*Resource{}
+	if err := r.DB.SelectContext(ctx, &resources, "SELECT id, name, email FROM resources"); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
+
+func (r *queryResolver) Resource(ctx context.Context, id string) (*Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resource := &Resource{}
+	err := r.DB.GetContext(ctx, resource, "SELECT id, name, email FROM resources WHERE id = $1", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return resource, nil
+}
+
+// Mutation returns MutationResolver implementation.
+func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
+
+// Query returns QueryResolver implementation.
+func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
+
+type mutationResolver struct{ *Resolver }
+type queryResolver struct{ *Resolver }`
+	sampleRepositoryGo = `Example of a repository layer in Go: the Repository interface lives in the API package (api or graph) next
+to the generated types, the Postgres implementation lives in the repository package, and handlers only use the
+interface through the Repo field.
+
+// pkg/api/repository.go
+package api
+
+import (
+	"context"
+	"errors"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+var ErrNotFound = errors.New("not found")
+
+type Repository interface {
+	ListResources(ctx context.Context) ([]Resource, error)
+	GetResource(ctx context.Context, id openapi_types.UUID) (Resource, error)
+	CreateResource(ctx context.Context, resource Resource) error
+	UpdateResource(ctx context.Context, resource Resource) error
+	DeleteResource(ctx context.Context, id openapi_types.UUID) error
+}
+
+// pkg/repository/postgres.go
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	openapi_types "github.com/oapi-codegen/runtime/types"
+
+	"myApp/pkg/api"
+)
+
+// queryTimeout bounds every database call made by the repository.
+const queryTimeout = 5 * time.Second
+
+type Postgres struct {
+	DB *sqlx.DB
+}
+
+func NewPostgres(db *sqlx.DB) *Postgres {
+	return &Postgres{DB: db}
+}
+
+func (p *Postgres) ListResources(ctx context.Context) ([]api.Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resources := []api.Resource{}
+	err := p.DB.SelectContext(ctx, &resources, "SELECT id, name, email FROM resources")
+	return resources, err
+}
+
+func (p *Postgres) GetResource(ctx context.Context, id openapi_types.UUID) (api.Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	resource := api.Resource{}
+	err := p.DB.GetContext(ctx, &resource, "SELECT id, name, email FROM resources WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resource, api.ErrNotFound
 	}
+	return resource, err
+}
+
+func (p *Postgres) CreateResource(ctx context.Context, resource api.Resource) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
 
-	if err := db.Store(ctx, sampleServerGo); err != nil {
+	_, err := p.DB.NamedExecContext(ctx, "INSERT INTO resources (id, name, email) VALUES (:id, :name, :email)", resource)
+	return err
+}
+
+func (p *Postgres) UpdateResource(ctx context.Context, resource api.Resource) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	res, err := p.DB.NamedExecContext(ctx, "UPDATE resources SET name = :name, email = :email WHERE id = :id", resource)
+	if err != nil {
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return api.ErrNotFound
+	}
+	return nil
+}
+
+func (p *Postgres) DeleteResource(ctx context.Context, id openapi_types.UUID) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, "DELETE FROM resources WHERE id = $1", id)
+	return err
+}
+
+// pkg/api/server.go
+package api
+
+type Server struct {
+	Repo Repository
+}
+
+func (s Server) GetResource(w http.ResponseWriter, r *http.Request, id openapi_types.UUID) {
+	resource, err := s.Repo.GetResource(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(resource); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}`
+	sampleCachedRepositoryGo = `Example of a cache-aside repository in Go: the Cached struct wraps another Repository, reads go
+through the Redis cache from the generated cache package, and writes invalidate the cached item and lists of the
+resource. Cache errors are logged and never fail the request.
+
+// pkg/repository/cached.go
+package repository
+
+import (
+	"context"
+	"log"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+
+	"myApp/pkg/api"
+	"myApp/pkg/cache"
+)
+
+type Cached struct {
+	next  api.Repository
+	cache *cache.Cache
+}
+
+func NewCached(next api.Repository, c *cache.Cache) *Cached {
+	return &Cached{next: next, cache: c}
+}
+
+func (c *Cached) ListResources(ctx context.Context) ([]api.Resource, error) {
+	const key = "resources:list:"
+	var resources []api.Resource
+	if ok, err := c.cache.Get(ctx, key, &resources); err != nil {
+		log.Printf("Failed to read cache %s: %v", key, err)
+	} else if ok {
+		return resources, nil
+	}
+
+	resources, err := c.next.ListResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.cache.Set(ctx, key, resources); err != nil {
+		log.Printf("Failed to write cache %s: %v", key, err)
+	}
+	return resources, nil
+}
+
+func (c *Cached) GetResource(ctx context.Context, id openapi_types.UUID) (api.Resource, error) {
+	key := "resources:" + id.String()
+	var resource api.Resource
+	if ok, err := c.cache.Get(ctx, key, &resource); err != nil {
+		log.Printf("Failed to read cache %s: %v", key, err)
+	} else if ok {
+		return resource, nil
+	}
 
+	resource, err := c.next.GetResource(ctx, id)
+	if err != nil {
+		return api.Resource{}, err
+	}
+	if err := c.cache.Set(ctx, key, resource); err != nil {
+		log.Printf("Failed to write cache %s: %v", key, err)
+	}
+	return resource, nil
+}
+
+func (c *Cached) UpdateResource(ctx context.Context, resource api.Resource) error {
+	if err := c.next.UpdateResource(ctx, resource); err != nil {
+		return err
+	}
+	c.invalidate(ctx, "resources:"+resource.Id.String())
 	return nil
 }
+
+func (c *Cached) invalidate(ctx context.Context, key string) {
+	if err := c.cache.Invalidate(ctx, key); err != nil {
+		log.Printf("Failed to invalidate cache %s: %v", key, err)
+	}
+	if err := c.cache.InvalidatePrefix(ctx, "resources:list:"); err != nil {
+		log.Printf("Failed to invalidate cached lists of resources: %v", err)
+	}
+}`
+	sampleOutboxGo = `Example of a repository method in Go publishing events with the transactional outbox pattern: the change
+and the event written by the generated outbox package are committed in a single transaction, and the relay worker
+publishes the event afterwards.
+
+// pkg/repository/postgres.go
+func (p *Postgres) UpdateResource(ctx context.Context, resource api.Resource) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	tx, err := p.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.NamedExecContext(ctx, "UPDATE resources SET name = :name WHERE id = :id", resource)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return api.ErrNotFound
+	}
+
+	if err := outbox.Write(ctx, tx, "resource.updated", resource.Id.String(), resource); err != nil {
+		return err
+	}
+	return tx.Commit()
+}`
+	sampleMultiTenantGo = `Example of tenant scoped queries in Go: the tenant of the request is set in the context by the generated
+tenant middleware, every query filters by the tenant_id column, and inserts set it, so tenants never see or change each
+other's data. The tenant_id is never part of the API types.
+
+// pkg/repository/postgres.go
+func (p *Postgres) GetResource(ctx context.Context, id openapi_types.UUID) (api.Resource, error) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	var row resourceRow
+	err := p.DB.GetContext(ctx, &row, "SELECT id, name FROM resources WHERE tenant_id = $1 AND id = $2",
+		tenant.FromContext(ctx), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return api.Resource{}, api.ErrNotFound
+	} else if err != nil {
+		return api.Resource{}, err
+	}
+	return row.toResource(), nil
+}
+
+func (p *Postgres) CreateResource(ctx context.Context, resource api.Resource) error {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	_, err := p.DB.ExecContext(ctx, "INSERT INTO resources (tenant_id, id, name) VALUES ($1, $2, $3)",
+		tenant.FromContext(ctx), resource.Id, resource.Name)
+	return err
+}`
+	sampleServiceGo = `Example of a service layer in Go with business rule stubs: the Service interface lives in the API package
+(api or graph) next to the generated types, the implementation lives in the service package on top of the Repository
+interface, and handlers only use the Service interface through the Svc field.
+
+// pkg/api/service.go
+package api
+
+import (
+	"context"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+)
+
+type Service interface {
+	ListOrders(ctx context.Context) ([]Order, error)
+	GetOrder(ctx context.Context, id openapi_types.UUID) (Order, error)
+	CreateOrder(ctx context.Context, order Order) error
+	UpdateOrder(ctx context.Context, order Order) error
+	DeleteOrder(ctx context.Context, id openapi_types.UUID) error
+}
+
+// pkg/service/service.go
+package service
+
+import (
+	"context"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+
+	"myApp/pkg/api"
+)
+
+type Service struct {
+	Repo api.Repository
+}
+
+func New(repo api.Repository) *Service {
+	return &Service{Repo: repo}
+}
+
+func (s *Service) ListOrders(ctx context.Context) ([]api.Order, error) {
+	return s.Repo.ListOrders(ctx)
+}
+
+func (s *Service) GetOrder(ctx context.Context, id openapi_types.UUID) (api.Order, error) {
+	return s.Repo.GetOrder(ctx, id)
+}
+
+func (s *Service) CreateOrder(ctx context.Context, order api.Order) error {
+	// TODO(business-rule): orders over $100 need approval.
+	// Set the order status to pending approval when the total exceeds 100 and notify the approvers.
+
+	return s.Repo.CreateOrder(ctx, order)
+}
+
+func (s *Service) UpdateOrder(ctx context.Context, order api.Order) error {
+	// TODO(business-rule): shipped orders can't be modified.
+	// Load the current order and reject the update when its status is shipped.
+
+	return s.Repo.UpdateOrder(ctx, order)
+}
+
+func (s *Service) DeleteOrder(ctx context.Context, id openapi_types.UUID) error {
+	return s.Repo.DeleteOrder(ctx, id)
+}`
+	sampleFileUploadGo = `Example of file upload and download handlers in Go, using the generated storage package. The documents
+table stores the file name and content type, the file content is kept in the storage.
+
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path"
+
+	openapi_types "github.com/oapi-codegen/runtime/types"
+
+	"myApp/pkg/storage"
+)
+
+// maxUploadSize limits the size of uploaded files to 32 MB.
+const maxUploadSize = 32 << 20
+
+func (s Server) UploadDocumentFile(w http.ResponseWriter, r *http.Request, id openapi_types.UUID) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	key := path.Join("documents", id.String(), "file")
+	if err := s.Storage.Save(ctx, key, file); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res, err := s.DB.ExecContext(ctx, "UPDATE documents SET file_name = $1, file_content_type = $2 WHERE id = $3",
+		header.Filename, header.Header.Get("Content-Type"), id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s Server) DownloadDocumentFile(w http.ResponseWriter, r *http.Request, id openapi_types.UUID) {
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+
+	var contentType string
+	if err := s.DB.GetContext(ctx, &contentType, "SELECT file_content_type FROM documents WHERE id = $1", id); err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	file, err := s.Storage.Open(ctx, path.Join("documents", id.String(), "file"))
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	if _, err := io.Copy(w, file); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}`
+	sampleBatchGo = `Example of a batch create/update handler in Go based on OpenAPI 3.0 spec. All items are upserted in a single
+transaction with a savepoint per item, so failed items are reported without losing the others, unless the client asked
+for an atomic batch.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// batchTimeout bounds the whole batch, which takes longer than a single query.
+const batchTimeout = 30 * time.Second
+
+func (s Server) BatchResources(w http.ResponseWriter, r *http.Request, params BatchResourcesParams) {
+	var req BatchResourcesJSONRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Items) > 1000 {
+		http.Error(w, "too many items, the limit is 1000", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), batchTimeout)
+	defer cancel()
+
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareNamedContext(ctx, "INSERT INTO resources (id, name, email) VALUES (:id, :name, :email) "+
+		"ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, email = EXCLUDED.email")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer stmt.Close()
+
+	failed := false
+	results := make([]BatchItemResult, 0, len(req.Items))
+	for i, resource := range req.Items {
+		if resource.Id == uuid.Nil {
+			resource.Id = uuid.New()
+		}
+		if _, err := tx.ExecContext(ctx, "SAVEPOINT item"); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if _, err := stmt.ExecContext(ctx, resource); err != nil {
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT item"); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			failed = true
+			msg := err.Error()
+			results = append(results, BatchItemResult{Index: i, Error: &msg})
+			continue
+		}
+		results = append(results, BatchItemResult{Index: i, Id: &resource.Id})
+	}
+
+	status := http.StatusOK
+	switch {
+	case failed && params.Atomic != nil && *params.Atomic:
+		// Returning without commit rolls back the whole batch.
+		status = http.StatusUnprocessableEntity
+	case failed:
+		status = http.StatusMultiStatus
+		fallthrough
+	default:
+		if err := tx.Commit(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(BatchResult{Results: results}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}`
+)
+
+// entries are the contents of the built-in knowledge base.
+var entries = []string{
+	sampleOtherDB,
+	sampleServerGo,
+	sampleResolversGo,
+	sampleRepositoryGo,
+	sampleCachedRepositoryGo,
+	sampleOutboxGo,
+	sampleMultiTenantGo,
+	sampleServiceGo,
+	sampleFileUploadGo,
+	sampleBatchGo,
+}
+
+// Populate stores the built-in knowledge base, embedding only the entries which aren't stored yet.
+func Populate(ctx context.Context, db *vector.KnowledgeService) error {
+	return db.StoreAll(ctx, entries)
+}
@@ -0,0 +1,135 @@
+// Package llm abstracts the LLM APIs DoubleTab talks to as providers. Conversations, tools, and tool calls are
+// expressed in the chat completion types of openai-go, which OpenAI-compatible APIs take as they are, and which other
+// providers translate from and to, so the workflow and the tools don't depend on the API they run with.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Provider is an LLM API serving chat completions with tool calls and embeddings.
+type Provider interface {
+	// Complete returns the completion of the conversation, with the tool calls of the model.
+	Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error)
+	// Stream streams the completion of the conversation in chunks.
+	Stream(ctx context.Context, params openai.ChatCompletionNewParams) Stream
+	// Embed returns the embeddings of the texts by the model, in the order of the texts.
+	Embed(ctx context.Context, model string, texts []string) ([][]float32, error)
+	// Models lists the models the API serves, or returns ErrNoModelList when it doesn't list them.
+	Models(ctx context.Context) ([]string, error)
+}
+
+// Stream is a streamed completion. Closing it ends the response, and Err returns the error which stopped it.
+type Stream interface {
+	Next() bool
+	Current() openai.ChatCompletionChunk
+	Err() error
+	Close() error
+}
+
+// ErrNoModelList is returned by providers whose API doesn't list its models.
+var ErrNoModelList = errors.New("the API doesn't list its models")
+
+// APIError is an error response of the API.
+type APIError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Options configure a provider.
+type Options struct {
+	// BaseURL is the endpoint of the API, or the default endpoint of the provider when it's empty.
+	BaseURL string
+	APIKey  string
+	// Middleware intercepts the HTTP requests to the API, e.g. to trace them or hold them back once the budget is
+	// exceeded.
+	Middleware []option.Middleware
+}
+
+// Factory creates a provider with the options.
+type Factory func(opts Options) (Provider, error)
+
+type registration struct {
+	factory Factory
+	baseURL string
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]registration)
+)
+
+// Register makes the provider available by the name, with the default endpoint of its API. Providers register
+// themselves in init functions, so adding one doesn't touch the code using them.
+func Register(name, baseURL string, factory Factory) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+
+	if _, ok := providers[name]; ok {
+		panic(fmt.Sprintf("llm provider %s registered twice", name))
+	}
+	providers[name] = registration{factory: factory, baseURL: baseURL}
+}
+
+// Providers returns the names of the registered providers, sorted.
+func Providers() []string {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	return slices.Sorted(maps.Keys(providers))
+}
+
+// BaseURL returns the endpoint of the API of the provider by name with the base URL, which overrides the default
+// endpoint of the provider when it's set.
+func BaseURL(name, baseURL string) string {
+	if baseURL != "" {
+		return baseURL
+	}
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	return providers[name].baseURL
+}
+
+// New creates the provider registered by the name.
+func New(name string, opts Options) (Provider, error) {
+	providersMu.RLock()
+	r, ok := providers[name]
+	providersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown LLM provider %s", name)
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = r.baseURL
+	}
+	return r.factory(opts)
+}
+
+// noRetriesKey is the context key of requests which aren't retried.
+type noRetriesKey struct{}
+
+// WithoutRetries returns the context of requests which fail at the first error instead of being retried, like checks
+// which should fail fast.
+func WithoutRetries(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noRetriesKey{}, true)
+}
+
+// Retried reports whether requests with the context are retried by the provider.
+func Retried(ctx context.Context) bool {
+	noRetries, _ := ctx.Value(noRetriesKey{}).(bool)
+	return !noRetries
+}
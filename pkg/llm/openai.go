@@ -0,0 +1,141 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// Providers of OpenAI-compatible APIs, served by OpenAI and by local and hosted servers alike.
+const (
+	ProviderOpenAI   = "openai"
+	ProviderOllama   = "ollama"
+	ProviderLlamaCpp = "llamacpp"
+	ProviderGemini   = "gemini"
+)
+
+func init() {
+	Register(ProviderOpenAI, "https://api.openai.com/v1", NewOpenAI)
+	Register(ProviderOllama, "http://127.0.0.1:11434/v1", NewOpenAI)
+	Register(ProviderLlamaCpp, "http://127.0.0.1:8080/v1", NewOpenAI)
+	Register(ProviderGemini, "https://generativelanguage.googleapis.com/v1beta/openai", NewOpenAI)
+}
+
+// OpenAI is the provider of OpenAI-compatible APIs, which take the requests of the workflow as they are.
+type OpenAI struct {
+	cli *openai.Client
+	// withoutStreamUsage is set once the API rejected the stream_options of a streamed completion, which some
+	// OpenAI-compatible servers, like older builds of Ollama and llama.cpp, don't know. Completions are streamed without
+	// usage from then on, which isn't counted toward the budget then.
+	withoutStreamUsage atomic.Bool
+}
+
+func NewOpenAI(opts Options) (Provider, error) {
+	reqOpts := []option.RequestOption{option.WithBaseURL(opts.BaseURL)}
+	if opts.APIKey != "" {
+		reqOpts = append(reqOpts, option.WithAPIKey(opts.APIKey))
+	}
+	if len(opts.Middleware) > 0 {
+		reqOpts = append(reqOpts, option.WithMiddleware(opts.Middleware...))
+	}
+	return &OpenAI{cli: openai.NewClient(reqOpts...)}, nil
+}
+
+func (p *OpenAI) Complete(ctx context.Context, params openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
+	completion, err := p.cli.Chat.Completions.New(ctx, params, requestOptions(ctx)...)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if len(completion.Choices) == 0 {
+		return nil, errors.New("the model responded without a completion")
+	}
+	return completion, nil
+}
+
+// Stream streams the completion. When the API rejects the request with its stream options, it's sent again without
+// them.
+func (p *OpenAI) Stream(ctx context.Context, params openai.ChatCompletionNewParams) Stream {
+	if !params.StreamOptions.Present {
+		return p.cli.Chat.Completions.NewStreaming(ctx, params, requestOptions(ctx)...)
+	}
+	withoutOptions := params
+	withoutOptions.StreamOptions = openai.ChatCompletionNewParams{}.StreamOptions
+	if p.withoutStreamUsage.Load() {
+		return p.cli.Chat.Completions.NewStreaming(ctx, withoutOptions, requestOptions(ctx)...)
+	}
+	stream := p.cli.Chat.Completions.NewStreaming(ctx, params, requestOptions(ctx)...)
+	var apiErr *openai.Error
+	if !errors.As(stream.Err(), &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		return stream
+	}
+	stream.Close()
+	retried := p.cli.Chat.Completions.NewStreaming(ctx, withoutOptions, requestOptions(ctx)...)
+	if retried.Err() == nil {
+		p.withoutStreamUsage.Store(true)
+	}
+	return retried
+}
+
+func (p *OpenAI) Embed(ctx context.Context, model string, texts []string) ([][]float32, error) {
+	resp, err := p.cli.Embeddings.New(ctx, openai.EmbeddingNewParams{
+		Input:          openai.F[openai.EmbeddingNewParamsInputUnion](openai.EmbeddingNewParamsInputArrayOfStrings(texts)),
+		Model:          openai.String(model),
+		EncodingFormat: openai.F(openai.EmbeddingNewParamsEncodingFormatFloat),
+	}, requestOptions(ctx)...)
+	if err != nil {
+		return nil, apiError(err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("got %d embeddings of %d texts", len(resp.Data), len(texts))
+	}
+	embeddings := make([][]float32, len(texts))
+	for _, data := range resp.Data {
+		if data.Index < 0 || data.Index >= int64(len(texts)) {
+			return nil, fmt.Errorf("got embedding of unknown text %d", data.Index)
+		}
+		embedding := make([]float32, len(data.Embedding))
+		for i, v := range data.Embedding {
+			embedding[i] = float32(v)
+		}
+		embeddings[data.Index] = embedding
+	}
+	return embeddings, nil
+}
+
+func (p *OpenAI) Models(ctx context.Context) ([]string, error) {
+	models, err := p.cli.Models.List(ctx, requestOptions(ctx)...)
+	if err != nil {
+		var apiErr *openai.Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, ErrNoModelList
+		}
+		return nil, apiError(err)
+	}
+	ids := make([]string, 0, len(models.Data))
+	for _, m := range models.Data {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
+
+// requestOptions returns the options of the requests with the context.
+func requestOptions(ctx context.Context) []option.RequestOption {
+	if !Retried(ctx) {
+		return []option.RequestOption{option.WithMaxRetries(0)}
+	}
+	return nil
+}
+
+// apiError returns error responses of the API as APIError.
+func apiError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return &APIError{StatusCode: apiErr.StatusCode, Err: err}
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+// Package redact removes credentials from text written to disk, e.g. logged prompts and completions, so debug output
+// can be shared without leaking API keys or database passwords.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Placeholder replaces redacted credentials.
+const Placeholder = "[REDACTED]"
+
+// builtinPatterns match common credentials regardless of the configured secrets and patterns. The value of patterns
+// with a group is only the part matched by the group, so the name of the credential stays readable.
+var builtinPatterns = []*regexp.Regexp{
+	// API keys of OpenAI and compatible providers.
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`),
+	// Bearer tokens of Authorization headers.
+	regexp.MustCompile(`(?i)\bbearer\s+([A-Za-z0-9._~+/=-]{8,})`),
+	// Passwords in PostgreSQL URLs and other URLs with user info.
+	regexp.MustCompile(`[a-z][a-z0-9+.-]*://[^\s:/@'"]+:([^\s@'"]+)@`),
+	// Quoted passwords, e.g. in connection strings (password='secret') and JSON ("password": "secret").
+	regexp.MustCompile(`(?i)password\\?['"]?\s*[=:]\s*\\?['"]([^'"\\]+)`),
+	// Unquoted passwords of connection strings and environment variables, e.g. PG_PASSWORD=secret.
+	regexp.MustCompile(`(?i)password=([^\s'"\\&;]+)`),
+}
+
+// minSecretLength is the length of the shortest secret redacted verbatim, as shorter values like "postgres" would
+// redact unrelated text.
+const minSecretLength = 6
+
+// Redactor redacts known secrets and text matching patterns.
+type Redactor struct {
+	secrets  []string
+	patterns []*regexp.Regexp
+}
+
+// New returns a redactor of the secrets, e.g. the configured API key and database passwords, and of text matching the
+// patterns, in addition to common credentials. Empty and short secrets are ignored.
+func New(secrets []string, patterns []string) (*Redactor, error) {
+	r := &Redactor{patterns: slices.Clone(builtinPatterns)}
+	for _, secret := range secrets {
+		if len(secret) < minSecretLength || slices.Contains(r.secrets, secret) {
+			continue
+		}
+		r.secrets = append(r.secrets, secret)
+		// Secrets with quotes or backslashes are escaped in JSON bodies.
+		if encoded, _ := json.Marshal(secret); string(encoded[1:len(encoded)-1]) != secret {
+			r.secrets = append(r.secrets, string(encoded[1:len(encoded)-1]))
+		}
+	}
+	// Longer secrets first, so secrets containing others are redacted whole.
+	slices.SortFunc(r.secrets, func(a, b string) int { return len(b) - len(a) })
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redaction pattern %q: %w", pattern, err)
+		}
+		r.patterns = append(r.patterns, re)
+	}
+	return r, nil
+}
+
+// Redact replaces the secrets and text matching the patterns with Placeholder.
+func (r *Redactor) Redact(text string) string {
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, Placeholder)
+	}
+	for _, re := range r.patterns {
+		text = replaceMatches(re, text)
+	}
+	return text
+}
+
+// replaceMatches replaces matches of the pattern, or only their first group when the pattern has groups.
+func replaceMatches(re *regexp.Regexp, text string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(text, Placeholder)
+	}
+	var sb strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+		if m[2] < 0 {
+			continue
+		}
+		sb.WriteString(text[last:m[2]])
+		sb.WriteString(Placeholder)
+		last = m[3]
+	}
+	sb.WriteString(text[last:])
+	return sb.String()
+}
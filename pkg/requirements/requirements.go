@@ -0,0 +1,191 @@
+// Package requirements describes a project declaratively: its entities and the options it's generated with, so the
+// whole workflow can run without a conversation, e.g. to regenerate a service in CI whenever its requirements change.
+package requirements
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+)
+
+// Field is a field of an entity.
+type Field struct {
+	Name string `yaml:"name"`
+	// Type is the type of the field in plain words, e.g. string, integer, timestamp, or a reference to another entity.
+	Type        string `yaml:"type"`
+	Required    bool   `yaml:"required,omitempty"`
+	Unique      bool   `yaml:"unique,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// Entity is a resource of the API, stored in its own table.
+type Entity struct {
+	Name        string  `yaml:"name"`
+	Description string  `yaml:"description,omitempty"`
+	Fields      []Field `yaml:"fields"`
+	// Rules are business rules of the entity, e.g. validations and limits.
+	Rules []string `yaml:"rules,omitempty"`
+}
+
+// Options override the generation options of the command line. Unset options keep their values.
+type Options struct {
+	APIStyle        *string `yaml:"api_style,omitempty"`
+	RepositoryLayer *bool   `yaml:"repository_layer,omitempty"`
+	ServiceLayer    *bool   `yaml:"service_layer,omitempty"`
+	FileStorage     *string `yaml:"file_storage,omitempty"`
+	BulkEndpoints   *bool   `yaml:"bulk_endpoints,omitempty"`
+	RateLimit       *bool   `yaml:"rate_limit,omitempty"`
+	CORS            *bool   `yaml:"cors,omitempty"`
+	MultiTenant     *bool   `yaml:"multi_tenant,omitempty"`
+	APIVersioning   *bool   `yaml:"api_versioning,omitempty"`
+	LintSeverity    *string `yaml:"lint_severity,omitempty"`
+}
+
+// Requirements describe the project to generate.
+type Requirements struct {
+	Name        string   `yaml:"name"`
+	Description string   `yaml:"description"`
+	Options     Options  `yaml:"options,omitempty"`
+	Entities    []Entity `yaml:"entities"`
+	// Notes are further requirements in plain words, e.g. which endpoints are read-heavy and should be cached.
+	Notes []string `yaml:"notes,omitempty"`
+}
+
+// Load reads the requirements from the YAML file.
+func Load(name string) (Requirements, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Requirements{}, fmt.Errorf("failed to open requirements: %w", err)
+	}
+	defer f.Close()
+
+	var r Requirements
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&r); err != nil {
+		return Requirements{}, fmt.Errorf("failed to parse requirements %s: %w", name, err)
+	}
+	if err := r.Validate(); err != nil {
+		return Requirements{}, fmt.Errorf("invalid requirements %s: %w", name, err)
+	}
+	return r, nil
+}
+
+// Validate checks that there are entities, that entities and their fields are named uniquely, and that the options
+// have supported values.
+func (r Requirements) Validate() error {
+	if r.Name == "" {
+		return errors.New("project without name")
+	}
+	if len(r.Entities) == 0 {
+		return fmt.Errorf("project %s has no entities", r.Name)
+	}
+	entities := make(map[string]bool)
+	for _, e := range r.Entities {
+		if e.Name == "" {
+			return errors.New("entity without name")
+		}
+		if entities[e.Name] {
+			return fmt.Errorf("duplicate entity %s", e.Name)
+		}
+		entities[e.Name] = true
+		if len(e.Fields) == 0 {
+			return fmt.Errorf("entity %s has no fields", e.Name)
+		}
+		fields := make(map[string]bool)
+		for _, f := range e.Fields {
+			if f.Name == "" || f.Type == "" {
+				return fmt.Errorf("field of entity %s without name or type", e.Name)
+			}
+			if fields[f.Name] {
+				return fmt.Errorf("duplicate field %s of entity %s", f.Name, e.Name)
+			}
+			fields[f.Name] = true
+		}
+	}
+
+	o := r.Options
+	if o.APIStyle != nil && *o.APIStyle != "openapi" && *o.APIStyle != "graphql" {
+		return fmt.Errorf("unsupported api style: %s", *o.APIStyle)
+	}
+	if o.FileStorage != nil && *o.FileStorage != "local" && *o.FileStorage != "s3" {
+		return fmt.Errorf("unsupported file storage: %s", *o.FileStorage)
+	}
+	if o.LintSeverity != nil && *o.LintSeverity != "error" && *o.LintSeverity != "warning" && *o.LintSeverity != "none" {
+		return fmt.Errorf("unsupported lint severity: %s", *o.LintSeverity)
+	}
+	return nil
+}
+
+// Apply sets the options of the requirements in the configuration.
+func (r Requirements) Apply(cfg *config.Config) {
+	o := r.Options
+	set(&cfg.APIStyle, o.APIStyle)
+	set(&cfg.RepositoryLayer, o.RepositoryLayer)
+	set(&cfg.ServiceLayer, o.ServiceLayer)
+	set(&cfg.FileStorage, o.FileStorage)
+	set(&cfg.BulkEndpoints, o.BulkEndpoints)
+	set(&cfg.RateLimit, o.RateLimit)
+	set(&cfg.CORS, o.CORS)
+	set(&cfg.MultiTenant, o.MultiTenant)
+	set(&cfg.APIVersioning, o.APIVersioning)
+	set(&cfg.LintSeverity, o.LintSeverity)
+}
+
+func set[T any](dst *T, value *T) {
+	if value != nil {
+		*dst = *value
+	}
+}
+
+// Prompt describes the requirements to the model, in place of the conversation agreeing on them with the user.
+func (r Requirements) Prompt() string {
+	var sb strings.Builder
+	sb.WriteString("Build the project described by the requirements below. The requirements are final, they were agreed on " +
+		"with the user already: don't ask questions or for confirmations, go through all steps of the workflow one after " +
+		"another, and fix failures on your own.\n\n")
+	fmt.Fprintf(&sb, "# %s\n\n", r.Name)
+	if r.Description != "" {
+		fmt.Fprintf(&sb, "%s\n\n", strings.TrimSpace(r.Description))
+	}
+	sb.WriteString("## Entities\n")
+	for _, e := range r.Entities {
+		fmt.Fprintf(&sb, "\n### %s\n\n", e.Name)
+		if e.Description != "" {
+			fmt.Fprintf(&sb, "%s\n\n", strings.TrimSpace(e.Description))
+		}
+		sb.WriteString("Fields:\n")
+		for _, f := range e.Fields {
+			traits := []string{f.Type}
+			if f.Required {
+				traits = append(traits, "required")
+			}
+			if f.Unique {
+				traits = append(traits, "unique")
+			}
+			fmt.Fprintf(&sb, "- %s (%s)", f.Name, strings.Join(traits, ", "))
+			if f.Description != "" {
+				fmt.Fprintf(&sb, ": %s", f.Description)
+			}
+			sb.WriteString("\n")
+		}
+		if len(e.Rules) > 0 {
+			sb.WriteString("\nBusiness rules:\n")
+			for _, rule := range e.Rules {
+				fmt.Fprintf(&sb, "- %s\n", rule)
+			}
+		}
+	}
+	if len(r.Notes) > 0 {
+		sb.WriteString("\n## Notes\n\n")
+		for _, note := range r.Notes {
+			fmt.Fprintf(&sb, "- %s\n", note)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,104 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go/option"
+)
+
+// ErrBudgetExceeded is returned for requests to the LLM API once the session exceeded its budget and wasn't allowed to
+// continue.
+var ErrBudgetExceeded = errors.New("session budget exceeded")
+
+// Budget limits the spend of the session on the LLM API, by its estimated cost, its tokens, or both. Once a limit is
+// crossed, requests pause until the user allows the session to spend another budget, so runaway repair loops don't burn
+// through the API quota.
+type Budget struct {
+	// MaxCost is the budget in USD, MaxTokens in tokens. Zero disables the limit.
+	MaxCost   float64
+	MaxTokens int64
+	Prices    Prices
+	// Confirm asks the user whether the session continues. Sessions without it stop once the budget is exceeded.
+	Confirm func(question string) bool
+
+	mu         sync.Mutex
+	costLimit  float64
+	tokenLimit int64
+	stopped    bool
+}
+
+// NewBudget returns the budget of the session, of which nothing is spent yet.
+func NewBudget(maxCost float64, maxTokens int64, prices Prices) *Budget {
+	return &Budget{
+		MaxCost:    maxCost,
+		MaxTokens:  maxTokens,
+		Prices:     prices,
+		costLimit:  maxCost,
+		tokenLimit: maxTokens,
+	}
+}
+
+// Limits describes the current limits of the budget, raised every time the user allowed the session to continue. It
+// returns an empty string when the budget has no limits.
+func (b *Budget) Limits() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return formatBudget(b.costLimit, b.tokenLimit)
+}
+
+// Middleware holds requests to the LLM API back once the budget is exceeded, failing them with ErrBudgetExceeded
+// unless the user allows the session to continue.
+func (b *Budget) Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	if err := b.check(); err != nil {
+		return nil, err
+	}
+	return next(req)
+}
+
+// check asks the user whether to continue when the spend crossed a limit, raising the limits by another budget when
+// they do. Concurrent requests wait for the answer.
+func (b *Budget) check() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stopped {
+		return ErrBudgetExceeded
+	}
+	tokens, cost := Spend(b.Prices)
+	costExceeded := b.MaxCost > 0 && cost >= b.costLimit
+	tokensExceeded := b.MaxTokens > 0 && tokens >= b.tokenLimit
+	if !costExceeded && !tokensExceeded {
+		return nil
+	}
+
+	question := fmt.Sprintf("The session used %d tokens for about $%.2f, exceeding its budget of %s. Continue with another %s?",
+		tokens, cost, formatBudget(b.costLimit, b.tokenLimit), formatBudget(b.MaxCost, b.MaxTokens))
+	if b.Confirm == nil || !b.Confirm(question) {
+		b.stopped = true
+		return ErrBudgetExceeded
+	}
+	if b.MaxCost > 0 {
+		b.costLimit = cost + b.MaxCost
+	}
+	if b.MaxTokens > 0 {
+		b.tokenLimit = tokens + b.MaxTokens
+	}
+	return nil
+}
+
+// formatBudget describes the limits of a budget, omitting disabled ones.
+func formatBudget(cost float64, tokens int64) string {
+	var limits []string
+	if cost > 0 {
+		limits = append(limits, fmt.Sprintf("$%.2f", cost))
+	}
+	if tokens > 0 {
+		limits = append(limits, fmt.Sprintf("%d tokens", tokens))
+	}
+	return strings.Join(limits, " and ")
+}
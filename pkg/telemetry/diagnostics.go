@@ -0,0 +1,116 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// statsPath is the path runtime statistics are served at by the diagnostics server.
+const statsPath = "/debug/stats"
+
+// started is when the process started, for the uptime in runtime statistics.
+var started = time.Now()
+
+// RuntimeStats are statistics of the running process, for finding leaks and stalls of long-running sessions.
+type RuntimeStats struct {
+	Uptime     time.Duration `json:"uptime"`
+	Goroutines int           `json:"goroutines"`
+	// HeapAlloc and HeapSys are the bytes of allocated heap objects and of the heap obtained from the OS.
+	HeapAlloc   uint64 `json:"heap_alloc"`
+	HeapSys     uint64 `json:"heap_sys"`
+	HeapObjects uint64 `json:"heap_objects"`
+	NumGC       uint32 `json:"num_gc"`
+	// DBs are the connection pool statistics of the databases by name.
+	DBs map[string]DBStats `json:"dbs"`
+}
+
+type DBStats struct {
+	MaxOpen   int           `json:"max_open"`
+	Open      int           `json:"open"`
+	InUse     int           `json:"in_use"`
+	Idle      int           `json:"idle"`
+	WaitCount int64         `json:"wait_count"`
+	WaitTime  time.Duration `json:"wait_time"`
+}
+
+// CollectStats returns the runtime statistics of the process with the connection pools of the databases.
+func CollectStats(dbs map[string]*sql.DB) RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	stats := RuntimeStats{
+		Uptime:      time.Since(started).Round(time.Second),
+		Goroutines:  runtime.NumGoroutine(),
+		HeapAlloc:   mem.HeapAlloc,
+		HeapSys:     mem.HeapSys,
+		HeapObjects: mem.HeapObjects,
+		NumGC:       mem.NumGC,
+		DBs:         make(map[string]DBStats, len(dbs)),
+	}
+	for name, db := range dbs {
+		s := db.Stats()
+		stats.DBs[name] = DBStats{
+			MaxOpen:   s.MaxOpenConnections,
+			Open:      s.OpenConnections,
+			InUse:     s.InUse,
+			Idle:      s.Idle,
+			WaitCount: s.WaitCount,
+			WaitTime:  s.WaitDuration,
+		}
+	}
+	return stats
+}
+
+// ServeDiagnostics serves the profiles of net/http/pprof under /debug/pprof/ and the runtime statistics with the
+// connection pools of the databases under /debug/stats at the address. The profiles expose the internals of the
+// process, so the address is reachable only locally. The command line isn't served, as it may hold secrets like the
+// API key.
+func ServeDiagnostics(addr string, dbs map[string]*sql.DB) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc(statsPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(CollectStats(dbs)); err != nil {
+			log.Err(err).Msg("Failed to write runtime statistics")
+		}
+	})
+	// CPU profiles and traces take 30 seconds by default, so the server has no write timeout.
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msgf("Failed to serve diagnostics at %s", addr)
+		}
+	}()
+}
+
+// FetchStats returns the runtime statistics of the process serving diagnostics at the address.
+func FetchStats(ctx context.Context, addr string) (RuntimeStats, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+statsPath, nil)
+	if err != nil {
+		return RuntimeStats{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return RuntimeStats{}, fmt.Errorf("failed to reach diagnostics at %s: %w", addr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return RuntimeStats{}, fmt.Errorf("diagnostics at %s responded with %s", addr, resp.Status)
+	}
+	var stats RuntimeStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return RuntimeStats{}, fmt.Errorf("failed to parse runtime statistics: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,137 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/redact"
+)
+
+// LLMLog logs full requests to the LLM API and their responses to a JSONL file, with credentials redacted, to debug
+// bad generations.
+type LLMLog struct {
+	name     string
+	redactor *redact.Redactor
+	mu       sync.Mutex
+}
+
+// llmLogEntry is a line of the log. Request and response bodies are logged as JSON when they are, and as text
+// otherwise, e.g. streamed completions.
+type llmLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	Request    any       `json:"request,omitempty"`
+	Response   any       `json:"response,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// NewLLMLog returns the log appending to the file, redacting bodies with the redactor.
+func NewLLMLog(name string, redactor *redact.Redactor) *LLMLog {
+	return &LLMLog{name: name, redactor: redactor}
+}
+
+// Middleware logs the request and its response, once the response is read to the end or closed. Headers aren't
+// logged, as they carry the API key.
+func (l *LLMLog) Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	entry := llmLogEntry{
+		Time:   time.Now().UTC(),
+		Method: req.Method,
+		URL:    req.URL.String(),
+	}
+	if req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := io.ReadAll(body)
+			body.Close()
+			entry.Request = l.body(data)
+		}
+	}
+
+	resp, err := next(req)
+	if err != nil {
+		entry.DurationMS = time.Since(entry.Time).Milliseconds()
+		entry.Error = l.redactor.Redact(err.Error())
+		l.write(entry)
+		return resp, err
+	}
+	entry.Status = resp.StatusCode
+	resp.Body = &loggedBody{ReadCloser: resp.Body, log: l, entry: entry}
+	return resp, nil
+}
+
+// body returns the redacted body for the log entry.
+func (l *LLMLog) body(data []byte) any {
+	if len(data) == 0 {
+		return nil
+	}
+	redacted := l.redactor.Redact(string(data))
+	if json.Valid([]byte(redacted)) {
+		return json.RawMessage(redacted)
+	}
+	return redacted
+}
+
+// write appends the entry to the log. Failures are logged, they don't affect the request.
+func (l *LLMLog) write(entry llmLogEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Err(err).Msg("Failed to encode LLM log entry")
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// The log is readable by the user only, as prompts contain the schema and code of the project.
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Err(err).Msg("Failed to open LLM log")
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Err(err).Msg("Failed to write LLM log")
+	}
+}
+
+// loggedBody writes the log entry of the request with the response once it's read to the end or closed.
+type loggedBody struct {
+	io.ReadCloser
+	log   *LLMLog
+	entry llmLogEntry
+	read  bytes.Buffer
+	once  sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read.Write(p[:n])
+	if err == io.EOF {
+		b.end()
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end()
+	return err
+}
+
+func (b *loggedBody) end() {
+	b.once.Do(func() {
+		b.entry.DurationMS = time.Since(b.entry.Time).Milliseconds()
+		b.entry.Response = b.log.body(b.read.Bytes())
+		b.read.Reset()
+		b.log.write(b.entry)
+	})
+}
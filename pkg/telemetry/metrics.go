@@ -0,0 +1,131 @@
+package telemetry
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+const metricsNamespace = "doubletab"
+
+var (
+	llmRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_requests_total",
+		Help:      "Requests to the LLM API by operation, model, and HTTP status, or error when they failed without one.",
+	}, []string{"operation", "model", "status"})
+	llmDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "Duration of requests to the LLM API until their responses were read, streamed responses included.",
+		Buckets:   []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 40, 80, 160},
+	}, []string{"operation", "model"})
+	llmTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens used by requests to the LLM API by operation, model, and kind (prompt, completion).",
+	}, []string{"operation", "model", "kind"})
+	toolDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "tool_duration_seconds",
+		Help:      "Duration of tool calls by tool and outcome (succeeded, failed).",
+		Buckets:   []float64{0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"tool", "outcome"})
+	buildFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "build_failures_total",
+		Help:      "Builds of the generated code which failed.",
+	})
+	retrievalDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "retrieval_duration_seconds",
+		Help:      "Duration of retrievals from the knowledge base and the memory, embedding the query included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"source"})
+)
+
+// Sources of retrievals.
+const (
+	SourceKnowledge = "knowledge"
+	SourceMemory    = "memory"
+)
+
+// ServeMetrics serves the metrics in the Prometheus format at /metrics of the address, e.g. :9090, in the background.
+// Failures of the server are logged, they don't affect the session.
+func ServeMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msgf("Failed to serve metrics at %s", addr)
+		}
+	}()
+}
+
+// ObserveTool records the duration and outcome of the tool call, in the metrics and the statistics of the session.
+func ObserveTool(tool string, succeeded bool, duration time.Duration) {
+	outcome := "succeeded"
+	if !succeeded {
+		outcome = "failed"
+	}
+	toolDuration.WithLabelValues(tool, outcome).Observe(duration.Seconds())
+	recordToolCall(tool, succeeded, duration)
+}
+
+// BuildFailed counts the failed build of the generated code.
+func BuildFailed() {
+	buildFailures.Inc()
+}
+
+// ObserveRetrieval records the duration of the retrieval from the source.
+func ObserveRetrieval(source string, duration time.Duration) {
+	retrievalDuration.WithLabelValues(source).Observe(duration.Seconds())
+}
+
+// usage is the token usage reported in responses of the LLM API.
+type usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+}
+
+// responseUsage returns the token usage reported in the response body: in the JSON body, or in the last event of
+// streamed responses reporting it, which they only do when it's requested.
+func responseUsage(contentType string, body []byte) (usage, bool) {
+	var resp struct {
+		Usage *usage `json:"usage"`
+	}
+	if !strings.HasPrefix(contentType, "text/event-stream") {
+		if err := json.Unmarshal(body, &resp); err != nil || resp.Usage == nil {
+			return usage{}, false
+		}
+		return *resp.Usage, true
+	}
+
+	var found *usage
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(nil, len(body)+1)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok || !strings.Contains(data, `"usage"`) {
+			continue
+		}
+		resp.Usage = nil
+		if err := json.Unmarshal([]byte(strings.TrimSpace(data)), &resp); err == nil && resp.Usage != nil {
+			found = resp.Usage
+		}
+	}
+	if found == nil {
+		return usage{}, false
+	}
+	return *found, true
+}
@@ -0,0 +1,142 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/packages/ssestream"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/redact"
+)
+
+// maxContentAttribute limits the prompts, completions, and tool inputs and outputs recorded in spans, as spans of
+// whole generated files and conversations are rejected by the platforms.
+const maxContentAttribute = 256 * 1024
+
+// contentRedactor redacts prompts, completions, and tool inputs and outputs recorded in spans. Content isn't recorded
+// unless an LLM observability platform is configured, as it's only useful there.
+var contentRedactor atomic.Pointer[redact.Redactor]
+
+// observabilityExporter returns the exporter of spans to the LLM observability platform of the configuration, over
+// its OpenTelemetry API, or nil when none is configured.
+func observabilityExporter(ctx context.Context, cfg *config.Config) (sdktrace.SpanExporter, error) {
+	var (
+		endpoint string
+		headers  map[string]string
+	)
+	switch cfg.Observability {
+	case "":
+		return nil, nil
+	case config.ObservabilityLangfuse:
+		endpoint = strings.TrimSuffix(cfg.LangfuseHost, "/") + "/api/public/otel/v1/traces"
+		credentials := base64.StdEncoding.EncodeToString([]byte(cfg.LangfusePublicKey + ":" + cfg.LangfuseSecretKey))
+		headers = map[string]string{"Authorization": "Basic " + credentials}
+	case config.ObservabilityLangSmith:
+		endpoint = strings.TrimSuffix(cfg.LangSmithEndpoint, "/") + "/otel/v1/traces"
+		headers = map[string]string{"x-api-key": cfg.LangSmithAPIKey}
+		if cfg.LangSmithProject != "" {
+			headers["Langsmith-Project"] = cfg.LangSmithProject
+		}
+	default:
+		return nil, fmt.Errorf("unsupported LLM observability platform: %s", cfg.Observability)
+	}
+
+	redactor, err := redact.New(cfg.Secrets(), cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint), otlptracehttp.WithHeaders(headers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s exporter: %w", cfg.Observability, err)
+	}
+	contentRedactor.Store(redactor)
+	return exporter, nil
+}
+
+// RecordContent records the input and output of the span, e.g. the arguments and the response of a tool, when an LLM
+// observability platform is configured.
+func RecordContent(span trace.Span, input, output string) {
+	setContent(span, "input.value", input)
+	setContent(span, "output.value", output)
+}
+
+// setContent records the content in the attribute of the span, redacted and truncated, when an LLM observability
+// platform is configured.
+func setContent(span trace.Span, key, content string) {
+	redactor := contentRedactor.Load()
+	if redactor == nil || content == "" || !span.IsRecording() {
+		return
+	}
+	if len(content) > maxContentAttribute {
+		content = content[:maxContentAttribute] + "\n..."
+	}
+	span.SetAttributes(attribute.String(key, redactor.Redact(content)))
+}
+
+// capturesContent reports whether prompts and completions are recorded in spans.
+func capturesContent() bool {
+	return contentRedactor.Load() != nil
+}
+
+// completionContent returns the messages the model responded with, from the body of the completion, streamed or not,
+// or the body as it is when it can't be parsed.
+func completionContent(contentType string, body []byte) string {
+	var completion openai.ChatCompletion
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		var acc openai.ChatCompletionAccumulator
+		stream := ssestream.NewStream[openai.ChatCompletionChunk](ssestream.NewDecoder(newBodyResponse(body)), nil)
+		for stream.Next() {
+			acc.AddChunk(stream.Current())
+		}
+		if stream.Err() != nil {
+			return string(body)
+		}
+		completion = acc.ChatCompletion
+	} else if err := json.Unmarshal(body, &completion); err != nil {
+		return string(body)
+	}
+
+	type toolCall struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	}
+	type message struct {
+		Role      string     `json:"role"`
+		Content   string     `json:"content,omitempty"`
+		ToolCalls []toolCall `json:"tool_calls,omitempty"`
+	}
+	messages := make([]message, 0, len(completion.Choices))
+	for _, choice := range completion.Choices {
+		m := message{Role: string(choice.Message.Role), Content: choice.Message.Content}
+		for _, call := range choice.Message.ToolCalls {
+			m.ToolCalls = append(m.ToolCalls, toolCall{Name: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+		messages = append(messages, m)
+	}
+	content, err := json.Marshal(messages)
+	if err != nil {
+		return string(body)
+	}
+	return string(content)
+}
+
+// newBodyResponse returns a response of the read event stream, for decoding it.
+func newBodyResponse(body []byte) *http.Response {
+	return &http.Response{
+		Header: http.Header{"Content-Type": []string{"text/event-stream"}},
+		Body:   io.NopCloser(bytes.NewReader(body)),
+	}
+}
@@ -0,0 +1,145 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+)
+
+// reportTimeout limits sending the usage report, so closing the session isn't held up by the endpoint.
+const reportTimeout = 5 * time.Second
+
+// reportedToolName matches names of tools reported. Tool names the model made up aren't reported, as they could
+// contain anything.
+var reportedToolName = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+// UsageReport is the anonymous usage of a session reported with --telemetry: which tools ran and how often they
+// succeeded, never prompts, code, names of the project, or credentials.
+type UsageReport struct {
+	// InstallationID is a random ID of the installation, telling reports of many installations from many reports of one.
+	InstallationID string `json:"installation_id"`
+	Version        string `json:"version"`
+	OS             string `json:"os"`
+	Arch           string `json:"arch"`
+	Command        string `json:"command"`
+	// Tools are the calls of tools by tool name.
+	Tools            map[string]ToolReport `json:"tools"`
+	LLMRequests      int64                 `json:"llm_requests"`
+	PromptTokens     int64                 `json:"prompt_tokens"`
+	CompletionTokens int64                 `json:"completion_tokens"`
+}
+
+// ToolReport is the number of calls of a tool in a session, and how many of them failed.
+type ToolReport struct {
+	Calls    int `json:"calls"`
+	Failures int `json:"failures"`
+}
+
+// NewUsageReport returns the usage report of the session so far, with the command it ran.
+func NewUsageReport(command string) UsageReport {
+	if command == "" {
+		command = "session"
+	}
+	version := "(devel)"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+	total := TotalUsage()
+	report := UsageReport{
+		Version:          version,
+		OS:               runtime.GOOS,
+		Arch:             runtime.GOARCH,
+		Command:          command,
+		Tools:            make(map[string]ToolReport),
+		LLMRequests:      total.Requests,
+		PromptTokens:     total.PromptTokens,
+		CompletionTokens: total.CompletionTokens,
+	}
+	for tool, st := range SessionToolStats() {
+		if reportedToolName.MatchString(tool) {
+			report.Tools[tool] = ToolReport{Calls: st.Calls, Failures: st.Failures}
+		}
+	}
+	return report
+}
+
+// ReportUsage sends the usage report of the session to the telemetry endpoint, when telemetry is enabled. Failures
+// are only logged, as they don't concern the user.
+func ReportUsage(ctx context.Context, cfg *config.Config) {
+	if !cfg.Telemetry {
+		return
+	}
+	report := NewUsageReport(cfg.Command)
+	id, err := InstallationID(true)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to get installation ID of usage report")
+		return
+	}
+	report.InstallationID = id
+	body, err := json.Marshal(report)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to encode usage report")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.TelemetryEndpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to create usage report request")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to send usage report")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Debug().Msgf("Usage report was rejected: %s", resp.Status)
+	}
+}
+
+// InstallationID returns the random ID of the installation, stored in ~/.doubletab. It's created when create is set,
+// otherwise it's empty until then.
+func InstallationID(create bool) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	name := filepath.Join(home, ".doubletab", "installation-id")
+	if content, err := os.ReadFile(name); err == nil {
+		return strings.TrimSpace(string(content)), nil
+	}
+	if !create {
+		return "", nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate installation ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(name), err)
+	}
+	if err := os.WriteFile(name, []byte(id+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to store installation ID: %w", err)
+	}
+	return id, nil
+}
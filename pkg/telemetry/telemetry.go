@@ -0,0 +1,218 @@
+// Package telemetry traces sessions with OpenTelemetry: the turns of the main loop, agents, tool calls, and requests to
+// the LLM API are spans exported over OTLP, so slow sessions can be analyzed in Jaeger or Tempo. It also records
+// Prometheus metrics of long-lived sessions: LLM requests and their tokens, tool calls, builds, and retrievals.
+package telemetry
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go/option"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+)
+
+const (
+	tracerName  = "github.com/doubletabai/doubletab"
+	serviceName = "doubletab"
+)
+
+// Tracer returns the tracer of DoubleTab spans. Spans aren't recorded unless Setup configured an exporter.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Setup exports spans of the session to the OTLP/HTTP endpoint of the configuration, e.g. http://localhost:4318, and to
+// its LLM observability platform, with prompts, completions, and tool inputs and outputs. Tracing stays disabled when
+// neither is configured. The returned function flushes the spans which weren't exported yet, it must be called before
+// exiting.
+func Setup(ctx context.Context, cfg *config.Config, sessionID string) (func(context.Context) error, error) {
+	var opts []sdktrace.TracerProviderOption
+	if cfg.OTLPEndpoint != "" {
+		exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.OTLPEndpoint))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	exporter, err := observabilityExporter(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	if len(opts) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.SessionID(sessionID),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTel resource: %w", err)
+	}
+	provider := sdktrace.NewTracerProvider(append(opts, sdktrace.WithResource(res))...)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// End ends the span, marking it failed with the error, when it isn't nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Middleware traces requests to the LLM API, completions and embeddings alike, as client spans named by the operation
+// and the model, and records their metrics. Spans of streamed completions end once the stream is closed.
+func Middleware(req *http.Request, next option.MiddlewareNext) (*http.Response, error) {
+	operation := path.Base(req.URL.Path)
+	if operation == "completions" {
+		operation = "chat"
+	}
+	body := requestBody(req)
+	model := requestModel(body)
+	ctx, span := Tracer().Start(req.Context(), operation+" "+model,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("gen_ai.operation.name", operation),
+			attribute.String("gen_ai.request.model", model),
+			semconv.URLFull(req.URL.String()),
+		))
+
+	if capturesContent() {
+		setContent(span, "gen_ai.prompt", requestPrompt(body))
+	}
+
+	start := time.Now()
+	resp, err := next(req.WithContext(ctx))
+	if err != nil {
+		llmRequests.WithLabelValues(operation, model, "error").Inc()
+		llmDuration.WithLabelValues(operation, model).Observe(time.Since(start).Seconds())
+		End(span, err)
+		return resp, err
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	llmRequests.WithLabelValues(operation, model, strconv.Itoa(resp.StatusCode)).Inc()
+	resp.Body = &responseBody{
+		ReadCloser:  resp.Body,
+		span:        span,
+		operation:   operation,
+		model:       model,
+		contentType: resp.Header.Get("Content-Type"),
+		start:       start,
+	}
+	return resp, nil
+}
+
+// requestBody returns the body of the request, which the client keeps for retries.
+func requestBody(req *http.Request) []byte {
+	if req.GetBody == nil {
+		return nil
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	return data
+}
+
+// requestModel returns the model the request is made for, from its JSON body.
+func requestModel(body []byte) string {
+	var params struct {
+		Model string `json:"model"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return ""
+	}
+	return params.Model
+}
+
+// requestPrompt returns the messages of completion requests and the input of embedding requests, from their JSON body.
+func requestPrompt(body []byte) string {
+	var params struct {
+		Messages json.RawMessage `json:"messages"`
+		Input    json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(body, &params); err != nil {
+		return ""
+	}
+	return cmp.Or(string(params.Messages), string(params.Input))
+}
+
+// responseBody ends the span of the request and records its duration and token usage once its response is read to the
+// end or closed, as the client doesn't close all responses it read.
+type responseBody struct {
+	io.ReadCloser
+	span        trace.Span
+	operation   string
+	model       string
+	contentType string
+	start       time.Time
+	// read is the response read so far, for its token usage.
+	read bytes.Buffer
+	once sync.Once
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read.Write(p[:n])
+	if err == io.EOF {
+		b.end()
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end()
+	return err
+}
+
+func (b *responseBody) end() {
+	b.once.Do(func() {
+		duration := time.Since(b.start)
+		llmDuration.WithLabelValues(b.operation, b.model).Observe(duration.Seconds())
+		u, ok := responseUsage(b.contentType, b.read.Bytes())
+		addUsage(b.model, u, duration)
+		if ok {
+			llmTokens.WithLabelValues(b.operation, b.model, "prompt").Add(float64(u.PromptTokens))
+			llmTokens.WithLabelValues(b.operation, b.model, "completion").Add(float64(u.CompletionTokens))
+			b.span.SetAttributes(
+				attribute.Int64("gen_ai.usage.input_tokens", u.PromptTokens),
+				attribute.Int64("gen_ai.usage.output_tokens", u.CompletionTokens),
+			)
+		}
+		if b.operation == "chat" && capturesContent() {
+			setContent(b.span, "gen_ai.completion", completionContent(b.contentType, b.read.Bytes()))
+		}
+		b.read.Reset()
+		b.span.End()
+	})
+}
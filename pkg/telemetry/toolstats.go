@@ -0,0 +1,84 @@
+package telemetry
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// ToolStats are statistics of the calls of a tool in the session.
+type ToolStats struct {
+	Calls    int
+	Failures int
+	// Total is the time spent in the tool, P50, P90, and P99 are percentiles of the durations of its calls.
+	Total time.Duration
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+}
+
+// SuccessRate returns the share of the calls which succeeded.
+func (s ToolStats) SuccessRate() float64 {
+	if s.Calls == 0 {
+		return 0
+	}
+	return float64(s.Calls-s.Failures) / float64(s.Calls)
+}
+
+type toolCalls struct {
+	durations []time.Duration
+	failures  int
+}
+
+var (
+	sessionToolsMu sync.Mutex
+	sessionTools   = make(map[string]*toolCalls)
+)
+
+// recordToolCall adds the call of the tool to the statistics of the session.
+func recordToolCall(tool string, succeeded bool, duration time.Duration) {
+	sessionToolsMu.Lock()
+	defer sessionToolsMu.Unlock()
+
+	calls, ok := sessionTools[tool]
+	if !ok {
+		calls = &toolCalls{}
+		sessionTools[tool] = calls
+	}
+	calls.durations = append(calls.durations, duration)
+	if !succeeded {
+		calls.failures++
+	}
+}
+
+// SessionToolStats returns the statistics of the tools called in the session so far, by tool name.
+func SessionToolStats() map[string]ToolStats {
+	sessionToolsMu.Lock()
+	defer sessionToolsMu.Unlock()
+
+	stats := make(map[string]ToolStats, len(sessionTools))
+	for tool, calls := range sessionTools {
+		durations := slices.Sorted(slices.Values(calls.durations))
+		s := ToolStats{
+			Calls:    len(durations),
+			Failures: calls.failures,
+			P50:      percentile(durations, 50),
+			P90:      percentile(durations, 90),
+			P99:      percentile(durations, 99),
+		}
+		for _, d := range durations {
+			s.Total += d
+		}
+		stats[tool] = s
+	}
+	return stats
+}
+
+// percentile returns the p-th percentile of the sorted durations, by the nearest rank.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
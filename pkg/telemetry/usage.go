@@ -0,0 +1,142 @@
+package telemetry
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Price is the price of a model in USD per million tokens.
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// defaultPrices are the list prices of common OpenAI models. Models are matched by the longest prefix, so snapshots
+// like gpt-4o-2024-08-06 are priced as their model.
+var defaultPrices = map[string]Price{
+	"gpt-4o":                 {Prompt: 2.5, Completion: 10},
+	"gpt-4o-mini":            {Prompt: 0.15, Completion: 0.6},
+	"gpt-4.1":                {Prompt: 2, Completion: 8},
+	"gpt-4.1-mini":           {Prompt: 0.4, Completion: 1.6},
+	"gpt-4.1-nano":           {Prompt: 0.1, Completion: 0.4},
+	"gpt-4-turbo":            {Prompt: 10, Completion: 30},
+	"gpt-3.5-turbo":          {Prompt: 0.5, Completion: 1.5},
+	"o1":                     {Prompt: 15, Completion: 60},
+	"o1-mini":                {Prompt: 1.1, Completion: 4.4},
+	"o3-mini":                {Prompt: 1.1, Completion: 4.4},
+	"text-embedding-3-small": {Prompt: 0.02},
+	"text-embedding-3-large": {Prompt: 0.13},
+	"text-embedding-ada-002": {Prompt: 0.1},
+}
+
+// Prices are the prices of models by model name.
+type Prices map[string]Price
+
+// ParsePrices returns the default prices, overridden by the prices given as model=prompt/completion in USD per million
+// tokens, e.g. gpt-4o=2.5/10.
+func ParsePrices(overrides map[string]string) (Prices, error) {
+	prices := maps.Clone(defaultPrices)
+	for model, value := range overrides {
+		prompt, completion, _ := strings.Cut(value, "/")
+		var p Price
+		var err error
+		if p.Prompt, err = strconv.ParseFloat(prompt, 64); err != nil || p.Prompt < 0 {
+			return nil, fmt.Errorf("invalid prompt price of %s: %s", model, value)
+		}
+		if completion != "" {
+			if p.Completion, err = strconv.ParseFloat(completion, 64); err != nil || p.Completion < 0 {
+				return nil, fmt.Errorf("invalid completion price of %s: %s", model, value)
+			}
+		}
+		prices[model] = p
+	}
+	return prices, nil
+}
+
+// Lookup returns the price of the model, of the longest model name it starts with.
+func (p Prices) Lookup(model string) (Price, bool) {
+	var (
+		price Price
+		found string
+		ok    bool
+	)
+	for name, candidate := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(found) {
+			price, found, ok = candidate, name, true
+		}
+	}
+	return price, ok
+}
+
+// ModelUsage is the usage of a model in the session.
+type ModelUsage struct {
+	Requests         int64
+	PromptTokens     int64
+	CompletionTokens int64
+	// Duration is the time spent waiting for responses of the model, until they were read.
+	Duration time.Duration
+}
+
+// Tokens returns the number of all tokens used.
+func (u ModelUsage) Tokens() int64 {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// Cost returns the estimated cost of the usage in USD.
+func (u ModelUsage) Cost(price Price) float64 {
+	return (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1e6
+}
+
+var (
+	sessionUsageMu sync.Mutex
+	sessionUsage   = make(map[string]ModelUsage)
+)
+
+// addUsage adds a request to the model, with its token usage and duration, to the session usage.
+func addUsage(model string, u usage, duration time.Duration) {
+	sessionUsageMu.Lock()
+	defer sessionUsageMu.Unlock()
+
+	m := sessionUsage[model]
+	m.Requests++
+	m.PromptTokens += u.PromptTokens
+	m.CompletionTokens += u.CompletionTokens
+	m.Duration += duration
+	sessionUsage[model] = m
+}
+
+// SessionUsage returns the usage of models in the session so far, by model name, as reported by the LLM API.
+func SessionUsage() map[string]ModelUsage {
+	sessionUsageMu.Lock()
+	defer sessionUsageMu.Unlock()
+
+	return maps.Clone(sessionUsage)
+}
+
+// TotalUsage returns the usage of all models in the session so far.
+func TotalUsage() ModelUsage {
+	var total ModelUsage
+	for _, u := range SessionUsage() {
+		total.Requests += u.Requests
+		total.PromptTokens += u.PromptTokens
+		total.CompletionTokens += u.CompletionTokens
+		total.Duration += u.Duration
+	}
+	return total
+}
+
+// Spend returns the tokens used in the session so far and their estimated cost in USD, with models of unknown prices
+// counted as free.
+func Spend(prices Prices) (tokens int64, cost float64) {
+	for model, u := range SessionUsage() {
+		tokens += u.Tokens()
+		if price, ok := prices.Lookup(model); ok {
+			cost += u.Cost(price)
+		}
+	}
+	return tokens, cost
+}
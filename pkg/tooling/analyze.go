@@ -0,0 +1,449 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+)
+
+// analysisFile keeps the analysis of the existing code of the project, relative to the project root, so resources
+// added in later sessions follow the project too.
+const analysisFile = ".doubletab/analysis.json"
+
+// maxAnalysisFields limits the fields listed per model in the summary of the analysis.
+const maxAnalysisFields = 30
+
+const (
+	analyzeProjectPrompt = `
+## Extending an existing project
+
+The project has code which wasn't generated by you. Follow its conventions: register the routes of the new resource
+with the router the project uses, next to its other routes, implement the handlers like its handlers, reuse its models
+and the packages they're in, and import the packages of the project with its module path. Don't rewrite code of other
+resources. The analysis of the project is:
+`
+	analyzeSpecPrompt = `
+The project already serves the endpoints below. Don't define them again, and follow their path conventions.
+`
+)
+
+// routerModules are the import paths of the routers recognized in the analysis, by their names.
+var routerModules = map[string]string{
+	"github.com/go-chi/chi":               "chi",
+	"github.com/gin-gonic/gin":            "gin",
+	"github.com/labstack/echo":            "echo",
+	"github.com/gofiber/fiber":            "fiber",
+	"github.com/gorilla/mux":              "gorilla/mux",
+	"github.com/julienschmidt/httprouter": "httprouter",
+}
+
+// handlerParams are the parameter types of the handler functions of the routers.
+var handlerParams = []string{"http.ResponseWriter", "*gin.Context", "echo.Context", "*fiber.Ctx"}
+
+// modelTags are the keys of struct tags mapping fields of models to columns.
+var modelTags = []string{`db:"`, `gorm:"`, `bun:"`}
+
+var (
+	createTableRegexp = regexp.MustCompile(`(?i)\bcreate\s+table\s+(?:if\s+not\s+exists\s+)?([\w."]+)`)
+	moduleRegexp      = regexp.MustCompile(`(?m)^module\s+(\S+)`)
+)
+
+// projectAnalysis is the structured summary of the existing code of the project.
+type projectAnalysis struct {
+	Module     string              `json:"module"`
+	Routers    []string            `json:"routers"`
+	Routes     []analyzedRoute     `json:"routes"`
+	Handlers   []analyzedFunc      `json:"handlers"`
+	Models     []analyzedModel     `json:"models"`
+	Migrations []analyzedMigration `json:"migrations"`
+}
+
+type analyzedRoute struct {
+	// Method is empty when the route serves any method, or the method is set apart from the path.
+	Method  string `json:"method,omitempty"`
+	Path    string `json:"path"`
+	Handler string `json:"handler"`
+	File    string `json:"file"`
+}
+
+type analyzedFunc struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+}
+
+type analyzedModel struct {
+	Name   string          `json:"name"`
+	File   string          `json:"file"`
+	Fields []analyzedField `json:"fields"`
+}
+
+type analyzedField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Tag  string `json:"tag,omitempty"`
+}
+
+type analyzedMigration struct {
+	File   string   `json:"file"`
+	Tables []string `json:"tables"`
+}
+
+const AnalyzeProjectToolName = "analyze_project"
+
+func (s *Service) AnalyzeProjectTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(AnalyzeProjectToolName),
+			Description: openai.String("Analyzes the existing Go code of the project: its routers and routes, handlers, models, and migrations. Generated specs and code of new resources follow the analysis, so they extend the project instead of replacing it. Use it first when the user wants to add resources to an existing service."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type":       "object",
+				"properties": map[string]interface{}{},
+			}),
+		}),
+	}
+}
+
+// AnalyzeProject analyzes the existing code of the project and saves the analysis. Its summary is the response, stored
+// in the memory of the session like every tool response.
+func (s *Service) AnalyzeProject(_ context.Context, multi *pterm.MultiPrinter) string {
+	spinner := NewSpinner(multi, "Analyzing project...")
+	rootDir := os.Getenv("PROJECT_ROOT")
+	analysis, err := analyzeProject(rootDir)
+	if err != nil {
+		spinner.Fail("Project analysis failed")
+		return fmt.Sprintf("Failed to analyze project: %v", err)
+	}
+	if len(analysis.Routes) == 0 && len(analysis.Handlers) == 0 && len(analysis.Models) == 0 && len(analysis.Migrations) == 0 {
+		spinner.Success("Project analyzed")
+		return "The project has no routes, handlers, models, or migrations yet, so it's built from scratch."
+	}
+	content, err := json.MarshalIndent(analysis, "", "  ")
+	if err != nil {
+		spinner.Fail("Project analysis failed")
+		return fmt.Sprintf("Failed to encode analysis: %v", err)
+	}
+	name := filepath.Join(rootDir, analysisFile)
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err == nil {
+		err = os.WriteFile(name, append(content, '\n'), 0644)
+	}
+	if err != nil {
+		spinner.Fail("Project analysis failed")
+		return fmt.Sprintf("Failed to save analysis: %v", err)
+	}
+	spinner.Success("Project analyzed")
+	return "Project analyzed, new resources are generated following it:\n" + analysis.summary()
+}
+
+// loadAnalysis returns the saved analysis of the project, or nil when the project wasn't analyzed.
+func loadAnalysis() (*projectAnalysis, error) {
+	content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), analysisFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read analysis of the project: %w", err)
+	}
+	var analysis projectAnalysis
+	if err := json.Unmarshal(content, &analysis); err != nil {
+		return nil, fmt.Errorf("failed to parse analysis of the project: %w", err)
+	}
+	return &analysis, nil
+}
+
+// analysisPrompt returns the instructions of extending the project with the summary of its analysis, or nothing when
+// the project wasn't analyzed.
+func analysisPrompt() (string, error) {
+	analysis, err := loadAnalysis()
+	if analysis == nil {
+		return "", err
+	}
+	return analyzeProjectPrompt + "\n" + analysis.summary(), nil
+}
+
+// analyzeProject parses the Go files and SQL migrations of the project. Hidden directories, vendored code, and tests
+// are skipped.
+func analyzeProject(rootDir string) (*projectAnalysis, error) {
+	if rootDir == "" {
+		rootDir = "."
+	}
+	analysis := &projectAnalysis{}
+	if content, err := os.ReadFile(filepath.Join(rootDir, "go.mod")); err == nil {
+		if m := moduleRegexp.FindSubmatch(content); m != nil {
+			analysis.Module = string(m[1])
+		}
+	}
+	routers := make(map[string]bool)
+	err := filepath.WalkDir(rootDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != rootDir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "vendor" || d.Name() == "testdata") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(rootDir, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		switch {
+		case filepath.Ext(name) == ".sql":
+			return analysis.addMigration(name, rel)
+		case filepath.Ext(name) == ".go" && !strings.HasSuffix(name, "_test.go"):
+			file, err := parser.ParseFile(token.NewFileSet(), name, nil, parser.SkipObjectResolution)
+			if err != nil {
+				// Files which don't parse are left to the build to report.
+				return nil
+			}
+			analysis.addGoFile(file, rel, routers)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Routes and handlers without a router of a module are served by net/http.
+	if len(routers) == 0 && (len(analysis.Routes) > 0 || len(analysis.Handlers) > 0) {
+		routers["net/http"] = true
+	}
+	for router := range routers {
+		analysis.Routers = append(analysis.Routers, router)
+	}
+	sort.Strings(analysis.Routers)
+	return analysis, nil
+}
+
+// addMigration adds the SQL file to the migrations when it creates tables or is in a migrations directory.
+func (a *projectAnalysis) addMigration(name, rel string) error {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	var tables []string
+	for _, m := range createTableRegexp.FindAllStringSubmatch(string(content), -1) {
+		tables = append(tables, strings.ReplaceAll(m[1], `"`, ""))
+	}
+	if len(tables) > 0 || strings.Contains(rel, "migration") {
+		a.Migrations = append(a.Migrations, analyzedMigration{File: rel, Tables: tables})
+	}
+	return nil
+}
+
+// addGoFile adds the routes, handlers, and models of the Go file, and the routers it imports.
+func (a *projectAnalysis) addGoFile(file *ast.File, rel string, routers map[string]bool) {
+	for _, imp := range file.Imports {
+		importPath, _ := strconv.Unquote(imp.Path.Value)
+		for module, router := range routerModules {
+			if importPath == module || strings.HasPrefix(importPath, module+"/") {
+				routers[router] = true
+			}
+		}
+	}
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if isHandler(d.Type) {
+				a.Handlers = append(a.Handlers, analyzedFunc{Name: funcName(d), File: rel})
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok {
+					continue
+				}
+				if st, ok := ts.Type.(*ast.StructType); ok && isModel(st) {
+					a.Models = append(a.Models, analyzedModel{Name: ts.Name.Name, File: rel, Fields: modelFields(st)})
+				}
+			}
+		}
+	}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if call, ok := n.(*ast.CallExpr); ok {
+			if route, ok := routeOf(call); ok {
+				route.File = rel
+				a.Routes = append(a.Routes, route)
+			}
+		}
+		return true
+	})
+}
+
+// isHandler reports whether the function has the parameters of a handler of a router.
+func isHandler(ft *ast.FuncType) bool {
+	if ft.Params == nil || len(ft.Params.List) == 0 {
+		return false
+	}
+	param := types.ExprString(ft.Params.List[0].Type)
+	for _, p := range handlerParams {
+		if param == p {
+			return true
+		}
+	}
+	return false
+}
+
+// funcName returns the name of the function, prefixed with the type of its receiver for methods.
+func funcName(d *ast.FuncDecl) string {
+	if d.Recv == nil || len(d.Recv.List) == 0 {
+		return d.Name.Name
+	}
+	recv := strings.TrimPrefix(types.ExprString(d.Recv.List[0].Type), "*")
+	recv, _, _ = strings.Cut(recv, "[")
+	return recv + "." + d.Name.Name
+}
+
+// isModel reports whether fields of the struct are mapped to columns.
+func isModel(st *ast.StructType) bool {
+	for _, field := range st.Fields.List {
+		if field.Tag == nil {
+			continue
+		}
+		for _, tag := range modelTags {
+			if strings.Contains(field.Tag.Value, tag) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// modelFields returns the fields of the struct. Embedded fields are named by their types.
+func modelFields(st *ast.StructType) []analyzedField {
+	var fields []analyzedField
+	for _, field := range st.Fields.List {
+		typ := types.ExprString(field.Type)
+		var tag string
+		if field.Tag != nil {
+			tag = strings.Trim(field.Tag.Value, "`")
+		}
+		if len(field.Names) == 0 {
+			fields = append(fields, analyzedField{Name: strings.TrimPrefix(typ, "*"), Type: typ, Tag: tag})
+		}
+		for _, name := range field.Names {
+			fields = append(fields, analyzedField{Name: name.Name, Type: typ, Tag: tag})
+		}
+	}
+	return fields
+}
+
+// routeOf returns the route registered by the call, e.g. r.Get("/books", h.ListBooks), mux.HandleFunc("GET /books",
+// listBooks), or e.GET("/books", listBooks).
+func routeOf(call *ast.CallExpr) (analyzedRoute, bool) {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || len(call.Args) < 2 {
+		return analyzedRoute{}, false
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return analyzedRoute{}, false
+	}
+	pattern, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return analyzedRoute{}, false
+	}
+
+	var route analyzedRoute
+	switch name := sel.Sel.Name; name {
+	case "Handle", "HandleFunc":
+		// Patterns of net/http since Go 1.22 may start with the method.
+		if method, p, ok := strings.Cut(pattern, " "); ok {
+			route.Method, pattern = method, strings.TrimSpace(p)
+		}
+	case "Get", "Post", "Put", "Patch", "Delete", "GET", "POST", "PUT", "PATCH", "DELETE":
+		route.Method = strings.ToUpper(name)
+	default:
+		return analyzedRoute{}, false
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return analyzedRoute{}, false
+	}
+	route.Path = pattern
+	handler := call.Args[len(call.Args)-1]
+	if _, ok := handler.(*ast.FuncLit); ok {
+		route.Handler = "inline function"
+	} else {
+		route.Handler = types.ExprString(handler)
+	}
+	return route, true
+}
+
+// summary describes the analysis for the model.
+func (a *projectAnalysis) summary() string {
+	var sb strings.Builder
+	if a.Module != "" {
+		fmt.Fprintf(&sb, "Module: %s\n", a.Module)
+	}
+	if len(a.Routers) > 0 {
+		fmt.Fprintf(&sb, "Routers: %s\n", strings.Join(a.Routers, ", "))
+	}
+	if len(a.Routes) > 0 {
+		sb.WriteString("\nRoutes:\n")
+		sb.WriteString(a.routeList())
+	}
+	if len(a.Handlers) > 0 {
+		sb.WriteString("\nHandlers:\n")
+		for _, h := range a.Handlers {
+			fmt.Fprintf(&sb, "- %s (%s)\n", h.Name, h.File)
+		}
+	}
+	if len(a.Models) > 0 {
+		sb.WriteString("\nModels:\n")
+		for _, m := range a.Models {
+			fmt.Fprintf(&sb, "- %s (%s)\n", m.Name, m.File)
+			for i, f := range m.Fields {
+				if i == maxAnalysisFields {
+					fmt.Fprintf(&sb, "  - ... %d more fields\n", len(m.Fields)-i)
+					break
+				}
+				if f.Tag != "" {
+					fmt.Fprintf(&sb, "  - %s %s `%s`\n", f.Name, f.Type, f.Tag)
+				} else {
+					fmt.Fprintf(&sb, "  - %s %s\n", f.Name, f.Type)
+				}
+			}
+		}
+	}
+	if len(a.Migrations) > 0 {
+		sb.WriteString("\nMigrations:\n")
+		for _, m := range a.Migrations {
+			if len(m.Tables) > 0 {
+				fmt.Fprintf(&sb, "- %s creates %s\n", m.File, strings.Join(m.Tables, ", "))
+			} else {
+				fmt.Fprintf(&sb, "- %s\n", m.File)
+			}
+		}
+	}
+	return sb.String()
+}
+
+// routeList lists the routes, one per line.
+func (a *projectAnalysis) routeList() string {
+	var sb strings.Builder
+	for _, r := range a.Routes {
+		method := r.Method
+		if method == "" {
+			method = "ANY"
+		}
+		fmt.Fprintf(&sb, "- %s %s -> %s (%s)\n", method, r.Path, r.Handler, r.File)
+	}
+	return sb.String()
+}
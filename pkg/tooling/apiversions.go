@@ -0,0 +1,148 @@
+package tooling
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// FrozenAPIVersion is a previous version of the API, snapshotted into its own packages and served alongside the
+// current version.
+type FrozenAPIVersion struct {
+	// Name of the version, e.g. v1, which is also its base path.
+	Name string
+	// Imports lists the snapshotted packages.
+	Imports []string
+	// Server is the Go expression creating the server of the version.
+	Server string
+}
+
+// versionedPackages are snapshotted, in the order of their dependencies, when a new API version is created.
+var versionedPackages = []string{"api", "repository", "service"}
+
+const CreateAPIVersionToolName = "create_api_version"
+
+func (s *Service) CreateAPIVersionTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(CreateAPIVersionToolName),
+			Description: openai.String("Freezes the current API version (e.g. /v1) into separate packages, still served, and starts the next version (e.g. /v2). Use it before regenerating the spec when entities change incompatibly."),
+		}),
+	}
+}
+
+func (s *Service) CreateAPIVersion() string {
+	if s.APIStyle != APIStyleOpenAPI {
+		return "API versions are supported only for OpenAPI projects, evolve GraphQL schema with deprecations instead"
+	}
+	if s.APIVersion == "" {
+		return "The API isn't versioned, it can be enabled with --api-versioning flag when starting a new project"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rootDir := os.Getenv("PROJECT_ROOT")
+	if rootDir == "" {
+		rootDir = "."
+	}
+	version := s.APIVersion
+	n, err := strconv.Atoi(strings.TrimPrefix(version, "v"))
+	if err != nil {
+		return fmt.Sprintf("Invalid API version %s: %v", version, err)
+	}
+
+	var snapshotted []string
+	for _, pkg := range versionedPackages {
+		if _, err := os.Stat(filepath.Join(rootDir, "pkg", pkg)); os.IsNotExist(err) {
+			continue
+		}
+		snapshotted = append(snapshotted, pkg)
+	}
+	frozen := FrozenAPIVersion{Name: version}
+	for _, pkg := range snapshotted {
+		if err := s.snapshotPackage(rootDir, pkg, version, snapshotted); err != nil {
+			return fmt.Sprintf("Failed to snapshot %s package: %v", pkg, err)
+		}
+		frozen.Imports = append(frozen.Imports, "myApp/pkg/"+pkg+version)
+	}
+	frozen.Server = s.frozenServer(version)
+
+	s.FrozenAPIVersions = append(s.FrozenAPIVersions, frozen)
+	s.APIVersion = fmt.Sprintf("v%d", n+1)
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return fmt.Sprintf("Failed to serve frozen API version: %v", err)
+	}
+
+	return fmt.Sprintf("API %[1]s is frozen in pkg/api%[1]s and still served at /%[1]s. Now regenerate the OpenAPI spec, "+
+		"schema, and code for %[2]s, served at /%[2]s. Keep the database schema backward compatible, add new columns or "+
+		"tables instead of renaming or dropping the ones %[1]s uses.", version, s.APIVersion)
+}
+
+// BasePath returns the path the current API version is served at, or an empty string when the API isn't versioned.
+func (s *Service) BasePath() string {
+	if s.APIVersion == "" {
+		return ""
+	}
+	return "/" + s.APIVersion
+}
+
+// frozenServer returns the expression creating the server of a frozen version, based on the layers the project uses.
+func (s *Service) frozenServer(version string) string {
+	var server string
+	switch {
+	case s.ServiceLayer:
+		server = fmt.Sprintf("api%[1]s.Server{Svc: service%[1]s.New(repository%[1]s.NewPostgres(db))", version)
+	case s.RepositoryLayer:
+		server = fmt.Sprintf("api%[1]s.Server{Repo: repository%[1]s.NewPostgres(db)", version)
+	default:
+		server = fmt.Sprintf("api%s.Server{DB: db", version)
+	}
+	if s.FileUploads {
+		server += ", Storage: srv.Storage"
+	}
+	return server + "}"
+}
+
+// snapshotPackage copies the package into a package suffixed with the version, renaming it and pointing imports of the
+// other snapshotted packages to their snapshots.
+func (s *Service) snapshotPackage(rootDir, pkg, version string, snapshotted []string) error {
+	srcDir := filepath.Join(rootDir, "pkg", pkg)
+	dstDir := filepath.Join(rootDir, "pkg", pkg+version)
+	packageRegexp := regexp.MustCompile(`(?m)^package ` + pkg + `$`)
+
+	return filepath.WalkDir(srcDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		code := string(content)
+		switch {
+		case strings.HasSuffix(name, ".go"):
+			code = packageRegexp.ReplaceAllString(code, "package "+pkg+version)
+			for _, imported := range snapshotted {
+				// The alias keeps references to the package valid.
+				code = strings.ReplaceAll(code, `"myApp/pkg/`+imported+`"`,
+					fmt.Sprintf(`%s "myApp/pkg/%s%s"`, imported, imported, version))
+			}
+		case filepath.Base(name) == "cfg.yaml":
+			code = strings.Replace(code, "package: "+pkg, "package: "+pkg+version, 1)
+		}
+
+		rel, err := filepath.Rel(srcDir, name)
+		if err != nil {
+			return err
+		}
+		return s.writeFile(filepath.Join(dstDir, rel), code)
+	})
+}
@@ -0,0 +1,140 @@
+package tooling
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Approval policies of tools.
+const (
+	// ApprovalAuto runs the tool without asking.
+	ApprovalAuto = "auto"
+	// ApprovalPrompt asks the user before the tool runs.
+	ApprovalPrompt = "prompt"
+	// ApprovalDeny never runs the tool.
+	ApprovalDeny = "deny"
+)
+
+// approvalDefault is the key of the policy of tools without their own policy.
+const approvalDefault = "*"
+
+// maxApprovalArguments limits the arguments shown when asking for approval, as generated code can be long.
+const maxApprovalArguments = 2000
+
+// toolNames are the names of all tools, including the ones only agents call, so policies of unknown tools are caught.
+var toolNames = []string{
+	GenerateOpenAPISpecToolName, ListTablesToolName, GenerateSchemaToolName, StoreSchemaToolName,
+	GenerateHandlersCodeToolName, GenerateServerCodeToolName, SaveServerCodeToolName, SaveRepositoryCodeToolName,
+	SaveServiceCodeToolName, BuildCodeToolName, RunTestsToolName, VetCodeToolName, LintCodeToolName, SecurityScanToolName,
+	RunAndVerifyToolName, EditFunctionToolName, ApplyPatchToolName, ReconcileArtifactsToolName, RollbackStepToolName,
+	ManageDepsToolName, FuzzAPIToolName, GenerateGraphQLSchemaToolName, GenerateResolversCodeToolName,
+	SaveResolversCodeToolName, GenerateLiveUpdatesToolName, GenerateFileStorageToolName, GenerateCacheLayerToolName,
+	GenerateEventPublishingToolName, GenerateIdempotencyToolName, CreateAPIVersionToolName, GenerateReadmeToolName,
+	QueryKnowledgeBaseToolName, QueryMemoryToolName, WritePlanToolName, PublishPRToolName, AnalyzeProjectToolName,
+	GenerateTerraformToolName, GenerateERDToolName, VerifyConsistencyToolName, RunQueryToolName,
+}
+
+// defaultApprovals are the built-in policies of tools which are asked before they run, as they have effects outside
+// the project. Only policies the user gives for the tool itself loosen them.
+var defaultApprovals = map[string]string{
+	PublishPRToolName: ApprovalPrompt,
+}
+
+// approvalStrictness orders the policies, so stricter policies than the built-in ones still apply.
+var approvalStrictness = map[string]int{ApprovalAuto: 0, ApprovalPrompt: 1, ApprovalDeny: 2}
+
+// checkApprovals checks that the policies are given for known tools.
+func checkApprovals(approvals map[string]string) error {
+	for tool := range approvals {
+		if tool != approvalDefault && !slices.Contains(toolNames, tool) {
+			return fmt.Errorf("approval policy of unknown tool %s", tool)
+		}
+	}
+	return nil
+}
+
+// approvalPolicy returns the policy of the tool and whose policy it is. The policy given by the user for the tool,
+// or else their default policy for all tools, applies, falling back to running it without asking. Policies of the
+// project, and the built-in policy of the tool unless the user gave one for the tool itself, only apply when they are
+// stricter, so neither a cloned project nor a default policy for all tools loosens the policy of the user, or lets a
+// pull request be pushed without asking.
+func (s *Service) approvalPolicy(tool string) (string, string) {
+	policy, owner := ApprovalAuto, "the user"
+	own, ok := s.Approvals[tool]
+	if ok {
+		policy = own
+	} else if p, ok := s.Approvals[approvalDefault]; ok {
+		policy = p
+	}
+	project, ok := s.ProjectApprovals[tool]
+	if !ok {
+		project, ok = s.ProjectApprovals[approvalDefault]
+	}
+	if ok && approvalStrictness[project] > approvalStrictness[policy] {
+		policy, owner = project, "the project"
+	}
+	if builtIn, ok := defaultApprovals[tool]; ok && own == "" && approvalStrictness[builtIn] > approvalStrictness[policy] {
+		policy, owner = builtIn, "DoubleTab"
+	}
+	return policy, owner
+}
+
+// approve applies the approval policy of the tool, asking the user when the policy says so. It returns the reason the
+// tool can't run, or an empty string when it can.
+func (s *Service) approve(tool, arguments string) string {
+	policy, owner := s.approvalPolicy(tool)
+	switch policy {
+	case ApprovalDeny:
+		return fmt.Sprintf("it's denied by the approval policy of %s", owner)
+	case ApprovalPrompt:
+		question := fmt.Sprintf("Allow %s?", tool)
+		if args := approvalArguments(arguments); args != "" {
+			question = fmt.Sprintf("Allow %s with arguments:\n%s", tool, args)
+		}
+		return s.confirm(question)
+	}
+	return ""
+}
+
+// confirm asks the user the question, e.g. to approve a tool call or its effects. It returns the reason the call
+// can't go ahead, or an empty string when the user approved it.
+func (s *Service) confirm(question string) string {
+	if s.Confirm == nil && s.ConfirmLater == nil {
+		return "it requires approval of the user, who can't be asked in headless runs"
+	}
+	if s.ConfirmLater != nil {
+		if !s.ConfirmLater(question) {
+			return "it awaits approval of the user, who was asked for it. Tell the user, and call it again with the same arguments once they approved it"
+		}
+		return ""
+	}
+	if !s.Confirm(question) {
+		return "the user didn't approve it. Ask them what to do instead"
+	}
+	return ""
+}
+
+// approvalArguments formats the arguments of the tool for the user to review, with code and other text arguments as
+// they are rather than quoted.
+func approvalArguments(arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err == nil {
+		var sb strings.Builder
+		for _, key := range slices.Sorted(maps.Keys(args)) {
+			value, ok := args[key].(string)
+			if !ok {
+				encoded, _ := json.Marshal(args[key])
+				value = string(encoded)
+			}
+			fmt.Fprintf(&sb, "%s: %s\n", key, value)
+		}
+		arguments = strings.TrimSuffix(sb.String(), "\n")
+	}
+	if len(arguments) > maxApprovalArguments {
+		arguments = arguments[:maxApprovalArguments] + "\n..."
+	}
+	return arguments
+}
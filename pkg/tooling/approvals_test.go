@@ -0,0 +1,82 @@
+package tooling
+
+import "testing"
+
+func TestApprovalPolicy(t *testing.T) {
+	tests := []struct {
+		name      string
+		tool      string
+		user      map[string]string
+		project   map[string]string
+		want      string
+		wantOwner string
+	}{
+		{
+			name:      "no policies",
+			tool:      RunQueryToolName,
+			want:      ApprovalAuto,
+			wantOwner: "the user",
+		},
+		{
+			name:      "project policy of the tool loosening the default of the user",
+			tool:      RunQueryToolName,
+			user:      map[string]string{approvalDefault: ApprovalPrompt},
+			project:   map[string]string{RunQueryToolName: ApprovalAuto},
+			want:      ApprovalPrompt,
+			wantOwner: "the user",
+		},
+		{
+			name:      "project policy stricter than the one of the user",
+			tool:      StoreSchemaToolName,
+			user:      map[string]string{StoreSchemaToolName: ApprovalPrompt},
+			project:   map[string]string{StoreSchemaToolName: ApprovalDeny},
+			want:      ApprovalDeny,
+			wantOwner: "the project",
+		},
+		{
+			name:      "project default stricter than the default of the user",
+			tool:      StoreSchemaToolName,
+			user:      map[string]string{approvalDefault: ApprovalAuto},
+			project:   map[string]string{approvalDefault: ApprovalPrompt},
+			want:      ApprovalPrompt,
+			wantOwner: "the project",
+		},
+		{
+			name:      "user policy of the tool loosening the built-in one",
+			tool:      PublishPRToolName,
+			user:      map[string]string{PublishPRToolName: ApprovalAuto},
+			want:      ApprovalAuto,
+			wantOwner: "the user",
+		},
+		{
+			name:      "user default not loosening the built-in policy",
+			tool:      PublishPRToolName,
+			user:      map[string]string{approvalDefault: ApprovalAuto},
+			want:      ApprovalPrompt,
+			wantOwner: "DoubleTab",
+		},
+		{
+			name:      "project policy not loosening the built-in one",
+			tool:      PublishPRToolName,
+			project:   map[string]string{PublishPRToolName: ApprovalAuto},
+			want:      ApprovalPrompt,
+			wantOwner: "DoubleTab",
+		},
+		{
+			name:      "user policy denying the tool",
+			tool:      RollbackStepToolName,
+			user:      map[string]string{RollbackStepToolName: ApprovalDeny},
+			project:   map[string]string{RollbackStepToolName: ApprovalAuto},
+			want:      ApprovalDeny,
+			wantOwner: "the user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Service{Approvals: tt.user, ProjectApprovals: tt.project}
+			if got, owner := s.approvalPolicy(tt.tool); got != tt.want || owner != tt.wantOwner {
+				t.Errorf("approvalPolicy(%s) = %s of %s, want %s of %s", tt.tool, got, owner, tt.want, tt.wantOwner)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+
+	"github.com/doubletabai/doubletab/pkg/vector"
+)
+
+const (
+	// MainCaller is the caller of tools called by the assistant of the session rather than by agents of tools.
+	MainCaller = "main"
+	// maxAuditResult limits the summaries of tool responses in the audit log.
+	maxAuditResult = 500
+)
+
+// callerKey is the context key of the tool whose agent calls tools.
+type callerKey struct{}
+
+// auditFileMu serializes appending to the audit file, as tools run concurrently.
+var auditFileMu sync.Mutex
+
+// toolCaller returns the caller of tools called with the context.
+func toolCaller(ctx context.Context) string {
+	if caller, ok := ctx.Value(callerKey{}).(string); ok {
+		return caller
+	}
+	return MainCaller
+}
+
+// audit records the tool invocation in the audit log of the project and in the audit file, when set. Failures are
+// returned, but the invocation isn't affected by them.
+func (s *Service) audit(ctx context.Context, caller string, tool openai.ChatCompletionMessageToolCallFunction, resp string, started time.Time) error {
+	result, _, _ := strings.Cut(resp, "\n")
+	if len(result) > maxAuditResult {
+		result = result[:maxAuditResult]
+	}
+	entry := vector.AuditEntry{
+		Caller:     caller,
+		Tool:       tool.Name,
+		Arguments:  tool.Arguments,
+		Result:     result,
+		Succeeded:  Succeeded(resp),
+		DurationMS: time.Since(started).Milliseconds(),
+		CreatedAt:  started.UTC(),
+	}
+	if s.Mem != nil {
+		entry.SessionID = s.Mem.SessionID
+	}
+
+	// Invocations canceled midway are recorded too.
+	ctx = context.WithoutCancel(ctx)
+	if s.Audit != nil {
+		if err := s.Audit.Append(ctx, entry); err != nil {
+			return err
+		}
+	}
+	if s.AuditFile != "" {
+		return appendAuditFile(s.AuditFile, entry)
+	}
+	return nil
+}
+
+// appendAuditFile appends the entry to the JSONL file.
+func appendAuditFile(name string, entry vector.AuditEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	auditFileMu.Lock()
+	defer auditFileMu.Unlock()
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit file: %w", err)
+	}
+	return nil
+}
@@ -1,13 +1,19 @@
 package tooling
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 
 	"github.com/openai/openai-go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/telemetry"
 )
 
 const BuildCodeToolName = "build_code"
@@ -23,17 +29,194 @@ func (s *Service) BuildCodeTool() openai.ChatCompletionToolParam {
 }
 
 func (s *Service) BuildCode(ctx context.Context) string {
+	if err := s.build(ctx); err != nil {
+		return err.Error()
+	}
+
+	if s.StrictVerification {
+		return "Code built, vetted, and tested with the race detector successfully"
+	}
+	return "Code built successfully"
+}
+
+// maxBuildFixAttempts limits the number of times generated code is repaired after failing to build.
+const maxBuildFixAttempts = 3
+
+// buildFixLoop calls generate, which generates and writes the code, and builds the project afterwards. Build errors
+// are passed to the next call of generate to repair the code, until the project builds or the attempts are used up.
+// generate is called with empty build errors first.
+func (s *Service) buildFixLoop(ctx context.Context, generate func(ctx context.Context, buildErrors string) string) string {
+	var buildErrors string
+	for attempt := 0; ; attempt++ {
+		result := generate(ctx, buildErrors)
+		if ctx.Err() != nil {
+			return result
+		}
+		showProgress(ctx, "building the code (attempt %d of %d)", attempt+1, maxBuildFixAttempts+1)
+		err := s.build(ctx)
+		if err == nil {
+			reportProgress(ctx, "code built (attempt %d of %d)", attempt+1, maxBuildFixAttempts+1)
+			return result
+		}
+		reportProgress(ctx, "build failed (attempt %d of %d)", attempt+1, maxBuildFixAttempts+1)
+		if attempt == maxBuildFixAttempts {
+			return fmt.Sprintf("The code still doesn't build after %d repair attempts:\n%v\n\n%s", attempt, err, result)
+		}
+		log.Debug().Msgf("Repairing generated code, attempt %d: %v", attempt+1, err)
+		buildErrors = fmt.Sprintf("The saved code doesn't build. Fix the errors and save the code again:\n%v", err)
+	}
+}
+
+// runWithBuildFix runs the code generating agent in the build-fix loop, continuing its conversation with build errors.
+func (s *Service) runWithBuildFix(ctx context.Context, agent *Agent) string {
+	return s.buildFixLoop(ctx, func(ctx context.Context, buildErrors string) string {
+		if buildErrors == "" {
+			return agent.Run(ctx)
+		}
+		return agent.Continue(ctx, buildErrors)
+	})
+}
+
+// build builds the generated project. With strict verification, the project is also vetted and its tests are run
+// with the race detector, catching data races of concurrent code like SSE streams and workers.
+func (s *Service) build(ctx context.Context) (err error) {
+	defer func() {
+		if err != nil && ctx.Err() == nil {
+			telemetry.BuildFailed()
+		}
+	}()
+
+	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path of project root: %w", err)
+	}
+	cmd := exec.CommandContext(ctx, "go", "build", "./...")
+	cmd.Dir = absRoot
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go build failed: %w\n%s", err, output)
+	}
+	if !s.StrictVerification {
+		return nil
+	}
+
+	cmd = exec.CommandContext(ctx, "go", "vet", "./...")
+	cmd.Dir = absRoot
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go vet failed: %w\n%s", err, output)
+	}
+	return goTest(ctx, absRoot, "-race")
+}
+
+const RunTestsToolName = "run_tests"
+
+func (s *Service) RunTestsTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(RunTestsToolName),
+			Description: openai.String("Runs tests of the generated Go code and reports the failed ones with their output, or the test coverage when they pass."),
+		}),
+	}
+}
+
+func (s *Service) RunTests(ctx context.Context) string {
 	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
 	if err != nil {
 		return fmt.Sprintf("Failed to get absolute path of project root: %v", err)
 	}
-	cmd := exec.CommandContext(ctx, "go", "build")
+	profile := filepath.Join(s.TmpDir, "coverage.out")
+	flags := []string{"-coverprofile=" + profile}
+	if s.StrictVerification {
+		flags = append(flags, "-race")
+	}
+	if err := goTest(ctx, absRoot, flags...); err != nil {
+		return err.Error()
+	}
+
+	summary, err := coverageSummary(ctx, absRoot, profile)
+	if err != nil {
+		log.Err(err).Msg("Failed to summarize test coverage")
+		return "All tests passed"
+	}
+	return "All tests passed\n" + summary
+}
+
+// goTest runs tests of the generated project with the flags, returning their failures as an error.
+func goTest(ctx context.Context, absRoot string, flags ...string) error {
+	args := append(append([]string{"test", "-json"}, flags...), "./...")
+	cmd := exec.CommandContext(ctx, "go", args...)
 	cmd.Dir = absRoot
+	// The race detector requires cgo.
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=1")
 
 	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Sprintf("go build failed: %v\n%s", err, output)
+	if err == nil {
+		return nil
+	}
+	failures := parseTestFailures(output)
+	if failures == "" {
+		return fmt.Errorf("go test failed: %w\n%s", err, output)
 	}
 
-	return "Code built successfully"
+	return fmt.Errorf("go test failed, fix the code so the tests pass (change a test only when it contradicts the spec):\n%s", failures)
+}
+
+// testEvent is a line of go test -json output.
+type testEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Output  string
+}
+
+// parseTestFailures returns build errors, the output of failed tests, and the output of failed packages without failed
+// tests. Lines that aren't test events, such as build errors of older Go versions, are kept as they are.
+func parseTestFailures(output []byte) string {
+	type result struct {
+		pkg, test string
+	}
+	outputs := make(map[result][]string)
+	pkgsWithFailedTests := make(map[string]bool)
+	var failed []result
+	var other []string
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var e testEvent
+		if err := json.Unmarshal(line, &e); err != nil {
+			other = append(other, string(line))
+			continue
+		}
+		r := result{pkg: e.Package, test: e.Test}
+		switch e.Action {
+		case "build-output":
+			other = append(other, strings.TrimSuffix(e.Output, "\n"))
+		case "output":
+			outputs[r] = append(outputs[r], e.Output)
+		case "fail":
+			failed = append(failed, r)
+			if e.Test != "" {
+				pkgsWithFailedTests[e.Package] = true
+			}
+		}
+	}
+
+	var sb strings.Builder
+	for _, line := range other {
+		sb.WriteString(line + "\n")
+	}
+	for _, r := range failed {
+		// Output of the package repeats the output of its failed tests.
+		if r.test == "" && pkgsWithFailedTests[r.pkg] {
+			continue
+		}
+		name := r.pkg
+		if r.test != "" {
+			name += " " + r.test
+		}
+		fmt.Fprintf(&sb, "FAIL %s\n%s", name, strings.Join(outputs[r], ""))
+	}
+	return sb.String()
 }
@@ -0,0 +1,265 @@
+package tooling
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// Entries of a session bundle, a gzipped tar archive.
+const (
+	bundleSessionEntry    = "session.json"
+	bundleTranscriptEntry = "transcript.md"
+	bundleMemoryEntry     = "memory.jsonl"
+	bundleWorkflowEntry   = "workflow.json"
+	bundleArtifactsEntry  = "artifacts.txt"
+	bundleVersion         = 1
+)
+
+// bundleSession describes the exported session.
+type bundleSession struct {
+	Version    int       `json:"version"`
+	SessionID  string    `json:"session_id"`
+	Generator  string    `json:"generator"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// bundleEntry is a file of the bundle archive.
+type bundleEntry struct {
+	name    string
+	content []byte
+}
+
+// ImportedSession is the session imported from a bundle.
+type ImportedSession struct {
+	SessionID string
+	// Missing are the generated artifacts of the session which aren't in the project, e.g. files which weren't
+	// committed. Steps which created them are run again when the session is resumed.
+	Missing []string
+	// LockKept is set when the project has its own lock file, which was kept instead of the bundled one.
+	LockKept bool
+}
+
+// ExportSession writes the bundle of the session to the file: the transcript, the memory with its embeddings, the
+// workflow state, the lock file, and the list of generated artifacts. The bundle is imported with ImportSession, so
+// the session can be resumed on another machine.
+func ExportSession(ctx context.Context, vs *vector.Service, sid, name string) error {
+	rootDir := os.Getenv("PROJECT_ROOT")
+	state, err := os.ReadFile(workflow.StateFile(rootDir, sid))
+	if err != nil {
+		return fmt.Errorf("failed to read workflow state of session %s: %w", sid, err)
+	}
+	mem := &vector.MemoryService{V: vs, SessionID: sid}
+	records, err := mem.Export(ctx)
+	if err != nil {
+		return err
+	}
+	tracked, err := loadManifest()
+	if err != nil {
+		return err
+	}
+
+	session, err := json.MarshalIndent(bundleSession{
+		Version:    bundleVersion,
+		SessionID:  sid,
+		Generator:  generatorVersion(),
+		ExportedAt: time.Now().UTC(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	var memory, artifacts bytes.Buffer
+	enc := json.NewEncoder(&memory)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return fmt.Errorf("failed to encode memory: %w", err)
+		}
+	}
+	for _, a := range tracked {
+		fmt.Fprintf(&artifacts, "%s %s\n", a.Kind, a.Name)
+	}
+
+	// The data model is appended to the transcript, so readers of the transcript see what the session designed.
+	transcriptContent := transcript(records)
+	if diagram, err := savedERD(); err != nil {
+		return fmt.Errorf("failed to read entity-relationship diagram: %w", err)
+	} else if diagram != "" {
+		transcriptContent = append(transcriptContent, "## Data model\n\n"+diagram...)
+	}
+
+	entries := []bundleEntry{
+		{bundleSessionEntry, session},
+		{bundleTranscriptEntry, transcriptContent},
+		{bundleMemoryEntry, memory.Bytes()},
+		{bundleWorkflowEntry, state},
+		{bundleArtifactsEntry, artifacts.Bytes()},
+	}
+	if lock, err := os.ReadFile(filepath.Join(rootDir, manifestFile)); err == nil {
+		entries = append(entries, bundleEntry{manifestFile, lock})
+	}
+
+	// The bundle lives outside the project usually, so it isn't tracked like generated files.
+	return writeBundle(name, entries)
+}
+
+// writeBundle writes the entries to the file as a gzipped tar archive.
+func writeBundle(name string, entries []bundleEntry) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0644, Size: int64(len(e.content)), ModTime: time.Now()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+		if _, err := tw.Write(e.content); err != nil {
+			return fmt.Errorf("failed to write bundle: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := os.WriteFile(name, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// transcript renders the memory records as a Markdown conversation. Repeated memories are rendered once, with the
+// number of their occurrences.
+func transcript(records []vector.Record) []byte {
+	var sb bytes.Buffer
+	for _, r := range records {
+		var repeated string
+		if r.Occurrences > 1 {
+			repeated = fmt.Sprintf(", %d times", r.Occurrences)
+		}
+		fmt.Fprintf(&sb, "## %s (%s%s)\n\n%s\n\n", r.Role, r.CreatedAt.Format(time.RFC3339), repeated, strings.TrimSpace(r.Content))
+	}
+	return sb.Bytes()
+}
+
+// ImportSession restores the session of the bundle in the project, so it can be resumed. The session mustn't exist in
+// the project yet. The lock file of the bundle is only restored when the project has none.
+func ImportSession(ctx context.Context, vs *vector.Service, name string) (*ImportedSession, error) {
+	entries, err := readBundle(name)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range []string{bundleSessionEntry, bundleMemoryEntry, bundleWorkflowEntry} {
+		if _, ok := entries[entry]; !ok {
+			return nil, fmt.Errorf("bundle has no %s", entry)
+		}
+	}
+	var session bundleSession
+	if err := json.Unmarshal(entries[bundleSessionEntry], &session); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", bundleSessionEntry, err)
+	}
+	if session.Version > bundleVersion {
+		return nil, fmt.Errorf("bundle was exported by a newer version of DoubleTab (format %d)", session.Version)
+	}
+	// The session ID names the workflow state file, so it mustn't point elsewhere.
+	if !filepath.IsLocal(session.SessionID) || filepath.Base(session.SessionID) != session.SessionID {
+		return nil, fmt.Errorf("invalid session ID %q", session.SessionID)
+	}
+
+	rootDir := os.Getenv("PROJECT_ROOT")
+	stateFile := workflow.StateFile(rootDir, session.SessionID)
+	if _, err := os.Stat(stateFile); err == nil {
+		return nil, fmt.Errorf("session %s exists in the project already", session.SessionID)
+	}
+
+	var records []vector.Record
+	scanner := bufio.NewScanner(bytes.NewReader(entries[bundleMemoryEntry]))
+	// Memories contain whole generated files, far longer than the default token size.
+	scanner.Buffer(nil, len(entries[bundleMemoryEntry])+1)
+	for scanner.Scan() {
+		var r vector.Record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", bundleMemoryEntry, err)
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", bundleMemoryEntry, err)
+	}
+	mem := &vector.MemoryService{V: vs, SessionID: session.SessionID}
+	if err := mem.Import(ctx, records); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create workflow state directory: %w", err)
+	}
+	if err := os.WriteFile(stateFile, entries[bundleWorkflowEntry], 0644); err != nil {
+		return nil, fmt.Errorf("failed to write workflow state: %w", err)
+	}
+
+	imported := &ImportedSession{SessionID: session.SessionID}
+	if lock, ok := entries[manifestFile]; ok {
+		lockFile := filepath.Join(rootDir, manifestFile)
+		if _, err := os.Stat(lockFile); err == nil {
+			imported.LockKept = true
+		} else if err := os.WriteFile(lockFile, lock, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", manifestFile, err)
+		}
+	}
+	for _, line := range strings.Split(string(entries[bundleArtifactsEntry]), "\n") {
+		kind, file, ok := strings.Cut(line, " ")
+		if !ok || kind != artifactFile {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(rootDir, file)); errors.Is(err, os.ErrNotExist) {
+			imported.Missing = append(imported.Missing, file)
+		}
+	}
+	return imported, nil
+}
+
+// readBundle returns the contents of the bundle entries by name.
+func readBundle(name string) (map[string][]byte, error) {
+	fh, err := os.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer fh.Close()
+	gz, err := gzip.NewReader(fh)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle: %w", err)
+	}
+	defer gz.Close()
+
+	entries := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bundle entry %s: %w", hdr.Name, err)
+		}
+		entries[hdr.Name] = content
+	}
+	return entries, nil
+}
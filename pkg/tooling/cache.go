@@ -0,0 +1,62 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/openai/openai-go"
+)
+
+// goRedisPackage is added to the generated project when the cache layer is generated.
+const goRedisPackage = "github.com/redis/go-redis/v9@v9.7.3"
+
+const cacheLayerPrompt = `
+## Cache layer
+
+Read-heavy resources are cached in Redis with the cache-aside pattern, using the cache package (already generated):
+
+- Generate a Cached struct in the repository package implementing the Repository interface on top of another
+  Repository, created by NewCached(next %[1]s.Repository, c *cache.Cache) *Cached, and save it using
+  "save_repository_code" tool together with the other repository code.
+- Get methods read "<resource>:<id>" keys with Cache.Get and, on a miss, call the next repository and store the result
+  with Cache.Set.
+- List methods do the same with "<resource>:list:<parameters>" keys, including all filtering and paging parameters.
+- Create, update, and delete methods call the next repository first, then invalidate the item key with
+  Cache.Invalidate and all the lists of the resource with Cache.InvalidatePrefix("<resource>:list:").
+- Cache errors are logged and never fail the request, the next repository is used instead.
+`
+
+const GenerateCacheLayerToolName = "generate_cache_layer"
+
+func (s *Service) GenerateCacheLayerTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateCacheLayerToolName),
+			Description: openai.String("Generates Redis cache package and docker-compose wiring, and enables cache-aside layer wrapping the repository layer. Use it before generating code when the user agreed to cache read-heavy endpoints."),
+		}),
+	}
+}
+
+func (s *Service) GenerateCacheLayer(ctx context.Context) string {
+	rootDir := os.Getenv("PROJECT_ROOT")
+	if err := s.writeFile(path.Join(rootDir, "pkg", "cache", "cache.go"), cacheGo); err != nil {
+		return fmt.Sprintf("Failed to save cache package: %v", err)
+	}
+	if err := goGet(ctx, rootDir, goRedisPackage); err != nil {
+		return fmt.Sprintf("Failed to add Redis client dependency: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// The cache wraps the repository, so the layer is required even when it wasn't requested.
+	s.Cache = true
+	s.RepositoryLayer = true
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return fmt.Sprintf("Failed to wire cache into the server: %v", err)
+	}
+
+	return "Cache package generated and wired into the server, code generation will include the cached repository"
+}
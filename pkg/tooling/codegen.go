@@ -0,0 +1,78 @@
+package tooling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v3"
+)
+
+const oapiCodegenPackage = "github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen"
+
+// oapiCodegenConfig is the part of oapi-codegen configuration (cfg.yaml) the generated handlers are validated with.
+type oapiCodegenConfig struct {
+	Package string
+	Output  string
+}
+
+// runOAPICodegen generates the handlers of the api package with oapi-codegen configured by cfg.yaml of the package, and
+// returns the path of the generated file, and whether it was regenerated. The handlers are only regenerated when the
+// spec or the configuration changed since they were generated last. The configuration and the go:generate directive are
+// restored when missing, so the handlers can be regenerated with go generate too. The installed oapi-codegen is used
+// when available.
+func (s *Service) runOAPICodegen(ctx context.Context, rootDir string) (string, bool, error) {
+	apiDir := filepath.Join(rootDir, "pkg", "api")
+	for name, content := range map[string]string{"cfg.yaml": cfgYaml, "generate.go": generateGo} {
+		if _, err := os.Stat(filepath.Join(apiDir, name)); errors.Is(err, os.ErrNotExist) {
+			if err := s.writeFile(filepath.Join(apiDir, name), content); err != nil {
+				return "", false, err
+			}
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(apiDir, "cfg.yaml"))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read oapi-codegen config: %w", err)
+	}
+	var cfg oapiCodegenConfig
+	if err := yaml.Unmarshal(content, &cfg); err != nil {
+		return "", false, fmt.Errorf("failed to parse oapi-codegen config: %w", err)
+	}
+	if cfg.Package != "api" {
+		return "", false, fmt.Errorf("oapi-codegen config generates package %q instead of api", cfg.Package)
+	}
+	// The output must stay in the api package, next to the server implementing its interface.
+	if cfg.Output == "" || !filepath.IsLocal(cfg.Output) || filepath.Dir(cfg.Output) != "." || filepath.Ext(cfg.Output) != ".go" {
+		return "", false, fmt.Errorf("oapi-codegen config output %q isn't a Go file in the api package", cfg.Output)
+	}
+
+	output := filepath.Join(apiDir, cfg.Output)
+	input := inputHash(filepath.Join(apiDir, "cfg.yaml"), filepath.Join(apiDir, "doc", "openapi.yaml"))
+	if upToDate(output, input) {
+		return output, false, nil
+	}
+
+	args := []string{"-config", "cfg.yaml", filepath.Join("doc", "openapi.yaml")}
+	cmd := exec.CommandContext(ctx, "go", append([]string{"run", oapiCodegenPackage}, args...)...)
+	if bin, ok := s.toolPath("oapi-codegen"); ok {
+		cmd = exec.CommandContext(ctx, bin, args...)
+	}
+	cmd.Dir = apiDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", false, fmt.Errorf("oapi-codegen failed: %w\n%s", err, output)
+	}
+
+	generated, err := s.headGeneratedFile(output)
+	if err != nil {
+		return "", false, fmt.Errorf("oapi-codegen didn't generate %s: %w", cfg.Output, err)
+	}
+	if err := trackGenerated(output, generated, input); err != nil {
+		log.Err(err).Msg("Failed to track generated handlers file")
+	}
+	return output, true, nil
+}
@@ -0,0 +1,500 @@
+package tooling
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Formats of the collection of example requests written with the OpenAPI spec.
+const (
+	CollectionPostman = "postman"
+	CollectionBruno   = "bruno"
+	CollectionNone    = "none"
+)
+
+// postmanCollectionFile and brunoCollectionDir keep the collections of example requests, relative to the project root.
+const (
+	postmanCollectionFile = "pkg/api/doc/postman_collection.json"
+	brunoCollectionDir    = "pkg/api/doc/bruno"
+)
+
+// collectionBaseURL is the URL of the generated server run locally with the PORT of .env.example.
+const collectionBaseURL = "http://localhost:8181"
+
+// collectionMethods orders the requests of a path.
+var collectionMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+var (
+	pathParamRegexp   = regexp.MustCompile(`^\{(.+)\}$`)
+	bruFileNameRegexp = regexp.MustCompile(`[^A-Za-z0-9 _-]+`)
+)
+
+// apiCollection is the collection of example requests of every endpoint of the spec, shared by the formats.
+type apiCollection struct {
+	Name string
+	// AuthType is the authentication of all requests (bearer, basic), or empty when the spec doesn't declare one.
+	AuthType string
+	// Headers are sent with all requests, e.g. API keys and the tenant header.
+	Headers  []collectionParam
+	Requests []collectionRequest
+	// Variables are the variables of the requests with their initial values.
+	Variables []collectionParam
+}
+
+type collectionRequest struct {
+	// Folder groups the requests of a resource, e.g. books.
+	Folder string
+	Name   string
+	Method string
+	// Path has its parameters replaced with variables, e.g. /books/{{books_id}}.
+	Path    string
+	Query   []collectionParam
+	Headers []collectionParam
+	// Body is the JSON body of the request, empty when it has none.
+	Body string
+	// Multipart is set for file uploads, sent as the "file" form field.
+	Multipart bool
+	// Capture is the variable the id of the created resource is stored in, so requests of the resource can be sent
+	// right after creating it.
+	Capture string
+}
+
+type collectionParam struct {
+	Key      string
+	Value    string
+	Disabled bool
+}
+
+// writeAPICollection writes the collection of example requests of every endpoint of the OpenAPI spec of the project,
+// in the configured format, so the API can be exercised right away.
+func (s *Service) writeAPICollection() error {
+	if s.APICollection == "" || s.APICollection == CollectionNone {
+		return nil
+	}
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		return err
+	}
+	c := s.buildAPICollection(spec)
+	root := os.Getenv("PROJECT_ROOT")
+	switch s.APICollection {
+	case CollectionPostman:
+		content, err := c.postman()
+		if err != nil {
+			return fmt.Errorf("failed to encode Postman collection: %w", err)
+		}
+		return s.writeFile(path.Join(root, postmanCollectionFile), content)
+	case CollectionBruno:
+		for name, content := range c.bruno() {
+			if err := s.writeFile(path.Join(root, brunoCollectionDir, name), content); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported API collection format: %s", s.APICollection)
+	}
+}
+
+func (s *Service) buildAPICollection(spec *openAPISpec) *apiCollection {
+	c := &apiCollection{Name: spec.Info.Title}
+	if c.Name == "" {
+		c.Name = projectModule
+	}
+	vars := map[string]string{"baseUrl": collectionBaseURL}
+	if len(spec.Servers) > 0 {
+		// Relative server URLs, e.g. /v1 of versioned APIs, are relative to the local server.
+		serverURL := strings.TrimSuffix(spec.Servers[0].URL, "/")
+		if strings.HasPrefix(serverURL, "http://") || strings.HasPrefix(serverURL, "https://") {
+			vars["baseUrl"] = serverURL
+		} else {
+			vars["baseUrl"] = collectionBaseURL + serverURL
+		}
+	}
+
+	schemes := make([]string, 0, len(spec.Components.SecuritySchemes))
+	for name := range spec.Components.SecuritySchemes {
+		schemes = append(schemes, name)
+	}
+	sort.Strings(schemes)
+	for _, name := range schemes {
+		scheme := spec.Components.SecuritySchemes[name]
+		switch {
+		case scheme.Type == "http" && strings.EqualFold(scheme.Scheme, "bearer") && c.AuthType == "":
+			c.AuthType = "bearer"
+			vars["token"] = ""
+		case scheme.Type == "http" && strings.EqualFold(scheme.Scheme, "basic") && c.AuthType == "":
+			c.AuthType = "basic"
+			vars["username"] = ""
+			vars["password"] = ""
+		case scheme.Type == "apiKey" && scheme.In == "header" && scheme.Name != "":
+			c.Headers = append(c.Headers, collectionParam{Key: scheme.Name, Value: "{{apiKey}}"})
+			vars["apiKey"] = ""
+		}
+	}
+	if s.MultiTenant {
+		// The generated server reads the tenant from the default TENANT_HEADER unless JWT_SECRET is set.
+		c.Headers = append(c.Headers, collectionParam{Key: "X-Tenant-ID", Value: "{{tenant_id}}"})
+		vars["tenant_id"] = "tenant-1"
+	}
+
+	refs := make(map[string]string)
+	creates := make(map[string]string)
+	for _, r := range spec.resources() {
+		refs[r.Name] = "{{" + r.Name + "_id}}"
+		creates[r.Path] = r.Name
+	}
+	paths := make([]string, 0, len(spec.Paths))
+	for p := range spec.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		item := spec.Paths[p]
+		ops := item.operations()
+		for _, method := range collectionMethods {
+			op, ok := ops[method]
+			if !ok {
+				continue
+			}
+			params := append(append([]specParameter(nil), item.Parameters...), op.Parameters...)
+			req := collectionRequest{
+				Folder: collectionFolder(p),
+				Name:   op.Summary,
+				Method: method,
+				Path:   spec.collectionPath(p, params, refs, vars),
+			}
+			if req.Name == "" {
+				req.Name = method + " " + p
+			}
+			for _, param := range params {
+				if param.In == "query" {
+					value := fmt.Sprint(spec.sampleValue(param.Schema, refs, 0))
+					req.Query = append(req.Query, collectionParam{Key: param.Name, Value: value, Disabled: !param.Required})
+				}
+			}
+			if op.RequestBody != nil {
+				if _, ok := op.RequestBody.Content["multipart/form-data"]; ok {
+					req.Multipart = true
+				} else if schema := op.requestSchema(); schema != nil {
+					body, _ := json.MarshalIndent(spec.sampleValue(schema, refs, 0), "", "  ")
+					req.Body = string(body)
+				}
+			}
+			if method == http.MethodPost && s.Idempotency {
+				req.Headers = append(req.Headers, collectionParam{Key: "Idempotency-Key", Value: "{{$guid}}"})
+			}
+			if method == http.MethodPost {
+				if name, ok := creates[p]; ok {
+					req.Capture = name + "_id"
+				}
+			}
+			c.Requests = append(c.Requests, req)
+		}
+	}
+
+	for key, value := range vars {
+		c.Variables = append(c.Variables, collectionParam{Key: key, Value: value})
+	}
+	sort.Slice(c.Variables, func(i, j int) bool {
+		return c.Variables[i].Key < c.Variables[j].Key
+	})
+	return c
+}
+
+// collectionPath replaces the parameters of the path with variables. Id parameters are named after the resource
+// before them, e.g. books_id of /books/{id}, so they match the variables ids of created resources are stored in.
+// Variables of other parameters start with sample values.
+func (spec *openAPISpec) collectionPath(p string, params []specParameter, refs, vars map[string]string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		m := pathParamRegexp.FindStringSubmatch(segment)
+		if m == nil {
+			continue
+		}
+		name := m[1]
+		if name == "id" && i > 0 {
+			name = segments[i-1] + "_id"
+		}
+		if _, ok := vars[name]; !ok {
+			vars[name] = ""
+			if _, ok := refs[strings.TrimSuffix(name, "_id")]; !ok {
+				for _, param := range params {
+					if param.In == "path" && param.Name == m[1] {
+						vars[name] = fmt.Sprint(spec.sampleValue(param.Schema, refs, 0))
+					}
+				}
+			}
+		}
+		segments[i] = "{{" + name + "}}"
+	}
+	return strings.Join(segments, "/")
+}
+
+// collectionFolder returns the resource of the path, e.g. books of /books/{id} and /books:batch.
+func collectionFolder(p string) string {
+	folder, _, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	folder, _, _ = strings.Cut(folder, ":")
+	if folder == "" {
+		return "root"
+	}
+	return folder
+}
+
+// url returns the URL of the request with its enabled query parameters.
+func (req collectionRequest) url() string {
+	var query []string
+	for _, param := range req.Query {
+		if !param.Disabled {
+			query = append(query, param.Key+"="+param.Value)
+		}
+	}
+	if len(query) == 0 {
+		return "{{baseUrl}}" + req.Path
+	}
+	return "{{baseUrl}}" + req.Path + "?" + strings.Join(query, "&")
+}
+
+type postmanCollection struct {
+	Info struct {
+		Name   string `json:"name"`
+		Schema string `json:"schema"`
+	} `json:"info"`
+	Auth     *postmanAuth   `json:"auth,omitempty"`
+	Item     []postmanItem  `json:"item"`
+	Variable []postmanParam `json:"variable"`
+}
+
+type postmanAuth struct {
+	Type   string         `json:"type"`
+	Bearer []postmanParam `json:"bearer,omitempty"`
+	Basic  []postmanParam `json:"basic,omitempty"`
+}
+
+type postmanItem struct {
+	Name    string          `json:"name"`
+	Item    []postmanItem   `json:"item,omitempty"`
+	Request *postmanRequest `json:"request,omitempty"`
+	Event   []postmanEvent  `json:"event,omitempty"`
+}
+
+type postmanRequest struct {
+	Method string         `json:"method"`
+	Header []postmanParam `json:"header"`
+	URL    postmanURL     `json:"url"`
+	Body   *postmanBody   `json:"body,omitempty"`
+}
+
+type postmanURL struct {
+	Raw   string         `json:"raw"`
+	Host  []string       `json:"host"`
+	Path  []string       `json:"path"`
+	Query []postmanParam `json:"query,omitempty"`
+}
+
+type postmanBody struct {
+	Mode     string         `json:"mode"`
+	Raw      string         `json:"raw,omitempty"`
+	FormData []postmanParam `json:"formdata,omitempty"`
+	Options  map[string]any `json:"options,omitempty"`
+}
+
+type postmanParam struct {
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"`
+	Type     string `json:"type,omitempty"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+type postmanEvent struct {
+	Listen string `json:"listen"`
+	Script struct {
+		Type string   `json:"type"`
+		Exec []string `json:"exec"`
+	} `json:"script"`
+}
+
+// postman returns the collection in the Postman Collection v2.1 format.
+func (c *apiCollection) postman() (string, error) {
+	var pc postmanCollection
+	pc.Info.Name = c.Name
+	pc.Info.Schema = "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"
+	switch c.AuthType {
+	case "bearer":
+		pc.Auth = &postmanAuth{Type: "bearer", Bearer: []postmanParam{{Key: "token", Value: "{{token}}", Type: "string"}}}
+	case "basic":
+		pc.Auth = &postmanAuth{Type: "basic", Basic: []postmanParam{
+			{Key: "username", Value: "{{username}}", Type: "string"},
+			{Key: "password", Value: "{{password}}", Type: "string"},
+		}}
+	}
+	for _, v := range c.Variables {
+		pc.Variable = append(pc.Variable, postmanParam{Key: v.Key, Value: v.Value})
+	}
+
+	folders := make(map[string]int)
+	for _, req := range c.Requests {
+		pr := &postmanRequest{
+			Method: req.Method,
+			Header: []postmanParam{},
+			URL: postmanURL{
+				Raw:  req.url(),
+				Host: []string{"{{baseUrl}}"},
+				Path: strings.Split(strings.TrimPrefix(req.Path, "/"), "/"),
+			},
+		}
+		for _, h := range append(append([]collectionParam(nil), c.Headers...), req.Headers...) {
+			pr.Header = append(pr.Header, postmanParam{Key: h.Key, Value: h.Value})
+		}
+		for _, q := range req.Query {
+			pr.URL.Query = append(pr.URL.Query, postmanParam{Key: q.Key, Value: q.Value, Disabled: q.Disabled})
+		}
+		switch {
+		case req.Multipart:
+			pr.Body = &postmanBody{Mode: "formdata", FormData: []postmanParam{{Key: "file", Type: "file"}}}
+		case req.Body != "":
+			pr.Header = append(pr.Header, postmanParam{Key: "Content-Type", Value: "application/json"})
+			pr.Body = &postmanBody{Mode: "raw", Raw: req.Body, Options: map[string]any{"raw": map[string]string{"language": "json"}}}
+		}
+		item := postmanItem{Name: req.Name, Request: pr}
+		if req.Capture != "" {
+			var event postmanEvent
+			event.Listen = "test"
+			event.Script.Type = "text/javascript"
+			event.Script.Exec = []string{
+				"if (pm.response.code < 300) {",
+				fmt.Sprintf("  pm.collectionVariables.set(%q, pm.response.json().id);", req.Capture),
+				"}",
+			}
+			item.Event = []postmanEvent{event}
+		}
+
+		i, ok := folders[req.Folder]
+		if !ok {
+			i = len(pc.Item)
+			folders[req.Folder] = i
+			pc.Item = append(pc.Item, postmanItem{Name: req.Folder})
+		}
+		pc.Item[i].Item = append(pc.Item[i].Item, item)
+	}
+
+	// Scripts are kept readable, without escaping their comparisons.
+	var sb strings.Builder
+	enc := json.NewEncoder(&sb)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(pc); err != nil {
+		return "", err
+	}
+	return sb.String(), nil
+}
+
+// bruno returns the files of the collection in the Bruno format by their paths relative to the collection directory.
+// Requests are kept in folders of their resources, and the variables in the Local environment.
+func (c *apiCollection) bruno() map[string]string {
+	files := make(map[string]string)
+	manifest, _ := json.MarshalIndent(map[string]any{
+		"version": "1",
+		"name":    c.Name,
+		"type":    "collection",
+		"ignore":  []string{"node_modules", ".git"},
+	}, "", "  ")
+	files["bruno.json"] = string(manifest) + "\n"
+
+	var sb strings.Builder
+	writeBruBlock(&sb, "headers", c.Headers)
+	switch c.AuthType {
+	case "bearer":
+		writeBruBlock(&sb, "auth", []collectionParam{{Key: "mode", Value: "bearer"}})
+		writeBruBlock(&sb, "auth:bearer", []collectionParam{{Key: "token", Value: "{{token}}"}})
+	case "basic":
+		writeBruBlock(&sb, "auth", []collectionParam{{Key: "mode", Value: "basic"}})
+		writeBruBlock(&sb, "auth:basic", []collectionParam{{Key: "username", Value: "{{username}}"}, {Key: "password", Value: "{{password}}"}})
+	default:
+		writeBruBlock(&sb, "auth", []collectionParam{{Key: "mode", Value: "none"}})
+	}
+	files["collection.bru"] = sb.String()
+
+	sb.Reset()
+	writeBruBlock(&sb, "vars", c.Variables)
+	files["environments/Local.bru"] = sb.String()
+
+	for seq, req := range c.Requests {
+		sb.Reset()
+		body := "none"
+		switch {
+		case req.Multipart:
+			body = "multipartForm"
+		case req.Body != "":
+			body = "json"
+		}
+		writeBruBlock(&sb, "meta", []collectionParam{
+			{Key: "name", Value: req.Name},
+			{Key: "type", Value: "http"},
+			{Key: "seq", Value: fmt.Sprint(seq + 1)},
+		})
+		writeBruBlock(&sb, strings.ToLower(req.Method), []collectionParam{
+			{Key: "url", Value: req.url()},
+			{Key: "body", Value: body},
+			{Key: "auth", Value: "inherit"},
+		})
+		writeBruBlock(&sb, "params:query", req.Query)
+		writeBruBlock(&sb, "headers", req.Headers)
+		switch {
+		case req.Multipart:
+			writeBruBlock(&sb, "body:multipart-form", []collectionParam{{Key: "file", Value: "@file()"}})
+		case req.Body != "":
+			writeBruText(&sb, "body:json", req.Body)
+		}
+		if req.Capture != "" {
+			writeBruText(&sb, "script:post-response", fmt.Sprintf(
+				"if (res.status < 300) {\n  bru.setVar(%q, res.body.id);\n}", req.Capture))
+		}
+
+		name := strings.Join(strings.Fields(bruFileNameRegexp.ReplaceAllString(req.Name, " ")), " ")
+		file := path.Join(req.Folder, name+".bru")
+		for i := 2; files[file] != ""; i++ {
+			file = path.Join(req.Folder, fmt.Sprintf("%s %d.bru", name, i))
+		}
+		files[file] = sb.String()
+	}
+	return files
+}
+
+// writeBruBlock writes the dictionary block of a .bru file, skipping empty blocks. Disabled entries are prefixed
+// with ~.
+func writeBruBlock(sb *strings.Builder, name string, entries []collectionParam) {
+	if len(entries) == 0 {
+		return
+	}
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(sb, "%s {\n", name)
+	for _, e := range entries {
+		prefix := ""
+		if e.Disabled {
+			prefix = "~"
+		}
+		fmt.Fprintf(sb, "  %s%s: %s\n", prefix, e.Key, e.Value)
+	}
+	sb.WriteString("}\n")
+}
+
+// writeBruText writes the text block of a .bru file, e.g. a JSON body or a script, indented within the block.
+func writeBruText(sb *strings.Builder, name, text string) {
+	if sb.Len() > 0 {
+		sb.WriteString("\n")
+	}
+	fmt.Fprintf(sb, "%s {\n", name)
+	for _, line := range strings.Split(text, "\n") {
+		fmt.Fprintf(sb, "  %s\n", line)
+	}
+	sb.WriteString("}\n")
+}
@@ -0,0 +1,216 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/openai/openai-go"
+	"github.com/rs/zerolog/log"
+)
+
+// generatedColumns are columns of generated tables which aren't properties of the request schemas, set by the database
+// or the server.
+var generatedColumns = map[string]bool{"id": true, "created_at": true, "updated_at": true, "tenant_id": true}
+
+// specColumnTypes are the PostgreSQL types of columns each JSON schema type of the spec can be stored in, by udt name.
+// Columns of types not listed by any, like enum types, are taken to match strings.
+var specColumnTypes = map[string][]string{
+	"string":  {"text", "varchar", "bpchar", "citext", "uuid", "date", "timestamp", "timestamptz", "time", "timetz", "interval", "inet", "cidr", "bytea"},
+	"integer": {"int2", "int4", "int8", "numeric"},
+	"number":  {"float4", "float8", "numeric", "int2", "int4", "int8"},
+	"boolean": {"bool"},
+	"object":  {"json", "jsonb"},
+	"array":   {"json", "jsonb"},
+}
+
+// specFormatTypes narrow the column types of strings of the formats.
+var specFormatTypes = map[string][]string{
+	"date-time": {"timestamp", "timestamptz"},
+	"date":      {"date", "timestamp", "timestamptz"},
+	"uuid":      {"uuid", "text", "varchar", "bpchar"},
+	"binary":    {"bytea", "text"},
+}
+
+// consistencyColumn is a column of the applied schema.
+type consistencyColumn struct {
+	Table      string `db:"table_name"`
+	Name       string `db:"column_name"`
+	Type       string `db:"udt_name"`
+	Nullable   string `db:"is_nullable"`
+	HasDefault bool   `db:"has_default"`
+}
+
+const VerifyConsistencyToolName = "verify_consistency"
+
+func (s *Service) VerifyConsistencyTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(VerifyConsistencyToolName),
+			Description: openai.String("Cross-checks the request schemas of the resources in the OpenAPI spec against the tables applied to the database (columns, nullability, types) and reports the drift between them."),
+		}),
+	}
+}
+
+func (s *Service) VerifyConsistency(ctx context.Context) string {
+	findings, warnings, err := s.consistencyFindings(ctx, "")
+	if err != nil {
+		return fmt.Sprintf("Failed to verify consistency of the spec and the schema: %v", err)
+	}
+	var result string
+	if len(findings) > 0 {
+		result = "Inconsistent spec and schema, regenerate the schema of the tables or the spec so they match:\n" + strings.Join(findings, "\n")
+	} else {
+		result = "The spec and the schema are consistent"
+	}
+	if len(warnings) > 0 {
+		result += "\n\nResources without tables, which is fine when they aren't stored in the database:\n" + strings.Join(warnings, "\n")
+	}
+	return result
+}
+
+// checkConsistency verifies the consistency of the spec and the schema before the code of the entity, or of all
+// resources without it, is generated. It returns the rejection of the generation when columns drifted apart from the
+// properties, or an empty string. Resources without tables are only warned about, as not every resource is stored in
+// the database. Failing to verify them doesn't stop the generation.
+func (s *Service) checkConsistency(ctx context.Context, entity string) string {
+	findings, warnings, err := s.consistencyFindings(ctx, entity)
+	for _, warning := range warnings {
+		log.Warn().Msg(strings.TrimPrefix(warning, "- "))
+	}
+	if err != nil {
+		log.Err(err).Msg("Failed to verify consistency of the spec and the schema")
+		return ""
+	}
+	if len(findings) == 0 {
+		return ""
+	}
+	return "Inconsistent spec and schema, fix them before generating the server code:\n" + strings.Join(findings, "\n")
+}
+
+// consistencyFindings returns the drift between the resources of the spec, or only the resource of the entity when
+// it's given, and the tables of the database, one finding per line. Every property of a request schema must have a
+// column of a matching type, nullable unless the property is required, and columns the requests can't set must be
+// nullable or have defaults. Resources without tables are returned as warnings, apart from the drift.
+func (s *Service) consistencyFindings(ctx context.Context, entity string) ([]string, []string, error) {
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		return nil, nil, err
+	}
+	var columns []consistencyColumn
+	if err := s.DB.SelectContext(ctx, &columns, `SELECT table_name, column_name, udt_name, is_nullable,
+       column_default IS NOT NULL AS has_default
+FROM information_schema.columns WHERE table_schema = 'public' ORDER BY table_name, ordinal_position`); err != nil {
+		return nil, nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	tables := make(map[string][]consistencyColumn)
+	for _, c := range columns {
+		tables[c.Table] = append(tables[c.Table], c)
+	}
+
+	var findings, warnings []string
+	for _, r := range spec.resources() {
+		if entity != "" && !resourceOf(r.Name, entity) {
+			continue
+		}
+		// Tables named by another convention than the current one, e.g. generated before it changed, match as well.
+		table := s.tableName(r.Name)
+		var cols []consistencyColumn
+		for _, name := range []string{table, pluralize(table), singularize(table)} {
+			if cols = tables[name]; cols != nil {
+				table = name
+				break
+			}
+		}
+		if cols == nil {
+			warnings = append(warnings, fmt.Sprintf("- %s: no table for the resource %s", table, r.Path))
+			continue
+		}
+		schema := spec.resolve(r.Schema)
+		properties := make(map[string]bool)
+		for _, prop := range slices.Sorted(maps.Keys(schema.Properties)) {
+			propSchema := spec.resolve(schema.Properties[prop])
+			if propSchema.ReadOnly {
+				continue
+			}
+			name := snakeCase(prop)
+			properties[name] = true
+			i := slices.IndexFunc(cols, func(c consistencyColumn) bool { return c.Name == name })
+			if i < 0 {
+				findings = append(findings, fmt.Sprintf("- %s: no column for property %s", table, prop))
+				continue
+			}
+			col := cols[i]
+			if !columnTypeMatches(propSchema, col.Type) {
+				typ := propSchema.Type
+				if propSchema.Format != "" {
+					typ += " (" + propSchema.Format + ")"
+				}
+				findings = append(findings, fmt.Sprintf("- %s.%s: column of type %s stores property %s of type %s", table, col.Name, col.Type, prop, typ))
+			}
+			if slices.Contains(schema.Required, prop) && col.Nullable == "YES" {
+				findings = append(findings, fmt.Sprintf("- %s.%s: column is nullable, but property %s is required", table, col.Name, prop))
+			}
+			if !slices.Contains(schema.Required, prop) && col.Nullable == "NO" && !col.HasDefault {
+				findings = append(findings, fmt.Sprintf("- %s.%s: column is NOT NULL without a default, but property %s is optional", table, col.Name, prop))
+			}
+		}
+		for _, col := range cols {
+			if properties[col.Name] || generatedColumns[col.Name] || col.Name == s.idColumn(table) || col.Nullable == "YES" || col.HasDefault {
+				continue
+			}
+			findings = append(findings, fmt.Sprintf("- %s.%s: column is NOT NULL without a default, but no property sets it", table, col.Name))
+		}
+	}
+	return findings, warnings, nil
+}
+
+// resourceOf reports whether the resource, named by its collection path like books, is the resource of the entity,
+// like Book.
+func resourceOf(resource, entity string) bool {
+	singular := strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(entity)))
+	resource = strings.ReplaceAll(resource, "-", "_")
+	return slices.Contains([]string{singular, singular + "s", singular + "es", strings.TrimSuffix(singular, "y") + "ies"}, resource)
+}
+
+// columnTypeMatches reports whether the column type can store values of the schema.
+func columnTypeMatches(schema *specSchema, udt string) bool {
+	if schema.Type == "" {
+		return true
+	}
+	if schema.Type == "array" && strings.HasPrefix(udt, "_") {
+		return true
+	}
+	known := false
+	for _, types := range specColumnTypes {
+		known = known || slices.Contains(types, udt)
+	}
+	if !known {
+		return schema.Type == "string"
+	}
+	if !slices.Contains(specColumnTypes[schema.Type], udt) {
+		return false
+	}
+	if formats, ok := specFormatTypes[schema.Format]; ok && schema.Type == "string" {
+		return slices.Contains(formats, udt)
+	}
+	return true
+}
+
+// snakeCase returns the column name of the property, e.g. author_id of authorId and authorID.
+func snakeCase(name string) string {
+	var sb strings.Builder
+	var prev rune
+	for _, r := range name {
+		if unicode.IsUpper(r) && (unicode.IsLower(prev) || unicode.IsDigit(prev)) {
+			sb.WriteByte('_')
+		}
+		sb.WriteRune(unicode.ToLower(r))
+		prev = r
+	}
+	return sb.String()
+}
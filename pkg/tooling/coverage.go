@@ -0,0 +1,96 @@
+package tooling
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"golang.org/x/tools/cover"
+)
+
+// handlerPackages contain the handlers and resolvers whose coverage is reported per function.
+var handlerPackages = []string{projectModule + "/pkg/api/", projectModule + "/pkg/graph/"}
+
+// coverageSummary prints the per-package coverage of the profile to the terminal and returns it along with the
+// handlers not covered by any test.
+func coverageSummary(ctx context.Context, absRoot, profile string) (string, error) {
+	profiles, err := cover.ParseProfiles(profile)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse coverage profile: %w", err)
+	}
+
+	type statements struct {
+		covered, total int
+	}
+	pkgs := make(map[string]*statements)
+	var total statements
+	for _, p := range profiles {
+		pkg := path.Dir(p.FileName)
+		if pkgs[pkg] == nil {
+			pkgs[pkg] = &statements{}
+		}
+		for _, b := range p.Blocks {
+			pkgs[pkg].total += b.NumStmt
+			total.total += b.NumStmt
+			if b.Count > 0 {
+				pkgs[pkg].covered += b.NumStmt
+				total.covered += b.NumStmt
+			}
+		}
+	}
+	percent := func(s statements) float64 {
+		if s.total == 0 {
+			return 0
+		}
+		return 100 * float64(s.covered) / float64(s.total)
+	}
+
+	names := make([]string, 0, len(pkgs))
+	for pkg := range pkgs {
+		names = append(names, pkg)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	table := pterm.TableData{{"Package", "Coverage"}}
+	for _, pkg := range names {
+		coverage := fmt.Sprintf("%.1f%%", percent(*pkgs[pkg]))
+		table = append(table, []string{pkg, coverage})
+		fmt.Fprintf(&sb, "%s: %s\n", pkg, coverage)
+	}
+	table = append(table, []string{"total", fmt.Sprintf("%.1f%%", percent(total))})
+	if err := pterm.DefaultTable.WithHasHeader().WithData(table).Render(); err != nil {
+		return "", fmt.Errorf("failed to print coverage: %w", err)
+	}
+	fmt.Fprintf(&sb, "Total coverage: %.1f%% of statements", percent(total))
+
+	// go tool cover reports coverage of functions, which the profile has no notion of.
+	cmd := exec.CommandContext(ctx, "go", "tool", "cover", "-func="+profile)
+	cmd.Dir = absRoot
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go tool cover failed: %w", err)
+	}
+	var untested []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[2] != "0.0%" {
+			continue
+		}
+		for _, pkg := range handlerPackages {
+			if strings.HasPrefix(fields[0], pkg) {
+				untested = append(untested, fields[1])
+			}
+		}
+	}
+	if len(untested) > 0 {
+		fmt.Fprintf(&sb, "\nUntested handlers: %s", strings.Join(untested, ", "))
+	}
+	return sb.String(), nil
+}
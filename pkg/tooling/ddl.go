@@ -0,0 +1,41 @@
+package tooling
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// parseDDL parses the statements with the PostgreSQL parser, so syntax errors are reported before anything reaches the
+// database.
+func parseDDL(statements []string) error {
+	var errs []error
+	for _, stmt := range statements {
+		if _, err := pg_query.Parse(stmt); err != nil {
+			errs = append(errs, fmt.Errorf("%w in: %s", err, stmt))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// execDDL executes the statements in a single transaction, so they are either all applied or none. With dryRun, the
+// transaction is always rolled back, catching errors only the database reports, like unknown types or invalid checks.
+func (s *Service) execDDL(ctx context.Context, statements []string, dryRun bool) error {
+	tx, err := s.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%w in: %s", err, stmt)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+	return tx.Commit()
+}
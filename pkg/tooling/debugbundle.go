@@ -0,0 +1,152 @@
+package tooling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/redact"
+	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// Entries of a debug bundle, besides the transcript, the workflow state, and the lock file of session bundles.
+const (
+	debugVersionsEntry   = "versions.txt"
+	debugConfigEntry     = "config.yaml"
+	debugAuditEntry      = "audit.jsonl"
+	debugHistoryEntry    = "history.jsonl"
+	debugSchemaEntry     = "artifacts/schema.sql"
+	debugArtifactsDir    = "artifacts/"
+	maxDebugAuditEntries = 10000
+)
+
+// DebugBundleName returns the default name of the debug bundle of the session.
+func DebugBundleName(sid string) string {
+	return fmt.Sprintf("doubletab-debug-%s.tar.gz", sid)
+}
+
+// ExportDebugBundle writes the archive maintainers need to reproduce a failure of the session to the file: the
+// transcript, the configuration, the audit log and generation history of the session, the workflow state, the
+// generated files and tables, and the versions of DoubleTab and its dependencies. Credentials are redacted from all of
+// them.
+func ExportDebugBundle(ctx context.Context, cfg *config.Config, vs *vector.Service, history *vector.HistoryService, audit *vector.AuditService, sid, name string) error {
+	redactor, err := redact.New(cfg.Secrets(), cfg.Redact)
+	if err != nil {
+		return err
+	}
+	rootDir := os.Getenv("PROJECT_ROOT")
+	state, err := os.ReadFile(workflow.StateFile(rootDir, sid))
+	if err != nil {
+		return fmt.Errorf("failed to read workflow state of session %s: %w", sid, err)
+	}
+	mem := &vector.MemoryService{V: vs, SessionID: sid}
+	records, err := mem.Export(ctx)
+	if err != nil {
+		return err
+	}
+	settings, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	invocations, err := audit.Query(ctx, vector.AuditFilter{SessionID: sid, Limit: maxDebugAuditEntries})
+	if err != nil {
+		return err
+	}
+	var auditLog bytes.Buffer
+	enc := json.NewEncoder(&auditLog)
+	for _, e := range invocations {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to encode audit log: %w", err)
+		}
+	}
+	steps, err := history.List(ctx)
+	if err != nil {
+		return err
+	}
+	var historyLog bytes.Buffer
+	enc = json.NewEncoder(&historyLog)
+	for _, e := range steps {
+		if e.SessionID != sid {
+			continue
+		}
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("failed to encode history: %w", err)
+		}
+	}
+
+	entries := []bundleEntry{
+		{debugVersionsEntry, debugVersions(ctx, cfg, vs)},
+		{debugConfigEntry, settings},
+		{bundleTranscriptEntry, transcript(records)},
+		{bundleWorkflowEntry, state},
+		{debugAuditEntry, auditLog.Bytes()},
+		{debugHistoryEntry, historyLog.Bytes()},
+	}
+	entries = append(entries, debugArtifacts(rootDir)...)
+	for i, e := range entries {
+		entries[i].content = []byte(redactor.Redact(string(e.content)))
+	}
+	return writeBundle(name, entries)
+}
+
+// debugArtifacts returns the lock file, the generated files which still exist, and the DDL of the generated tables.
+func debugArtifacts(rootDir string) []bundleEntry {
+	var entries []bundleEntry
+	if lock, err := os.ReadFile(filepath.Join(rootDir, manifestFile)); err == nil {
+		entries = append(entries, bundleEntry{manifestFile, lock})
+	}
+	tracked, err := loadManifest()
+	if err != nil {
+		return entries
+	}
+	var schema bytes.Buffer
+	for _, a := range tracked {
+		switch a.Kind {
+		case artifactTable:
+			fmt.Fprintf(&schema, "%s;\n\n", strings.TrimSuffix(strings.TrimSpace(a.DDL), ";"))
+		case artifactFile:
+			if !filepath.IsLocal(a.Name) {
+				continue
+			}
+			if content, err := os.ReadFile(filepath.Join(rootDir, a.Name)); err == nil {
+				entries = append(entries, bundleEntry{debugArtifactsDir + filepath.ToSlash(a.Name), content})
+			}
+		}
+	}
+	if schema.Len() > 0 {
+		entries = append(entries, bundleEntry{debugSchemaEntry, schema.Bytes()})
+	}
+	return entries
+}
+
+// debugVersions describes the versions of DoubleTab, Go, PostgreSQL, and the models, failures included.
+func debugVersions(ctx context.Context, cfg *config.Config, vs *vector.Service) []byte {
+	var sb bytes.Buffer
+	fmt.Fprintf(&sb, "doubletab: %s\n", generatorVersion())
+	fmt.Fprintf(&sb, "built with: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if out, err := exec.CommandContext(ctx, "go", "version").Output(); err == nil {
+		fmt.Fprintf(&sb, "go: %s\n", strings.TrimSpace(string(out)))
+	} else {
+		fmt.Fprintf(&sb, "go: %v\n", err)
+	}
+	if pgVersion, err := vs.Version(ctx); err == nil {
+		fmt.Fprintf(&sb, "postgresql: %s\n", pgVersion)
+	} else {
+		fmt.Fprintf(&sb, "postgresql: %v\n", err)
+	}
+	fmt.Fprintf(&sb, "chat model: %s\ncode model: %s\nembedding model: %s\n", cfg.LLMChatModel, cfg.LLMCodeModel, cfg.LLMEmbeddingModel)
+	fmt.Fprintf(&sb, "created at: %s\n", time.Now().UTC().Format(time.RFC3339))
+	return sb.Bytes()
+}
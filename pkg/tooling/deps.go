@@ -0,0 +1,100 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// projectModule is the module path of the generated project.
+const projectModule = "myApp"
+
+const ManageDepsToolName = "manage_deps"
+
+func (s *Service) ManageDepsTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(ManageDepsToolName),
+			Description: openai.String("Manages Go modules of the generated project: init creates go.mod when it's missing, get adds modules of the packages imported by the generated code (e.g. github.com/go-chi/chi/v5), and tidy adds missing and removes unused requirements. Use it when the build fails on missing modules."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"action": map[string]interface{}{
+						"type": "string",
+						"enum": []string{"init", "get", "tidy"},
+					},
+					"packages": map[string]interface{}{
+						"type":        "array",
+						"description": "Packages to get, optionally with versions (e.g. github.com/jackc/pgx/v5@v5.7.2).",
+						"items": map[string]string{
+							"type": "string",
+						},
+					},
+				},
+				"required": []string{"action"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) ManageDeps(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	action, _ := args["action"].(string)
+	rootDir := os.Getenv("PROJECT_ROOT")
+
+	switch action {
+	case "init":
+		if _, err := os.Stat(path.Join(rootDir, "go.mod")); err == nil {
+			return fmt.Sprintf("go.mod already exists, the module is %s", projectModule)
+		}
+		if err := goModCommand(ctx, rootDir, "init", projectModule); err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("Module %s initialized", projectModule)
+	case "get":
+		items, _ := args["packages"].([]interface{})
+		var pkgs []string
+		for _, item := range items {
+			pkg, ok := item.(string)
+			// Packages starting with a dash would be passed as flags.
+			if !ok || pkg == "" || strings.HasPrefix(pkg, "-") {
+				return fmt.Sprintf("Invalid package: %v", item)
+			}
+			pkgs = append(pkgs, pkg)
+		}
+		if len(pkgs) == 0 {
+			return "No packages to get"
+		}
+		if err := goGet(ctx, rootDir, pkgs...); err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("Added %s", strings.Join(pkgs, ", "))
+	case "tidy":
+		if err := goModCommand(ctx, rootDir, "tidy"); err != nil {
+			return err.Error()
+		}
+		return "go.mod tidied"
+	default:
+		return fmt.Sprintf("Unknown action %q, use init, get, or tidy", action)
+	}
+}
+
+// goModCommand runs go mod with the arguments in the generated project.
+func goModCommand(ctx context.Context, rootDir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"mod"}, args...)...)
+	cmd.Dir = rootDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go mod %s failed: %w\n%s", args[0], err, output)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// DevTool is a binary generating or checking the generated code, installed into the tools directory when missing.
+type DevTool struct {
+	Name    string
+	Package string
+	// Version is pinned, so the generated code matches the dependencies of the generated project.
+	Version string
+	// APIStyle is set for tools used only by projects of the style.
+	APIStyle string
+}
+
+var devTools = []DevTool{
+	{Name: "oapi-codegen", Package: oapiCodegenPackage, Version: "v2.4.1", APIStyle: APIStyleOpenAPI},
+	{Name: "staticcheck", Package: "honnef.co/go/tools/cmd/staticcheck", Version: "v0.6.1"},
+	{Name: "golangci-lint", Package: "github.com/golangci/golangci-lint/cmd/golangci-lint", Version: "v1.64.8"},
+	{Name: "gosec", Package: "github.com/securego/gosec/v2/cmd/gosec", Version: "v2.22.2"},
+}
+
+// toolPath returns the path of the tool binary, installed into the tools directory or found in PATH.
+func (s *Service) toolPath(name string) (string, bool) {
+	installed := filepath.Join(s.ToolsDir, name)
+	if info, err := os.Stat(installed); err == nil && !info.IsDir() {
+		return installed, true
+	}
+	if found, err := exec.LookPath(name); err == nil {
+		return found, true
+	}
+	return "", false
+}
+
+// MissingTools returns the tools used by the project that aren't installed.
+func (s *Service) MissingTools() []DevTool {
+	var missing []DevTool
+	for _, tool := range devTools {
+		if tool.APIStyle != "" && tool.APIStyle != s.APIStyle {
+			continue
+		}
+		if _, ok := s.toolPath(tool.Name); !ok {
+			missing = append(missing, tool)
+		}
+	}
+	return missing
+}
+
+// InstallTools installs the pinned versions of the tools into the tools directory.
+func (s *Service) InstallTools(ctx context.Context, tools []DevTool) error {
+	if err := os.MkdirAll(s.ToolsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create tools directory: %w", err)
+	}
+	for _, tool := range tools {
+		cmd := exec.CommandContext(ctx, "go", "install", tool.Package+"@"+tool.Version)
+		cmd.Env = append(os.Environ(), "GOBIN="+s.ToolsDir)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to install %s %s: %w\n%s", tool.Name, tool.Version, err, output)
+		}
+	}
+	return nil
+}
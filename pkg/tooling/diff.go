@@ -0,0 +1,198 @@
+package tooling
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes.
+const diffContext = 3
+
+// FileContents are the contents of the tracked files at a point in time, by their paths relative to the project root,
+// so the changes of the files since can be shown.
+type FileContents map[string]string
+
+// FileChange is a tracked file which was added, changed, or removed.
+type FileChange struct {
+	Path string `json:"path"`
+	// Diff is the unified diff of the change. Added files are diffed with an empty file, and removed files with one.
+	Diff    string `json:"diff"`
+	Added   bool   `json:"added,omitempty"`
+	Removed bool   `json:"removed,omitempty"`
+}
+
+// ReadFileContents returns the current contents of the tracked files. It's empty when the lock file can't be read.
+func ReadFileContents() FileContents {
+	contents := make(FileContents)
+	tracked, err := loadManifest()
+	if err != nil {
+		return contents
+	}
+	for _, a := range tracked {
+		if a.Kind != artifactFile {
+			continue
+		}
+		if content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), filepath.FromSlash(a.Name))); err == nil {
+			contents[a.Name] = string(content)
+		}
+	}
+	return contents
+}
+
+// Changes returns the tracked files which were added, changed, or removed since the contents were read, sorted by path.
+func (c FileContents) Changes() []FileChange {
+	current := ReadFileContents()
+	var changes []FileChange
+	for name, content := range current {
+		if diff := lineDiff(name, c[name], content); diff != "" {
+			_, existed := c[name]
+			changes = append(changes, FileChange{Path: name, Diff: diff, Added: !existed})
+		}
+	}
+	for name, content := range c {
+		if _, ok := current[name]; !ok {
+			changes = append(changes, FileChange{Path: name, Diff: lineDiff(name, content, ""), Removed: true})
+		}
+	}
+	slices.SortFunc(changes, func(a, b FileChange) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return changes
+}
+
+// lineDiff returns a unified diff of the lines of the content before and after a change, or an empty string when
+// they're equal.
+func lineDiff(name, before, after string) string {
+	if before == after {
+		return ""
+	}
+	a, b := splitLines(before), splitLines(after)
+	matches := matchLines(a, b)
+
+	// Every line of the diff is prefixed with ' ', '-', or '+', like in unified diffs.
+	var lines []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && matches[i] == j:
+			lines = append(lines, " "+a[i])
+			i++
+			j++
+		case i < len(a) && matches[i] == -1:
+			lines = append(lines, "-"+a[i])
+			i++
+		default:
+			lines = append(lines, "+"+b[j])
+			j++
+		}
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- a/%s\n+++ b/%s\n", name, name)
+	for start := 0; start < len(lines); {
+		if lines[start][0] == ' ' {
+			start++
+			continue
+		}
+		// A hunk spans changes separated by less than twice the context.
+		from := max(start-diffContext, 0)
+		end := start
+		for k := start; k < len(lines) && k-end <= 2*diffContext; k++ {
+			if lines[k][0] != ' ' {
+				end = k
+			}
+		}
+		to := min(end+diffContext+1, len(lines))
+
+		oldStart, newStart := 1, 1
+		for _, line := range lines[:from] {
+			if line[0] != '+' {
+				oldStart++
+			}
+			if line[0] != '-' {
+				newStart++
+			}
+		}
+		oldLines, newLines := 0, 0
+		for _, line := range lines[from:to] {
+			if line[0] != '+' {
+				oldLines++
+			}
+			if line[0] != '-' {
+				newLines++
+			}
+		}
+		// Empty ranges start at the line before them.
+		if oldLines == 0 {
+			oldStart--
+		}
+		if newLines == 0 {
+			newStart--
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", oldStart, oldLines, newStart, newLines)
+		for _, line := range lines[from:to] {
+			sb.WriteString(line + "\n")
+		}
+		start = to
+	}
+	return sb.String()
+}
+
+// matchLines returns the index of the line of b every line of a is matched with by their longest common subsequence,
+// or -1 for lines of a which aren't in b. It's meant for small edits: lines outside the common prefix and suffix are
+// compared with a quadratic LCS.
+func matchLines(a, b []string) []int {
+	matches := make([]int, len(a))
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		matches[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		matches[len(a)-1-suffix] = len(b) - 1 - suffix
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:].
+	lcs := make([][]int32, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(midA) {
+		switch {
+		case j < len(midB) && midA[i] == midB[j]:
+			matches[prefix+i] = prefix + j
+			i++
+			j++
+		case j == len(midB) || lcs[i+1][j] >= lcs[i][j+1]:
+			matches[prefix+i] = -1
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+// splitLines returns the lines of the content, without the newline ending the last one.
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
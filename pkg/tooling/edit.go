@@ -0,0 +1,143 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const EditFunctionToolName = "edit_function"
+
+func (s *Service) EditFunctionTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(EditFunctionToolName),
+			Description: openai.String("Replaces a single function or method of a saved Go file, keeping the rest of the file intact. Prefer it over saving the whole file again when fixing a few functions."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"file": map[string]string{
+						"type":        "string",
+						"description": "Path of the Go file relative to the project root, e.g. pkg/api/server.go.",
+					},
+					"function": map[string]string{
+						"type":        "string",
+						"description": "Name of the function, or Type.Method for methods, e.g. Server.CreateBook.",
+					},
+					"function_go_code": map[string]string{
+						"type":        "string",
+						"description": "The whole new declaration of the function, including its signature.",
+					},
+				},
+				"required": []string{"file", "function", "function_go_code"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) EditFunction(_ context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	file, _ := args["file"].(string)
+	function, _ := args["function"].(string)
+	newCode, _ := args["function_go_code"].(string)
+	newCode = strings.TrimSpace(TrimNonCode(newCode, "go"))
+
+	if !filepath.IsLocal(file) || filepath.Ext(file) != ".go" {
+		return fmt.Sprintf("Invalid file %q, pass a Go file relative to the project root", file)
+	}
+	name := filepath.Join(os.Getenv("PROJECT_ROOT"), file)
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return fmt.Sprintf("Failed to read %s: %v", file, err)
+	}
+	code := string(content)
+
+	fset := token.NewFileSet()
+	parsed, err := parser.ParseFile(fset, file, code, parser.ParseComments)
+	if err != nil {
+		return fmt.Sprintf("Failed to parse %s, save the whole file instead: %v", file, err)
+	}
+	if ast.IsGenerated(parsed) {
+		return fmt.Sprintf("%s is generated, regenerate it instead of editing it", file)
+	}
+	decl := findFunc(parsed, function)
+	if decl == nil {
+		return fmt.Sprintf("Function %s not found in %s", function, file)
+	}
+
+	newFile, err := parser.ParseFile(token.NewFileSet(), "", "package p\n\n"+newCode, parser.ParseComments)
+	if err != nil {
+		return fmt.Sprintf("Function code rejected, fix the following issues and edit it again:\ninvalid Go code: %v", err)
+	}
+	if len(newFile.Decls) != 1 || findFunc(newFile, function) == nil {
+		return fmt.Sprintf("Function code rejected, it must contain only the declaration of %s", function)
+	}
+
+	// The doc comment of the function is kept, unless the new code has one.
+	start := decl.Pos()
+	if decl.Doc != nil && findFunc(newFile, function).Doc != nil {
+		start = decl.Doc.Pos()
+	}
+	code = code[:fset.Position(start).Offset] + newCode + code[fset.Position(decl.End()).Offset:]
+
+	code, err = formatGo(name, code)
+	if err == nil {
+		err = s.checkSQL(code)
+	}
+	if err != nil {
+		return fmt.Sprintf("Function code rejected, fix the following issues and edit it again:\n%v", err)
+	}
+	if err := s.writeEditedFile(name, code); err != nil {
+		return fmt.Sprintf("Failed to save %s: %v", file, err)
+	}
+
+	return fmt.Sprintf("Function %s in %s replaced successfully", function, file)
+}
+
+// findFunc returns the declaration of the function, or of the method when the name is Type.Method.
+func findFunc(file *ast.File, name string) *ast.FuncDecl {
+	recv, method, isMethod := strings.Cut(name, ".")
+	for _, d := range file.Decls {
+		fn, ok := d.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if !isMethod {
+			if fn.Recv == nil && fn.Name.Name == name {
+				return fn
+			}
+			continue
+		}
+		if fn.Recv != nil && len(fn.Recv.List) == 1 && fn.Name.Name == method && receiverType(fn.Recv.List[0].Type) == recv {
+			return fn
+		}
+	}
+	return nil
+}
+
+// receiverType returns the name of the receiver type, without pointer and type parameters.
+func receiverType(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return receiverType(e.X)
+	case *ast.IndexExpr:
+		return receiverType(e.X)
+	case *ast.IndexListExpr:
+		return receiverType(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return ""
+}
@@ -0,0 +1,263 @@
+package tooling
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	entitySpecPrompt = `
+Generate the spec only for the resource named by the user. The paths and component schemas you generate are merged
+into the spec of the project, which is provided, so don't repeat paths or schemas of other resources and reference their
+schemas with $ref instead of redefining them.
+`
+	mergeSpecPrompt = `
+The spec you generate is merged into the spec of the project, which is provided: its paths and component schemas are
+added to the spec of the project, replacing the ones with the same names. Generate the paths and schemas of the
+resources the user asks for, the other resources are kept, and don't change schemas other resources use.
+`
+	entityServerPrompt = `
+## Implementing one resource
+
+Implement only the ServerInterface methods of the operations of the resource named by the user, the methods of other
+resources are implemented already in their own files. Save the methods with the save_server_code tool with "entity" set
+to the resource name, so they are saved to the file of the resource. The Server struct, its constructor, and helpers
+shared by the resources belong to server.go, saved without "entity". When server.go doesn't exist yet, save it first.
+Otherwise, its current content is provided together with other files shared by the resources, e.g. of the repository
+layer. Keep the code of other resources in them when saving them again.
+`
+)
+
+// specPath returns the path of the OpenAPI spec of the project.
+func specPath() string {
+	return path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "api", "doc", "openapi.yaml")
+}
+
+// entityServerFile returns the path of the file implementing the server methods of the entity, relative to the api
+// package.
+func entityServerFile(entity string) (string, error) {
+	name := strings.ToLower(strings.NewReplacer(" ", "_", "-", "_").Replace(strings.TrimSpace(entity)))
+	if !identifierRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid entity name %q", entity)
+	}
+	return name + "_server.go", nil
+}
+
+// sharedServerFiles returns the current content of the existing files which implement the server of all resources,
+// formatted as Markdown code blocks.
+func (s *Service) sharedServerFiles() (string, error) {
+	files := []string{path.Join("pkg", "api", "server.go")}
+	if s.RepositoryLayer {
+		files = append(files, path.Join("pkg", "api", "repository.go"), path.Join("pkg", "repository", "postgres.go"))
+		if s.Cache {
+			files = append(files, path.Join("pkg", "repository", "cached.go"))
+		}
+	}
+	if s.ServiceLayer {
+		files = append(files, path.Join("pkg", "api", "service.go"), path.Join("pkg", "service", "service.go"))
+	}
+
+	var sb strings.Builder
+	for _, name := range files {
+		content, err := os.ReadFile(path.Join(os.Getenv("PROJECT_ROOT"), name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "\n\nThe current %s is:\n```go\n%s\n```", name, content)
+	}
+	return sb.String(), nil
+}
+
+// mergeSpec merges the paths and components of the spec of an entity, or of any generated spec, into the spec of the
+// project. Paths and components defined by both are replaced, so the spec of an entity can be regenerated. The order of
+// the spec of the project is kept, new paths and components are appended. Components defined differently than in the
+// spec of the project fail the merge when paths or components the spec doesn't define use them, as replacing them
+// would change resources generated before.
+func mergeSpec(project, entity string) (string, error) {
+	var projectDoc, entityDoc yaml.Node
+	if err := yaml.Unmarshal([]byte(project), &projectDoc); err != nil {
+		return "", fmt.Errorf("failed to parse spec of the project: %w", err)
+	}
+	if err := yaml.Unmarshal([]byte(entity), &entityDoc); err != nil {
+		return "", fmt.Errorf("failed to parse spec of the entity: %w", err)
+	}
+	if len(projectDoc.Content) == 0 || projectDoc.Content[0].Kind != yaml.MappingNode {
+		return "", errors.New("spec of the project isn't a YAML mapping")
+	}
+	if len(entityDoc.Content) == 0 || entityDoc.Content[0].Kind != yaml.MappingNode {
+		return "", errors.New("spec of the entity isn't a YAML mapping")
+	}
+	projectRoot, entityRoot := projectDoc.Content[0], entityDoc.Content[0]
+	if conflicts := specConflicts(projectRoot, entityRoot); len(conflicts) > 0 {
+		return "", fmt.Errorf("spec conflicts with the spec of the project, keep these components unchanged or leave them out:\n%s",
+			strings.Join(conflicts, "\n"))
+	}
+
+	if paths := mappingValue(entityRoot, "paths"); paths != nil {
+		mergeMapping(ensureMapping(projectRoot, "paths"), paths)
+	}
+	if components := mappingValue(entityRoot, "components"); components != nil {
+		projectComponents := ensureMapping(projectRoot, "components")
+		for i := 0; i+1 < len(components.Content); i += 2 {
+			if components.Content[i+1].Kind == yaml.MappingNode {
+				mergeMapping(ensureMapping(projectComponents, components.Content[i].Value), components.Content[i+1])
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(&projectDoc); err != nil {
+		return "", fmt.Errorf("failed to encode merged spec: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", fmt.Errorf("failed to encode merged spec: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// specConflicts returns the components the spec defines differently than the spec of the project, with the paths and
+// components of the project using them which the spec doesn't define.
+func specConflicts(project, spec *yaml.Node) []string {
+	projectComponents, components := mappingValue(project, "components"), mappingValue(spec, "components")
+	if projectComponents == nil || components == nil {
+		return nil
+	}
+	// Paths and components the spec defines replace the ones of the project, so their uses don't count.
+	var users []*yaml.Node
+	var userNames []string
+	if projectPaths := mappingValue(project, "paths"); projectPaths != nil && projectPaths.Kind == yaml.MappingNode {
+		paths := mappingValue(spec, "paths")
+		for i := 0; i+1 < len(projectPaths.Content); i += 2 {
+			if paths == nil || mappingValue(paths, projectPaths.Content[i].Value) == nil {
+				users = append(users, projectPaths.Content[i+1])
+				userNames = append(userNames, "path "+projectPaths.Content[i].Value)
+			}
+		}
+	}
+	for i := 0; i+1 < len(projectComponents.Content); i += 2 {
+		section, sectionValue := projectComponents.Content[i].Value, projectComponents.Content[i+1]
+		if sectionValue.Kind != yaml.MappingNode {
+			continue
+		}
+		defined := mappingValue(components, section)
+		for j := 0; j+1 < len(sectionValue.Content); j += 2 {
+			if defined == nil || mappingValue(defined, sectionValue.Content[j].Value) == nil {
+				users = append(users, sectionValue.Content[j+1])
+				userNames = append(userNames, fmt.Sprintf("component %s/%s", section, sectionValue.Content[j].Value))
+			}
+		}
+	}
+
+	var conflicts []string
+	for i := 0; i+1 < len(components.Content); i += 2 {
+		section, defined := components.Content[i].Value, components.Content[i+1]
+		existing := mappingValue(projectComponents, section)
+		if defined.Kind != yaml.MappingNode || existing == nil {
+			continue
+		}
+		for j := 0; j+1 < len(defined.Content); j += 2 {
+			name := defined.Content[j].Value
+			current := mappingValue(existing, name)
+			if current == nil || sameNode(current, defined.Content[j+1]) {
+				continue
+			}
+			ref := fmt.Sprintf("#/components/%s/%s", section, name)
+			var usedBy []string
+			for k, user := range users {
+				if references(user, ref) {
+					usedBy = append(usedBy, userNames[k])
+				}
+			}
+			if len(usedBy) > 0 {
+				conflicts = append(conflicts, fmt.Sprintf("- %s/%s is used by %s", section, name, strings.Join(usedBy, ", ")))
+			}
+		}
+	}
+	return conflicts
+}
+
+// sameNode reports whether the YAML nodes have the same content, whatever their styles and comments.
+func sameNode(a, b *yaml.Node) bool {
+	var va, vb interface{}
+	if err := a.Decode(&va); err != nil {
+		return false
+	}
+	if err := b.Decode(&vb); err != nil {
+		return false
+	}
+	ea, errA := yaml.Marshal(va)
+	eb, errB := yaml.Marshal(vb)
+	return errA == nil && errB == nil && bytes.Equal(ea, eb)
+}
+
+// references reports whether the YAML node references the component with a $ref.
+func references(node *yaml.Node, ref string) bool {
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i].Value == "$ref" && node.Content[i+1].Value == ref {
+				return true
+			}
+		}
+	}
+	for _, child := range node.Content {
+		if references(child, ref) {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingValue returns the value of the key in the YAML mapping, or nil when it's missing.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// ensureMapping returns the mapping value of the key in the YAML mapping, adding an empty one when it's missing or
+// isn't a mapping.
+func ensureMapping(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			if mapping.Content[i+1].Kind != yaml.MappingNode {
+				mapping.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			}
+			return mapping.Content[i+1]
+		}
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
+
+// mergeMapping sets the keys of the source mapping in the destination mapping.
+func mergeMapping(dst, src *yaml.Node) {
+	for i := 0; i+1 < len(src.Content); i += 2 {
+		key, value := src.Content[i], src.Content[i+1]
+		replaced := false
+		for j := 0; j+1 < len(dst.Content); j += 2 {
+			if dst.Content[j].Value == key.Value {
+				dst.Content[j+1] = value
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst.Content = append(dst.Content, key, value)
+		}
+	}
+}
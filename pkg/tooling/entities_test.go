@@ -0,0 +1,189 @@
+package tooling
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeSpec(t *testing.T) {
+	const project = `openapi: 3.0.3
+paths:
+  /books:
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Book'
+components:
+  schemas:
+    Book:
+      type: object
+      properties:
+        title:
+          type: string
+    Error:
+      type: object
+`
+	tests := []struct {
+		name string
+		spec string
+		// want are parts of the merged spec, in their order.
+		want []string
+		// wantConflicts are parts of the error, empty when the merge succeeds.
+		wantConflicts []string
+	}{
+		{
+			name: "new path and component appended",
+			spec: `paths:
+  /authors:
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Author'
+components:
+  schemas:
+    Author:
+      type: object
+`,
+			want: []string{"/books:", "/authors:", "Book:", "Error:", "Author:"},
+		},
+		{
+			name: "component defined the same in another style",
+			spec: `components:
+  schemas:
+    Book:
+      type: object
+      properties:
+        title: {type: string}
+`,
+			want: []string{"/books:", "Book:", "title:"},
+		},
+		{
+			name: "changed component with its path replaced",
+			spec: `paths:
+  /books:
+    get:
+      responses:
+        "200":
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Book'
+components:
+  schemas:
+    Book:
+      type: object
+      properties:
+        isbn:
+          type: string
+`,
+			want: []string{"/books:", "Book:", "isbn:"},
+		},
+		{
+			name: "changed component referenced by a path of the project",
+			spec: `paths:
+  /authors:
+    get:
+      responses:
+        "200":
+          description: OK
+components:
+  schemas:
+    Book:
+      type: object
+      properties:
+        isbn:
+          type: string
+`,
+			wantConflicts: []string{"schemas/Book is used by path /books"},
+		},
+		{
+			name: "changed component nothing else references",
+			spec: `paths:
+  /books:
+    get:
+      responses:
+        "200":
+          description: OK
+components:
+  schemas:
+    Error:
+      type: string
+    Shelf:
+      type: object
+`,
+			want: []string{"/books:", "Error:", "type: string"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mergeSpec(project, tt.spec)
+			if len(tt.wantConflicts) > 0 {
+				if err == nil {
+					t.Fatalf("mergeSpec() = %q, want conflicts %q", got, tt.wantConflicts)
+				}
+				for _, conflict := range tt.wantConflicts {
+					if !strings.Contains(err.Error(), conflict) {
+						t.Errorf("mergeSpec() error = %v, want %q", err, conflict)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest := got
+			for _, part := range tt.want {
+				i := strings.Index(rest, part)
+				if i < 0 {
+					t.Fatalf("mergeSpec() = %s, want %q after the parts before it", got, part)
+				}
+				rest = rest[i+len(part):]
+			}
+		})
+	}
+}
+
+func TestMergeSpecConflictingRefs(t *testing.T) {
+	const project = `paths:
+  /orders:
+    post:
+      requestBody:
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Order'
+components:
+  schemas:
+    Order:
+      type: object
+      properties:
+        customer:
+          $ref: '#/components/schemas/Customer'
+    Customer:
+      type: object
+      properties:
+        name:
+          type: string
+`
+	const spec = `components:
+  schemas:
+    Customer:
+      type: object
+      properties:
+        email:
+          type: string
+`
+	_, err := mergeSpec(project, spec)
+	if err == nil {
+		t.Fatal("mergeSpec() succeeded, want the conflict of the Customer component")
+	}
+	if want := "schemas/Customer is used by component schemas/Order"; !strings.Contains(err.Error(), want) {
+		t.Errorf("mergeSpec() error = %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,322 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	ERDMermaid = "mermaid"
+	ERDDBML    = "dbml"
+)
+
+// erdFiles are the files the entity-relationship diagram is saved to by format, relative to the project root.
+var erdFiles = map[string]string{
+	ERDMermaid: "docs/erd.mmd",
+	ERDDBML:    "docs/erd.dbml",
+}
+
+// Markers of the diagram in README.md, so it's replaced when the diagram is generated again.
+const (
+	erdBeginMarker = "<!-- doubletab:erd-begin -->"
+	erdEndMarker   = "<!-- doubletab:erd-end -->"
+)
+
+// erdInternalTables are tables of generated infrastructure rather than entities, left out of the diagram.
+var erdInternalTables = map[string]bool{"outbox": true, "idempotency_keys": true}
+
+// erdTable is a table of the applied schema.
+type erdTable struct {
+	Name    string
+	Columns []erdColumn
+}
+
+type erdColumn struct {
+	Name     string
+	Type     string
+	Nullable bool
+	Primary  bool
+	// RefTable and RefColumn are the column the foreign key of the column references, or the id of the table the
+	// column name refers to, e.g. authors of author_id.
+	RefTable  string
+	RefColumn string
+}
+
+const GenerateERDToolName = "generate_erd"
+
+func (s *Service) GenerateERDTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateERDToolName),
+			Description: openai.String("Generates an entity-relationship diagram (Mermaid or DBML) of the tables applied to the database, saves it to the docs directory, and embeds it in README.md. Use it once the schema is stored, when the user wants a visual of the data model."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"format": map[string]interface{}{
+						"type":        "string",
+						"enum":        []string{ERDMermaid, ERDDBML},
+						"description": "Format of the diagram, mermaid (default) renders on GitHub and GitLab, dbml on dbdiagram.io.",
+					},
+				},
+			}),
+		}),
+	}
+}
+
+func (s *Service) GenerateERD(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	format, _ := args["format"].(string)
+	if format == "" {
+		format = ERDMermaid
+	}
+	file, ok := erdFiles[format]
+	if !ok {
+		return fmt.Sprintf("Unsupported format %q, use %s or %s", format, ERDMermaid, ERDDBML)
+	}
+
+	tables, err := s.appliedTables(ctx)
+	if err != nil {
+		return fmt.Sprintf("Failed to read the schema: %v", err)
+	}
+	if len(tables) == 0 {
+		return "No tables found, store the schema first"
+	}
+	diagram := mermaidERD(tables)
+	if format == ERDDBML {
+		diagram = dbmlERD(tables)
+	}
+	// The other format is removed, so README.md and exported transcripts embed the diagram just generated.
+	for other, name := range erdFiles {
+		if other != format {
+			if err := os.Remove(path.Join(os.Getenv("PROJECT_ROOT"), name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Sprintf("Failed to remove %s: %v", name, err)
+			}
+		}
+	}
+	if err := s.writeFile(path.Join(os.Getenv("PROJECT_ROOT"), file), diagram); err != nil {
+		return fmt.Sprintf("Failed to save %s: %v", file, err)
+	}
+	embedded, err := s.embedERD()
+	if err != nil {
+		return fmt.Sprintf("Diagram saved to %s, but embedding it in README.md failed: %v", file, err)
+	}
+	if !embedded {
+		return fmt.Sprintf("Diagram of %d tables saved to %s, it's embedded in README.md once it's generated", len(tables), file)
+	}
+	return fmt.Sprintf("Diagram of %d tables saved to %s and embedded in README.md", len(tables), file)
+}
+
+// appliedTables returns the tables of the public schema of the project database with their columns, primary keys,
+// and references. Columns without foreign keys named after another table, like author_id, refer to its id.
+func (s *Service) appliedTables(ctx context.Context) ([]erdTable, error) {
+	var columns []struct {
+		Table    string `db:"table_name"`
+		Column   string `db:"column_name"`
+		Type     string `db:"udt_name"`
+		Nullable string `db:"is_nullable"`
+	}
+	if err := s.DB.SelectContext(ctx, &columns, `SELECT table_name, column_name, udt_name, is_nullable
+FROM information_schema.columns WHERE table_schema = 'public' ORDER BY table_name, ordinal_position`); err != nil {
+		return nil, fmt.Errorf("failed to query columns: %w", err)
+	}
+	var keys []struct {
+		Table     string `db:"table_name"`
+		Column    string `db:"column_name"`
+		Kind      string `db:"contype"`
+		RefTable  string `db:"ref_table"`
+		RefColumn string `db:"ref_column"`
+	}
+	// Only the first column of composite keys is drawn.
+	if err := s.DB.SelectContext(ctx, &keys, `SELECT cl.relname AS table_name, a.attname AS column_name, c.contype,
+       COALESCE(rcl.relname, '') AS ref_table, COALESCE(ra.attname, '') AS ref_column
+FROM pg_constraint c
+JOIN pg_class cl ON cl.oid = c.conrelid
+JOIN pg_namespace n ON n.oid = cl.relnamespace
+JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+LEFT JOIN pg_class rcl ON rcl.oid = c.confrelid
+LEFT JOIN pg_attribute ra ON ra.attrelid = c.confrelid AND ra.attnum = c.confkey[1]
+WHERE n.nspname = 'public' AND c.contype IN ('p', 'f')`); err != nil {
+		return nil, fmt.Errorf("failed to query keys: %w", err)
+	}
+
+	var tables []erdTable
+	index := make(map[string]int)
+	for _, c := range columns {
+		if erdInternalTables[c.Table] {
+			continue
+		}
+		i, ok := index[c.Table]
+		if !ok {
+			i = len(tables)
+			index[c.Table] = i
+			tables = append(tables, erdTable{Name: c.Table})
+		}
+		tables[i].Columns = append(tables[i].Columns, erdColumn{Name: c.Column, Type: c.Type, Nullable: c.Nullable == "YES"})
+	}
+	column := func(table, name string) *erdColumn {
+		i, ok := index[table]
+		if !ok {
+			return nil
+		}
+		for j := range tables[i].Columns {
+			if tables[i].Columns[j].Name == name {
+				return &tables[i].Columns[j]
+			}
+		}
+		return nil
+	}
+	for _, k := range keys {
+		c := column(k.Table, k.Column)
+		if c == nil {
+			continue
+		}
+		if k.Kind == "p" {
+			c.Primary = true
+		} else if _, ok := index[k.RefTable]; ok {
+			c.RefTable, c.RefColumn = k.RefTable, k.RefColumn
+		}
+	}
+
+	names := make(map[string]bool)
+	for name := range index {
+		names[name] = true
+	}
+	for i := range tables {
+		for j := range tables[i].Columns {
+			c := &tables[i].Columns[j]
+			if c.RefTable != "" {
+				continue
+			}
+			if ref, ok := referencedResource(c.Name, names); ok && column(ref, "id") != nil {
+				c.RefTable, c.RefColumn = ref, "id"
+			}
+		}
+	}
+	return tables, nil
+}
+
+// mermaidERD renders the tables as a Mermaid erDiagram, with many-to-one relationships of their references.
+func mermaidERD(tables []erdTable) string {
+	var sb strings.Builder
+	sb.WriteString("erDiagram\n")
+	for _, t := range tables {
+		fmt.Fprintf(&sb, "    %s {\n", t.Name)
+		for _, c := range t.Columns {
+			var keys []string
+			if c.Primary {
+				keys = append(keys, "PK")
+			}
+			if c.RefTable != "" {
+				keys = append(keys, "FK")
+			}
+			line := fmt.Sprintf("        %s %s %s", strings.TrimPrefix(c.Type, "_")+arraySuffix(c.Type), c.Name, strings.Join(keys, ","))
+			sb.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+		sb.WriteString("    }\n")
+	}
+	for _, t := range tables {
+		for _, c := range t.Columns {
+			if c.RefTable == "" {
+				continue
+			}
+			cardinality := "||--o{"
+			if c.Nullable {
+				cardinality = "|o--o{"
+			}
+			fmt.Fprintf(&sb, "    %s %s %s : %q\n", c.RefTable, cardinality, t.Name, c.Name)
+		}
+	}
+	return sb.String()
+}
+
+// dbmlERD renders the tables in DBML, with inline references.
+func dbmlERD(tables []erdTable) string {
+	var sb strings.Builder
+	for i, t := range tables {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "Table %s {\n", t.Name)
+		for _, c := range t.Columns {
+			var settings []string
+			if c.Primary {
+				settings = append(settings, "pk")
+			}
+			if !c.Nullable && !c.Primary {
+				settings = append(settings, "not null")
+			}
+			if c.RefTable != "" {
+				settings = append(settings, fmt.Sprintf("ref: > %s.%s", c.RefTable, c.RefColumn))
+			}
+			typ := strings.TrimPrefix(c.Type, "_") + arraySuffix(c.Type)
+			if len(settings) == 0 {
+				fmt.Fprintf(&sb, "  %s %s\n", c.Name, typ)
+			} else {
+				fmt.Fprintf(&sb, "  %s %s [%s]\n", c.Name, typ, strings.Join(settings, ", "))
+			}
+		}
+		sb.WriteString("}\n")
+	}
+	return sb.String()
+}
+
+// arraySuffix returns the suffix of array types, whose udt names start with an underscore, e.g. _text of text[].
+func arraySuffix(udt string) string {
+	if strings.HasPrefix(udt, "_") {
+		return "[]"
+	}
+	return ""
+}
+
+// savedERD returns the saved diagram as a Markdown code block, or an empty string when none was generated.
+func savedERD() (string, error) {
+	for _, format := range []string{ERDMermaid, ERDDBML} {
+		content, err := os.ReadFile(path.Join(os.Getenv("PROJECT_ROOT"), erdFiles[format]))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("```%s\n%s```\n", format, content), nil
+	}
+	return "", nil
+}
+
+// embedERD embeds the saved diagram in the Data model section of README.md, replacing the diagram embedded before.
+// It reports whether the diagram was embedded, which it isn't when README.md or the diagram doesn't exist.
+func (s *Service) embedERD() (bool, error) {
+	diagram, err := savedERD()
+	if err != nil || diagram == "" {
+		return false, err
+	}
+	name := path.Join(os.Getenv("PROJECT_ROOT"), "README.md")
+	content, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	block := erdBeginMarker + "\n" + diagram + erdEndMarker
+	readme := string(content)
+	before, rest, found := strings.Cut(readme, erdBeginMarker)
+	if _, after, ok := strings.Cut(rest, erdEndMarker); found && ok {
+		readme = before + block + after
+	} else {
+		readme = strings.TrimRight(readme, "\n") + "\n\n## Data model\n\n" + block + "\n"
+	}
+	return true, s.writeFile(name, readme)
+}
@@ -1,67 +1,1635 @@
 package tooling
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"text/template"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/vector"
+)
+
+// gqlgenVersion is the gqlgen release added to projects generated in GraphQL mode.
+const gqlgenVersion = "v0.17.66"
+
+// pgxVersion is the pgx release added to projects generated with the pgx driver.
+const pgxVersion = "v5.6.0"
+
+// File templates needed for generating handlers based on OpenAPI spec or resolvers based on GraphQL schema.
+const (
+	cfgYaml = `package: api
+output: handlers.gen.go
+generate:
+  models: true
+  std-http-server: true
+`
+	generateGo = `package api
+
+//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen -config cfg.yaml doc/openapi.yaml
+`
+	toolsGo = `//go:build tools
+// +build tools
+
+package tools
+
+import (
+	_ "github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen"
+)
+`
+	mainGo = `package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/iancoleman/strcase"
+{{- if eq .PGDriver "pgx"}}
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+{{- end}}
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+{{- if ne .PGDriver "pgx"}}
+	_ "github.com/lib/pq"
+{{- end}}
+
+	"myApp/pkg/api"
+{{- if .Cache}}
+	"myApp/pkg/cache"
+{{- end}}
+	"myApp/pkg/config"
+{{- if .LiveUpdates}}
+	"myApp/pkg/events"
+{{- end}}
+{{- if or .RateLimit .CORS .MultiTenant .Idempotency}}
+	"myApp/pkg/middleware"
+{{- end}}
+{{- if .EventBroker}}
+	"myApp/pkg/outbox"
+{{- end}}
+{{- if .RepositoryLayer}}
+	"myApp/pkg/repository"
+{{- end}}
+{{- if .ServiceLayer}}
+	"myApp/pkg/service"
+{{- end}}
+{{- if .FileUploads}}
+	"myApp/pkg/storage"
+{{- end}}
+{{- range .FrozenAPIVersions}}
+{{range .Imports}}
+	"{{.}}"
+{{- end}}
+{{- end}}
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+{{if eq .PGDriver "pgx"}}
+	pool, err := pgxpool.New(ctx, cfg.PostgresConn())
+	if err != nil {
+		log.Fatalf("Failed to create database pool: %v", err)
+	}
+	defer pool.Close()
+	db := sqlx.NewDb(stdlib.OpenDBFromPool(pool), "pgx")
+	if err := db.PingContext(ctx); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+{{- else}}
+	db, err := sqlx.ConnectContext(ctx, "postgres", cfg.PostgresConn())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+{{- end}}
+	defer db.Close()
+	db.Mapper = reflectx.NewMapperFunc("json", strcase.ToSnake)
+{{if .Cache}}
+	c, err := cache.New(ctx, cfg.RedisURL, cfg.CacheTTL)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer c.Close()
+	repo := repository.NewCached(repository.NewPostgres(db), c)
+{{else if .RepositoryLayer}}
+	repo := repository.NewPostgres(db)
+{{end}}
+{{- if .ServiceLayer}}
+	srv := api.Server{Svc: service.New(repo)}
+{{- else if .RepositoryLayer}}
+	srv := api.Server{Repo: repo}
+{{- else}}
+	srv := api.Server{DB: db}
+{{- end}}
+{{- if .FileUploads}}
+{{- if eq .FileStorage "s3"}}
+	store, err := storage.NewS3(ctx, cfg.S3Bucket)
+	if err != nil {
+		log.Fatalf("Failed to create S3 storage: %v", err)
+	}
+	srv.Storage = store
+{{- else}}
+	srv.Storage = storage.NewLocal(cfg.StorageDir)
+{{- end}}
+{{- end}}
+{{- if or .LiveUpdates .APIVersion}}
+	mux := http.NewServeMux()
+{{- end}}
+{{- if .LiveUpdates}}
+	broker := events.NewBroker(cfg.PostgresConn())
+	defer broker.Close()
+{{- range .LiveUpdates}}
+	mux.Handle("GET /{{.}}/events", broker.Handler("{{.}}_changes"))
+{{- end}}
+{{- end}}
+{{- range .FrozenAPIVersions}}
+	api{{.Name}}.HandlerFromMuxWithBaseURL({{.Server}}, mux, "/{{.Name}}")
+{{- end}}
+{{- if .APIVersion}}
+	h := api.HandlerFromMuxWithBaseURL(srv, mux, "{{.BasePath}}")
+{{- else if .LiveUpdates}}
+	h := api.HandlerFromMux(srv, mux)
+{{- else}}
+	h := api.Handler(srv)
+{{- end}}
+{{- if .Idempotency}}
+	h = middleware.NewIdempotency(db, cfg.IdempotencyTTL).Middleware(h)
+{{- end}}
+{{- if .MultiTenant}}
+	h = middleware.NewTenant(cfg.TenantHeader, cfg.JWTSecret, cfg.TenantClaim).Middleware(h)
+{{- end}}
+{{- if .RateLimit}}
+	h = middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitKeyHeader).Middleware(h)
+{{- end}}
+{{- if .CORS}}
+	h = middleware.NewCORS(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders).Middleware(h)
+{{- end}}
+{{- if .EventBroker}}
+{{if eq .EventBroker "kafka"}}
+	publisher := outbox.NewKafka(cfg.KafkaBrokers)
+{{- else}}
+	publisher, err := outbox.NewNATS(cfg.NATSURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+{{- end}}
+	defer publisher.Close()
+	go outbox.NewRelay(db, publisher, cfg.OutboxPollInterval).Run(ctx)
+{{- end}}
+	log.Printf("Server listening on port %s", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, h))
+}
+`
+	graphqlToolsGo = `//go:build tools
+// +build tools
+
+package tools
+
+import (
+	_ "github.com/99designs/gqlgen"
+)
+`
+	graphqlMainGo = `package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/99designs/gqlgen/graphql/handler"
+	"github.com/99designs/gqlgen/graphql/playground"
+	"github.com/iancoleman/strcase"
+{{- if eq .PGDriver "pgx"}}
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+{{- end}}
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/reflectx"
+{{- if ne .PGDriver "pgx"}}
+	_ "github.com/lib/pq"
+{{- end}}
+{{if .Cache}}
+	"myApp/pkg/cache"
+{{- end}}
+	"myApp/pkg/config"
+{{- if .LiveUpdates}}
+	"myApp/pkg/events"
+{{- end}}
+	"myApp/pkg/graph"
+{{- if or .RateLimit .CORS .MultiTenant .Idempotency}}
+	"myApp/pkg/middleware"
+{{- end}}
+{{- if .EventBroker}}
+	"myApp/pkg/outbox"
+{{- end}}
+{{- if .RepositoryLayer}}
+	"myApp/pkg/repository"
+{{- end}}
+{{- if .ServiceLayer}}
+	"myApp/pkg/service"
+{{- end}}
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+{{if eq .PGDriver "pgx"}}
+	pool, err := pgxpool.New(ctx, cfg.PostgresConn())
+	if err != nil {
+		log.Fatalf("Failed to create database pool: %v", err)
+	}
+	defer pool.Close()
+	db := sqlx.NewDb(stdlib.OpenDBFromPool(pool), "pgx")
+	if err := db.PingContext(ctx); err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+{{- else}}
+	db, err := sqlx.ConnectContext(ctx, "postgres", cfg.PostgresConn())
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+{{- end}}
+	defer db.Close()
+	db.Mapper = reflectx.NewMapperFunc("json", strcase.ToSnake)
+{{if .Cache}}
+	c, err := cache.New(ctx, cfg.RedisURL, cfg.CacheTTL)
+	if err != nil {
+		log.Fatalf("Failed to connect to Redis: %v", err)
+	}
+	defer c.Close()
+	repo := repository.NewCached(repository.NewPostgres(db), c)
+{{else if .RepositoryLayer}}
+	repo := repository.NewPostgres(db)
+{{end}}
+{{- if .ServiceLayer}}
+	resolver := &graph.Resolver{Svc: service.New(repo)}
+{{- else if .RepositoryLayer}}
+	resolver := &graph.Resolver{Repo: repo}
+{{- else}}
+	resolver := &graph.Resolver{DB: db}
+{{- end}}
+	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
+	http.Handle("{{.BasePath}}/", playground.Handler("GraphQL playground", "{{.BasePath}}/query"))
+	http.Handle("{{.BasePath}}/query", srv)
+{{- if .LiveUpdates}}
+	broker := events.NewBroker(cfg.PostgresConn())
+	defer broker.Close()
+{{- range .LiveUpdates}}
+	http.Handle("GET /{{.}}/events", broker.Handler("{{.}}_changes"))
+{{- end}}
+{{- end}}
+	var h http.Handler = http.DefaultServeMux
+{{- if .Idempotency}}
+	h = middleware.NewIdempotency(db, cfg.IdempotencyTTL).Middleware(h)
+{{- end}}
+{{- if .MultiTenant}}
+	h = middleware.NewTenant(cfg.TenantHeader, cfg.JWTSecret, cfg.TenantClaim).Middleware(h)
+{{- end}}
+{{- if .RateLimit}}
+	h = middleware.NewRateLimiter(cfg.RateLimitRPS, cfg.RateLimitBurst, cfg.RateLimitKeyHeader).Middleware(h)
+{{- end}}
+{{- if .CORS}}
+	h = middleware.NewCORS(cfg.CORSAllowedOrigins, cfg.CORSAllowedMethods, cfg.CORSAllowedHeaders).Middleware(h)
+{{- end}}
+{{- if .EventBroker}}
+{{if eq .EventBroker "kafka"}}
+	publisher := outbox.NewKafka(cfg.KafkaBrokers)
+{{- else}}
+	publisher, err := outbox.NewNATS(cfg.NATSURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to NATS: %v", err)
+	}
+{{- end}}
+	defer publisher.Close()
+	go outbox.NewRelay(db, publisher, cfg.OutboxPollInterval).Run(ctx)
+{{- end}}
+	log.Printf("Server listening on port %s", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, h))
+}
+`
+	gqlgenYaml = `schema:
+  - schema.graphqls
+exec:
+  filename: generated.go
+  package: graph
+model:
+  filename: models_gen.go
+  package: graph
+resolver:
+  layout: follow-schema
+  dir: .
+  package: graph
+models:
+  ID:
+    model:
+      - github.com/99designs/gqlgen/graphql.ID
+`
+	graphGenerateGo = `package graph
+
+//go:generate go run github.com/99designs/gqlgen generate --config gqlgen.yml
+`
+	graphResolverGo = `package graph
+{{if .ServiceLayer}}
+type Resolver struct {
+	Svc Service
+}
+{{- else if .RepositoryLayer}}
+type Resolver struct {
+	Repo Repository
+}
+{{- else}}
+import "github.com/jmoiron/sqlx"
+
+type Resolver struct {
+	DB *sqlx.DB
+}
+{{- end}}
+`
+	eventsGo = `package events
+
+import (
+{{- if .MultiTenant}}
+	"encoding/json"
+{{- end}}
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+{{- if .MultiTenant}}
+
+	"myApp/pkg/tenant"
+{{- end}}
+)
+
+// Broker listens for PostgreSQL notifications and fans them out to Server-Sent Events clients.
+type Broker struct {
+	listener *pq.Listener
+
+	mu      sync.Mutex
+	clients map[string]map[chan string]struct{}
+}
+
+func NewBroker(conn string) *Broker {
+	b := &Broker{clients: make(map[string]map[chan string]struct{})}
+	b.listener = pq.NewListener(conn, time.Second, time.Minute, func(_ pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Listener error: %v", err)
+		}
+	})
+	go b.run()
+	return b
+}
+
+func (b *Broker) Close() error {
+	return b.listener.Close()
+}
+
+func (b *Broker) run() {
+	for n := range b.listener.Notify {
+		// A nil notification means the connection was re-established.
+		if n == nil {
+			continue
+		}
+		b.mu.Lock()
+		for client := range b.clients[n.Channel] {
+			select {
+			case client <- n.Extra:
+			default:
+				// Slow clients drop events rather than blocking the others.
+			}
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Handler streams notifications sent on the channel to the client as Server-Sent Events.
+func (b *Broker) Handler(channel string) http.Handler {
+	if err := b.listener.Listen(channel); err != nil {
+		log.Fatalf("Failed to listen on channel %s: %v", channel, err)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+
+{{- if .MultiTenant}}
+		tenantID := tenant.FromContext(r.Context())
+{{- end}}
+		client := make(chan string, 16)
+		b.mu.Lock()
+		if b.clients[channel] == nil {
+			b.clients[channel] = make(map[chan string]struct{})
+		}
+		b.clients[channel][client] = struct{}{}
+		b.mu.Unlock()
+		defer func() {
+			b.mu.Lock()
+			delete(b.clients[channel], client)
+			b.mu.Unlock()
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		flusher.Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case payload := <-client:
+{{- if .MultiTenant}}
+				if !belongsTo(payload, tenantID) {
+					continue
+				}
+{{- end}}
+				fmt.Fprintf(w, "data: %s\n\n", payload)
+				flusher.Flush()
+			}
+		}
+	})
+}
+{{- if .MultiTenant}}
+
+// belongsTo reports whether the changed row belongs to the tenant, so tenants don't receive each other's changes.
+func belongsTo(payload, tenantID string) bool {
+	var change struct {
+		Data map[string]any
+	}
+	if err := json.Unmarshal([]byte(payload), &change); err != nil {
+		return false
+	}
+	return change.Data["tenant_id"] == tenantID
+}
+{{- end}}
+`
+	asyncAPIYaml = `asyncapi: 2.6.0
+info:
+  title: Live updates
+  version: 1.0.0
+  description: Server-Sent Events streams of row changes, published by PostgreSQL triggers.
+defaultContentType: application/json
+channels:
+{{- range .LiveUpdates}}
+  /{{.}}/events:
+    description: Changes to the {{.}} table, streamed as text/event-stream.
+    subscribe:
+      operationId: subscribe_{{.}}_changes
+      message:
+        payload:
+          type: object
+          properties:
+            operation:
+              type: string
+              enum: [INSERT, UPDATE, DELETE]
+            data:
+              type: object
+              description: The inserted, updated, or deleted row.
+{{- end}}
+`
+	storageGo = `package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage keeps uploaded files under keys like "<resource>/<id>/<field>".
+type Storage interface {
+	Save(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Local stores files on the local disk.
+type Local struct {
+	Dir string
+}
+
+func NewLocal(dir string) *Local {
+	if dir == "" {
+		dir = "uploads"
+	}
+	return &Local{Dir: dir}
+}
+
+func (l *Local) path(key string) string {
+	// Cleaning the key as an absolute path prevents escaping the storage directory.
+	return filepath.Join(l.Dir, filepath.Clean("/"+key))
+}
+
+func (l *Local) Save(_ context.Context, key string, r io.Reader) error {
+	name := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	fh, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+	_, err = io.Copy(fh, r)
+	return err
+}
+
+func (l *Local) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	fh, err := os.Open(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return fh, err
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	err := os.Remove(l.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+`
+	storageS3Go = `package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3 stores files in an S3 bucket, using the default AWS credentials chain.
+type S3 struct {
+	Client *s3.Client
+	Bucket string
+}
+
+func NewS3(ctx context.Context, bucket string) (*S3, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &S3{Client: s3.NewFromConfig(cfg), Bucket: bucket}, nil
+}
+
+func (s *S3) Save(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+	return err
+}
+
+func (s *S3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	out, err := s.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+`
+	rateLimitGo = `package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket rate limiter keyed by the API key header or, when it's missing, the client IP.
+type RateLimiter struct {
+	rate      float64
+	burst     float64
+	keyHeader string
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows rps requests per second with bursts of up to burst requests per client.
+func NewRateLimiter(rps float64, burst int, keyHeader string) *RateLimiter {
+	l := &RateLimiter{
+		rate:      rps,
+		burst:     float64(burst),
+		keyHeader: keyHeader,
+		buckets:   make(map[string]*bucket),
+	}
+	go l.cleanup()
+	return l
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		if l.keyHeader != "" {
+			key = r.Header.Get(l.keyHeader)
+		}
+		if key == "" {
+			key = clientIP(r)
+		}
+		if !l.allow(key) {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *RateLimiter) allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// cleanup drops buckets of clients idle long enough to have them refilled, keeping the memory bounded.
+func (l *RateLimiter) cleanup() {
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for key, b := range l.buckets {
+			if time.Since(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, key)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+`
+	corsGo = `package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// CORS answers preflight requests and sets the CORS headers for the allowed origins.
+type CORS struct {
+	origins []string
+	methods string
+	headers string
+}
+
+func NewCORS(origins, methods, headers []string) *CORS {
+	return &CORS{
+		origins: origins,
+		methods: strings.Join(methods, ", "),
+		headers: strings.Join(headers, ", "),
+	}
+}
+
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if slices.Contains(c.origins, "*") || slices.Contains(c.origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", c.methods)
+			w.Header().Set("Access-Control-Allow-Headers", c.headers)
+			w.Header().Set("Access-Control-Max-Age", "600")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+`
+	configGo = `package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+{{- if .RateLimit}}
+	"strconv"
+{{- end}}
+	"strings"
+{{- if or .Cache .EventBroker .Idempotency}}
+	"time"
+{{- end}}
+)
+
+// Config of the server, read from environment variables.
+type Config struct {
+	Port string
+
+	PGHost     string
+	PGPort     string
+	PGDatabase string
+	PGUser     string
+	PGPassword string
+	PGSSLMode  string
+{{- if .FileUploads}}
+{{if eq .FileStorage "s3"}}
+	S3Bucket string
+{{- else}}
+	StorageDir string
+{{- end}}
+{{- end}}
+{{- if .RateLimit}}
+
+	RateLimitRPS       float64
+	RateLimitBurst     int
+	RateLimitKeyHeader string
+{{- end}}
+{{- if .CORS}}
+
+	CORSAllowedOrigins []string
+	CORSAllowedMethods []string
+	CORSAllowedHeaders []string
+{{- end}}
+{{- if .MultiTenant}}
+
+	TenantHeader string
+	TenantClaim  string
+	JWTSecret    string
+{{- end}}
+{{- if .Cache}}
+
+	RedisURL string
+	CacheTTL time.Duration
+{{- end}}
+{{- if .EventBroker}}
+{{if eq .EventBroker "kafka"}}
+	KafkaBrokers       []string
+{{- else}}
+	NATSURL            string
+{{- end}}
+	OutboxPollInterval time.Duration
+{{- end}}
+{{- if .Idempotency}}
+
+	IdempotencyTTL time.Duration
+{{- end}}
+}
+
+// Load reads the configuration from environment variables, falling back to defaults for the optional ones. Variables
+// from the .env file are loaded first, without overriding the ones already set.
+func Load() (*Config, error) {
+	if err := loadDotEnv(".env"); err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{
+		Port:       env("PORT", "8181"),
+		PGHost:     env("PG_HOST", "localhost"),
+		PGPort:     env("PG_PORT", "5432"),
+		PGDatabase: os.Getenv("PG_DATABASE"),
+		PGUser:     os.Getenv("PG_USER"),
+		PGPassword: os.Getenv("PG_PASSWORD"),
+		PGSSLMode:  env("PG_SSLMODE", "disable"),
+	}
+	if cfg.PGDatabase == "" || cfg.PGUser == "" {
+		return nil, errors.New("PG_DATABASE and PG_USER are required")
+	}
+{{- if .FileUploads}}
+{{if eq .FileStorage "s3"}}
+	if cfg.S3Bucket = os.Getenv("S3_BUCKET"); cfg.S3Bucket == "" {
+		return nil, errors.New("S3_BUCKET is required")
+	}
+{{- else}}
+	cfg.StorageDir = env("STORAGE_DIR", "uploads")
+{{- end}}
+{{- end}}
+{{- if .RateLimit}}
+
+	var err error
+	if cfg.RateLimitRPS, err = strconv.ParseFloat(env("RATE_LIMIT_RPS", "10"), 64); err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_RPS: %w", err)
+	}
+	if cfg.RateLimitBurst, err = strconv.Atoi(env("RATE_LIMIT_BURST", "20")); err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_BURST: %w", err)
+	}
+	cfg.RateLimitKeyHeader = os.Getenv("RATE_LIMIT_KEY_HEADER")
+{{- end}}
+{{- if .CORS}}
+
+	cfg.CORSAllowedOrigins = envList("CORS_ALLOWED_ORIGINS", "*")
+	cfg.CORSAllowedMethods = envList("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
+	cfg.CORSAllowedHeaders = envList("CORS_ALLOWED_HEADERS", "Content-Type,Authorization")
+{{- end}}
+{{- if .MultiTenant}}
+
+	cfg.TenantHeader = env("TENANT_HEADER", "X-Tenant-ID")
+	cfg.TenantClaim = env("TENANT_CLAIM", "tenant_id")
+	cfg.JWTSecret = os.Getenv("JWT_SECRET")
+{{- end}}
+{{- if .Cache}}
+
+	cfg.RedisURL = env("REDIS_URL", "redis://localhost:6379/0")
+	ttl, err := time.ParseDuration(env("CACHE_TTL", "5m"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid CACHE_TTL: %w", err)
+	}
+	cfg.CacheTTL = ttl
+{{- end}}
+{{- if .EventBroker}}
+{{if eq .EventBroker "kafka"}}
+	cfg.KafkaBrokers = envList("KAFKA_BROKERS", "localhost:9092")
+{{- else}}
+	cfg.NATSURL = env("NATS_URL", "nats://localhost:4222")
+{{- end}}
+	pollInterval, err := time.ParseDuration(env("OUTBOX_POLL_INTERVAL", "1s"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OUTBOX_POLL_INTERVAL: %w", err)
+	}
+	cfg.OutboxPollInterval = pollInterval
+{{- end}}
+{{- if .Idempotency}}
+
+	idempotencyTTL, err := time.ParseDuration(env("IDEMPOTENCY_TTL", "24h"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid IDEMPOTENCY_TTL: %w", err)
+	}
+	cfg.IdempotencyTTL = idempotencyTTL
+{{- end}}
+
+	return cfg, nil
+}
+
+// PostgresConn returns the connection string of the database.
+func (c *Config) PostgresConn() string {
+	return fmt.Sprintf("host='%s' port='%s' dbname='%s' user='%s' password='%s' sslmode='%s'",
+		c.PGHost, c.PGPort, c.PGDatabase, c.PGUser, c.PGPassword, c.PGSSLMode)
+}
+
+func env(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+{{- if or .CORS (eq .EventBroker "kafka")}}
+
+// envList splits a comma separated variable.
+func envList(name, def string) []string {
+	var list []string
+	for _, item := range strings.Split(env(name, def), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+{{- end}}
+
+// loadDotEnv sets the KEY=value variables of the file, skipping comments and variables already set. Missing file is
+// not an error.
+func loadDotEnv(name string) error {
+	fh, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if err := os.Setenv(key, strings.Trim(strings.TrimSpace(value), "\"'")); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	return scanner.Err()
+}
+`
+	envExample = `# Server
+PORT=8181
+
+# PostgreSQL
+PG_HOST=localhost
+PG_PORT=5432
+PG_DATABASE=
+PG_USER=
+PG_PASSWORD=
+PG_SSLMODE=disable
+{{- if .FileUploads}}
+
+# File storage
+{{- if eq .FileStorage "s3"}}
+# AWS credentials and region are read from the default chain (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_REGION).
+S3_BUCKET=
+{{- else}}
+STORAGE_DIR=uploads
+{{- end}}
+{{- end}}
+{{- if .RateLimit}}
+
+# Rate limiting, requests are keyed by RATE_LIMIT_KEY_HEADER or the client IP when it's empty.
+RATE_LIMIT_RPS=10
+RATE_LIMIT_BURST=20
+RATE_LIMIT_KEY_HEADER=
+{{- end}}
+{{- if .CORS}}
+
+# CORS, comma separated.
+CORS_ALLOWED_ORIGINS=*
+CORS_ALLOWED_METHODS=GET,POST,PUT,PATCH,DELETE,OPTIONS
+CORS_ALLOWED_HEADERS=Content-Type,Authorization
+{{- end}}
+{{- if .MultiTenant}}
+
+# Multi-tenancy, the tenant is read from the TENANT_CLAIM claim of HS256 signed bearer tokens when JWT_SECRET is set,
+# otherwise from the TENANT_HEADER header.
+TENANT_HEADER=X-Tenant-ID
+TENANT_CLAIM=tenant_id
+JWT_SECRET=
+{{- end}}
+{{- if .Cache}}
+
+# Redis cache of the repository layer, CACHE_TTL is a Go duration (e.g. 30s, 5m).
+REDIS_URL=redis://localhost:6379/0
+CACHE_TTL=5m
+{{- end}}
+{{- if .EventBroker}}
+
+# Event publishing, events are relayed from the outbox table every OUTBOX_POLL_INTERVAL (a Go duration).
+{{- if eq .EventBroker "kafka"}}
+KAFKA_BROKERS=localhost:9092
+{{- else}}
+NATS_URL=nats://localhost:4222
+{{- end}}
+OUTBOX_POLL_INTERVAL=1s
+{{- end}}
+{{- if .Idempotency}}
+
+# Idempotency-Key of POST requests can be reused after IDEMPOTENCY_TTL (a Go duration).
+IDEMPOTENCY_TTL=24h
+{{- end}}
+`
+	dockerComposeYaml = `services:
+  postgres:
+    image: postgres:17
+    environment:
+      POSTGRES_DB: ${PG_DATABASE}
+      POSTGRES_USER: ${PG_USER}
+      POSTGRES_PASSWORD: ${PG_PASSWORD}
+    ports:
+      - "${PG_PORT:-5432}:5432"
+    volumes:
+      # Migrations are applied in order when the database is created.
+      - ./migrations:/docker-entrypoint-initdb.d
+      - postgres-data:/var/lib/postgresql/data
+{{- if .Cache}}
+  redis:
+    image: redis:7
+    ports:
+      - "6379:6379"
+{{- end}}
+{{- if eq .EventBroker "kafka"}}
+  kafka:
+    image: apache/kafka:3.9.0
+    ports:
+      - "9092:9092"
+{{- else if eq .EventBroker "nats"}}
+  nats:
+    image: nats:2.10
+    ports:
+      - "4222:4222"
+{{- end}}
+
+volumes:
+  postgres-data:
+`
+	golangciYaml = `run:
+  timeout: 5m
+
+linters:
+  enable:
+    - bodyclose
+    - errcheck
+    - errorlint
+    - gosimple
+    - govet
+    - ineffassign
+    - misspell
+    - revive
+    - rowserrcheck
+    - sqlclosecheck
+    - staticcheck
+    - unused
+
+issues:
+  max-issues-per-linter: 0
+  max-same-issues: 0
+
+severity:
+  default-severity: error
+  rules:
+    # Style findings don't break anything, they're fixed only when the threshold includes warnings.
+    - linters:
+        - misspell
+        - revive
+      severity: warning
+`
+	cacheGo = `package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Cache stores JSON encoded values in Redis, expiring them after the TTL.
+type Cache struct {
+	rdb *redis.Client
+	ttl time.Duration
+}
+
+func New(ctx context.Context, url string, ttl time.Duration) (*Cache, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	rdb := redis.NewClient(opts)
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &Cache{rdb: rdb, ttl: ttl}, nil
+}
+
+// Get decodes the cached value of the key into v and reports whether it was found.
+func (c *Cache) Get(ctx context.Context, key string, v any) (bool, error) {
+	data, err := c.rdb.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+func (c *Cache) Set(ctx context.Context, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.rdb.Set(ctx, key, data, c.ttl).Err()
+}
+
+func (c *Cache) Invalidate(ctx context.Context, keys ...string) error {
+	return c.rdb.Del(ctx, keys...).Err()
+}
+
+// InvalidatePrefix deletes all the keys starting with the prefix, e.g. all cached lists of a resource.
+func (c *Cache) InvalidatePrefix(ctx context.Context, prefix string) error {
+	var keys []string
+	iter := c.rdb.Scan(ctx, 0, prefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return c.rdb.Del(ctx, keys...).Err()
+}
+
+func (c *Cache) Close() error {
+	return c.rdb.Close()
+}
+`
+	outboxGo = `package outbox
+
+import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"os"
-	"path"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
-// File templates needed for generating handlers based on OpenAPI spec.
-const (
-	cfgYaml = `package: api
-output: handlers.gen.go
-generate:
-  models: true
-  std-http-server: true
+// batchSize limits the number of events relayed in a single transaction.
+const batchSize = 100
+
+// Publisher delivers events to the message broker.
+type Publisher interface {
+	Publish(ctx context.Context, topic, key string, payload []byte) error
+	Close() error
+}
+
+// Write stores the event in the outbox within the transaction changing the data, so it's published if and only if the
+// transaction commits.
+func Write(ctx context.Context, tx *sqlx.Tx, topic, key string, payload any) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO outbox (topic, key, payload) VALUES ($1, $2, $3)", topic, key, data)
+	return err
+}
+
+// Relay publishes events from the outbox in order and marks them as published. Events are delivered at least once,
+// consumers should be idempotent.
+type Relay struct {
+	db        *sqlx.DB
+	publisher Publisher
+	interval  time.Duration
+}
+
+type event struct {
+	ID      int64
+	Topic   string
+	Key     string
+	Payload []byte
+}
+
+func NewRelay(db *sqlx.DB, publisher Publisher, interval time.Duration) *Relay {
+	return &Relay{db: db, publisher: publisher, interval: interval}
+}
+
+// Run polls the outbox until the context is canceled.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		for {
+			n, err := r.relay(ctx)
+			if err != nil {
+				log.Printf("Failed to relay outbox events: %v", err)
+				break
+			}
+			if n < batchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay publishes a batch of events, locking them so other instances of the server don't publish them twice.
+func (r *Relay) relay(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var events []event
+	err = tx.SelectContext(ctx, &events, "SELECT id, topic, key, payload FROM outbox WHERE published_at IS NULL "+
+		"ORDER BY id LIMIT $1 FOR UPDATE SKIP LOCKED", batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	// Events published before a failure are still marked, the rest is retried with the next poll.
+	var published []int64
+	var publishErr error
+	for _, e := range events {
+		if publishErr = r.publisher.Publish(ctx, e.Topic, e.Key, e.Payload); publishErr != nil {
+			break
+		}
+		published = append(published, e.ID)
+	}
+	if len(published) > 0 {
+		_, err = tx.ExecContext(ctx, "UPDATE outbox SET published_at = now() WHERE id = ANY($1)", pq.Array(published))
+		if err != nil {
+			return 0, err
+		}
+		if err := tx.Commit(); err != nil {
+			return 0, err
+		}
+	}
+	if publishErr != nil {
+		return len(published), fmt.Errorf("failed to publish event: %w", publishErr)
+	}
+	return len(events), nil
+}
 `
-	generateGo = `package api
+	outboxKafkaGo = `package outbox
 
-//go:generate go run github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen -config cfg.yaml doc/openapi.yaml
+import (
+	"context"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka publishes events to topics named after them, keyed by the resource id to keep events of a resource ordered.
+type Kafka struct {
+	w *kafka.Writer
+}
+
+func NewKafka(brokers []string) *Kafka {
+	return &Kafka{w: &kafka.Writer{
+		Addr:                   kafka.TCP(brokers...),
+		Balancer:               &kafka.Hash{},
+		RequiredAcks:           kafka.RequireAll,
+		AllowAutoTopicCreation: true,
+	}}
+}
+
+func (k *Kafka) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	return k.w.WriteMessages(ctx, kafka.Message{Topic: topic, Key: []byte(key), Value: payload})
+}
+
+func (k *Kafka) Close() error {
+	return k.w.Close()
+}
 `
-	toolsGo = `//go:build tools
-// +build tools
+	outboxNATSGo = `package outbox
 
-package tools
+import (
+	"context"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATS publishes events to subjects named after them, with the resource id in the Key header.
+type NATS struct {
+	nc *nats.Conn
+}
+
+func NewNATS(url string) (*NATS, error) {
+	nc, err := nats.Connect(url)
+	if err != nil {
+		return nil, err
+	}
+	return &NATS{nc: nc}, nil
+}
+
+func (n *NATS) Publish(ctx context.Context, topic, key string, payload []byte) error {
+	msg := nats.NewMsg(topic)
+	msg.Header.Set("Key", key)
+	msg.Data = payload
+	if err := n.nc.PublishMsg(msg); err != nil {
+		return err
+	}
+	return n.nc.FlushWithContext(ctx)
+}
+
+func (n *NATS) Close() error {
+	return n.nc.Drain()
+}
+`
+	tenantGo = `package tenant
+
+import "context"
+
+type contextKey struct{}
+
+// WithTenant returns a copy of the context carrying the tenant.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the tenant of the request, identified by the tenant middleware.
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+`
+	tenantMiddlewareGo = `package middleware
 
 import (
-	_ "github.com/oapi-codegen/oapi-codegen/v2/cmd/oapi-codegen"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"myApp/pkg/tenant"
 )
+
+// Tenant identifies the tenant of every request, rejecting requests without one. With a JWT secret, the tenant is
+// read from a claim of the HS256 signed bearer token, otherwise from the header.
+type Tenant struct {
+	header string
+	secret []byte
+	claim  string
+}
+
+func NewTenant(header, jwtSecret, claim string) *Tenant {
+	return &Tenant{header: header, secret: []byte(jwtSecret), claim: claim}
+}
+
+func (t *Tenant) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := t.identify(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(tenant.WithTenant(r.Context(), id)))
+	})
+}
+
+func (t *Tenant) identify(r *http.Request) (string, error) {
+	if len(t.secret) == 0 {
+		if id := r.Header.Get(t.header); id != "" {
+			return id, nil
+		}
+		return "", errors.New("missing " + t.header + " header")
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return "", errors.New("missing bearer token")
+	}
+	claims, err := t.verify(token)
+	if err != nil {
+		return "", err
+	}
+	id, _ := claims[t.claim].(string)
+	if id == "" {
+		return "", errors.New("missing " + t.claim + " claim")
+	}
+	return id, nil
+}
+
+// verify checks the signature and expiration of the HS256 signed token and returns its claims.
+func (t *Tenant) verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "HS256" {
+		return nil, errors.New("unsupported token algorithm")
+	}
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, errors.New("invalid token signature")
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	if exp, ok := claims["exp"].(float64); ok && time.Now().Unix() > int64(exp) {
+		return nil, errors.New("token expired")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
 `
-	mainGo = `package main
+	idempotencyGo = `package middleware
 
 import (
+	"bytes"
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
 	"log"
 	"net/http"
-	"os"
+	"time"
 
-	"github.com/iancoleman/strcase"
 	"github.com/jmoiron/sqlx"
-	"github.com/jmoiron/sqlx/reflectx"
-	_ "github.com/lib/pq"
+{{- if .MultiTenant}}
 
-	"myApp/pkg/api"
+	"myApp/pkg/tenant"
+{{- end}}
 )
 
-func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+// Idempotency replays the stored response of POST requests repeated with the same Idempotency-Key header, so retried
+// requests don't create duplicates. Keys expire after the TTL.
+type Idempotency struct {
+	db  *sqlx.DB
+	ttl time.Duration
+}
 
-	conn := fmt.Sprintf("host='%s' port='%s' dbname='%s' user='%s' password='%s' sslmode='%s'",
-		os.Getenv("PG_HOST"), os.Getenv("PG_PORT"), os.Getenv("PG_DATABASE"), os.Getenv("PG_USER"), os.Getenv("PG_PASSWORD"), os.Getenv("PG_SSLMODE"))
+type storedResponse struct {
+	RequestHash string
+	Status      sql.NullInt64
+	ContentType sql.NullString
+	Body        []byte
+}
 
-	db, err := sqlx.ConnectContext(ctx, "postgres", conn)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+func NewIdempotency(db *sqlx.DB, ttl time.Duration) *Idempotency {
+	i := &Idempotency{db: db, ttl: ttl}
+	go i.cleanup()
+	return i
+}
+
+func (i *Idempotency) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+{{- if .MultiTenant}}
+		key = tenant.FromContext(r.Context()) + ":" + key
+{{- end}}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		sum := sha256.Sum256(append([]byte(r.URL.Path+"\n"), body...))
+		hash := hex.EncodeToString(sum[:])
+
+		res, err := i.db.ExecContext(r.Context(), "INSERT INTO idempotency_keys (key, request_hash) VALUES ($1, $2) "+
+			"ON CONFLICT (key) DO NOTHING", key, hash)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			i.replay(w, r, key, hash)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		// Responses are stored even when the client is gone. Server errors are dropped, so the request can be retried.
+		ctx := context.WithoutCancel(r.Context())
+		if rec.status >= http.StatusInternalServerError {
+			_, err = i.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE key = $1", key)
+		} else {
+			_, err = i.db.ExecContext(ctx, "UPDATE idempotency_keys SET status = $1, content_type = $2, body = $3 "+
+				"WHERE key = $4", rec.status, rec.Header().Get("Content-Type"), rec.body.Bytes(), key)
+		}
+		if err != nil {
+			log.Printf("Failed to store response of idempotency key: %v", err)
+		}
+	})
+}
+
+func (i *Idempotency) replay(w http.ResponseWriter, r *http.Request, key, hash string) {
+	var stored storedResponse
+	err := i.db.GetContext(r.Context(), &stored, "SELECT request_hash, status, content_type, body FROM idempotency_keys "+
+		"WHERE key = $1", key)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The first request failed in the meantime.
+		http.Error(w, "request with this Idempotency-Key failed, retry it", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	defer db.Close()
-	db.Mapper = reflectx.NewMapperFunc("json", strcase.ToSnake)
 
-	srv := api.Server{DB: db}
-	h := api.Handler(srv)
-	log.Printf("Server listening on port 8181")
-	log.Fatal(http.ListenAndServe(":8181", h))
+	if stored.RequestHash != hash {
+		http.Error(w, "Idempotency-Key was already used for a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if !stored.Status.Valid {
+		http.Error(w, "request with this Idempotency-Key is still being processed", http.StatusConflict)
+		return
+	}
+
+	if stored.ContentType.String != "" {
+		w.Header().Set("Content-Type", stored.ContentType.String)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(int(stored.Status.Int64))
+	w.Write(stored.Body)
+}
+
+// cleanup deletes expired keys, so they can be reused.
+func (i *Idempotency) cleanup() {
+	for range time.Tick(time.Minute) {
+		_, err := i.db.Exec("DELETE FROM idempotency_keys WHERE created_at < $1", time.Now().Add(-i.ttl))
+		if err != nil {
+			log.Printf("Failed to delete expired idempotency keys: %v", err)
+		}
+	}
+}
+
+// responseRecorder captures the response while writing it to the client.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
 }
 `
 	goMod = `module myApp
@@ -293,7 +1861,7 @@ gopkg.in/yaml.v3 v3.0.1/go.mod h1:K4uyk7z7BCEPqu6E+C64Yfv1cQ7kz7rIZviUmN+EgEM=
 `
 )
 
-func createBoilerPlate() error {
+func (s *Service) createBoilerPlate(ctx context.Context) error {
 	rootDir := os.Getenv("PROJECT_ROOT")
 	if rootDir != "" {
 		if err := os.MkdirAll(rootDir, 0755); err != nil {
@@ -303,75 +1871,257 @@ func createBoilerPlate() error {
 		rootDir = "."
 	}
 
-	mainFh, err := os.Create(path.Join(rootDir, "main.go"))
-	if err != nil {
-		return fmt.Errorf("failed to create main.go: %w", err)
-	}
-	defer mainFh.Close()
-	_, err = mainFh.WriteString(mainGo)
-	if err != nil {
-		return fmt.Errorf("failed to write main.go: %w", err)
+	tools := toolsGo
+	if s.APIStyle == APIStyleGraphQL {
+		tools = graphqlToolsGo
 	}
 
-	toolsDir := path.Join(rootDir, "tools")
-	if err := os.MkdirAll(toolsDir, 0755); err != nil {
-		return fmt.Errorf("failed to create tools directory: %w", err)
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return err
 	}
-	toolsFh, err := os.Create(path.Join(toolsDir, "tools.go"))
-	if err != nil {
-		return fmt.Errorf("failed to create tools.go: %w", err)
+	if err := s.writeFile(path.Join(rootDir, "tools", "tools.go"), tools); err != nil {
+		return err
 	}
-	defer toolsFh.Close()
-	_, err = toolsFh.WriteString(toolsGo)
-	if err != nil {
-		return fmt.Errorf("failed to write tools.go: %w", err)
+	if err := s.writeFile(path.Join(rootDir, "go.mod"), goMod); err != nil {
+		return err
 	}
-
-	goModFh, err := os.Create(path.Join(rootDir, "go.mod"))
-	if err != nil {
-		return fmt.Errorf("failed to create go.mod: %w", err)
+	if err := s.writeFile(path.Join(rootDir, "go.sum"), goSum); err != nil {
+		return err
 	}
-	defer goModFh.Close()
-	_, err = goModFh.WriteString(goMod)
-	if err != nil {
-		return fmt.Errorf("failed to write go.mod: %w", err)
+	if err := s.writeFile(path.Join(rootDir, ".golangci.yml"), golangciYaml); err != nil {
+		return err
+	}
+	if s.PGDriver == vector.DriverPGX {
+		if err := goGet(ctx, rootDir, "github.com/jackc/pgx/v5@"+pgxVersion); err != nil {
+			return err
+		}
 	}
 
-	goSumFh, err := os.Create(path.Join(rootDir, "go.sum"))
-	if err != nil {
-		return fmt.Errorf("failed to create go.sum: %w", err)
+	if s.RateLimit {
+		if err := s.writeFile(path.Join(rootDir, "pkg", "middleware", "ratelimit.go"), rateLimitGo); err != nil {
+			return err
+		}
 	}
-	defer goSumFh.Close()
-	_, err = goSumFh.WriteString(goSum)
-	if err != nil {
-		return fmt.Errorf("failed to write go.sum: %w", err)
+	if s.CORS {
+		if err := s.writeFile(path.Join(rootDir, "pkg", "middleware", "cors.go"), corsGo); err != nil {
+			return err
+		}
+	}
+	if s.MultiTenant {
+		if err := s.writeFile(path.Join(rootDir, "pkg", "middleware", "tenant.go"), tenantMiddlewareGo); err != nil {
+			return err
+		}
+		if err := s.writeFile(path.Join(rootDir, "pkg", "tenant", "tenant.go"), tenantGo); err != nil {
+			return err
+		}
+	}
+
+	if s.APIStyle == APIStyleGraphQL {
+		return s.createGraphQLBoilerPlate(ctx, rootDir)
 	}
 
 	apiDir := path.Join(rootDir, "pkg", "api")
-	docDir := path.Join(apiDir, "doc")
-	if err := os.MkdirAll(docDir, 0755); err != nil {
+	if err := s.writeFile(path.Join(apiDir, "cfg.yaml"), cfgYaml); err != nil {
+		return err
+	}
+	if err := s.writeFile(path.Join(apiDir, "generate.go"), generateGo); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Join(apiDir, "doc"), 0755); err != nil {
 		return fmt.Errorf("failed to create api doc directory: %w", err)
 	}
 
-	cfgFh, err := os.Create(path.Join(apiDir, "cfg.yaml"))
-	if err != nil {
-		return fmt.Errorf("failed to create cfg.yaml: %w", err)
+	return nil
+}
+
+func (s *Service) createGraphQLBoilerPlate(ctx context.Context, rootDir string) error {
+	graphDir := path.Join(rootDir, "pkg", "graph")
+	if err := s.writeFile(path.Join(graphDir, "gqlgen.yml"), gqlgenYaml); err != nil {
+		return err
+	}
+	if err := s.writeFile(path.Join(graphDir, "generate.go"), graphGenerateGo); err != nil {
+		return err
+	}
+	// gqlgen keeps resolver.go untouched once it exists, so only write it the first time.
+	if _, err := os.Stat(path.Join(graphDir, "resolver.go")); os.IsNotExist(err) {
+		resolver, err := s.renderTemplate(graphResolverGo)
+		if err != nil {
+			return err
+		}
+		if err := s.writeFile(path.Join(graphDir, "resolver.go"), resolver); err != nil {
+			return err
+		}
+	}
+	return goGet(ctx, rootDir, "github.com/99designs/gqlgen@"+gqlgenVersion)
+}
+
+// writeAppFiles renders main.go, the config package, .env.example, and docker-compose.yml of the generated project,
+// which depend on the generation options. They're re-rendered whenever tools add new routes or features.
+func (s *Service) writeAppFiles(rootDir string) error {
+	tmpl := mainGo
+	if s.APIStyle == APIStyleGraphQL {
+		tmpl = graphqlMainGo
+	}
+	files := map[string]string{
+		"main.go":                               tmpl,
+		path.Join("pkg", "config", "config.go"): configGo,
+		".env.example":                          envExample,
+		"docker-compose.yml":                    dockerComposeYaml,
+	}
+	for name, tmpl := range files {
+		content, err := s.renderTemplate(tmpl)
+		if err != nil {
+			return err
+		}
+		if err := s.writeFile(path.Join(rootDir, name), content); err != nil {
+			return err
+		}
 	}
-	defer cfgFh.Close()
-	_, err = cfgFh.WriteString(cfgYaml)
+	return nil
+}
+
+// renderTemplate executes a file template with the generation options of the service.
+func (s *Service) renderTemplate(tmpl string) (string, error) {
+	t, err := template.New("file").Parse(tmpl)
 	if err != nil {
-		return fmt.Errorf("failed to write cfg.yaml: %w", err)
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, s); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// writeFile creates the file together with its parent directories and writes the content to it. Files of the project
+// are tracked in the lock file with the hash of their content. Files generated with the same content before are left
+// untouched, so regeneration keeps changes made to them since, and manual edits of files generated with other content
+// are merged into it. Hand-written files of the project are left as they are. Protected regions of the file are kept
+// verbatim, and the configured file header is added.
+func (s *Service) writeFile(name, content string) error {
+	if handWrittenFile(name) {
+		log.Warn().Msgf("%s was written by hand, so the generated content wasn't saved to it", path.Base(name))
+		return nil
 	}
+	return s.writeEditedFile(name, content)
+}
 
-	genFh, err := os.Create(path.Join(apiDir, "generate.go"))
+// writeEditedFile writes the file like writeFile, also when it was written by hand, for edits of its current content.
+func (s *Service) writeEditedFile(name, content string) error {
+	// Go files are formatted, so templates and the model's code follow the configured style alike.
+	if path.Ext(name) == ".go" {
+		formatted, err := formatGo(name, content)
+		if err != nil {
+			return fmt.Errorf("failed to format %s: %w", path.Base(name), err)
+		}
+		content = formatted
+	}
+	content = addFileHeader(name, content)
+	content, err := keepProtectedRegions(name, content)
 	if err != nil {
-		return fmt.Errorf("failed to create generate.go: %w", err)
+		return err
+	}
+	if unchangedFile(name, content) {
+		return nil
+	}
+	merged, conflictErr := mergeManualEdits(name, content)
+	switch {
+	case unchangedFile(name, merged):
+		// Manual edits of a file regenerated with the content generated last are all kept, leaving it unchanged.
+	default:
+		if err := s.saveFile(name, merged); err != nil {
+			return err
+		}
+	}
+	// The generated content is tracked even when manual edits were merged into it, as it's the base of the next merge.
+	if err := trackFile(name, content); err != nil {
+		log.Err(err).Msgf("Failed to track %s", path.Base(name))
+	}
+	if conflictErr != nil {
+		log.Warn().Msg(conflictErr.Error())
+	}
+	return conflictErr
+}
+
+// replaceFile writes the file with the configured file header, replacing its content without merging manual edits, for
+// files whose generation takes their current content into account already, like the spec of a resource merged into the
+// spec of the project.
+func (s *Service) replaceFile(name, content string) error {
+	content = addFileHeader(name, content)
+	if err := s.saveFile(name, content); err != nil {
+		return err
+	}
+	if err := trackFile(name, content); err != nil {
+		log.Err(err).Msgf("Failed to track %s", path.Base(name))
+	}
+	return nil
+}
+
+// saveFile writes the content to the file, through EditFile when it's set, or creating the file together with its
+// parent directories otherwise.
+func (s *Service) saveFile(name, content string) error {
+	if s.EditFile != nil {
+		if err := s.EditFile(name, content); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path.Base(name), err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+		return fmt.Errorf("failed to create %s directory: %w", path.Dir(name), err)
+	}
+	if err := writeFileAtomic(name, []byte(content)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path.Base(name), err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces the file with the content, writing it to a temporary file in the same directory first and
+// renaming it over the file once it's synced to disk, so an interrupted write never leaves a truncated file, like a
+// server.go breaking later builds. The mode of the file is kept when it exists.
+func writeFileAtomic(name string, content []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(name); err == nil {
+		mode = info.Mode().Perm()
 	}
-	defer genFh.Close()
-	_, err = genFh.WriteString(generateGo)
+	dir := path.Dir(name)
+	fh, err := os.CreateTemp(dir, "."+path.Base(name)+".*.tmp")
 	if err != nil {
-		return fmt.Errorf("failed to write generate.go: %w", err)
+		return err
+	}
+	tmp := fh.Name()
+	defer os.Remove(tmp)
+	if _, err := fh.Write(content); err != nil {
+		fh.Close()
+		return err
 	}
+	if err := fh.Chmod(mode); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Sync(); err != nil {
+		fh.Close()
+		return err
+	}
+	if err := fh.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	// Syncing the directory persists the rename itself. Some platforms can't sync directories, which isn't an error.
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+	return nil
+}
 
+// goGet adds the given packages to the generated project's go.mod and go.sum.
+func goGet(ctx context.Context, rootDir string, pkgs ...string) error {
+	cmd := exec.CommandContext(ctx, "go", append([]string{"get"}, pkgs...)...)
+	cmd.Dir = rootDir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go get failed: %w\n%s", err, output)
+	}
 	return nil
 }
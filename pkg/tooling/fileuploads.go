@@ -0,0 +1,77 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	FileStorageLocal = "local"
+	FileStorageS3    = "s3"
+)
+
+// awsSDKPackages are added to the generated project when files are stored in S3.
+var awsSDKPackages = []string{
+	"github.com/aws/aws-sdk-go-v2@v1.36.3",
+	"github.com/aws/aws-sdk-go-v2/config@v1.29.9",
+	"github.com/aws/aws-sdk-go-v2/service/s3@v1.78.2",
+}
+
+const fileUploadsPrompt = `
+## File uploads
+
+Some resources have file fields, handled by the storage package (already generated):
+
+- The Server struct must have a Storage field of storage.Storage type, next to the other fields.
+- Implement the multipart upload endpoints by reading the "file" form field (limit the request size with
+  http.MaxBytesReader), saving it with Storage.Save under the "<resource>/<id>/<field>" key, and storing the file name
+  and content type in the resource's table.
+- Implement the download endpoints by opening the file with Storage.Open, returning 404 for storage.ErrNotFound, and
+  streaming it with the stored content type.
+- Delete the stored files with Storage.Delete when the resource is deleted.
+`
+
+const GenerateFileStorageToolName = "generate_file_storage"
+
+func (s *Service) GenerateFileStorageTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateFileStorageToolName),
+			Description: openai.String("Generates the storage package (local disk or S3) used by file upload endpoints and wires it into the server. Use it before generating server code when resources have file fields."),
+		}),
+	}
+}
+
+func (s *Service) GenerateFileStorage(ctx context.Context) string {
+	if s.APIStyle != APIStyleOpenAPI {
+		return "File uploads are supported only for OpenAPI projects"
+	}
+
+	rootDir := os.Getenv("PROJECT_ROOT")
+	storageDir := path.Join(rootDir, "pkg", "storage")
+	if err := s.writeFile(path.Join(storageDir, "storage.go"), storageGo); err != nil {
+		return fmt.Sprintf("Failed to save storage package: %v", err)
+	}
+	if s.FileStorage == FileStorageS3 {
+		if err := s.writeFile(path.Join(storageDir, "s3.go"), storageS3Go); err != nil {
+			return fmt.Sprintf("Failed to save S3 storage: %v", err)
+		}
+		if err := goGet(ctx, rootDir, awsSDKPackages...); err != nil {
+			return fmt.Sprintf("Failed to add AWS SDK dependencies: %v", err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.FileUploads = true
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return fmt.Sprintf("Failed to wire storage into the server: %v", err)
+	}
+
+	return fmt.Sprintf("Storage package generated (%s storage), server code can use the Storage field now", s.FileStorage)
+}
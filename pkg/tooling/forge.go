@@ -0,0 +1,151 @@
+package tooling
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+)
+
+// pullRequest is a pull request, or a merge request on GitLab, to be opened.
+type pullRequest struct {
+	Title string
+	Body  string
+	// Head is the branch with the changes, Base the branch they're merged into.
+	Head string
+	Base string
+}
+
+// forge opens pull requests of a repository on a git hosting service.
+type forge interface {
+	// defaultBranch returns the default branch of the repository.
+	defaultBranch(ctx context.Context) (string, error)
+	// openPullRequest opens the pull request, or finds the open one of its head branch, and returns its URL.
+	openPullRequest(ctx context.Context, pr pullRequest) (string, error)
+}
+
+// newForge returns the forge of the repository of the git remote URL, of the forge given with --forge or else
+// detected by the host of the remote.
+func (s *Service) newForge(remoteURL string) (forge, error) {
+	host, repo, err := parseRemote(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	kind := s.Forge
+	if kind == "" {
+		kind = detectForge(host)
+	}
+	switch kind {
+	case config.ForgeGitHub:
+		if s.GitHubToken == "" {
+			return nil, fmt.Errorf("there is no GitHub token, ask the user to set --github-token or GITHUB_TOKEN")
+		}
+		// Other hosts than github.com are GitHub Enterprise, which serves its API under /api/v3.
+		apiURL := s.GitHubAPIURL
+		if apiURL == "" {
+			apiURL = "https://" + host + "/api/v3"
+			if host == "github.com" {
+				apiURL = "https://api.github.com"
+			}
+		}
+		return &githubForge{apiURL: apiURL, token: s.GitHubToken, repo: ownerRepo(repo)}, nil
+	case config.ForgeGitLab:
+		if s.GitLabToken == "" {
+			return nil, fmt.Errorf("there is no GitLab token, ask the user to set --gitlab-token or GITLAB_TOKEN")
+		}
+		apiURL := cmp.Or(s.GitLabAPIURL, "https://"+host+"/api/v4")
+		return &gitlabForge{apiURL: apiURL, token: s.GitLabToken, project: repo}, nil
+	case config.ForgeGitea:
+		if s.GiteaToken == "" {
+			return nil, fmt.Errorf("there is no Gitea token, ask the user to set --gitea-token or GITEA_TOKEN")
+		}
+		apiURL := cmp.Or(s.GiteaAPIURL, "https://"+host+"/api/v1")
+		return &giteaForge{apiURL: apiURL, token: s.GiteaToken, repo: ownerRepo(repo)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported forge: %s", kind)
+	}
+}
+
+// detectForge returns the forge hosting the repositories of the host. Hosts of unknown forges are taken for GitHub
+// Enterprise.
+func detectForge(host string) string {
+	switch {
+	case strings.Contains(host, "gitlab"):
+		return config.ForgeGitLab
+	case strings.Contains(host, "gitea"), host == "codeberg.org":
+		return config.ForgeGitea
+	default:
+		return config.ForgeGitHub
+	}
+}
+
+// parseRemote returns the host and the path of the repository, e.g. owner/name, of the git remote URL, given as HTTPS,
+// SSH, or scp-like git@host:owner/name.
+func parseRemote(remoteURL string) (host, repo string, err error) {
+	p := remoteURL
+	if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+		host, p = u.Hostname(), u.Path
+	} else if userHost, rest, ok := strings.Cut(remoteURL, ":"); ok {
+		_, host, _ = strings.Cut(userHost, "@")
+		if host == "" {
+			host = userHost
+		}
+		p = rest
+	}
+	repo = strings.Trim(strings.TrimSuffix(p, ".git"), "/")
+	if parts := strings.Split(repo, "/"); len(parts) < 2 || parts[0] == "" || parts[len(parts)-1] == "" {
+		return "", "", fmt.Errorf("unsupported git remote URL: %s", remoteURL)
+	}
+	return host, repo, nil
+}
+
+// ownerRepo returns the owner/name of the repository path, without the path the forge is served under, if any. Only
+// GitLab has nested namespaces.
+func ownerRepo(repo string) string {
+	parts := strings.Split(repo, "/")
+	return strings.Join(parts[len(parts)-2:], "/")
+}
+
+// apiRequest sends the request to the API of a forge, with the body encoded as JSON unless it's nil, and decodes the
+// response into the result.
+func apiRequest(ctx context.Context, method, endpoint string, header http.Header, body, result any) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = header.Clone()
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read API response: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("API responded with %s: %s", resp.Status, content)
+	}
+	if err := json.Unmarshal(content, result); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return nil
+}
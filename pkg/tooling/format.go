@@ -0,0 +1,42 @@
+package tooling
+
+import (
+	"fmt"
+
+	"golang.org/x/tools/imports"
+	gofumpt "mvdan.cc/gofumpt/format"
+)
+
+const (
+	GoFormatterGofmt   = "gofmt"
+	GoFormatterGofumpt = "gofumpt"
+)
+
+// goFormatter formats all Go code saved to the project. It's set once from the config, as files are written by plain
+// functions as well as by the service.
+var goFormatter = GoFormatterGofmt
+
+// setGoFormat sets the formatter of the generated Go code and the comma separated import path prefixes grouped after
+// third-party imports.
+func setGoFormat(formatter, localPrefix string) {
+	goFormatter = formatter
+	imports.LocalPrefix = localPrefix
+}
+
+// formatGo formats Go code written by the model the way goimports does, so missing imports are added and unused ones
+// removed, and with gofumpt when configured. The file name is used to resolve imports of the project's packages. Code
+// that doesn't parse is returned as an error to be fixed by the model instead of being saved.
+func formatGo(name, code string) (string, error) {
+	formatted, err := imports.Process(name, []byte(code), nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid Go code: %w", err)
+	}
+	if goFormatter == GoFormatterGofumpt {
+		// The language version and module path match go.mod of the generated project.
+		formatted, err = gofumpt.Source(formatted, gofumpt.Options{LangVersion: "go1.23", ModulePath: projectModule})
+		if err != nil {
+			return "", fmt.Errorf("invalid Go code: %w", err)
+		}
+	}
+	return string(formatted), nil
+}
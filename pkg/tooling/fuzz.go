@@ -0,0 +1,458 @@
+package tooling
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+)
+
+const (
+	// defaultFuzzIterations is the number of requests sent to every operation by default.
+	defaultFuzzIterations = 20
+	// maxFuzzFindings limits the number of reported findings, so they fit into the context of the model.
+	maxFuzzFindings = 20
+	// fuzzChars are used in random strings, including characters breaking naively built SQL or JSON.
+	fuzzChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 _-'\"\\%üł😀"
+)
+
+const FuzzAPIToolName = "fuzz_api"
+
+func (s *Service) FuzzAPITool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(FuzzAPIToolName),
+			Description: openai.String("Starts the generated server and sends it randomized requests, both conforming and deliberately not conforming to the OpenAPI spec. Reports 5xx responses, undeclared response statuses, and accepted invalid requests to fix."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"iterations": map[string]interface{}{
+						"type":        "integer",
+						"description": "Number of requests sent to every operation, 20 by default.",
+					},
+					"seed": map[string]interface{}{
+						"type":        "integer",
+						"description": "Seed of the random requests, pass the one from a previous report to repeat it.",
+					},
+				},
+			}),
+		}),
+	}
+}
+
+func (s *Service) FuzzAPI(ctx context.Context, multi *pterm.MultiPrinter, arguments string) string {
+	if s.APIStyle != APIStyleOpenAPI {
+		return "Fuzzing is supported only for OpenAPI projects"
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	iterations := defaultFuzzIterations
+	if n, ok := args["iterations"].(float64); ok && n > 0 {
+		iterations = int(n)
+	}
+	seed := uint64(time.Now().UnixNano())
+	if n, ok := args["seed"].(float64); ok {
+		seed = uint64(n)
+	}
+
+	spinner := NewSpinner(multi, "Fuzzing the API...")
+	defer spinner.Success("API fuzzed")
+
+	spec, err := loadOpenAPISpec()
+	if err != nil {
+		return err.Error()
+	}
+	srv, err := s.startServer(ctx)
+	if err != nil {
+		return fmt.Sprintf("Failed to start the server: %v", err)
+	}
+
+	f := &fuzzer{
+		spec:     spec,
+		srv:      srv,
+		rnd:      rand.New(rand.NewPCG(seed, seed)),
+		basePath: s.BasePath(),
+		ids:      make(map[string][]string),
+		findings: make(map[string]string),
+	}
+	paths := make([]string, 0, len(spec.Paths))
+	for p := range spec.Paths {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	requests := 0
+	for _, p := range paths {
+		ops := spec.Paths[p].operations()
+		methods := make([]string, 0, len(ops))
+		for method := range ops {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		for _, method := range methods {
+			for i := 0; i < iterations && ctx.Err() == nil; i++ {
+				f.fuzz(ctx, p, method, ops[method])
+				requests++
+			}
+		}
+	}
+	f.cleanup(ctx)
+	output := srv.stop()
+
+	if len(f.findings) == 0 {
+		return fmt.Sprintf("Sent %d requests (seed %d), no 5xx responses or contract violations found", requests, seed)
+	}
+	findings := make([]string, 0, len(f.findings))
+	for _, finding := range f.findings {
+		findings = append(findings, finding)
+	}
+	sort.Strings(findings)
+	if len(output) > 4000 {
+		output = "..." + output[len(output)-4000:]
+	}
+	return fmt.Sprintf("Fuzzing found issues with seed %d, fix the code and fuzz again with the same seed:\n%s\n\nServer output:\n%s",
+		seed, strings.Join(findings, "\n"), output)
+}
+
+// fuzzer sends random requests to the server and collects findings. All randomness comes from rnd, and maps are
+// iterated in sorted order, so the same seed sends the same requests, as far as they don't depend on the responses.
+type fuzzer struct {
+	spec     *openAPISpec
+	srv      *runningServer
+	rnd      *rand.Rand
+	basePath string
+	// ids of resources created while fuzzing, keyed by their collection paths.
+	ids map[string][]string
+	// findings are keyed by the operation, kind, and status, so repeated findings are reported once.
+	findings map[string]string
+}
+
+// fuzz sends a random request to the operation. Two thirds of requests conform to the spec, the rest are mutated to
+// be invalid.
+func (f *fuzzer) fuzz(ctx context.Context, pathTemplate, method string, op *specOperation) {
+	params := append(append([]specParameter{}, f.spec.Paths[pathTemplate].Parameters...), op.Parameters...)
+	schema := op.requestSchema()
+	var body any
+	if schema != nil {
+		body = f.randomValue(schema, 0)
+	}
+
+	var mutation string
+	if f.rnd.IntN(3) == 0 {
+		mutations := f.mutations(schema, params)
+		if len(mutations) > 0 {
+			mutation = mutations[f.rnd.IntN(len(mutations))]
+		}
+	}
+
+	p := pathTemplate
+	query := url.Values{}
+	for _, param := range params {
+		value := f.randomValue(param.Schema, 0)
+		switch param.In {
+		case "path":
+			collection := strings.TrimSuffix(pathTemplate[:strings.Index(pathTemplate, "{")], "/")
+			if ids := f.ids[collection]; len(ids) > 0 && f.rnd.IntN(2) == 0 {
+				value = ids[f.rnd.IntN(len(ids))]
+			}
+			if mutation == "invalid path parameter" && f.spec.resolve(param.Schema).Format == "uuid" {
+				value = "not-a-uuid"
+			}
+			p = strings.Replace(p, "{"+param.Name+"}", url.PathEscape(fmt.Sprint(value)), 1)
+		case "query":
+			if param.Required || f.rnd.IntN(2) == 0 {
+				query.Set(param.Name, fmt.Sprint(value))
+			}
+		}
+	}
+	if len(query) > 0 {
+		p += "?" + query.Encode()
+	}
+
+	var data []byte
+	if schema != nil {
+		if mutation != "" {
+			body = f.mutate(mutation, f.spec.resolve(schema), body)
+		}
+		if mutation == "invalid JSON" {
+			data = []byte(`{"`)
+		} else {
+			data, _ = json.Marshal(body)
+		}
+	}
+
+	status, respBody, err := f.srv.send(ctx, method, f.basePath+p, data)
+	if err != nil {
+		f.report(method, pathTemplate, "request failed", 0, fmt.Sprintf("%s %s: %v", method, p, err))
+		return
+	}
+
+	example := fmt.Sprintf("%s %s", method, p)
+	if data != nil {
+		example += " " + truncate(string(data), 300)
+	}
+	example += fmt.Sprintf(" -> %d %s", status, truncate(strings.TrimSpace(string(respBody)), 300))
+	switch {
+	case status >= 500:
+		f.report(method, pathTemplate, "server error", status, example)
+	case !op.declares(status):
+		f.report(method, pathTemplate, "undeclared status", status, example)
+	case mutation != "" && status < 300:
+		f.report(method, pathTemplate, "accepted "+mutation, status, example)
+	case mutation == "" && method == http.MethodPost && status < 300 && !strings.ContainsAny(pathTemplate, "{:"):
+		var created map[string]any
+		if json.Unmarshal(respBody, &created) == nil {
+			if id, ok := created["id"].(string); ok {
+				f.ids[pathTemplate] = append(f.ids[pathTemplate], id)
+			}
+		}
+	}
+}
+
+func (f *fuzzer) report(method, pathTemplate, kind string, status int, example string) {
+	key := fmt.Sprintf("%s %s %s %d", method, pathTemplate, kind, status)
+	if _, ok := f.findings[key]; ok || len(f.findings) >= maxFuzzFindings {
+		return
+	}
+	f.findings[key] = fmt.Sprintf("- %s %s: %s, e.g. %s", method, pathTemplate, kind, example)
+}
+
+// cleanup deletes the resources created while fuzzing.
+func (f *fuzzer) cleanup(ctx context.Context) {
+	for _, r := range f.spec.resources() {
+		if f.spec.Paths[r.ItemPath].Delete == nil {
+			continue
+		}
+		for _, id := range f.ids[r.Path] {
+			_, _, _ = f.srv.send(ctx, http.MethodDelete, f.basePath+r.ItemPath[:strings.LastIndex(r.ItemPath, "{")]+id, nil)
+		}
+	}
+}
+
+// mutations returns the ways the request can be made invalid.
+func (f *fuzzer) mutations(schema *specSchema, params []specParameter) []string {
+	var mutations []string
+	for _, param := range params {
+		if param.In == "path" && f.spec.resolve(param.Schema).Format == "uuid" {
+			mutations = append(mutations, "invalid path parameter")
+			break
+		}
+	}
+	if schema == nil {
+		return mutations
+	}
+	mutations = append(mutations, "invalid JSON")
+	schema = f.spec.resolve(schema)
+	if schema.Type == "object" || len(schema.Properties) > 0 {
+		mutations = append(mutations, "wrong type")
+		if len(schema.Required) > 0 {
+			mutations = append(mutations, "missing required property")
+		}
+		for _, prop := range schema.Properties {
+			prop = f.spec.resolve(prop)
+			if len(prop.Enum) > 0 || prop.MaxLength != nil || prop.Minimum != nil || prop.Maximum != nil {
+				mutations = append(mutations, "out of range value")
+				break
+			}
+		}
+	}
+	return mutations
+}
+
+// mutate makes the body of the request invalid.
+func (f *fuzzer) mutate(mutation string, schema *specSchema, body any) any {
+	obj, ok := body.(map[string]any)
+	if !ok {
+		return body
+	}
+	names := make([]string, 0, len(schema.Properties))
+	for name, prop := range schema.Properties {
+		if !f.spec.resolve(prop).ReadOnly {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	switch mutation {
+	case "wrong type":
+		if len(names) == 0 {
+			return body
+		}
+		name := names[f.rnd.IntN(len(names))]
+		if f.spec.resolve(schema.Properties[name]).Type == "string" {
+			obj[name] = 12345
+		} else {
+			obj[name] = "not-a-" + f.spec.resolve(schema.Properties[name]).Type
+		}
+	case "missing required property":
+		delete(obj, schema.Required[f.rnd.IntN(len(schema.Required))])
+	case "out of range value":
+		for _, name := range names {
+			prop := f.spec.resolve(schema.Properties[name])
+			switch {
+			case len(prop.Enum) > 0:
+				obj[name] = "not-in-enum"
+			case prop.MaxLength != nil:
+				obj[name] = strings.Repeat("x", *prop.MaxLength+1)
+			case prop.Minimum != nil:
+				obj[name] = *prop.Minimum - 1
+			case prop.Maximum != nil:
+				obj[name] = *prop.Maximum + 1
+			default:
+				continue
+			}
+			return obj
+		}
+	}
+	return obj
+}
+
+// randomValue returns a random value conforming to the schema. Properties referring to other resources by id are set
+// to ids of resources created while fuzzing, when there are any.
+func (f *fuzzer) randomValue(schema *specSchema, depth int) any {
+	schema = f.spec.resolve(schema)
+	if len(schema.Enum) > 0 {
+		return schema.Enum[f.rnd.IntN(len(schema.Enum))]
+	}
+	switch schema.Type {
+	case "string":
+		return f.randomString(schema)
+	case "integer", "number":
+		lower, upper := -1000.0, 1000.0
+		if schema.Minimum != nil {
+			lower = *schema.Minimum
+		}
+		if schema.Maximum != nil {
+			upper = *schema.Maximum
+		}
+		if upper < lower {
+			upper = lower
+		}
+		value := lower + f.rnd.Float64()*(upper-lower)
+		if schema.Type == "integer" {
+			return int64(value)
+		}
+		return value
+	case "boolean":
+		return f.rnd.IntN(2) == 0
+	case "array":
+		items := []any{}
+		if depth > 5 || schema.Items == nil {
+			return items
+		}
+		for i := f.rnd.IntN(4); i > 0; i-- {
+			items = append(items, f.randomValue(schema.Items, depth+1))
+		}
+		return items
+	}
+	obj := make(map[string]any)
+	if depth > 5 {
+		return obj
+	}
+
+	required := make(map[string]bool)
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+	names := make(map[string]bool)
+	for collection := range f.ids {
+		names[strings.TrimPrefix(collection, "/")] = true
+	}
+	properties := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		properties = append(properties, name)
+	}
+	sort.Strings(properties)
+	for _, name := range properties {
+		prop := schema.Properties[name]
+		if f.spec.resolve(prop).ReadOnly || (!required[name] && f.rnd.IntN(2) == 0) {
+			continue
+		}
+		if ref, ok := referencedResource(name, names); ok {
+			ids := f.ids["/"+ref]
+			obj[name] = ids[f.rnd.IntN(len(ids))]
+			continue
+		}
+		obj[name] = f.randomValue(prop, depth+1)
+	}
+	return obj
+}
+
+func (f *fuzzer) randomString(schema *specSchema) string {
+	switch schema.Format {
+	case "uuid":
+		return f.randomUUID()
+	case "date-time":
+		return time.Unix(f.rnd.Int64N(4102444800), 0).UTC().Format(time.RFC3339)
+	case "date":
+		return time.Unix(f.rnd.Int64N(4102444800), 0).UTC().Format(time.DateOnly)
+	case "email":
+		return "user" + strconv.Itoa(f.rnd.IntN(100000)) + "@example.com"
+	case "uri", "url":
+		return "https://example.com/" + strconv.Itoa(f.rnd.IntN(100000))
+	}
+
+	minLength, maxLength := 0, 32
+	if schema.MinLength != nil {
+		minLength = *schema.MinLength
+	}
+	if schema.MaxLength != nil {
+		maxLength = *schema.MaxLength
+	}
+	if maxLength < minLength {
+		maxLength = minLength
+	}
+	chars := []rune(fuzzChars)
+	value := make([]rune, minLength+f.rnd.IntN(maxLength-minLength+1))
+	for i := range value {
+		value[i] = chars[f.rnd.IntN(len(chars))]
+	}
+	return string(value)
+}
+
+// randomUUID returns a random version 4 UUID, drawn from rnd like the other values.
+func (f *fuzzer) randomUUID() string {
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], f.rnd.Uint64())
+	binary.LittleEndian.PutUint64(b[8:], f.rnd.Uint64())
+	id, err := uuid.NewRandomFromReader(bytes.NewReader(b[:]))
+	if err != nil {
+		return uuid.NewString()
+	}
+	return id.String()
+}
+
+// declares reports whether the status is one of the responses of the operation.
+func (op *specOperation) declares(status int) bool {
+	if len(op.Responses) == 0 {
+		return true
+	}
+	code := strconv.Itoa(status)
+	for _, key := range []string{code, code[:1] + "XX", code[:1] + "xx", "default"} {
+		if _, ok := op.Responses[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
@@ -0,0 +1,159 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// commitSubjects are the subjects of commits of the files generated by tools. Commits of other tools, e.g. fixing the
+// code while verifying it, are named after their step.
+var commitSubjects = map[string]string{
+	GenerateOpenAPISpecToolName:     "Add OpenAPI spec",
+	GenerateGraphQLSchemaToolName:   "Add GraphQL schema",
+	GenerateSchemaToolName:          "Add database migrations",
+	StoreSchemaToolName:             "Add database migrations",
+	GenerateHandlersCodeToolName:    "Generate API handlers",
+	GenerateServerCodeToolName:      "Implement server handlers",
+	GenerateResolversCodeToolName:   "Implement GraphQL resolvers",
+	GenerateFileStorageToolName:     "Add file storage",
+	GenerateCacheLayerToolName:      "Add Redis cache layer",
+	GenerateEventPublishingToolName: "Add event publishing",
+	GenerateIdempotencyToolName:     "Add Idempotency-Key handling",
+	GenerateTerraformToolName:       "Add Terraform of the database",
+	GenerateLiveUpdatesToolName:     "Add live updates",
+	CreateAPIVersionToolName:        "Create new API version",
+	GenerateERDToolName:             "Add entity-relationship diagram",
+	GenerateReadmeToolName:          "Add README",
+	WritePlanToolName:               "Add project plan",
+	ReconcileArtifactsToolName:      "Reconcile generated files",
+	RollbackStepToolName:            "Roll back step",
+}
+
+// gitMu serializes commits of tools running in parallel, as git doesn't allow concurrent changes of the index.
+var gitMu sync.Mutex
+
+// InitGit initializes a git repository in the project root, unless the project is in one already, so its steps can
+// be committed.
+func (s *Service) InitGit(ctx context.Context) error {
+	root := os.Getenv("PROJECT_ROOT")
+	if _, err := runGit(ctx, root, "rev-parse", "--is-inside-work-tree"); err == nil {
+		return nil
+	}
+	_, err := runGit(ctx, root, "init", "--quiet")
+	return err
+}
+
+// CommitStep commits the files the tool generated, changed, or removed since the snapshot taken before it ran, with the
+// lock file, when GitCommits is set, so steps can be diffed and reverted with git. Other changes of the project, staged
+// or not, aren't committed.
+func (s *Service) CommitStep(ctx context.Context, step, tool string, before Snapshot) error {
+	if !s.GitCommits {
+		return nil
+	}
+	changed, removed := TakeSnapshot().changed(before)
+	var added, deleted, listed []string
+	for _, key := range changed {
+		if key.kind == artifactFile {
+			added = append(added, key.name)
+			listed = append(listed, key.name)
+		}
+	}
+	for _, key := range removed {
+		if key.kind == artifactFile {
+			deleted = append(deleted, key.name)
+			listed = append(listed, key.name+" (removed)")
+		}
+	}
+	if len(listed) == 0 {
+		return nil
+	}
+	return commitFiles(ctx, os.Getenv("PROJECT_ROOT"), added, deleted, commitMessage(step, tool, listed))
+}
+
+// commitFiles commits the added or changed files and the removed files, relative to the repository root, with the lock
+// file. Other changes of the repository aren't committed. There is nothing to commit when the files are unchanged.
+func commitFiles(ctx context.Context, root string, added, removed []string, message string) error {
+	gitMu.Lock()
+	defer gitMu.Unlock()
+
+	var paths []string
+	for _, name := range added {
+		if _, err := os.Stat(filepath.Join(root, name)); err == nil && filepath.IsLocal(name) {
+			paths = append(paths, name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, manifestFile)); err == nil {
+		paths = append(paths, manifestFile)
+	}
+	if len(paths) > 0 {
+		if _, err := runGit(ctx, root, append([]string{"add", "--"}, paths...)...); err != nil {
+			return err
+		}
+	}
+	for _, name := range removed {
+		if !filepath.IsLocal(name) {
+			continue
+		}
+		// Files which were never committed have nothing to remove.
+		if tracked, err := runGit(ctx, root, "ls-files", "--", name); err != nil || tracked == "" {
+			continue
+		}
+		if _, err := runGit(ctx, root, "rm", "--cached", "--quiet", "--", name); err != nil {
+			return err
+		}
+		paths = append(paths, name)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	// Regenerated files may be unchanged since they were committed.
+	if status, err := runGit(ctx, root, append([]string{"status", "--porcelain", "--"}, paths...)...); err != nil || status == "" {
+		return err
+	}
+
+	args := []string{"commit", "--quiet", "-m", message, "--"}
+	// Commits of projects without a git identity are made by DoubleTab, rather than failing.
+	if email, _ := runGit(ctx, root, "config", "user.email"); email == "" {
+		args = append([]string{"-c", "user.name=DoubleTab", "-c", "user.email=doubletab@localhost"}, args...)
+	}
+	_, err := runGit(ctx, root, append(args, paths...)...)
+	return err
+}
+
+// commitMessage describes the commit of the files of the tool run in the step.
+func commitMessage(step, tool string, files []string) string {
+	subject, ok := commitSubjects[tool]
+	switch {
+	case ok:
+	case step != "":
+		subject = fmt.Sprintf("Update code in step %s", step)
+	default:
+		subject = fmt.Sprintf("Update code with %s", tool)
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n\n", subject)
+	if step != "" {
+		fmt.Fprintf(&sb, "Step: %s\n", step)
+	}
+	fmt.Fprintf(&sb, "Tool: %s\n\nFiles:\n", tool)
+	for _, f := range files {
+		fmt.Fprintf(&sb, "- %s\n", f)
+	}
+	return sb.String()
+}
+
+// runGit runs git with the arguments in the directory, returning its trimmed output.
+func runGit(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w\n%s", args[0], err, output)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
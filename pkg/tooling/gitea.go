@@ -0,0 +1,61 @@
+package tooling
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// giteaForge opens pull requests of the repository, as owner/name, via the Gitea API, e.g. of Codeberg or Forgejo.
+type giteaForge struct {
+	apiURL string
+	token  string
+	repo   string
+}
+
+func (f *giteaForge) defaultBranch(ctx context.Context) (string, error) {
+	var r struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := f.request(ctx, http.MethodGet, "/repos/"+f.repo, nil, &r); err != nil {
+		return "", err
+	}
+	return r.DefaultBranch, nil
+}
+
+func (f *giteaForge) openPullRequest(ctx context.Context, pr pullRequest) (string, error) {
+	body := map[string]string{"title": pr.Title, "head": pr.Head, "base": pr.Base, "body": pr.Body}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err := f.request(ctx, http.MethodPost, "/repos/"+f.repo+"/pulls", body, &created)
+	if err == nil {
+		return created.HTMLURL, nil
+	}
+	// The pull request of the branch may be open already, the push updated it. Open pull requests can't be listed by
+	// their head branch, so they're searched.
+	var open []struct {
+		HTMLURL string `json:"html_url"`
+		Head    struct {
+			Ref string `json:"ref"`
+		} `json:"head"`
+	}
+	query := url.Values{"state": {"open"}, "limit": {"50"}}
+	if listErr := f.request(ctx, http.MethodGet, "/repos/"+f.repo+"/pulls?"+query.Encode(), nil, &open); listErr != nil {
+		return "", err
+	}
+	for _, p := range open {
+		if p.Head.Ref == pr.Head {
+			return p.HTMLURL, nil
+		}
+	}
+	return "", err
+}
+
+func (f *giteaForge) request(ctx context.Context, method, endpoint string, body, result any) error {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	header.Set("Authorization", "token "+f.token)
+	return apiRequest(ctx, method, strings.TrimSuffix(f.apiURL, "/")+endpoint, header, body, result)
+}
@@ -0,0 +1,54 @@
+package tooling
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// githubForge opens pull requests of the repository, as owner/name, via the GitHub API.
+type githubForge struct {
+	apiURL string
+	token  string
+	repo   string
+}
+
+func (f *githubForge) defaultBranch(ctx context.Context) (string, error) {
+	var r struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := f.request(ctx, http.MethodGet, "/repos/"+f.repo, nil, &r); err != nil {
+		return "", err
+	}
+	return r.DefaultBranch, nil
+}
+
+func (f *githubForge) openPullRequest(ctx context.Context, pr pullRequest) (string, error) {
+	body := map[string]string{"title": pr.Title, "head": pr.Head, "base": pr.Base, "body": pr.Body}
+	var created struct {
+		HTMLURL string `json:"html_url"`
+	}
+	err := f.request(ctx, http.MethodPost, "/repos/"+f.repo+"/pulls", body, &created)
+	if err == nil {
+		return created.HTMLURL, nil
+	}
+	// The pull request of the branch may be open already, the push updated it.
+	owner, _, _ := strings.Cut(f.repo, "/")
+	var open []struct {
+		HTMLURL string `json:"html_url"`
+	}
+	query := url.Values{"head": {owner + ":" + pr.Head}, "state": {"open"}}
+	if listErr := f.request(ctx, http.MethodGet, "/repos/"+f.repo+"/pulls?"+query.Encode(), nil, &open); listErr != nil || len(open) == 0 {
+		return "", err
+	}
+	return open[0].HTMLURL, nil
+}
+
+func (f *githubForge) request(ctx context.Context, method, endpoint string, body, result any) error {
+	header := http.Header{}
+	header.Set("Accept", "application/vnd.github+json")
+	header.Set("Authorization", "Bearer "+f.token)
+	header.Set("X-GitHub-Api-Version", "2022-11-28")
+	return apiRequest(ctx, method, strings.TrimSuffix(f.apiURL, "/")+endpoint, header, body, result)
+}
@@ -0,0 +1,58 @@
+package tooling
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitlabForge opens merge requests of the project, by its path with namespace, via the GitLab API.
+type gitlabForge struct {
+	apiURL  string
+	token   string
+	project string
+}
+
+func (f *gitlabForge) defaultBranch(ctx context.Context) (string, error) {
+	var p struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := f.request(ctx, http.MethodGet, "", nil, &p); err != nil {
+		return "", err
+	}
+	return p.DefaultBranch, nil
+}
+
+func (f *gitlabForge) openPullRequest(ctx context.Context, pr pullRequest) (string, error) {
+	body := map[string]string{
+		"title":         pr.Title,
+		"source_branch": pr.Head,
+		"target_branch": pr.Base,
+		"description":   pr.Body,
+	}
+	var created struct {
+		WebURL string `json:"web_url"`
+	}
+	err := f.request(ctx, http.MethodPost, "/merge_requests", body, &created)
+	if err == nil {
+		return created.WebURL, nil
+	}
+	// The merge request of the branch may be open already, the push updated it.
+	var open []struct {
+		WebURL string `json:"web_url"`
+	}
+	query := url.Values{"source_branch": {pr.Head}, "state": {"opened"}}
+	if listErr := f.request(ctx, http.MethodGet, "/merge_requests?"+query.Encode(), nil, &open); listErr != nil || len(open) == 0 {
+		return "", err
+	}
+	return open[0].WebURL, nil
+}
+
+// request sends the request to the endpoint of the project.
+func (f *gitlabForge) request(ctx context.Context, method, endpoint string, body, result any) error {
+	header := http.Header{}
+	header.Set("PRIVATE-TOKEN", f.token)
+	project := "/projects/" + url.PathEscape(f.project)
+	return apiRequest(ctx, method, strings.TrimSuffix(f.apiURL, "/")+project+endpoint, header, body, result)
+}
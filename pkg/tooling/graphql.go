@@ -0,0 +1,250 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/vector"
+)
+
+const (
+	generateGraphQLSchemaPrompt = `You are an AI assistant that generates GraphQL schemas (SDL) for gqlgen.
+
+First, query memory for any relevant information. Then, based on the memory and user input, generate a GraphQL schema
+in SDL format. The schema should follow a typical CRUD structure for every entity:
+
+- Query.resources: List all resources.
+- Query.resource(id: ID!): Get a resource by ID.
+- Mutation.createResource(input: ResourceInput!): Create a new resource.
+- Mutation.updateResource(id: ID!, input: ResourceInput!): Update a resource.
+- Mutation.deleteResource(id: ID!): Delete a resource, returning Boolean!.
+
+The schema should:
+- Use singular type names and plural list query names.
+- All IDs should be UUIDs exposed as the ID scalar.
+- Use input types for mutations, shared by create and update.
+- Mark required fields as non-null.
+- Output only the SDL, without any comments or explanations.
+
+When the user asks for bulk operations or high-volume ingestion of a resource, also add
+Mutation.upsertResources(inputs: [ResourceBatchInput!]!, atomic: Boolean): [BatchResult!]!, where ResourceBatchInput
+has an optional id (items with an ID are updated, the others are created) and BatchResult has index, id, and error
+fields.
+`
+	generateResolversCodePrompt = `You are an AI assistant that implements gqlgen resolvers in Go based on previously generated GraphQL schema.
+
+gqlgen already generated the models and resolver stubs. Your workflow is as follows:
+
+1. Check the knowledge base for best practices and sample resolver code.
+2. Query the memory for generated models and resolver stubs to see what structs and methods are there.
+3. Implement every resolver method strictly following sample code from the knowledge base.
+4. Save the code to the schema.resolvers.go file in the graph package.
+5. Build the code. If it fails, address the build errors and re-generate the resolvers code. When modules of
+   imported packages are missing, add them with the manage_deps tool.
+6. Run the tests. If any fail, address the failures and re-generate the resolvers code.
+7. Vet the code. If there are any issues, address them and re-generate the resolvers code.
+8. Lint the code. If there are blocking findings, address them and re-generate the resolvers code.
+9. Scan the code for security issues. If there are blocking findings, address them and re-generate the resolvers code.
+
+Important notes:
+- Don't create any new types for resources, use the ones generated by gqlgen. Stick to the sample code provided by the
+  knowledge base.
+- Keep the method signatures exactly as generated by gqlgen.
+- Don't ask the user for any additional information, use the GraphQL schema and generated code as the source of truth.
+- When fixing a few functions of the saved code, replace them with the edit_function tool instead of saving the whole
+  file again.
+- For other incremental fixes, e.g. a few lines across functions or files, write a unified diff against the saved
+  files (paths relative to the project root, e.g. --- a/pkg/api/server.go) and apply it with the apply_patch tool. If
+  hunks conflict, read the conflicts, fix the diff, and apply it again.
+` + sqlGuidelinesPrompt + protectedRegionsPrompt
+)
+
+const GenerateGraphQLSchemaToolName = "generate_graphql_schema"
+
+func (s *Service) GenerateGraphQLSchemaTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateGraphQLSchemaToolName),
+			Description: openai.String("Generates a GraphQL schema (SDL) based on user input about entities and fields."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"user_input": map[string]string{
+						"type": "string",
+					},
+				},
+				"required": []string{"user_input"},
+			}),
+		}),
+	}
+}
+
+const GenerateResolversCodeToolName = "generate_resolvers_code"
+
+func (s *Service) GenerateResolversCodeTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateResolversCodeToolName),
+			Description: openai.String("Runs gqlgen and implements Go resolvers based on previously generated GraphQL schema."),
+		}),
+	}
+}
+
+const SaveResolversCodeToolName = "save_resolvers_code"
+
+func (s *Service) SaveResolversCodeTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(SaveResolversCodeToolName),
+			Description: openai.String("Save implemented resolvers Go code to the schema.resolvers.go file in the graph package."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"resolvers_go_code": map[string]string{
+						"type": "string",
+					},
+				},
+				"required": []string{"resolvers_go_code"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) GenerateGraphQLSchema(ctx context.Context, multi *pterm.MultiPrinter, arguments string) string {
+	spinner := NewSpinner(multi, "Generating GraphQL schema...")
+	defer spinner.Success("GraphQL schema generated")
+
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	userInput, _ := args["user_input"].(string)
+	if userInput == "" {
+		return "Failed to generate GraphQL schema: missing user_input argument"
+	}
+	if s.BulkEndpoints {
+		userInput += "\n\nInclude batch mutations for every resource."
+	}
+	if s.MultiTenant {
+		userInput += "\n\n" + tenantFieldNote
+	}
+
+	log.Debug().Msgf("Creating GraphQL schema for question: %s", userInput)
+	agent := s.Agent(generateGraphQLSchemaPrompt, userInput).
+		WithTools(s.QueryMemoryTool()).
+		WithModel(s.ChatModel)
+
+	schema := agent.Run(ctx)
+
+	if err := s.createBoilerPlate(ctx); err != nil {
+		return fmt.Sprintf("Failed to create boilerplate: %v", err)
+	}
+
+	graphDir := path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "graph")
+	schema = TrimNonCode(schema, "graphql")
+
+	if err := s.replaceFile(path.Join(graphDir, "schema.graphqls"), schema); err != nil {
+		return fmt.Sprintf("Failed to write GraphQL schema file: %v", err)
+	}
+
+	return schema
+}
+
+func (s *Service) GenerateResolversCode(ctx context.Context, multi *pterm.MultiPrinter) string {
+	spinner := NewSpinner(multi, "Generating resolvers...")
+	defer spinner.Success("Resolvers generated")
+
+	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return fmt.Sprintf("Failed to get absolute path of project root: %v", err)
+	}
+	graphDir := filepath.Join(absRoot, "pkg", "graph")
+	generatedFiles := []string{"generated.go", "models_gen.go", "schema.resolvers.go"}
+
+	// gqlgen only runs when the schema or its configuration changed since it generated the files last. The resolvers are
+	// implemented after gqlgen generated them, so only the files gqlgen owns are checked.
+	input := inputHash(filepath.Join(graphDir, "gqlgen.yml"), filepath.Join(graphDir, "schema.graphqls"))
+	regenerate := slices.ContainsFunc(generatedFiles[:2], func(name string) bool {
+		return !upToDate(filepath.Join(graphDir, name), input)
+	})
+	if regenerate {
+		cmd := exec.CommandContext(ctx, "go", "generate", "./...")
+		cmd.Dir = absRoot
+
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Sprintf("go generate failed: %v\n%s", err, output)
+		}
+	}
+
+	for _, name := range generatedFiles {
+		code, err := s.headGeneratedFile(filepath.Join(graphDir, name))
+		if err != nil {
+			return fmt.Sprintf("Failed to read generated file (%s): %v", name, err)
+		}
+		if regenerate {
+			if err := trackGenerated(filepath.Join(graphDir, name), code, input); err != nil {
+				log.Err(err).Msgf("Failed to track generated %s", name)
+			}
+		}
+		if name == "generated.go" {
+			continue
+		}
+		if err := s.Mem.Store(ctx, vector.RoleTool, code); err != nil {
+			log.Err(err).Msgf("Failed to store generated %s in memory", name)
+		}
+	}
+
+	schema, err := os.ReadFile(filepath.Join(graphDir, "schema.graphqls"))
+	if err != nil {
+		return fmt.Sprintf("Failed to read GraphQL schema file: %v", err)
+	}
+
+	prompt, tools := s.withLayers(generateResolversCodePrompt, "Resolver",
+		[]openai.ChatCompletionToolParam{s.QueryKnowledgeBaseTool(), s.QueryMemoryTool(), s.SaveResolversCodeTool(),
+			s.EditFunctionTool(), s.ApplyPatchTool(), s.ManageDepsTool(), s.BuildCodeTool(), s.RunTestsTool(), s.VetCodeTool(),
+			s.LintCodeTool(), s.SecurityScanTool()})
+	agent := s.Agent(prompt, string(schema)).
+		WithTools(tools...).
+		WithModel(s.CodeModel)
+
+	return s.runWithBuildFix(ctx, agent)
+}
+
+func (s *Service) SaveResolversCode(_ context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	code, _ := args["resolvers_go_code"].(string)
+	if code == "" {
+		return "Failed to save resolvers code: missing resolvers_go_code argument"
+	}
+	name := path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "graph", "schema.resolvers.go")
+	code, err := formatGo(name, TrimNonCode(code, "go"))
+	if err == nil {
+		err = s.checkSQL(code)
+	}
+	if err != nil {
+		return fmt.Sprintf("Resolvers code rejected, fix the following issues and save it again:\n%v", err)
+	}
+
+	if err := s.writeFile(name, code); err != nil {
+		return fmt.Sprintf("Failed to save schema.resolvers.go file: %v", err)
+	}
+
+	return "Resolvers code saved successfully"
+}
@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 
@@ -25,13 +24,23 @@ Implement ServerInterface generated by oapi-codegen. Your workflow is as follows
 2. Query the memory for generated handlers code to see what structs and what interfaces are there.
 3. Implement the ServerInterface methods strictly following sample code from the knowledge base.
 4. Save the code to the server.go file in the api package.
-5. Build the server code. If it fails, address the build errors and re-generate the server code.
+5. Build the server code. If it fails, address the build errors and re-generate the server code. When modules of
+   imported packages are missing, add them with the manage_deps tool.
+6. Run the tests. If any fail, address the failures and re-generate the server code.
+7. Vet the code. If there are any issues, address them and re-generate the server code.
+8. Lint the code. If there are blocking findings, address them and re-generate the server code.
+9. Scan the code for security issues. If there are blocking findings, address them and re-generate the server code.
 
 Important notes:
 - Don't create any new types for resources, use the ones provided by the generated handlers code. Stick to the sample
   code provided by the knowledge base.
 - Don't ask the user for any additional information, use the OpenAPI spec and generated handlers code spec as the source of truth.
-`
+- When fixing a few functions of the saved code, replace them with the edit_function tool instead of saving the whole
+  file again.
+- For other incremental fixes, e.g. a few lines across functions or files, write a unified diff against the saved
+  files (paths relative to the project root, e.g. --- a/pkg/api/server.go) and apply it with the apply_patch tool. If
+  hunks conflict, read the conflicts, fix the diff, and apply it again.
+` + sqlGuidelinesPrompt + protectedRegionsPrompt
 )
 
 const GenerateHandlersCodeToolName = "generate_handlers_code"
@@ -60,6 +69,10 @@ func (s *Service) GenerateServerCodeTool() openai.ChatCompletionToolParam {
 					"openapi_spec": map[string]string{
 						"type": "string",
 					},
+					"entity": map[string]string{
+						"type":        "string",
+						"description": "Name of the only entity to implement the server methods for, when the project is built entity by entity.",
+					},
 				},
 				"required": []string{"openapi_spec"},
 			}),
@@ -81,6 +94,10 @@ func (s *Service) SaveServerCodeTool() openai.ChatCompletionToolParam {
 					"server_go_code": map[string]string{
 						"type": "string",
 					},
+					"entity": map[string]string{
+						"type":        "string",
+						"description": "Name of the entity whose server methods the code implements, saved to their own file instead of server.go.",
+					},
 				},
 				"required": []string{"server_go_code"},
 			}),
@@ -96,17 +113,17 @@ func (s *Service) GenerateHandlersCode(ctx context.Context, multi *pterm.MultiPr
 	if err != nil {
 		return fmt.Sprintf("Failed to get absolute path of project root: %v", err)
 	}
-	cmd := exec.CommandContext(ctx, "go", "generate", "./...")
-	cmd.Dir = absRoot
-
-	output, err := cmd.CombinedOutput()
+	handlersFile, regenerated, err := s.runOAPICodegen(ctx, absRoot)
 	if err != nil {
-		return fmt.Sprintf("go generate failed: %v\n%s", err, output)
+		return fmt.Sprintf("Failed to generate handlers: %v", err)
+	}
+	if !regenerated {
+		return "Handlers code is up to date, the spec didn't change since it was generated"
 	}
 
-	handlersGo, err := os.ReadFile(filepath.Join(absRoot, "pkg", "api", "handlers.gen.go"))
+	handlersGo, err := os.ReadFile(handlersFile)
 	if err != nil {
-		return fmt.Sprintf("Failed to read generated handlers file (handlers.gen.go): %v", err)
+		return fmt.Sprintf("Failed to read generated handlers file (%s): %v", filepath.Base(handlersFile), err)
 	}
 
 	if err := s.Mem.Store(ctx, vector.RoleTool, string(handlersGo)); err != nil {
@@ -125,35 +142,71 @@ func (s *Service) GenerateServerCode(ctx context.Context, multi *pterm.MultiPrin
 		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
 	}
 	openApiSpec := args["openapi_spec"].(string)
+	entity, _ := args["entity"].(string)
+	// Code generated for a spec which doesn't match the tables fails at runtime, so the drift is fixed first.
+	if drift := s.checkConsistency(ctx, entity); drift != "" {
+		return drift
+	}
 
 	log.Debug().Msgf("Creating server code for OpenAPI spec: %s", openApiSpec)
 
-	agent := s.Agent(generateServerCodePrompt, openApiSpec).
-		WithTools(s.QueryKnowledgeBaseTool(), s.QueryMemoryTool(), s.SaveServerCodeTool(), s.BuildCodeTool()).
+	prompt := generateServerCodePrompt
+	if s.FileUploads {
+		prompt += fileUploadsPrompt
+	}
+	extension, err := analysisPrompt()
+	if err != nil {
+		return fmt.Sprintf("Failed to load analysis of the project: %v", err)
+	}
+	prompt += extension
+	userInput := openApiSpec
+	if entity != "" {
+		prompt += entityServerPrompt
+		shared, err := s.sharedServerFiles()
+		if err != nil {
+			return fmt.Sprintf("Failed to read files shared by the resources: %v", err)
+		}
+		userInput += fmt.Sprintf("\n\nImplement the server methods of the %s resource.%s", entity, shared)
+	}
+	prompt, tools := s.withLayers(prompt, "Server",
+		[]openai.ChatCompletionToolParam{s.QueryKnowledgeBaseTool(), s.QueryMemoryTool(), s.SaveServerCodeTool(),
+			s.EditFunctionTool(), s.ApplyPatchTool(), s.ManageDepsTool(), s.BuildCodeTool(), s.RunTestsTool(), s.VetCodeTool(),
+			s.LintCodeTool(), s.SecurityScanTool()})
+	agent := s.Agent(prompt, userInput).
+		WithTools(tools...).
 		WithModel(s.CodeModel)
 
-	return agent.Run(ctx)
+	return s.runWithBuildFix(ctx, agent)
 }
 
 func (s *Service) SaveServerCode(_ context.Context, arguments string) string {
-	apiDir := path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "api")
-	fh, err := os.Create(path.Join(apiDir, "server.go"))
-	if err != nil {
-		return fmt.Sprintf("Failed to create server.go file: %v", err)
-	}
-	defer fh.Close()
-
 	var args map[string]interface{}
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
 		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
 	}
-	code := args["server_go_code"].(string)
-	code = TrimNonCode(code, "go")
-
-	_, err = fh.WriteString(code)
+	file := "server.go"
+	if entity, _ := args["entity"].(string); entity != "" {
+		var err error
+		if file, err = entityServerFile(entity); err != nil {
+			return fmt.Sprintf("Invalid entity: %v", err)
+		}
+	}
+	code, _ := args["server_go_code"].(string)
+	if code == "" {
+		return "Failed to save server code: missing server_go_code argument"
+	}
+	name := path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "api", file)
+	code, err := formatGo(name, TrimNonCode(code, "go"))
+	if err == nil {
+		err = s.checkSQL(code)
+	}
 	if err != nil {
-		return fmt.Sprintf("Failed to write server.go file: %v", err)
+		return fmt.Sprintf("Server code rejected, fix the following issues and save it again:\n%v", err)
+	}
+
+	if err := s.writeFile(name, code); err != nil {
+		return fmt.Sprintf("Failed to save %s file: %v", file, err)
 	}
 
-	return "Server code saved successfully"
+	return fmt.Sprintf("Server code saved successfully to %s", file)
 }
@@ -0,0 +1,174 @@
+package tooling
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// generatedMarker starts the line marking files as generated, followed by the session which generated them. Files
+// without it are hand-written, or were taken over by removing it. It doesn't say "DO NOT EDIT", as linters skip such
+// files, and manual edits are merged into regenerated files anyway.
+const generatedMarker = "Generated by DoubleTab, session "
+
+// The header of generated files is set once from the config, as it's added and stripped by plain functions as well.
+var (
+	// headerLines are the lines of the configured header text, e.g. a license, without comment syntax.
+	headerLines []string
+	// headerMarker adds the generated marker to the header.
+	headerMarker bool
+	// headerSession returns the current session, which changes when the session is branched.
+	headerSession func() string
+)
+
+// commentSyntaxes are the line comment delimiters of the files headers are added to, by extension. Files of other
+// types, like JSON, have no comments and get no header.
+var commentSyntaxes = map[string][2]string{
+	".go":        {"// ", ""},
+	".proto":     {"// ", ""},
+	".js":        {"// ", ""},
+	".ts":        {"// ", ""},
+	".sql":       {"-- ", ""},
+	".yaml":      {"# ", ""},
+	".yml":       {"# ", ""},
+	".graphql":   {"# ", ""},
+	".graphqls":  {"# ", ""},
+	".toml":      {"# ", ""},
+	".sh":        {"# ", ""},
+	".tf":        {"# ", ""},
+	".tfvars":    {"# ", ""},
+	".mmd":       {"%% ", ""},
+	".md":        {"<!-- ", " -->"},
+	".html":      {"<!-- ", " -->"},
+	"Dockerfile": {"# ", ""},
+	"Makefile":   {"# ", ""},
+	".gitignore": {"# ", ""},
+	".env":       {"# ", ""},
+}
+
+// setFileHeader sets the header added to generated files: the text of the header file, when set, and the generated
+// marker with the session, when marker is set.
+func setFileHeader(headerFile string, marker bool, session func() string) error {
+	headerLines, headerMarker, headerSession = nil, marker, session
+	if headerFile == "" {
+		return nil
+	}
+	text, err := os.ReadFile(headerFile)
+	if err != nil {
+		return fmt.Errorf("failed to read file header: %w", err)
+	}
+	if text := strings.TrimRight(strings.ReplaceAll(string(text), "\r\n", "\n"), "\n"); text != "" {
+		headerLines = strings.Split(text, "\n")
+	}
+	return nil
+}
+
+// commentSyntax returns the line comment delimiters of the file, or false when it has none.
+func commentSyntax(name string) (string, string, bool) {
+	base := path.Base(name)
+	syntax, ok := commentSyntaxes[base]
+	if !ok {
+		syntax, ok = commentSyntaxes[path.Ext(base)]
+	}
+	return syntax[0], syntax[1], ok
+}
+
+// fileHeader returns the header of the file with the session in the generated marker, followed by a blank line, so Go
+// doesn't take it for the package doc. It's empty when no header is configured or the file has no comments.
+func fileHeader(name, session string) string {
+	start, end, ok := commentSyntax(name)
+	if !ok || (len(headerLines) == 0 && !headerMarker) {
+		return ""
+	}
+	header := fileHeaderText(name)
+	if headerMarker {
+		header += start + generatedMarker + session + end + "\n"
+	}
+	return header + "\n"
+}
+
+// markerRegexp returns the regexp matching the generated marker line of the file, with the session as submatch.
+func markerRegexp(name string) *regexp.Regexp {
+	start, end, _ := commentSyntax(name)
+	return regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(start+generatedMarker) + `(\S+)` + regexp.QuoteMeta(end) + `$`)
+}
+
+// addFileHeader returns the content with the header of the file, replacing the header it has already, e.g. when the
+// model rewrites a file it read. Shebang lines stay first. The session of the current file is kept when the file is
+// unchanged otherwise, so regenerating it in another session doesn't change it just for the session.
+func addFileHeader(name, content string) string {
+	if fileHeader(name, "") == "" {
+		return content
+	}
+	var shebang string
+	if strings.HasPrefix(content, "#!") {
+		line, rest, _ := strings.Cut(content, "\n")
+		shebang, content = line+"\n", rest
+	}
+	content = stripFileHeader(name, content)
+
+	if current, err := os.ReadFile(name); err == nil {
+		if m := markerRegexp(name).FindStringSubmatch(string(current)); m != nil {
+			if kept := shebang + fileHeader(name, m[1]) + content; unchangedFile(name, kept) {
+				return kept
+			}
+		}
+	}
+	var session string
+	if headerSession != nil {
+		session = headerSession()
+	}
+	return shebang + fileHeader(name, session) + content
+}
+
+// headGeneratedFile adds the configured file header to the file written by a code generator, like oapi-codegen, and
+// returns its content. The generated content is written through EditFile when it's set, also when it has no header to
+// add, so open documents of an editor show it and the regeneration can be undone there.
+func (s *Service) headGeneratedFile(name string) (string, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	headed := addFileHeader(name, string(content))
+	if headed == string(content) && s.EditFile == nil {
+		return headed, nil
+	}
+	if err := s.saveFile(name, headed); err != nil {
+		return "", err
+	}
+	return headed, nil
+}
+
+// stripFileHeader returns the content without the configured header text and the generated marker at its start.
+func stripFileHeader(name, content string) string {
+	stripped := false
+	if text := fileHeaderText(name); text != "" && strings.HasPrefix(content, text) {
+		content, stripped = content[len(text):], true
+	}
+	if line, rest, _ := strings.Cut(content, "\n"); markerRegexp(name).MatchString(line) {
+		content, stripped = rest, true
+	}
+	// The blank line separating the header from the content goes with it.
+	if stripped {
+		content = strings.TrimPrefix(content, "\n")
+	}
+	return content
+}
+
+// fileHeaderText returns the commented lines of the configured header text of the file, without the marker.
+func fileHeaderText(name string) string {
+	start, end, _ := commentSyntax(name)
+	var sb strings.Builder
+	for _, line := range headerLines {
+		sb.WriteString(strings.TrimRight(start+line, " ") + end + "\n")
+	}
+	return sb.String()
+}
+
+// generatedFile reports whether the content of the file has the generated marker. It's only meaningful when markers
+// are added, as files generated without them look hand-written.
+func generatedFile(name, content string) bool {
+	return markerRegexp(name).MatchString(content)
+}
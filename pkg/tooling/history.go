@@ -0,0 +1,95 @@
+package tooling
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/doubletabai/doubletab/pkg/vector"
+)
+
+// artifactKey identifies a tracked artifact.
+type artifactKey struct {
+	kind string
+	name string
+}
+
+// Snapshot is the state of the tracked artifacts at a point in time, by the hashes of files and the DDL of tables, so
+// the artifacts a tool generated, changed, or removed can be found.
+type Snapshot map[artifactKey]string
+
+// TakeSnapshot returns the current state of the tracked artifacts. It's empty when the lock file can't be read.
+func TakeSnapshot() Snapshot {
+	snapshot := make(Snapshot)
+	tracked, err := loadManifest()
+	if err != nil {
+		return snapshot
+	}
+	for _, a := range tracked {
+		if a.Kind == artifactTable {
+			snapshot[artifactKey{a.Kind, a.Name}] = a.DDL
+		} else {
+			snapshot[artifactKey{a.Kind, a.Name}] = a.Hash
+		}
+	}
+	return snapshot
+}
+
+// changed returns the artifacts which are new or changed in the snapshot since the earlier one, and the ones removed
+// since, sorted by kind and name.
+func (s Snapshot) changed(earlier Snapshot) (changed, removed []artifactKey) {
+	for key, version := range s {
+		if v, ok := earlier[key]; !ok || v != version {
+			changed = append(changed, key)
+		}
+	}
+	for key := range earlier {
+		if _, ok := s[key]; !ok {
+			removed = append(removed, key)
+		}
+	}
+	compare := func(a, b artifactKey) int {
+		return cmp.Or(cmp.Compare(a.kind, b.kind), cmp.Compare(a.name, b.name))
+	}
+	slices.SortFunc(changed, compare)
+	slices.SortFunc(removed, compare)
+	return changed, removed
+}
+
+// RecordHistory appends the tool run to the generation history of the project, with the artifacts it generated,
+// changed, or removed since the snapshot taken before it ran. Runs of tools of no step which generated nothing, like
+// queries of the knowledge base, aren't recorded.
+func (s *Service) RecordHistory(ctx context.Context, step, tool string, before Snapshot) error {
+	if s.History == nil {
+		return nil
+	}
+	changed, removed := TakeSnapshot().changed(before)
+	if step == "" && len(changed) == 0 && len(removed) == 0 {
+		return nil
+	}
+	var artifacts []string
+	for _, key := range changed {
+		artifacts = append(artifacts, historyName(key))
+	}
+	for _, key := range removed {
+		artifacts = append(artifacts, historyName(key)+" (removed)")
+	}
+	return s.History.Append(ctx, vector.Entry{
+		SessionID: s.Mem.SessionID,
+		Step:      step,
+		Tool:      tool,
+		Artifacts: artifacts,
+		ChatModel: s.ChatModel,
+		CodeModel: s.CodeModel,
+		CreatedAt: time.Now(),
+	})
+}
+
+// historyName names the artifact in the history: files by their paths and tables prefixed with their kind.
+func historyName(key artifactKey) string {
+	if key.kind == artifactTable {
+		return "table " + key.name
+	}
+	return key.name
+}
@@ -0,0 +1,104 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// hookEvents are the events hooks run after, by the tools completing them. Hooks of other events run after the tool
+// named by the event, e.g. post-generate_readme.
+var hookEvents = map[string][]string{
+	"post-save": {SaveServerCodeToolName, SaveRepositoryCodeToolName, SaveServiceCodeToolName, SaveResolversCodeToolName,
+		EditFunctionToolName, ApplyPatchToolName},
+	"post-spec":     {GenerateOpenAPISpecToolName, GenerateGraphQLSchemaToolName},
+	"post-schema":   {StoreSchemaToolName},
+	"post-handlers": {GenerateHandlersCodeToolName},
+	"post-server":   {GenerateServerCodeToolName, GenerateResolversCodeToolName},
+}
+
+// hook is a shell command run after the tools of its event succeeded.
+type hook struct {
+	event   string
+	command string
+}
+
+// parseHooks parses hooks given as event=command. Unknown events are rejected, like policies of unknown tools, as a
+// hook of a misspelled event would never run.
+func parseHooks(specs []string) ([]hook, error) {
+	var hooks []hook
+	for _, spec := range specs {
+		event, command, ok := strings.Cut(spec, "=")
+		event, command = strings.TrimSpace(event), strings.TrimSpace(command)
+		if !ok || command == "" || !strings.HasPrefix(event, "post-") || event == "post-" {
+			return nil, fmt.Errorf("invalid hook %q, it must be post-<event>=<command>", spec)
+		}
+		if _, ok := hookEvents[event]; !ok && !slices.Contains(toolNames, strings.TrimPrefix(event, "post-")) {
+			return nil, fmt.Errorf("hook of unknown event %s, it must be one of %s or post-<tool>", event,
+				strings.Join(slices.Sorted(maps.Keys(hookEvents)), ", "))
+		}
+		hooks = append(hooks, hook{event: event, command: command})
+	}
+	return hooks, nil
+}
+
+// toolHooks returns the hooks which run after the tool.
+func (s *Service) toolHooks(tool string) []hook {
+	var hooks []hook
+	for _, h := range s.Hooks {
+		if h.event == "post-"+tool || slices.Contains(hookEvents[h.event], tool) {
+			hooks = append(hooks, h)
+		}
+	}
+	return hooks
+}
+
+// runHooks runs the hooks of the tool in the project root, with the files the tool changed as their arguments, and
+// returns their outputs to be added to the response of the tool. Files changed by the hooks, e.g. by formatters, are
+// tracked with their new content, so they don't count as edited by hand.
+func (s *Service) runHooks(ctx context.Context, tool string, hooks []hook, before Snapshot) string {
+	var changed []string
+	keys, _ := TakeSnapshot().changed(before)
+	for _, key := range keys {
+		if key.kind == artifactFile {
+			changed = append(changed, key.name)
+		}
+	}
+
+	rootDir, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return fmt.Sprintf("\n\nFailed to run hooks: %v", err)
+	}
+	var sb strings.Builder
+	for _, h := range hooks {
+		// The files are passed as positional parameters, so the command gets them as its arguments.
+		cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", h.command + ` "$@"`, "sh"}, changed...)...)
+		cmd.Dir = rootDir
+		cmd.Env = append(os.Environ(), "DOUBLETAB_EVENT="+h.event, "DOUBLETAB_TOOL="+tool)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			fmt.Fprintf(&sb, "\n\nHook %s (%s) failed: %v", h.event, h.command, err)
+		} else {
+			fmt.Fprintf(&sb, "\n\nHook %s (%s) succeeded", h.event, h.command)
+		}
+		if out := strings.TrimSpace(string(output)); out != "" {
+			sb.WriteString(":\n" + out)
+		}
+	}
+
+	for _, name := range changed {
+		content, err := os.ReadFile(filepath.Join(rootDir, name))
+		if err != nil {
+			continue
+		}
+		if err := trackFile(filepath.Join(rootDir, name), string(content)); err != nil {
+			fmt.Fprintf(&sb, "\n\nFailed to track %s changed by hooks: %v", name, err)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,57 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/openai/openai-go"
+)
+
+const idempotencyTableSQL = `CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key TEXT PRIMARY KEY,
+	request_hash TEXT NOT NULL,
+	status INT,
+	content_type TEXT,
+	body BYTEA,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+const GenerateIdempotencyToolName = "generate_idempotency"
+
+func (s *Service) GenerateIdempotencyTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateIdempotencyToolName),
+			Description: openai.String("Generates Idempotency-Key handling (a dedup table and a middleware replaying stored responses) for POST endpoints, so retried create requests don't create duplicates. Use it when clients are payment-like or retry-prone."),
+		}),
+	}
+}
+
+func (s *Service) GenerateIdempotency(ctx context.Context) string {
+	if _, err := s.DB.ExecContext(ctx, idempotencyTableSQL); err != nil {
+		return fmt.Sprintf("Failed to create idempotency keys table: %v", err)
+	}
+	if err := s.writeMigrationOnce("idempotency_keys", idempotencyTableSQL); err != nil {
+		return fmt.Sprintf("Failed to save idempotency keys migration: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rootDir := os.Getenv("PROJECT_ROOT")
+	middleware, err := s.renderTemplate(idempotencyGo)
+	if err != nil {
+		return fmt.Sprintf("Failed to render idempotency middleware: %v", err)
+	}
+	if err := s.writeFile(path.Join(rootDir, "pkg", "middleware", "idempotency.go"), middleware); err != nil {
+		return fmt.Sprintf("Failed to save idempotency middleware: %v", err)
+	}
+	s.Idempotency = true
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return fmt.Sprintf("Failed to wire idempotency middleware into the server: %v", err)
+	}
+
+	return "Idempotency-Key handling generated for POST requests, handlers don't need any changes"
+}
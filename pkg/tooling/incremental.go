@@ -0,0 +1,288 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+// unchangedFile reports whether writing the content to the file can be skipped, as the file on disk has the content
+// already. Files changed since they were generated, e.g. by go get or by the user, are written, merging their changes
+// into the content.
+func unchangedFile(name, content string) bool {
+	current, err := os.ReadFile(name)
+	return err == nil && string(current) == content
+}
+
+// upToDate reports whether the file was generated from the inputs with the given hash and wasn't changed since, so it
+// doesn't have to be regenerated.
+func upToDate(name, input string) bool {
+	rel, ok := projectPath(name)
+	if !ok {
+		return false
+	}
+	a, found, err := trackedArtifact(artifactFile, rel)
+	if err != nil || !found || a.Input != input {
+		return false
+	}
+	current, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), rel))
+	return err == nil && contentHash(string(current)) == a.Hash
+}
+
+// inputHash returns the hash of the contents of the input files. Missing files count as empty.
+func inputHash(names ...string) string {
+	var sb strings.Builder
+	for _, name := range names {
+		content, _ := os.ReadFile(name)
+		fmt.Fprintf(&sb, "%s\n%d\n%s", filepath.Base(name), len(content), content)
+	}
+	return contentHash(sb.String())
+}
+
+// constraintTypes are the pg_constraint types of the column constraints altered in place, by their parsed type.
+var constraintTypes = map[pg_query.ConstrType]string{
+	pg_query.ConstrType_CONSTR_PRIMARY: "p",
+	pg_query.ConstrType_CONSTR_UNIQUE:  "u",
+	pg_query.ConstrType_CONSTR_FOREIGN: "f",
+	pg_query.ConstrType_CONSTR_CHECK:   "c",
+}
+
+// constraintNames are the names of the single-column constraints of a table, by column and pg_constraint type.
+type constraintNames map[string]map[string][]string
+
+// constraintNames returns the names of the single-column constraints of the table, which PostgreSQL named itself when
+// the table was created.
+func (s *Service) constraintNames(ctx context.Context, table string) (constraintNames, error) {
+	var rows []struct {
+		Column string `db:"attname"`
+		Type   string `db:"contype"`
+		Name   string `db:"conname"`
+	}
+	if err := s.DB.SelectContext(ctx, &rows, `SELECT a.attname, c.contype::text AS contype, c.conname
+FROM pg_constraint c JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+WHERE c.conrelid = to_regclass($1) AND cardinality(c.conkey) = 1 ORDER BY c.conname`, table); err != nil {
+		return nil, fmt.Errorf("failed to query constraints of %s: %w", table, err)
+	}
+	names := make(constraintNames)
+	for _, r := range rows {
+		if names[r.Column] == nil {
+			names[r.Column] = make(map[string][]string)
+		}
+		names[r.Column][r.Type] = append(names[r.Column][r.Type], r.Name)
+	}
+	return names, nil
+}
+
+// columnConstraints are the parsed constraints of a column definition, as written.
+type columnConstraints struct {
+	notNull bool
+	// defaultExpr is the expression of the default value, empty without one.
+	defaultExpr string
+	// byType are the constraints altered as table constraints, by their pg_constraint type.
+	byType map[string][]string
+	// other are constraints which can't be altered in place, like identities and generated columns.
+	other []string
+}
+
+// parseColumnConstraints parses the constraints of the column definition, including the checks of enums. It returns
+// false when the definition can't be parsed.
+func parseColumnConstraints(col Column) (columnConstraints, bool) {
+	parsed := columnConstraints{byType: make(map[string][]string)}
+	query := "CREATE TABLE t (" + col.definition() + ")"
+	result, err := pg_query.Parse(query)
+	if err != nil || len(result.Stmts) != 1 {
+		return parsed, false
+	}
+	create := result.Stmts[0].GetStmt().GetCreateStmt()
+	if create == nil || len(create.TableElts) != 1 || create.TableElts[0].GetColumnDef() == nil {
+		return parsed, false
+	}
+	nodes := create.TableElts[0].GetColumnDef().Constraints
+	for i, node := range nodes {
+		// The text of a constraint runs from its location to the next constraint, or to the end of the definition.
+		c := node.GetConstraint()
+		start, end := int(c.GetLocation()), len(query)-1
+		if i+1 < len(nodes) {
+			end = int(nodes[i+1].GetConstraint().GetLocation())
+		}
+		if c == nil || start < 0 || end < start {
+			return parsed, false
+		}
+		text := strings.TrimSpace(query[start:end])
+		switch typ, ok := constraintTypes[c.Contype]; {
+		case c.Contype == pg_query.ConstrType_CONSTR_NULL:
+		case c.Contype == pg_query.ConstrType_CONSTR_NOTNULL:
+			parsed.notNull = true
+		case c.Contype == pg_query.ConstrType_CONSTR_DEFAULT:
+			parsed.defaultExpr = strings.TrimSpace(text[defaultKeywordRegexp.FindStringIndex(text)[1]:])
+		case ok:
+			parsed.byType[typ] = append(parsed.byType[typ], text)
+		default:
+			parsed.other = append(parsed.other, text)
+		}
+	}
+	return parsed, true
+}
+
+var (
+	// defaultKeywordRegexp matches the keyword starting the default value of a column.
+	defaultKeywordRegexp = regexp.MustCompile(`(?i)\bDEFAULT\b`)
+	// referencesKeywordRegexp matches the keyword starting a foreign key of a column.
+	referencesKeywordRegexp = regexp.MustCompile(`(?i)\bREFERENCES\b`)
+	// constraintKeywordRegexp matches the keywords of constraints which name the columns they constrain.
+	constraintKeywordRegexp = regexp.MustCompile(`(?i)\b(UNIQUE|PRIMARY\s+KEY)\b`)
+)
+
+// tableConstraint returns the constraint of the column as a table constraint, naming the column it constrains.
+func tableConstraint(column, typ, text string) string {
+	switch typ {
+	case "f":
+		loc := referencesKeywordRegexp.FindStringIndex(text)
+		return text[:loc[0]] + fmt.Sprintf("FOREIGN KEY (%s) ", column) + text[loc[0]:]
+	case "u", "p":
+		loc := constraintKeywordRegexp.FindStringIndex(text)
+		return text[:loc[1]] + fmt.Sprintf(" (%s)", column) + text[loc[1]:]
+	default:
+		return text
+	}
+}
+
+// alterTable returns the statements changing the table stored with the old schema to the new columns and constraints.
+// Columns whose type changed are converted in place, and their nullability, defaults, and constraints are altered in
+// place, with the names PostgreSQL gave the constraints. Columns whose constraints can't be altered in place are
+// re-created, and the columns whose data is lost are returned, including removed columns, so the user can be asked
+// first. Table constraints can only be added, as PostgreSQL names them itself.
+func alterTable(table string, old artifact, columns []Column, constraints []string, names constraintNames) ([]string, []string, error) {
+	var statements, lost []string
+	for _, constraint := range old.Constraints {
+		if !slices.Contains(constraints, constraint) {
+			return nil, nil, fmt.Errorf("constraint %q of table %s can't be removed in place, roll back the step which created the table and store its schema again", constraint, table)
+		}
+	}
+
+	oldColumns := make(map[string]Column)
+	for _, col := range old.Columns {
+		oldColumns[col.Name] = col
+	}
+	for _, col := range old.Columns {
+		if !slices.ContainsFunc(columns, func(c Column) bool { return c.Name == col.Name }) {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, col.Name))
+			lost = append(lost, col.Name)
+		}
+	}
+	for _, col := range columns {
+		oldCol, ok := oldColumns[col.Name]
+		switch {
+		case !ok:
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, col.definition()))
+		case oldCol.definition() == col.definition():
+		default:
+			altered, ok := alterColumn(table, oldCol, col, columns, constraints, old.Constraints, names[col.Name])
+			if !ok {
+				altered = []string{
+					fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s", table, col.Name),
+					fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, col.definition()),
+				}
+				lost = append(lost, col.Name)
+			}
+			statements = append(statements, altered...)
+		}
+	}
+	for _, constraint := range constraints {
+		if !slices.Contains(old.Constraints, constraint) {
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD %s", table, constraint))
+		}
+	}
+	return statements, lost, nil
+}
+
+// alterColumn returns the statements changing the column from the old definition to the new one in place, keeping its
+// data, or false when it can't be changed in place. Changed constraints are dropped by their names and added again as
+// table constraints. Table constraints of the column which PostgreSQL can't tell apart from the dropped ones are added
+// again as well.
+func alterColumn(table string, oldCol, col Column, columns []Column, constraints, oldConstraints []string, names map[string][]string) ([]string, bool) {
+	oldParsed, ok := parseColumnConstraints(oldCol)
+	if !ok {
+		return nil, false
+	}
+	parsed, ok := parseColumnConstraints(col)
+	if !ok || !slices.Equal(oldParsed.other, parsed.other) {
+		return nil, false
+	}
+
+	var drops, alters, adds []string
+	for _, typ := range []string{"f", "u", "p", "c"} {
+		if slices.Equal(oldParsed.byType[typ], parsed.byType[typ]) {
+			continue
+		}
+		if len(names[typ]) < len(oldParsed.byType[typ]) {
+			return nil, false
+		}
+		for _, name := range names[typ] {
+			drops = append(drops, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", table, name))
+		}
+		for _, text := range parsed.byType[typ] {
+			adds = append(adds, fmt.Sprintf("ALTER TABLE %s ADD %s", table, tableConstraint(col.Name, typ, text)))
+		}
+		for _, constraint := range oldConstraints {
+			if slices.Contains(constraints, constraint) && constraintType(constraint) == typ && onlyColumn(constraint, col.Name, columns) {
+				adds = append(adds, fmt.Sprintf("ALTER TABLE %s ADD %s", table, constraint))
+			}
+		}
+	}
+	if oldCol.Type != col.Type {
+		alters = append(alters, fmt.Sprintf("ALTER TABLE %[1]s ALTER COLUMN %[2]s TYPE %[3]s USING %[2]s::%[3]s", table, col.Name, col.Type))
+	}
+	if oldParsed.notNull != parsed.notNull {
+		action := "DROP"
+		if parsed.notNull {
+			action = "SET"
+		}
+		alters = append(alters, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s NOT NULL", table, col.Name, action))
+	}
+	if oldParsed.defaultExpr != parsed.defaultExpr {
+		if parsed.defaultExpr == "" {
+			alters = append(alters, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s DROP DEFAULT", table, col.Name))
+		} else {
+			alters = append(alters, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s SET DEFAULT %s", table, col.Name, parsed.defaultExpr))
+		}
+	}
+	return slices.Concat(drops, alters, adds), true
+}
+
+// constraintType returns the pg_constraint type of the table constraint, or an empty string when it's of another type.
+func constraintType(constraint string) string {
+	switch upper := strings.ToUpper(constraint); {
+	case strings.Contains(upper, "FOREIGN KEY"):
+		return "f"
+	case strings.Contains(upper, "PRIMARY KEY"):
+		return "p"
+	case strings.Contains(upper, "UNIQUE"):
+		return "u"
+	case strings.Contains(upper, "CHECK"):
+		return "c"
+	default:
+		return ""
+	}
+}
+
+// onlyColumn reports whether the table constraint constrains the column and no other column of the table, like a
+// check of a single column, which PostgreSQL names after the column just like the column's own constraints.
+func onlyColumn(constraint, column string, columns []Column) bool {
+	found := false
+	for _, ident := range sqlIdentifierRegexp.FindAllString(constraint, -1) {
+		switch {
+		case ident == column:
+			found = true
+		case slices.ContainsFunc(columns, func(c Column) bool { return c.Name == ident }):
+			return false
+		}
+	}
+	return found
+}
@@ -0,0 +1,214 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	repositoryLayerPrompt = `
+## Repository layer
+
+The project uses a separate repository layer, so the generated code doesn't access the database directly:
+
+- Before implementing the %[1]s, generate the repository code following the sample code from the knowledge base and
+  save it using "save_repository_code" tool:
+  - A Repository interface in the %[2]s package with CRUD methods for every resource, using the generated types.
+  - A Postgres struct in the repository package implementing the interface with sqlx, created by
+    NewPostgres(db *sqlx.DB) *Postgres.
+- The %[1]s struct must have a single Repo field of the Repository type and must call it instead of the database.
+`
+	serviceLayerPrompt = `
+## Service layer
+
+The project uses a service layer between the %[1]s and the repository layer, holding business rules:
+
+- Before implementing the %[1]s, query the memory for business rules the user described for every resource (e.g.
+  "orders over $100 need approval"), then generate the service code following the sample code from the knowledge base
+  and save it using "save_service_code" tool:
+  - A Service interface in the %[2]s package with a method for every operation of every resource, using the generated
+    types.
+  - A Service struct in the service package implementing the interface on top of the Repository interface, created by
+    New(repo %[2]s.Repository) *Service.
+- Every business rule becomes a clearly marked stub in the method it applies to, starting with a
+  "// TODO(business-rule): <rule as described by the user>" comment and leaving the rule unimplemented.
+- The %[1]s struct must have a single Svc field of the Service type and must call it instead of the repository.
+`
+)
+
+const SaveRepositoryCodeToolName = "save_repository_code"
+
+func (s *Service) SaveRepositoryCodeTool() openai.ChatCompletionToolParam {
+	properties := map[string]interface{}{
+		"interface_go_code": map[string]string{
+			"type": "string",
+		},
+		"postgres_go_code": map[string]string{
+			"type": "string",
+		},
+	}
+	required := []string{"interface_go_code", "postgres_go_code"}
+	if s.Cache {
+		properties["cached_go_code"] = map[string]string{
+			"type": "string",
+		}
+		required = append(required, "cached_go_code")
+	}
+
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(SaveRepositoryCodeToolName),
+			Description: openai.String("Save generated repository Go code: the Repository interface, its Postgres implementation, and the cached implementation when the cache layer is enabled."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type":       "object",
+				"properties": properties,
+				"required":   required,
+			}),
+		}),
+	}
+}
+
+const SaveServiceCodeToolName = "save_service_code"
+
+func (s *Service) SaveServiceCodeTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(SaveServiceCodeToolName),
+			Description: openai.String("Save generated service layer Go code: the Service interface and its implementation with business rule stubs."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"interface_go_code": map[string]string{
+						"type": "string",
+					},
+					"service_go_code": map[string]string{
+						"type": "string",
+					},
+				},
+				"required": []string{"interface_go_code", "service_go_code"},
+			}),
+		}),
+	}
+}
+
+// apiPackage returns the package of the generated project holding the API types.
+func (s *Service) apiPackage() string {
+	if s.APIStyle == APIStyleGraphQL {
+		return "graph"
+	}
+	return "api"
+}
+
+// withLayers extends the code generation prompt and tools with the repository and service layers, the cache, event
+// publishing, and multi-tenancy, if enabled.
+func (s *Service) withLayers(prompt, structName string, tools []openai.ChatCompletionToolParam) (string, []openai.ChatCompletionToolParam) {
+	if s.RepositoryLayer {
+		prompt += fmt.Sprintf(repositoryLayerPrompt, structName, s.apiPackage())
+		tools = append(tools, s.SaveRepositoryCodeTool())
+	}
+	if s.Cache {
+		prompt += fmt.Sprintf(cacheLayerPrompt, s.apiPackage())
+	}
+	if s.EventBroker != "" {
+		prompt += eventPublishingPrompt
+	}
+	if s.MultiTenant {
+		prompt += multiTenantPrompt
+	}
+	if s.ServiceLayer {
+		prompt += fmt.Sprintf(serviceLayerPrompt, structName, s.apiPackage())
+		tools = append(tools, s.SaveServiceCodeTool())
+	}
+	return prompt, tools
+}
+
+func (s *Service) SaveRepositoryCode(_ context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	rootDir := os.Getenv("PROJECT_ROOT")
+	ifaceName := path.Join(rootDir, "pkg", s.apiPackage(), "repository.go")
+	pgName := path.Join(rootDir, "pkg", "repository", "postgres.go")
+	cachedName := path.Join(rootDir, "pkg", "repository", "cached.go")
+
+	ifaceCode, _ := args["interface_go_code"].(string)
+	pgCode, _ := args["postgres_go_code"].(string)
+	if ifaceCode == "" || pgCode == "" {
+		return "Failed to save repository code: missing interface_go_code or postgres_go_code argument"
+	}
+	ifaceCode, err := formatGo(ifaceName, TrimNonCode(ifaceCode, "go"))
+	if err != nil {
+		return fmt.Sprintf("Repository interface rejected, fix the following issues and save it again:\n%v", err)
+	}
+	pgCode, err = formatGo(pgName, TrimNonCode(pgCode, "go"))
+	if err == nil {
+		err = s.checkSQL(pgCode)
+	}
+	if err != nil {
+		return fmt.Sprintf("Repository code rejected, fix the following issues and save it again:\n%v", err)
+	}
+	cachedCode, hasCached := args["cached_go_code"].(string)
+	if hasCached {
+		if cachedCode, err = formatGo(cachedName, TrimNonCode(cachedCode, "go")); err != nil {
+			return fmt.Sprintf("Cached repository rejected, fix the following issues and save it again:\n%v", err)
+		}
+	}
+
+	if err := s.writeFile(ifaceName, ifaceCode); err != nil {
+		return fmt.Sprintf("Failed to save repository interface: %v", err)
+	}
+	if err := s.writeFile(pgName, pgCode); err != nil {
+		return fmt.Sprintf("Failed to save repository implementation: %v", err)
+	}
+	if hasCached {
+		if err := s.writeFile(cachedName, cachedCode); err != nil {
+			return fmt.Sprintf("Failed to save cached repository: %v", err)
+		}
+	}
+
+	return "Repository code saved successfully"
+}
+
+func (s *Service) SaveServiceCode(_ context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	rootDir := os.Getenv("PROJECT_ROOT")
+	ifaceName := path.Join(rootDir, "pkg", s.apiPackage(), "service.go")
+	svcName := path.Join(rootDir, "pkg", "service", "service.go")
+
+	ifaceCode, _ := args["interface_go_code"].(string)
+	svcCode, _ := args["service_go_code"].(string)
+	if ifaceCode == "" || svcCode == "" {
+		return "Failed to save service code: missing interface_go_code or service_go_code argument"
+	}
+	ifaceCode, err := formatGo(ifaceName, TrimNonCode(ifaceCode, "go"))
+	if err != nil {
+		return fmt.Sprintf("Service interface rejected, fix the following issues and save it again:\n%v", err)
+	}
+	svcCode, err = formatGo(svcName, TrimNonCode(svcCode, "go"))
+	if err == nil {
+		err = s.checkSQL(svcCode)
+	}
+	if err != nil {
+		return fmt.Sprintf("Service code rejected, fix the following issues and save it again:\n%v", err)
+	}
+
+	if err := s.writeFile(ifaceName, ifaceCode); err != nil {
+		return fmt.Sprintf("Failed to save service interface: %v", err)
+	}
+	if err := s.writeFile(svcName, svcCode); err != nil {
+		return fmt.Sprintf("Failed to save service implementation: %v", err)
+	}
+
+	return "Service code saved successfully"
+}
@@ -0,0 +1,100 @@
+package tooling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// lintSeverities ranks severities of golangci-lint findings, as set by the generated .golangci.yml.
+var lintSeverities = map[string]int{
+	"warning": 1,
+	"error":   2,
+}
+
+// lintIssue is a finding of golangci-lint JSON report.
+type lintIssue struct {
+	FromLinter string
+	Text       string
+	Severity   string
+	Pos        struct {
+		Filename string
+		Line     int
+	}
+}
+
+const LintCodeToolName = "lint_code"
+
+func (s *Service) LintCodeTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(LintCodeToolName),
+			Description: openai.String("Runs golangci-lint on the generated Go code and reports findings, telling which of them block the workflow until fixed."),
+		}),
+	}
+}
+
+func (s *Service) LintCode(ctx context.Context) string {
+	if s.LintSeverity == "none" {
+		return "Linting is disabled, skip it"
+	}
+	bin, ok := s.toolPath("golangci-lint")
+	if !ok {
+		return "golangci-lint isn't installed, skip linting"
+	}
+	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return fmt.Sprintf("Failed to get absolute path of project root: %v", err)
+	}
+	cmd := exec.CommandContext(ctx, bin, "run", "--out-format", "json", "./...")
+	cmd.Dir = absRoot
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// golangci-lint exits with an error when there are findings, so the report is parsed first.
+	runErr := cmd.Run()
+	var report struct {
+		Issues []lintIssue
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		if runErr != nil {
+			return fmt.Sprintf("golangci-lint failed: %v\n%s", runErr, stderr.String())
+		}
+		return fmt.Sprintf("Failed to parse golangci-lint report: %v", err)
+	}
+
+	var blocking, other []string
+	for _, issue := range report.Issues {
+		finding := fmt.Sprintf("%s:%d: %s (%s)", issue.Pos.Filename, issue.Pos.Line, issue.Text, issue.FromLinter)
+		if lintSeverities[issue.Severity] >= lintSeverities[s.LintSeverity] {
+			blocking = append(blocking, finding)
+		} else {
+			other = append(other, finding)
+		}
+	}
+
+	var sb strings.Builder
+	switch {
+	case len(blocking) > 0:
+		sb.WriteString("Lint findings blocking the workflow, fix them and re-generate the code:\n")
+		sb.WriteString(strings.Join(blocking, "\n"))
+	case len(other) > 0:
+		sb.WriteString("No blocking lint findings")
+	default:
+		return "No lint findings"
+	}
+	if len(other) > 0 {
+		sb.WriteString("\nOther findings, fix them only when it's simple:\n")
+		sb.WriteString(strings.Join(other, "\n"))
+	}
+	return sb.String()
+}
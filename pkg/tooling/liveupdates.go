@@ -0,0 +1,100 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/openai/openai-go"
+)
+
+// notifyTriggerSQL publishes every change of a table as a JSON notification on the <table>_changes channel.
+const notifyTriggerSQL = `
+CREATE OR REPLACE FUNCTION notify_%[1]s_changes() RETURNS trigger AS $$
+BEGIN
+	PERFORM pg_notify('%[1]s_changes', json_build_object(
+		'operation', TG_OP,
+		'data', CASE WHEN TG_OP = 'DELETE' THEN row_to_json(OLD) ELSE row_to_json(NEW) END
+	)::text);
+	RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS %[1]s_changes ON %[1]s;
+
+CREATE TRIGGER %[1]s_changes
+	AFTER INSERT OR UPDATE OR DELETE ON %[1]s
+	FOR EACH ROW EXECUTE FUNCTION notify_%[1]s_changes();
+`
+
+var identifierRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+const GenerateLiveUpdatesToolName = "generate_live_updates"
+
+func (s *Service) GenerateLiveUpdatesTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateLiveUpdatesToolName),
+			Description: openai.String("Adds a Server-Sent Events endpoint (GET /<table_name>/events) streaming changes of an existing table, based on PostgreSQL LISTEN/NOTIFY, and documents it in AsyncAPI spec."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"table_name": map[string]string{
+						"type": "string",
+					},
+				},
+				"required": []string{"table_name"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) GenerateLiveUpdates(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	table, _ := args["table_name"].(string)
+	if table == "" {
+		return "Failed to generate live updates: missing table_name argument"
+	}
+	if !identifierRegexp.MatchString(table) {
+		return fmt.Sprintf("Invalid table name %q, use lowercase letters, digits, and underscores only", table)
+	}
+
+	if _, err := s.DB.ExecContext(ctx, fmt.Sprintf(notifyTriggerSQL, table)); err != nil {
+		return fmt.Sprintf("Failed to create notify trigger: %v", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !slices.Contains(s.LiveUpdates, table) {
+		s.LiveUpdates = append(s.LiveUpdates, table)
+	}
+
+	rootDir := os.Getenv("PROJECT_ROOT")
+	events, err := s.renderTemplate(eventsGo)
+	if err != nil {
+		return fmt.Sprintf("Failed to render events package: %v", err)
+	}
+	if err := s.writeFile(path.Join(rootDir, "pkg", "events", "events.go"), events); err != nil {
+		return fmt.Sprintf("Failed to save events package: %v", err)
+	}
+	asyncAPI, err := s.renderTemplate(asyncAPIYaml)
+	if err != nil {
+		return fmt.Sprintf("Failed to render AsyncAPI spec: %v", err)
+	}
+	if err := s.writeFile(path.Join(rootDir, "pkg", s.apiPackage(), "doc", "asyncapi.yaml"), asyncAPI); err != nil {
+		return fmt.Sprintf("Failed to save AsyncAPI spec: %v", err)
+	}
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return fmt.Sprintf("Failed to register live updates endpoint: %v", err)
+	}
+
+	return fmt.Sprintf("Live updates of %[1]s table are available as Server-Sent Events at GET /%[1]s/events", table)
+}
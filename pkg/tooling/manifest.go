@@ -0,0 +1,358 @@
+package tooling
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// manifestFile records what was generated for the project, relative to the project root. It's meant to be
+	// committed with the project, so regeneration, orphan detection, and drift warnings work on every machine.
+	manifestFile = "doubletab.lock"
+	// legacyManifestFile tracked the artifacts before the lock file, it's read when there is no lock file yet.
+	legacyManifestFile = ".doubletab/manifest.json"
+	manifestVersion    = 1
+)
+
+const (
+	artifactFile  = "file"
+	artifactTable = "table"
+)
+
+// manifest is the content of the lock file.
+type manifest struct {
+	Version int `yaml:"version"`
+	// Generator is the version of DoubleTab which generated the project last.
+	Generator string `yaml:"generator"`
+	// Tools are the versions of the code generation and checking tools, by name.
+	Tools map[string]string `yaml:"tools,omitempty"`
+	Spec  *specVersion      `yaml:"spec,omitempty"`
+	// Artifacts are sorted by kind and name, so the lock file diffs well.
+	Artifacts []artifact `yaml:"artifacts"`
+}
+
+// specVersion identifies the API spec the project was generated from.
+type specVersion struct {
+	// Path is the path of the OpenAPI spec or GraphQL schema, relative to the project root.
+	Path string `yaml:"path"`
+	// Version is the version of the OpenAPI spec (info.version). GraphQL schemas have none.
+	Version string `yaml:"version,omitempty"`
+	Hash    string `yaml:"hash"`
+}
+
+// artifact is a file or a database table generated for the project.
+type artifact struct {
+	Kind string `json:"kind" yaml:"kind"`
+	// Name is the path of the file relative to the project root, or the name of the table.
+	Name string `json:"name" yaml:"name"`
+	// Table is the table the artifact was generated for, e.g. of a migration file. Empty for tables themselves and
+	// artifacts of the whole project.
+	Table string `json:"table,omitempty" yaml:"table,omitempty"`
+	// Hash is the SHA-256 hash of the file content as it was generated.
+	Hash string `json:"hash,omitempty" yaml:"hash,omitempty"`
+	// Input is the hash of the inputs the file was generated from, e.g. of the spec for generated handlers, so the file
+	// is only regenerated when they change.
+	Input string `json:"input,omitempty" yaml:"input,omitempty"`
+	// DDL are the statements creating the table as it is now.
+	DDL string `json:"ddl,omitempty" yaml:"ddl,omitempty"`
+	// Columns and Constraints are the schema the table was stored with last, so a changed schema is applied by altering
+	// the table.
+	Columns     []Column `json:"columns,omitempty" yaml:"columns,omitempty"`
+	Constraints []string `json:"constraints,omitempty" yaml:"constraints,omitempty"`
+}
+
+// manifestMu serializes updates of the manifest, as tools run concurrently.
+var manifestMu sync.Mutex
+
+// loadManifest returns the tracked artifacts, or none when nothing was generated yet.
+func loadManifest() ([]artifact, error) {
+	m, err := readManifest()
+	if err != nil {
+		return nil, err
+	}
+	return m.Artifacts, nil
+}
+
+func readManifest() (*manifest, error) {
+	content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), manifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return readLegacyManifest()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifestFile, err)
+	}
+	var m manifest
+	if err := yaml.Unmarshal(content, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", manifestFile, err)
+	}
+	if m.Version > manifestVersion {
+		return nil, fmt.Errorf("%s was written by a newer version of DoubleTab (format %d)", manifestFile, m.Version)
+	}
+	return &m, nil
+}
+
+func readLegacyManifest() (*manifest, error) {
+	m := &manifest{Version: manifestVersion}
+	content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), legacyManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if err := json.Unmarshal(content, &m.Artifacts); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return m, nil
+}
+
+// saveManifest writes the lock file with the artifacts, recording the current generator, tool, and spec versions.
+func saveManifest(artifacts []artifact) error {
+	m := manifest{
+		Version:   manifestVersion,
+		Generator: generatorVersion(),
+		Tools:     make(map[string]string),
+		Spec:      currentSpecVersion(),
+		Artifacts: artifacts,
+	}
+	for _, tool := range devTools {
+		m.Tools[tool.Name] = tool.Version
+	}
+	sort.Slice(m.Artifacts, func(i, j int) bool {
+		if m.Artifacts[i].Kind != m.Artifacts[j].Kind {
+			return m.Artifacts[i].Kind < m.Artifacts[j].Kind
+		}
+		return m.Artifacts[i].Name < m.Artifacts[j].Name
+	})
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(m); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", manifestFile, err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", manifestFile, err)
+	}
+	// The lock file isn't written with writeFile, as it would track the lock file itself.
+	if err := writeFileAtomic(filepath.Join(os.Getenv("PROJECT_ROOT"), manifestFile), buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestFile, err)
+	}
+	return nil
+}
+
+// generatorVersion returns the module version DoubleTab was built from, "(devel)" for local builds.
+func generatorVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// currentSpecVersion returns the version of the OpenAPI spec or GraphQL schema of the project, or nil when there is none
+// yet.
+func currentSpecVersion() *specVersion {
+	for _, name := range []string{"pkg/api/doc/openapi.yaml", "pkg/graph/schema.graphqls"} {
+		content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), name))
+		if err != nil {
+			continue
+		}
+		v := &specVersion{Path: name, Hash: contentHash(string(content))}
+		var spec struct {
+			Info struct {
+				Version string
+			}
+		}
+		if filepath.Ext(name) == ".yaml" && yaml.Unmarshal(content, &spec) == nil {
+			v.Version = spec.Info.Version
+		}
+		return v
+	}
+	return nil
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// trackArtifacts adds the artifacts to the manifest. Artifacts which are tracked already are updated with the fields
+// set, so e.g. the hash of a file isn't lost when it's tracked again as the migration of a table.
+func trackArtifacts(artifacts ...artifact) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	tracked, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	for _, a := range artifacts {
+		found := false
+		for i, t := range tracked {
+			if t.Kind != a.Kind || t.Name != a.Name {
+				continue
+			}
+			found = true
+			if a.Table != "" {
+				tracked[i].Table = a.Table
+			}
+			if a.Hash != "" {
+				tracked[i].Hash = a.Hash
+			}
+			if a.Input != "" {
+				tracked[i].Input = a.Input
+			}
+			if a.DDL != "" {
+				tracked[i].DDL = a.DDL
+			}
+			if a.Columns != nil {
+				tracked[i].Columns = a.Columns
+				tracked[i].Constraints = a.Constraints
+			}
+		}
+		if !found {
+			tracked = append(tracked, a)
+		}
+	}
+	return saveManifest(tracked)
+}
+
+// trackFile tracks the file written to the project with the hash of its content, and keeps the content as the base of
+// merges with manual edits. Files outside the project aren't tracked.
+func trackFile(name, content string) error {
+	return trackGenerated(name, content, "")
+}
+
+// trackGenerated tracks the file like trackFile, together with the hash of the inputs it was generated from.
+func trackGenerated(name, content, input string) error {
+	rel, ok := projectPath(name)
+	if !ok {
+		return nil
+	}
+	if err := trackArtifacts(artifact{Kind: artifactFile, Name: rel, Hash: contentHash(content), Input: input}); err != nil {
+		return err
+	}
+	return saveBase(rel, content)
+}
+
+// projectPath returns the path of the file relative to the project root, or false when it's outside the project.
+func projectPath(name string) (string, bool) {
+	rootDir, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return "", false
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(rootDir, abs)
+	if err != nil || !filepath.IsLocal(rel) {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// trackedArtifact returns the tracked artifact of the kind and name, or false when it isn't tracked.
+func trackedArtifact(kind, name string) (artifact, bool, error) {
+	tracked, err := loadManifest()
+	if err != nil {
+		return artifact{}, false, err
+	}
+	for _, a := range tracked {
+		if a.Kind == kind && a.Name == name {
+			return a, true, nil
+		}
+	}
+	return artifact{}, false, nil
+}
+
+// GeneratedArtifacts describes the tables and files tracked in the manifest, e.g. for the model to know what was
+// generated when the conversation which generated them is lost. It's empty when nothing was generated.
+func GeneratedArtifacts() (string, error) {
+	tracked, err := loadManifest()
+	if err != nil {
+		return "", err
+	}
+	var tables, files []string
+	for _, a := range tracked {
+		switch a.Kind {
+		case artifactTable:
+			tables = append(tables, a.Name)
+		case artifactFile:
+			files = append(files, a.Name)
+		}
+	}
+	var sb strings.Builder
+	if len(tables) > 0 {
+		fmt.Fprintf(&sb, "Tables: %s\n", strings.Join(tables, ", "))
+	}
+	if len(files) > 0 {
+		fmt.Fprintf(&sb, "Files: %s\n", strings.Join(files, ", "))
+	}
+	return sb.String(), nil
+}
+
+// untrackArtifacts removes the artifacts from the manifest.
+func untrackArtifacts(artifacts ...artifact) error {
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+
+	tracked, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	kept := tracked[:0]
+	for _, t := range tracked {
+		removed := false
+		for _, a := range artifacts {
+			removed = removed || (t.Kind == a.Kind && t.Name == a.Name)
+		}
+		if !removed {
+			kept = append(kept, t)
+		} else if t.Kind == artifactFile {
+			if err := removeBase(t.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return saveManifest(kept)
+}
+
+// Drift returns the generated files which were changed or deleted since they were generated, with the kind of the
+// change, so the user is warned before regeneration overwrites their changes.
+func (s *Service) Drift() ([]string, error) {
+	tracked, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	var drifted []string
+	for _, a := range tracked {
+		if a.Kind != artifactFile || a.Hash == "" {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), a.Name))
+		if errors.Is(err, os.ErrNotExist) {
+			drifted = append(drifted, a.Name+" (deleted)")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", a.Name, err)
+		}
+		if contentHash(string(content)) != a.Hash {
+			drifted = append(drifted, a.Name+" (modified)")
+		}
+	}
+	return drifted, nil
+}
@@ -0,0 +1,173 @@
+package tooling
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// baseDir keeps the content of the generated files as they were generated last, relative to the project root. It's
+// the base of the three-way merge of manual edits with regenerated files.
+const baseDir = ".doubletab/base"
+
+// Markers of conflicting changes in merged files, like the ones of git.
+const (
+	conflictOursMarker   = "<<<<<<< manual edits"
+	conflictSepMarker    = "======="
+	conflictTheirsMarker = ">>>>>>> regenerated"
+)
+
+// saveBase keeps the generated content of the file of the project as the base of later merges.
+func saveBase(rel, content string) error {
+	name := filepath.Join(os.Getenv("PROJECT_ROOT"), baseDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return fmt.Errorf("failed to create base directory: %w", err)
+	}
+	if err := writeFileAtomic(name, []byte(content)); err != nil {
+		return fmt.Errorf("failed to save base of %s: %w", rel, err)
+	}
+	return nil
+}
+
+// removeBase removes the base of the file of the project, once it's no longer tracked.
+func removeBase(rel string) error {
+	err := os.Remove(filepath.Join(os.Getenv("PROJECT_ROOT"), baseDir, filepath.FromSlash(rel)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// handWrittenFile reports whether the file of the project exists without having been generated, or was taken over by
+// hand since. With generated markers, files are generated when they have the marker: untracked files without it are
+// hand-written, and tracked files whose marker was removed were taken over. Without markers, untracked files are
+// hand-written when the project was analyzed, so it had the file before DoubleTab extended it. Such files aren't
+// overwritten by generated content, while edits of their content by the model are saved with writeEditedFile.
+func handWrittenFile(name string) bool {
+	current, err := os.ReadFile(name)
+	if err != nil {
+		return false
+	}
+	rel, ok := projectPath(name)
+	if !ok {
+		return false
+	}
+	_, found, err := trackedArtifact(artifactFile, rel)
+	if err != nil {
+		return false
+	}
+	if headerMarker && fileHeader(name, "") != "" {
+		if !found {
+			return !generatedFile(name, string(current))
+		}
+		// Files generated before markers were added have none, so only removing the marker they were generated with
+		// takes them over.
+		base, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), baseDir, filepath.FromSlash(rel)))
+		return err == nil && generatedFile(name, string(base)) && !generatedFile(name, string(current))
+	}
+	if found {
+		return false
+	}
+	analysis, err := loadAnalysis()
+	return err == nil && analysis != nil
+}
+
+// mergeManualEdits returns the content to write to the file instead of the generated content. When the file was
+// edited by hand since it was generated last, the edits are merged with the generated content, using the content
+// generated last as the base. Conflicting changes are kept both, between conflict markers, and reported with an error.
+// Otherwise, the generated content is returned as it is, also for untracked files, which are generated ones whose
+// tracking was lost when they reach here, like files with the generated marker.
+func mergeManualEdits(name, content string) (string, error) {
+	current, err := os.ReadFile(name)
+	if err != nil {
+		return content, nil
+	}
+	rel, ok := projectPath(name)
+	if !ok {
+		return content, nil
+	}
+	a, found, err := trackedArtifact(artifactFile, rel)
+	if err != nil || !found || a.Hash == "" || contentHash(string(current)) == a.Hash {
+		return content, nil
+	}
+	base, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), baseDir, filepath.FromSlash(rel)))
+	// Files generated before bases were kept can't be merged, they are overwritten like before.
+	if err != nil || contentHash(string(base)) != a.Hash {
+		return content, nil
+	}
+
+	// Protected regions are restored in both sides already, so they don't count as changes against the base either.
+	mergeBase := string(base)
+	if restored, err := restoreProtectedRegions(string(current), mergeBase); err == nil {
+		mergeBase = restored
+	}
+
+	merged, conflicts := merge3(mergeBase, string(current), content)
+	if conflicts > 0 {
+		return merged, fmt.Errorf("the regenerated %s conflicts with its manual edits in %d places, marked with %q and %q in the file. Ask the user to resolve them, and don't save the file again before",
+			rel, conflicts, conflictOursMarker, conflictTheirsMarker)
+	}
+	return merged, nil
+}
+
+// merge3 merges the changes of ours and theirs to their common base line by line, and returns the merged content with
+// the number of conflicts. Changes of the same lines are conflicting, unless they are equal, and are kept both between
+// conflict markers. Line endings don't count as changes, as editors on Windows may save files with CRLF line endings,
+// which the merged content keeps when ours has them.
+func merge3(base, ours, theirs string) (string, int) {
+	crlf := strings.Contains(ours, "\r\n")
+	base, ours, theirs = lfLineEndings(base), lfLineEndings(ours), lfLineEndings(theirs)
+	b, o, t := splitLines(base), splitLines(ours), splitLines(theirs)
+	oursMatches, theirsMatches := matchLines(b, o), matchLines(b, t)
+
+	var merged []string
+	conflicts := 0
+	i, j, k := 0, 0, 0
+	for i < len(b) || j < len(o) || k < len(t) {
+		if i < len(b) && oursMatches[i] == j && theirsMatches[i] == k {
+			merged = append(merged, b[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		// The changed chunk ends at the next line of the base both sides kept.
+		end := i
+		for end < len(b) && (oursMatches[end] == -1 || theirsMatches[end] == -1) {
+			end++
+		}
+		oursEnd, theirsEnd := len(o), len(t)
+		if end < len(b) {
+			oursEnd, theirsEnd = oursMatches[end], theirsMatches[end]
+		}
+		baseChunk, oursChunk, theirsChunk := b[i:end], o[j:oursEnd], t[k:theirsEnd]
+		switch {
+		case slices.Equal(oursChunk, baseChunk):
+			merged = append(merged, theirsChunk...)
+		case slices.Equal(theirsChunk, baseChunk), slices.Equal(oursChunk, theirsChunk):
+			merged = append(merged, oursChunk...)
+		default:
+			conflicts++
+			merged = append(merged, conflictOursMarker)
+			merged = append(merged, oursChunk...)
+			merged = append(merged, conflictSepMarker)
+			merged = append(merged, theirsChunk...)
+			merged = append(merged, conflictTheirsMarker)
+		}
+		i, j, k = end, oursEnd, theirsEnd
+	}
+	if len(merged) == 0 {
+		return "", conflicts
+	}
+	if crlf {
+		return strings.Join(merged, "\r\n") + "\r\n", conflicts
+	}
+	return strings.Join(merged, "\n") + "\n", conflicts
+}
+
+// lfLineEndings returns the content with CRLF line endings replaced by LF ones.
+func lfLineEndings(content string) string {
+	return strings.ReplaceAll(content, "\r\n", "\n")
+}
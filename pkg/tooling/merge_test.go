@@ -0,0 +1,146 @@
+package tooling
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	conflict := func(ours, theirs string) string {
+		return conflictOursMarker + "\n" + ours + conflictSepMarker + "\n" + theirs + conflictTheirsMarker + "\n"
+	}
+	tests := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			name:   "unchanged",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nb\nc\n",
+		},
+		{
+			name:   "ours only",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "theirs only",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nC\n",
+			want:   "a\nb\nC\n",
+		},
+		{
+			name:   "changes of different lines",
+			base:   "a\nb\nc\nd\n",
+			ours:   "A\nb\nc\nd\n",
+			theirs: "a\nb\nc\nD\n",
+			want:   "A\nb\nc\nD\n",
+		},
+		{
+			name:   "equal changes of the same line",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:      "conflicting changes of the same line",
+			base:      "a\nb\nc\n",
+			ours:      "a\nours\nc\n",
+			theirs:    "a\ntheirs\nc\n",
+			want:      "a\n" + conflict("ours\n", "theirs\n") + "c\n",
+			conflicts: 1,
+		},
+		{
+			name:      "two conflicts",
+			base:      "a\nb\nc\nd\ne\n",
+			ours:      "a\nB1\nc\nD1\ne\n",
+			theirs:    "a\nB2\nc\nD2\ne\n",
+			want:      "a\n" + conflict("B1\n", "B2\n") + "c\n" + conflict("D1\n", "D2\n") + "e\n",
+			conflicts: 2,
+		},
+		{
+			name:      "deleted and changed line",
+			base:      "a\nb\nc\n",
+			ours:      "a\nc\n",
+			theirs:    "a\nB\nc\n",
+			want:      "a\n" + conflict("", "B\n") + "c\n",
+			conflicts: 1,
+		},
+		{
+			name:   "insertion of ours at the end",
+			base:   "a\nb\n",
+			ours:   "a\nb\nc\n",
+			theirs: "A\nb\n",
+			want:   "A\nb\nc\n",
+		},
+		{
+			name:   "insertion of theirs at the end",
+			base:   "a\nb\n",
+			ours:   "A\nb\n",
+			theirs: "a\nb\nc\n",
+			want:   "A\nb\nc\n",
+		},
+		{
+			name:   "equal insertions at the end",
+			base:   "a\n",
+			ours:   "a\nb\n",
+			theirs: "a\nb\n",
+			want:   "a\nb\n",
+		},
+		{
+			name:      "different insertions at the end",
+			base:      "a\n",
+			ours:      "a\nours\n",
+			theirs:    "a\ntheirs\n",
+			want:      "a\n" + conflict("ours\n", "theirs\n"),
+			conflicts: 1,
+		},
+		{
+			name:   "insertion into an empty base",
+			base:   "",
+			ours:   "",
+			theirs: "a\n",
+			want:   "a\n",
+		},
+		{
+			name:   "CRLF of ours isn't a change",
+			base:   "a\nb\nc\n",
+			ours:   "a\r\nb\r\nc\r\n",
+			theirs: "a\nb\nC\n",
+			want:   "a\r\nb\r\nC\r\n",
+		},
+		{
+			name:   "CRLF of ours with its changes",
+			base:   "a\nb\nc\n",
+			ours:   "A\r\nb\r\nc\r\n",
+			theirs: "a\nb\nC\n",
+			want:   "A\r\nb\r\nC\r\n",
+		},
+		{
+			name:      "CRLF of ours with a conflict",
+			base:      "a\nb\n",
+			ours:      "a\r\nours\r\n",
+			theirs:    "a\ntheirs\n",
+			want:      strings.ReplaceAll("a\n"+conflict("ours\n", "theirs\n"), "\n", "\r\n"),
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := merge3(tt.base, tt.ours, tt.theirs)
+			if got != tt.want || conflicts != tt.conflicts {
+				t.Errorf("merge3() = %q, %d conflicts, want %q, %d conflicts", got, conflicts, tt.want, tt.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package tooling
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Conventions of table names.
+const (
+	NamingPlural   = "plural"
+	NamingSingular = "singular"
+	NamingKeep     = "keep"
+)
+
+// namingTablePlaceholder stands for the singular table name in the name of the id column, e.g. {table}_id.
+const namingTablePlaceholder = "{table}"
+
+var (
+	// referencesRegexp matches foreign keys in column constraints, with the referenced table and column.
+	referencesRegexp = regexp.MustCompile(`(?i)\bREFERENCES\s+(\w+)\s*\(\s*(\w+)\s*\)`)
+	// irregularPlurals are plurals of nouns not formed with a suffix, by their singular.
+	irregularPlurals = map[string]string{"person": "people", "child": "children", "man": "men", "woman": "women"}
+	// uncountableNouns are the same in singular and plural, though some end like plurals.
+	uncountableNouns = map[string]bool{"news": true, "series": true, "species": true, "data": true, "information": true,
+		"equipment": true, "metadata": true}
+)
+
+// namingPrompt returns the naming conventions of tables for the prompt of schema generation.
+func (s *Service) namingPrompt() string {
+	var sb strings.Builder
+	sb.WriteString("\n## Naming conventions\n\n- Name tables and columns in snake_case.\n")
+	switch s.NamingTables {
+	case NamingPlural:
+		sb.WriteString("- Name tables with plural nouns (e.g., books, order_items).\n")
+	case NamingSingular:
+		sb.WriteString("- Name tables with singular nouns (e.g., book, order_item).\n")
+	}
+	if s.NamingIDColumn != "" {
+		fmt.Fprintf(&sb, "- Name the primary key column %s (e.g., %s of the table %s), and reference it in foreign keys.\n",
+			s.NamingIDColumn, s.idColumn(s.tableName("book")), s.tableName("book"))
+	}
+	sb.WriteString("- Names breaking these conventions are renamed when the schema is stored.\n")
+	return sb.String()
+}
+
+// enforceNaming renames the table, the columns, and the tables and columns referenced by foreign keys of the schema to
+// follow the naming conventions, updating the checks of the renamed columns. Tracked tables keep their names and columns,
+// as renaming them would re-create them, and references to them are resolved to their tracked names and primary keys.
+// It returns the renames, empty when the schema followed the conventions.
+func (s *Service) enforceNaming(schema *Schema) ([]string, error) {
+	artifacts, err := loadManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", manifestFile, err)
+	}
+	tracked := make(map[string][]Column)
+	for _, a := range artifacts {
+		if a.Kind == artifactTable {
+			tracked[a.Name] = a.Columns
+		}
+	}
+
+	var renames []string
+	generated := schema.TableName
+	_, keep := tracked[generated]
+	if table := s.tableName(generated); !keep && table != generated {
+		renames = append(renames, fmt.Sprintf("table %s to %s", generated, table))
+		schema.TableName = table
+	}
+
+	columns := make(map[string]string)
+	if !keep {
+		// Only single-column primary keys are renamed to the id column, when it's configured.
+		key := primaryKeyColumn(schema.Columns)
+		for i, col := range schema.Columns {
+			name := snakeCase(col.Name)
+			if s.NamingIDColumn != "" && key == col.Name {
+				name = s.idColumn(schema.TableName)
+			}
+			if name != col.Name {
+				renames = append(renames, fmt.Sprintf("column %s to %s", col.Name, name))
+				columns[col.Name] = name
+				schema.Columns[i].Name = name
+			}
+		}
+	}
+
+	for i, col := range schema.Columns {
+		schema.Columns[i].Constraints = referencesRegexp.ReplaceAllStringFunc(col.Constraints, func(ref string) string {
+			m := referencesRegexp.FindStringSubmatch(ref)
+			table, column := s.referencedColumn(m[1], m[2], generated, schema.TableName, columns, tracked)
+			if table != m[1] || column != m[2] {
+				renames = append(renames, fmt.Sprintf("reference %s(%s) of %s to %s(%s)", m[1], m[2], col.Name, table, column))
+			}
+			return fmt.Sprintf("REFERENCES %s(%s)", table, column)
+		})
+	}
+
+	if len(columns) > 0 {
+		rename := func(expr string) string {
+			return sqlIdentifierRegexp.ReplaceAllStringFunc(expr, func(ident string) string {
+				if name, ok := columns[ident]; ok {
+					return name
+				}
+				return ident
+			})
+		}
+		for i, col := range schema.Columns {
+			schema.Columns[i].Check = rename(col.Check)
+		}
+		for i, check := range schema.Checks {
+			schema.Checks[i] = rename(check)
+		}
+	}
+	return renames, nil
+}
+
+// referencedColumn returns the table and column a foreign key of the schema references, following the renames. The
+// schema references itself by its generated name, with its renamed columns. Tracked tables are referenced by their
+// names, and references to their primary key by its tracked name, whatever the generated schema called it. Other tables
+// are renamed like the schema, as they're stored after it.
+func (s *Service) referencedColumn(table, column, generated, renamed string, columns map[string]string, tracked map[string][]Column) (string, string) {
+	if table == generated {
+		if name, ok := columns[column]; ok {
+			return renamed, name
+		}
+		return renamed, column
+	}
+	for _, name := range []string{table, s.tableName(table)} {
+		cols, ok := tracked[name]
+		if !ok {
+			continue
+		}
+		if key := primaryKeyColumn(cols); key != "" && !hasColumn(cols, column) && s.primaryKeyName(table, column) {
+			return name, key
+		}
+		return name, column
+	}
+	name := s.tableName(table)
+	if s.NamingIDColumn != "" && s.primaryKeyName(table, column) {
+		return name, s.idColumn(name)
+	}
+	return name, column
+}
+
+// primaryKeyName reports whether the column is named like the primary key of the table, by any of the usual conventions.
+func (s *Service) primaryKeyName(table, column string) bool {
+	singular := singularize(snakeCase(table))
+	return strings.EqualFold(column, "id") || column == s.idColumn(table) || column == singular+"_id" ||
+		column == snakeCase(table)+"_id"
+}
+
+// primaryKeyColumn returns the column of a single-column primary key, or an empty string when there is none.
+func primaryKeyColumn(columns []Column) string {
+	var key string
+	for _, col := range columns {
+		if primaryKeyRegexp.MatchString(col.Constraints) {
+			if key != "" {
+				return ""
+			}
+			key = col.Name
+		}
+	}
+	return key
+}
+
+func hasColumn(columns []Column, name string) bool {
+	for _, col := range columns {
+		if col.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlIdentifierRegexp matches identifiers of SQL expressions, and the string literals around them, which are kept.
+var sqlIdentifierRegexp = regexp.MustCompile(`'(?:[^']|'')*'|[A-Za-z_]\w*`)
+
+// tableName returns the name of the table following the naming conventions.
+func (s *Service) tableName(name string) string {
+	name = snakeCase(strings.ReplaceAll(strings.TrimSpace(name), "-", "_"))
+	switch s.NamingTables {
+	case NamingPlural:
+		return pluralize(name)
+	case NamingSingular:
+		return singularize(name)
+	default:
+		return name
+	}
+}
+
+// idColumn returns the name of the primary key column of the table, id when it isn't configured.
+func (s *Service) idColumn(table string) string {
+	if s.NamingIDColumn == "" {
+		return "id"
+	}
+	return strings.ReplaceAll(s.NamingIDColumn, namingTablePlaceholder, singularize(snakeCase(table)))
+}
+
+// pluralize returns the plural of the snake_case name, pluralizing its last word.
+func pluralize(name string) string {
+	prefix, word := splitLastWord(name)
+	if uncountableNouns[word] {
+		return name
+	}
+	if plural, ok := irregularPlurals[word]; ok {
+		return prefix + plural
+	}
+	for _, plural := range irregularPlurals {
+		if word == plural {
+			return name
+		}
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") || strings.HasSuffix(word, "ses") || strings.HasSuffix(word, "xes") ||
+		strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") || strings.HasSuffix(word, "zes"):
+		return name
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return name
+	case strings.HasSuffix(word, "y") && len(word) > 1 && !strings.ContainsRune("aeiou", rune(word[len(word)-2])):
+		return prefix + word[:len(word)-1] + "ies"
+	case strings.HasSuffix(word, "s") || strings.HasSuffix(word, "x") || strings.HasSuffix(word, "z") ||
+		strings.HasSuffix(word, "ch") || strings.HasSuffix(word, "sh"):
+		return prefix + word + "es"
+	default:
+		return prefix + word + "s"
+	}
+}
+
+// singularize returns the singular of the snake_case name, singularizing its last word.
+func singularize(name string) string {
+	prefix, word := splitLastWord(name)
+	if uncountableNouns[word] {
+		return name
+	}
+	for singular, plural := range irregularPlurals {
+		if word == plural {
+			return prefix + singular
+		}
+	}
+	switch {
+	case strings.HasSuffix(word, "ies") && len(word) > 3:
+		return prefix + word[:len(word)-3] + "y"
+	case strings.HasSuffix(word, "sses") || strings.HasSuffix(word, "uses") || strings.HasSuffix(word, "xes") ||
+		strings.HasSuffix(word, "zes") || strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes"):
+		return prefix + word[:len(word)-2]
+	case strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return prefix + word[:len(word)-1]
+	default:
+		return name
+	}
+}
+
+// splitLastWord splits the snake_case name before its last word, keeping the underscore in the prefix.
+func splitLastWord(name string) (string, string) {
+	i := strings.LastIndex(name, "_")
+	return name[:i+1], name[i+1:]
+}
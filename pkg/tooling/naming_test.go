@@ -0,0 +1,201 @@
+package tooling
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestPluralize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"book", "books"},
+		{"books", "books"},
+		{"order_item", "order_items"},
+		{"category", "categories"},
+		{"categories", "categories"},
+		{"day", "days"},
+		{"box", "boxes"},
+		{"match", "matches"},
+		{"wish", "wishes"},
+		{"address", "addresses"},
+		{"status", "statuses"},
+		{"bus", "buses"},
+		{"person", "people"},
+		{"people", "people"},
+		{"child", "children"},
+		{"sales_man", "sales_men"},
+		{"news", "news"},
+		{"series", "series"},
+		{"user_data", "user_data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pluralize(tt.name); got != tt.want {
+				t.Errorf("pluralize(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSingularize(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"books", "book"},
+		{"book", "book"},
+		{"order_items", "order_item"},
+		{"categories", "category"},
+		{"days", "day"},
+		{"boxes", "box"},
+		{"matches", "match"},
+		{"wishes", "wish"},
+		{"addresses", "address"},
+		{"address", "address"},
+		{"statuses", "status"},
+		{"status", "status"},
+		{"buses", "bus"},
+		{"people", "person"},
+		{"children", "child"},
+		{"women", "woman"},
+		{"news", "news"},
+		{"breaking_news", "breaking_news"},
+		{"species", "species"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := singularize(tt.name); got != tt.want {
+				t.Errorf("singularize(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceNaming(t *testing.T) {
+	tests := []struct {
+		name       string
+		tables     string
+		idColumn   string
+		tracked    []artifact
+		schema     Schema
+		wantTable  string
+		wantCols   []Column
+		wantChecks []string
+	}{
+		{
+			name:   "foreign key to a table renamed like the schema",
+			tables: NamingPlural,
+			schema: Schema{TableName: "book", Columns: []Column{
+				{Name: "id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "authorId", Type: "UUID", Constraints: "NOT NULL REFERENCES author(id)"},
+			}},
+			wantTable: "books",
+			wantCols: []Column{
+				{Name: "id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "author_id", Type: "UUID", Constraints: "NOT NULL REFERENCES authors(id)"},
+			},
+		},
+		{
+			name:     "foreign key to the configured id column",
+			tables:   NamingPlural,
+			idColumn: "{table}_id",
+			schema: Schema{TableName: "book", Columns: []Column{
+				{Name: "id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "author_id", Type: "UUID", Constraints: "REFERENCES authors(id)"},
+			}},
+			wantTable: "books",
+			wantCols: []Column{
+				{Name: "book_id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "author_id", Type: "UUID", Constraints: "REFERENCES authors(author_id)"},
+			},
+		},
+		{
+			name:     "self-reference with the renamed table and key",
+			tables:   NamingPlural,
+			idColumn: "{table}_id",
+			schema: Schema{TableName: "category", Columns: []Column{
+				{Name: "id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "parentId", Type: "UUID", Constraints: "REFERENCES category(id)"},
+			}, Checks: []string{"parentId <> id"}},
+			wantTable: "categories",
+			wantCols: []Column{
+				{Name: "category_id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "parent_id", Type: "UUID", Constraints: "REFERENCES categories(category_id)"},
+			},
+			wantChecks: []string{"parent_id <> category_id"},
+		},
+		{
+			name:     "foreign key to the tracked primary key",
+			tables:   NamingPlural,
+			idColumn: "{table}_id",
+			tracked: []artifact{{Kind: artifactTable, Name: "person", Columns: []Column{
+				{Name: "pid", Type: "UUID", Constraints: "PRIMARY KEY"},
+			}}},
+			schema: Schema{TableName: "pets", Columns: []Column{
+				{Name: "pet_id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "owner_id", Type: "UUID", Constraints: "REFERENCES person(id)"},
+			}},
+			wantTable: "pets",
+			wantCols: []Column{
+				{Name: "pet_id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "owner_id", Type: "UUID", Constraints: "REFERENCES person(pid)"},
+			},
+		},
+		{
+			name:   "tracked table keeps its name and columns",
+			tables: NamingPlural,
+			tracked: []artifact{{Kind: artifactTable, Name: "invoice", Columns: []Column{
+				{Name: "ID", Type: "UUID", Constraints: "PRIMARY KEY"},
+			}}},
+			schema: Schema{TableName: "invoice", Columns: []Column{
+				{Name: "ID", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "customerId", Type: "UUID", Constraints: "REFERENCES customer(id)"},
+			}},
+			wantTable: "invoice",
+			wantCols: []Column{
+				{Name: "ID", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "customerId", Type: "UUID", Constraints: "REFERENCES customers(id)"},
+			},
+		},
+		{
+			name:   "names kept",
+			tables: NamingKeep,
+			schema: Schema{TableName: "book", Columns: []Column{
+				{Name: "id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "author_id", Type: "UUID", Constraints: "REFERENCES author(id)"},
+			}},
+			wantTable: "book",
+			wantCols: []Column{
+				{Name: "id", Type: "UUID", Constraints: "PRIMARY KEY"},
+				{Name: "author_id", Type: "UUID", Constraints: "REFERENCES author(id)"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PROJECT_ROOT", t.TempDir())
+			if len(tt.tracked) > 0 {
+				if err := trackArtifacts(tt.tracked...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := &Service{NamingTables: tt.tables, NamingIDColumn: tt.idColumn}
+			schema := tt.schema
+			if _, err := s.enforceNaming(&schema); err != nil {
+				t.Fatal(err)
+			}
+			if schema.TableName != tt.wantTable {
+				t.Errorf("table = %s, want %s", schema.TableName, tt.wantTable)
+			}
+			if !reflect.DeepEqual(schema.Columns, tt.wantCols) {
+				t.Errorf("columns = %+v, want %+v", schema.Columns, tt.wantCols)
+			}
+			if !slices.Equal(schema.Checks, tt.wantChecks) {
+				t.Errorf("checks = %q, want %q", schema.Checks, tt.wantChecks)
+			}
+		})
+	}
+}
@@ -3,10 +3,11 @@ package tooling
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/openai/openai-go"
 	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
@@ -31,9 +32,26 @@ The API should:
 - Follow OpenAPI 3.0 syntax.
 - Include proper request/response models.
 - Avoid duplicating models just for Create/Update requests (eg. when some field like ID is not needed).
+
+For file fields (images, documents, attachments):
+- Don't put the file content in the resource model, add read-only <field>_name and <field>_content_type fields instead.
+- Add POST /resources/{id}/<field> with a multipart/form-data requestBody containing a "file" property of type string
+  and format binary, responding with 204.
+- Add GET /resources/{id}/<field> responding with the file content as application/octet-stream, or 404.
+` + batchEndpointsPrompt
+	batchEndpointsPrompt = `
+When the user asks for bulk operations or high-volume ingestion of a resource, also add:
+- POST /resources:batch: Create or update up to 1000 resources at once. The requestBody is an object with an "items"
+  array of the resource model (items with an ID are updated, the others are created) and there is an optional "atomic"
+  boolean query parameter. Respond with 200 when all items succeeded or 207 when some of them failed, both with an
+  object containing a "results" array of {index, id, error} objects, and with 422 (same body) when atomic is set and any
+  item failed.
 `
 )
 
+// maxSpecFixAttempts limits the number of times the generated OpenAPI spec is repaired after failing validation.
+const maxSpecFixAttempts = 3
+
 const GenerateOpenAPISpecToolName = "generate_openapi_spec"
 
 func (s *Service) GenerateOpenAPISpecTool() openai.ChatCompletionToolParam {
@@ -48,6 +66,14 @@ func (s *Service) GenerateOpenAPISpecTool() openai.ChatCompletionToolParam {
 					"user_input": map[string]string{
 						"type": "string",
 					},
+					"entity": map[string]string{
+						"type":        "string",
+						"description": "Name of the only entity to generate the spec for, when the project is built entity by entity. Its spec is merged into the spec of the project.",
+					},
+					"replace": map[string]string{
+						"type":        "boolean",
+						"description": "Replace the spec of the project instead of merging the generated spec into it, e.g. when entities were renamed or removed.",
+					},
 				},
 				"required": []string{"user_input"},
 			}),
@@ -64,31 +90,100 @@ func (s *Service) GenerateOpenAPISpec(ctx context.Context, multi *pterm.MultiPri
 		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
 	}
 	userInput := args["user_input"].(string)
+	entity, _ := args["entity"].(string)
+	if s.BulkEndpoints {
+		userInput += "\n\nInclude batch endpoints for every resource."
+	}
+	if s.MultiTenant {
+		userInput += "\n\n" + tenantFieldNote
+	}
+	if s.APIVersion != "" {
+		userInput += fmt.Sprintf("\n\nThis is version %[1]s of the API, set the server URL to /%[1]s (paths are relative to it).", s.APIVersion)
+	}
+
+	prompt := generateOpenAPISpecPrompt
+	analysis, err := loadAnalysis()
+	if err != nil {
+		return fmt.Sprintf("Failed to load analysis of the project: %v", err)
+	}
+	if analysis != nil && len(analysis.Routes) > 0 {
+		prompt += analyzeSpecPrompt
+		userInput += "\n\nThe endpoints of the project are:\n" + analysis.routeList()
+	}
+	// Generated specs are merged into the spec of the project, so resources generated before are kept.
+	var projectSpec string
+	if replace, _ := args["replace"].(bool); !replace {
+		content, err := os.ReadFile(specPath())
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Sprintf("Failed to read spec of the project: %v", err)
+		}
+		// The header is added again when the merged spec is written.
+		projectSpec = stripFileHeader(specPath(), string(content))
+	}
+	if entity != "" {
+		prompt += entitySpecPrompt
+		userInput += fmt.Sprintf("\n\nGenerate the spec only for the %s resource.", entity)
+	} else if projectSpec != "" {
+		prompt += mergeSpecPrompt
+	}
+	if projectSpec != "" {
+		userInput += fmt.Sprintf("\n\nThe spec of the project is:\n```yaml\n%s\n```", projectSpec)
+	}
 
 	log.Debug().Msgf("Creating spec for question: %s", userInput)
-	agent := s.Agent(generateOpenAPISpecPrompt, userInput).
+	agent := s.Agent(prompt, userInput).
 		WithTools(s.QueryMemoryTool()).
 		WithModel(s.ChatModel)
 
-	spec := agent.Run(ctx)
+	// The spec is validated before it's saved, as the schema and code generation steps fail on broken specs in ways
+	// that are much harder to repair.
+	spec := TrimNonCode(agent.Run(ctx), "yaml")
+	var merged string
+	for attempt := 0; ; attempt++ {
+		var err error
+		// Generated specs are validated merged into the spec of the project, as they reference other resources.
+		merged = spec
+		if projectSpec != "" {
+			merged, err = mergeSpec(projectSpec, spec)
+		}
+		if err == nil {
+			err = validateOpenAPISpec(ctx, merged)
+		}
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return fmt.Sprintf("Failed to generate OpenAPI spec: %v", ctx.Err())
+		}
+		if attempt == maxSpecFixAttempts {
+			return fmt.Sprintf("The generated OpenAPI spec is still invalid after %d repair attempts, so it wasn't saved:\n%v", attempt, err)
+		}
+		log.Debug().Msgf("Repairing OpenAPI spec, attempt %d: %v", attempt+1, err)
+		showProgress(ctx, "repairing the spec (attempt %d of %d)", attempt+1, maxSpecFixAttempts)
+		spec = TrimNonCode(agent.Continue(ctx, fmt.Sprintf(
+			"The spec is not a valid OpenAPI 3.0 spec. Fix the errors and respond with the whole corrected spec:\n%v", err)), "yaml")
+	}
 
-	if err := createBoilerPlate(); err != nil {
+	if err := s.createBoilerPlate(ctx); err != nil {
 		return fmt.Sprintf("Failed to create boilerplate: %v", err)
 	}
 
-	docDir := path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "api", "doc")
-	fh, err := os.Create(path.Join(docDir, "openapi.yaml"))
-	if err != nil {
-		return fmt.Sprintf("Failed to create openapi spec file: %v", err)
+	if err := s.replaceFile(specPath(), merged); err != nil {
+		return fmt.Sprintf("Failed to write openapi spec file: %v", err)
+	}
+	if err := s.writeAPICollection(); err != nil {
+		log.Err(err).Msg("Failed to write API collection")
 	}
-	defer fh.Close()
 
-	spec = TrimNonCode(spec, "yaml")
+	return spec
+}
 
-	_, err = fh.WriteString(spec)
+// validateOpenAPISpec parses the spec and validates it against the OpenAPI 3 specification.
+func validateOpenAPISpec(ctx context.Context, spec string) error {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData([]byte(spec))
 	if err != nil {
-		return fmt.Sprintf("Failed to write openapi spec file: %v", err)
+		return fmt.Errorf("failed to parse spec: %w", err)
 	}
-
-	return spec
+	return doc.Validate(ctx)
 }
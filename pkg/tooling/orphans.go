@@ -0,0 +1,270 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+// referenceFileExts are extensions of project files whose references keep tables in use.
+var referenceFileExts = []string{".go", ".yaml", ".yml", ".graphqls", ".graphql"}
+
+const ReconcileArtifactsToolName = "reconcile_artifacts"
+
+func (s *Service) ReconcileArtifactsTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(ReconcileArtifactsToolName),
+			Description: openai.String("Lists orphaned artifacts, i.e. tables and files generated for the project which are no longer referenced, e.g. after entities were renamed or removed. Deletes them when delete is set."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"delete": map[string]string{
+						"type":        "boolean",
+						"description": "Drop the orphaned tables and delete the orphaned files. Set it only after the user confirmed it.",
+					},
+				},
+			}),
+		}),
+	}
+}
+
+func (s *Service) ReconcileArtifacts(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	del, _ := args["delete"].(bool)
+
+	orphans, err := findOrphans()
+	if err != nil {
+		return fmt.Sprintf("Failed to find orphaned artifacts: %v", err)
+	}
+	if len(orphans) == 0 {
+		return "No orphaned artifacts found"
+	}
+	var list []string
+	for _, a := range orphans {
+		list = append(list, fmt.Sprintf("%s %s", a.Kind, a.Name))
+	}
+	if !del {
+		return fmt.Sprintf("Orphaned artifacts:\n%s\nAsk the user whether to delete them, and delete them with the %s tool if they agree.",
+			strings.Join(list, "\n"), ReconcileArtifactsToolName)
+	}
+
+	if err := s.deleteArtifacts(ctx, orphans); err != nil {
+		return fmt.Sprintf("Failed to delete orphaned artifacts: %v", err)
+	}
+	return fmt.Sprintf("Deleted orphaned artifacts:\n%s", strings.Join(list, "\n"))
+}
+
+// findOrphans returns the tracked artifacts which are no longer referenced. Tables are orphaned when no code or spec of
+// the project mentions them, files generated for a table together with the table, and other Go files when none of
+// their declarations is used by the rest of the project. Tracked files which were deleted meanwhile are untracked, and
+// files whose generated marker was removed are hand-written now.
+func findOrphans() ([]artifact, error) {
+	tracked, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+	rootDir := os.Getenv("PROJECT_ROOT")
+	if rootDir == "" {
+		rootDir = "."
+	}
+	sources, idents, err := projectReferences(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	orphanedTables := make(map[string]bool)
+	for _, a := range tracked {
+		if a.Kind != artifactTable {
+			continue
+		}
+		word := regexp.MustCompile(`\b` + regexp.QuoteMeta(a.Name) + `\b`)
+		referenced := false
+		for _, src := range sources {
+			referenced = referenced || word.MatchString(src)
+		}
+		orphanedTables[a.Name] = !referenced
+	}
+
+	var orphans, missing []artifact
+	for _, a := range tracked {
+		if a.Kind == artifactTable {
+			if orphanedTables[a.Name] {
+				orphans = append(orphans, a)
+			}
+			continue
+		}
+		name := filepath.Join(rootDir, a.Name)
+		content, err := os.ReadFile(name)
+		if errors.Is(err, os.ErrNotExist) {
+			missing = append(missing, a)
+			continue
+		}
+		// Files whose generated marker was removed were taken over by hand, and are never orphaned.
+		if err == nil && headerMarker && fileHeader(a.Name, "") != "" && !generatedFile(a.Name, string(content)) {
+			continue
+		}
+		if a.Table != "" {
+			if orphanedTables[a.Table] {
+				orphans = append(orphans, a)
+			}
+			continue
+		}
+		if filepath.Ext(a.Name) == ".go" && !goFileReferenced(name, idents) {
+			orphans = append(orphans, a)
+		}
+	}
+	if len(missing) > 0 {
+		if err := untrackArtifacts(missing...); err != nil {
+			return nil, err
+		}
+	}
+	return orphans, nil
+}
+
+// projectReferences returns the contents of the project files tables may be referenced by, keyed by path, and the
+// identifiers used by every Go file.
+func projectReferences(rootDir string) (map[string]string, map[string]map[string]bool, error) {
+	sources := make(map[string]string)
+	idents := make(map[string]map[string]bool)
+	err := filepath.WalkDir(rootDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// Migrations keep creating orphaned tables, so they don't count as references.
+			if name != rootDir && (strings.HasPrefix(d.Name(), ".") || d.Name() == "migrations" || d.Name() == "vendor") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !slices.Contains(referenceFileExts, filepath.Ext(name)) {
+			return nil
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		sources[name] = string(content)
+		if filepath.Ext(name) != ".go" {
+			return nil
+		}
+		file, err := parser.ParseFile(token.NewFileSet(), name, content, parser.SkipObjectResolution)
+		if err != nil {
+			return nil
+		}
+		idents[name] = make(map[string]bool)
+		ast.Inspect(file, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				idents[name][ident.Name] = true
+			}
+			return true
+		})
+		return nil
+	})
+	return sources, idents, err
+}
+
+// goFileReferenced reports whether other Go files of the project use any declaration of the file. Files which don't
+// parse, files without declarations, like go:generate directives, and files of main functions, init functions, or tests
+// are considered referenced.
+func goFileReferenced(name string, idents map[string]map[string]bool) bool {
+	if strings.HasSuffix(name, "_test.go") {
+		return true
+	}
+	file, err := parser.ParseFile(token.NewFileSet(), name, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return true
+	}
+	var declared []string
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil && (d.Name.Name == "main" || d.Name.Name == "init") {
+				return true
+			}
+			declared = append(declared, d.Name.Name)
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch sp := spec.(type) {
+				case *ast.TypeSpec:
+					declared = append(declared, sp.Name.Name)
+				case *ast.ValueSpec:
+					for _, n := range sp.Names {
+						if n.Name != "_" {
+							declared = append(declared, n.Name)
+						}
+					}
+				}
+			}
+		}
+	}
+	if len(declared) == 0 {
+		return true
+	}
+	for other, used := range idents {
+		if other == name {
+			continue
+		}
+		for _, d := range declared {
+			if used[d] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deleteArtifacts drops the tables, with their live updates triggers, and deletes the files, untracking them.
+func (s *Service) deleteArtifacts(ctx context.Context, artifacts []artifact) error {
+	var statements []string
+	for _, a := range artifacts {
+		if a.Kind != artifactTable {
+			continue
+		}
+		if !identifierRegexp.MatchString(a.Name) {
+			return fmt.Errorf("invalid table name %q", a.Name)
+		}
+		statements = append(statements, fmt.Sprintf("DROP TABLE IF EXISTS %s", a.Name),
+			fmt.Sprintf("DROP FUNCTION IF EXISTS notify_%s_changes()", a.Name))
+	}
+	if len(statements) > 0 {
+		if err := s.execDDL(ctx, statements, false); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	for _, a := range artifacts {
+		if a.Kind == artifactTable {
+			s.LiveUpdates = slices.DeleteFunc(s.LiveUpdates, func(table string) bool { return table == a.Name })
+		}
+	}
+	s.mu.Unlock()
+
+	for _, a := range artifacts {
+		if a.Kind != artifactFile {
+			continue
+		}
+		if err := os.Remove(filepath.Join(os.Getenv("PROJECT_ROOT"), a.Name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to delete %s: %w", a.Name, err)
+		}
+	}
+	return untrackArtifacts(artifacts...)
+}
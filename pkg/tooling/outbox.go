@@ -0,0 +1,119 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	EventBrokerKafka = "kafka"
+	EventBrokerNATS  = "nats"
+)
+
+// eventBrokerPackages are added to the generated project depending on the message broker.
+var eventBrokerPackages = map[string]string{
+	EventBrokerKafka: "github.com/segmentio/kafka-go@v0.4.47",
+	EventBrokerNATS:  "github.com/nats-io/nats.go@v1.39.1",
+}
+
+const (
+	outboxTableSQL = `CREATE TABLE IF NOT EXISTS outbox (
+	id BIGSERIAL PRIMARY KEY,
+	topic TEXT NOT NULL,
+	key TEXT NOT NULL,
+	payload JSONB NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	published_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unpublished_idx ON outbox (id) WHERE published_at IS NULL`
+
+	eventPublishingPrompt = `
+## Event publishing
+
+Other services react to changes of the resources through events published with the transactional outbox pattern,
+using the outbox package (already generated):
+
+- Every create, update, and delete runs in a single transaction (BeginTxx) together with
+  outbox.Write(ctx, tx, "<resource>.<created|updated|deleted>", id, payload), so the event is published if and only if
+  the change is committed.
+- The payload is the resource after the change, or just its id when it's deleted.
+- Never publish events directly, the relay worker delivers them from the outbox table.
+`
+)
+
+const GenerateEventPublishingToolName = "generate_event_publishing"
+
+func (s *Service) GenerateEventPublishingTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateEventPublishingToolName),
+			Description: openai.String("Generates event publishing (Kafka or NATS) with a transactional outbox table and a relay worker, so changes of resources are published as events. Use it before generating code when other services need to react to changes."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"broker": map[string]interface{}{
+						"type": "string",
+						"enum": []string{EventBrokerKafka, EventBrokerNATS},
+					},
+				},
+				"required": []string{"broker"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) GenerateEventPublishing(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	broker, _ := args["broker"].(string)
+	pkg, ok := eventBrokerPackages[broker]
+	if !ok {
+		return fmt.Sprintf("Unsupported broker %q, use %s or %s", broker, EventBrokerKafka, EventBrokerNATS)
+	}
+
+	if _, err := s.DB.ExecContext(ctx, outboxTableSQL); err != nil {
+		return fmt.Sprintf("Failed to create outbox table: %v", err)
+	}
+	if err := s.writeMigrationOnce("outbox", outboxTableSQL); err != nil {
+		return fmt.Sprintf("Failed to save outbox migration: %v", err)
+	}
+
+	rootDir := os.Getenv("PROJECT_ROOT")
+	outboxDir := path.Join(rootDir, "pkg", "outbox")
+	if err := s.writeFile(path.Join(outboxDir, "outbox.go"), outboxGo); err != nil {
+		return fmt.Sprintf("Failed to save outbox package: %v", err)
+	}
+	publishers := map[string]string{EventBrokerKafka: outboxKafkaGo, EventBrokerNATS: outboxNATSGo}
+	for name, code := range publishers {
+		file := path.Join(outboxDir, name+".go")
+		if name != broker {
+			// Drop the publisher of previously chosen broker.
+			_ = os.Remove(file)
+			continue
+		}
+		if err := s.writeFile(file, code); err != nil {
+			return fmt.Sprintf("Failed to save %s publisher: %v", name, err)
+		}
+	}
+	if err := goGet(ctx, rootDir, pkg); err != nil {
+		return fmt.Sprintf("Failed to add %s client dependency: %v", broker, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.EventBroker = broker
+	if err := s.writeAppFiles(rootDir); err != nil {
+		return fmt.Sprintf("Failed to wire outbox relay into the server: %v", err)
+	}
+
+	return fmt.Sprintf("Outbox table and %s publishing generated, code generation will write events to the outbox", broker)
+}
@@ -0,0 +1,277 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/rs/zerolog/log"
+)
+
+const ApplyPatchToolName = "apply_patch"
+
+func (s *Service) ApplyPatchTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(ApplyPatchToolName),
+			Description: openai.String("Applies a unified diff to files of the project, with paths relative to the project root (e.g. --- a/pkg/api/server.go). Either all hunks apply or none, conflicting hunks are reported. Prefer it over saving whole files for incremental fixes."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"patch": map[string]string{
+						"type": "string",
+					},
+				},
+				"required": []string{"patch"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) ApplyPatch(_ context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	patch, _ := args["patch"].(string)
+	patch = TrimNonCode(patch, "diff")
+
+	files, err := parsePatch(patch)
+	if err != nil {
+		return fmt.Sprintf("Invalid patch: %v", err)
+	}
+
+	// All files are patched in memory first, so a conflict in any of them leaves the project untouched.
+	rootDir := os.Getenv("PROJECT_ROOT")
+	patched := make(map[string]string)
+	var errs []error
+	for _, f := range files {
+		name := filepath.Join(rootDir, f.path)
+		var content string
+		if !f.isNew {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.path, err))
+				continue
+			}
+			content = string(data)
+			if strings.HasSuffix(f.path, ".go") && isGeneratedGo(content) {
+				errs = append(errs, fmt.Errorf("%s is generated, regenerate it instead of patching it", f.path))
+				continue
+			}
+		}
+		if f.isDeleted {
+			patched[name] = ""
+			continue
+		}
+		content, err := f.apply(content)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", f.path, err))
+			continue
+		}
+		if strings.HasSuffix(f.path, ".go") {
+			if content, err = formatGo(name, content); err == nil {
+				err = s.checkSQL(content)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", f.path, err))
+				continue
+			}
+		}
+		patched[name] = content
+	}
+	if len(errs) > 0 {
+		return fmt.Sprintf("Patch rejected, nothing was changed. Fix the following issues and apply it again:\n%v", errors.Join(errs...))
+	}
+
+	var changed []string
+	for _, f := range files {
+		name := filepath.Join(rootDir, f.path)
+		if f.isDeleted {
+			if err := os.Remove(name); err != nil {
+				return fmt.Sprintf("Failed to delete %s: %v", f.path, err)
+			}
+		} else if err := s.writeEditedFile(name, patched[name]); err != nil {
+			return fmt.Sprintf("Failed to save %s: %v", f.path, err)
+		}
+		changed = append(changed, f.path)
+	}
+	var deleted []artifact
+	for _, f := range files {
+		if f.isDeleted {
+			deleted = append(deleted, artifact{Kind: artifactFile, Name: filepath.ToSlash(f.path)})
+		}
+	}
+	if len(deleted) > 0 {
+		if err := untrackArtifacts(deleted...); err != nil {
+			log.Err(err).Msg("Failed to untrack deleted files")
+		}
+	}
+
+	return fmt.Sprintf("Patch applied to %s", strings.Join(changed, ", "))
+}
+
+// filePatch is the part of a unified diff changing a single file.
+type filePatch struct {
+	path      string
+	isNew     bool
+	isDeleted bool
+	hunks     []hunk
+}
+
+type hunk struct {
+	header string
+	// oldStart is the 1-based line the hunk starts at in the original file.
+	oldStart int
+	// lines of the hunk prefixed with ' ', '-', or '+'.
+	lines []string
+}
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,\d+)? \+\d+(?:,\d+)? @@`)
+
+// parsePatch parses a unified diff, as produced by diff -u or git diff.
+func parsePatch(patch string) ([]*filePatch, error) {
+	var files []*filePatch
+	var f *filePatch
+	var h *hunk
+	// Editors and models often strip the leading space of empty context lines, so empty lines are context, unless
+	// they trail the hunk.
+	blank := 0
+	lines := strings.Split(patch, "\n")
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			oldPath, newPath := patchPath(line[4:]), patchPath(lines[i+1][4:])
+			f = &filePatch{path: newPath, isNew: oldPath == "/dev/null", isDeleted: newPath == "/dev/null"}
+			if f.isDeleted {
+				f.path = oldPath
+			}
+			if !filepath.IsLocal(f.path) {
+				return nil, fmt.Errorf("path %q isn't relative to the project root", f.path)
+			}
+			files = append(files, f)
+			h = nil
+			blank = 0
+			i++
+		case strings.HasPrefix(line, "@@"):
+			if f == nil {
+				return nil, fmt.Errorf("hunk %q without file header", line)
+			}
+			m := hunkHeaderRegexp.FindStringSubmatch(line)
+			if m == nil {
+				return nil, fmt.Errorf("invalid hunk header %q", line)
+			}
+			start, _ := strconv.Atoi(m[1])
+			f.hunks = append(f.hunks, hunk{header: line, oldStart: start})
+			h = &f.hunks[len(f.hunks)-1]
+			blank = 0
+		case h != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")):
+			for ; blank > 0; blank-- {
+				h.lines = append(h.lines, " ")
+			}
+			h.lines = append(h.lines, line)
+		case h != nil && line == "":
+			blank++
+		}
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no file headers (--- a/file, +++ b/file) found")
+	}
+	return files, nil
+}
+
+// patchPath returns the path of a file header, without the a/ or b/ prefix and timestamp.
+func patchPath(header string) string {
+	p, _, _ := strings.Cut(header, "\t")
+	p = strings.TrimSpace(p)
+	if p == "/dev/null" {
+		return p
+	}
+	if rest, ok := strings.CutPrefix(p, "a/"); ok {
+		return rest
+	}
+	return strings.TrimPrefix(p, "b/")
+}
+
+// apply applies the hunks to the content. Hunks are matched by their context and removed lines, at the line from the
+// header or, when the file moved on, at the nearest line they match.
+func (f *filePatch) apply(content string) (string, error) {
+	lines := strings.Split(content, "\n")
+	if content == "" {
+		lines = nil
+	}
+	var errs []error
+	offset := 0
+	for _, h := range f.hunks {
+		var old, replacement []string
+		for _, line := range h.lines {
+			switch line[0] {
+			case ' ':
+				old = append(old, line[1:])
+				replacement = append(replacement, line[1:])
+			case '-':
+				old = append(old, line[1:])
+			case '+':
+				replacement = append(replacement, line[1:])
+			}
+		}
+
+		at := findLines(lines, old, max(h.oldStart-1+offset, 0))
+		if at < 0 {
+			errs = append(errs, fmt.Errorf("conflict in hunk %s, its context and removed lines don't match the file", h.header))
+			continue
+		}
+		lines = append(lines[:at], append(replacement, lines[at+len(old):]...)...)
+		offset += len(replacement) - len(old)
+	}
+	if len(errs) > 0 {
+		return "", errors.Join(errs...)
+	}
+	content = strings.Join(lines, "\n")
+	if f.isNew && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	return content, nil
+}
+
+// findLines returns the index the lines are found at in the file, nearest to the expected index, or -1.
+func findLines(file, lines []string, expected int) int {
+	matches := func(at int) bool {
+		if at < 0 || at+len(lines) > len(file) {
+			return false
+		}
+		for i, line := range lines {
+			if strings.TrimRight(file[at+i], " \t") != strings.TrimRight(line, " \t") {
+				return false
+			}
+		}
+		return true
+	}
+	for distance := 0; distance <= max(expected, len(file)); distance++ {
+		if matches(expected - distance) {
+			return expected - distance
+		}
+		if matches(expected + distance) {
+			return expected + distance
+		}
+	}
+	return -1
+}
+
+// isGeneratedGo reports whether the Go code is generated, e.g. by oapi-codegen or gqlgen.
+func isGeneratedGo(code string) bool {
+	file, err := parser.ParseFile(token.NewFileSet(), "", code, parser.PackageClauseOnly|parser.ParseComments)
+	return err == nil && ast.IsGenerated(file)
+}
@@ -0,0 +1,99 @@
+package tooling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/openai/openai-go"
+
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// planFile is the plan of the project written in plan-first mode, relative to the project root.
+const planFile = "PLAN.md"
+
+const WritePlanToolName = "write_plan"
+
+// planningTools may be used before the plan is approved, as they don't change the project.
+var planningTools = []string{WritePlanToolName, ListTablesToolName, QueryKnowledgeBaseToolName, QueryMemoryToolName,
+	AnalyzeProjectToolName}
+
+func (s *Service) WritePlanTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(WritePlanToolName),
+			Description: openai.String("Saves the plan of the project to PLAN.md for the user to review before anything is generated."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"plan": map[string]string{
+						"type":        "string",
+						"description": "The plan in Markdown with sections for entities and their fields, endpoints (or GraphQL queries and mutations), tables with their columns and relations, and files to be generated.",
+					},
+				},
+				"required": []string{"plan"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) WritePlan(arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	plan, _ := args["plan"].(string)
+	if plan == "" {
+		return "Invalid plan: it's empty"
+	}
+
+	if err := s.writeFile(filepath.Join(os.Getenv("PROJECT_ROOT"), planFile), TrimNonCode(plan, "markdown")+"\n"); err != nil {
+		return fmt.Sprintf("Failed to save %s: %v", planFile, err)
+	}
+	return fmt.Sprintf("Plan saved to %s. Ask the user to review it and either approve it by typing /approve, or tell what to change.", planFile)
+}
+
+// CheckPlanApproved fails when the workflow has a plan step which isn't approved yet, unless the tool only helps
+// writing the plan.
+func (s *Service) CheckPlanApproved(tool string) error {
+	if s.Workflow == nil || slices.Contains(planningTools, tool) {
+		return nil
+	}
+	for _, step := range s.Workflow.Steps() {
+		if slices.Contains(step.Tools, WritePlanToolName) && s.Workflow.Status(step.Name) != workflow.StatusCompleted {
+			return fmt.Errorf("the plan isn't approved yet, write it with %s tool and wait for the user to approve it", WritePlanToolName)
+		}
+	}
+	return nil
+}
+
+// withPlan adds a step writing the plan, approved by the user, between agreeing on the entities and the steps
+// requiring them.
+func withPlan(def workflow.Definition) workflow.Definition {
+	steps := make([]workflow.Step, 0, len(def.Steps)+1)
+	for _, step := range def.Steps {
+		step.Requires = slices.Clone(step.Requires)
+		for i, req := range step.Requires {
+			if req == "entities" {
+				step.Requires[i] = "plan"
+			}
+		}
+		steps = append(steps, step)
+		if step.Name == "entities" {
+			steps = append(steps, workflow.Step{
+				Name:        "plan",
+				Description: "Write a plan of the project (entities, endpoints, tables, and files to be generated) and wait for the user to approve it.",
+				Tools:       []string{WritePlanToolName},
+				Requires:    []string{"entities"},
+				Artifacts:   []string{planFile},
+				Approval:    true,
+			})
+		}
+	}
+	def.Steps = steps
+	return def
+}
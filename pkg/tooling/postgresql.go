@@ -4,7 +4,10 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/openai/openai-go"
 	"github.com/pterm/pterm"
@@ -14,20 +17,22 @@ import (
 const (
 	generateSchemaPrompt = `You are an AI assistant that helps generate PostgreSQL schemas. Your workflow is as follows:
 
-1. Generate a PostgreSQL schema based on an OpenAPI 3.0 specification.
-2. Store the generated schema in a PostgreSQL database using "store_schema" tool.
+1. Generate a PostgreSQL schema based on an OpenAPI 3.0 specification or a GraphQL schema.
+2. Store the generated schema in a PostgreSQL database using "store_schema" tool. The schema is checked before it's
+   applied, if it's rejected, fix the reported errors and store it again.
 
 ## Generating a PostgreSQL Schema
 
-Based on given OpenAPI 3.0 spec, generate a PostgreSQL schema in a structured JSON format. The response must strictly
+Based on given OpenAPI 3.0 spec or GraphQL schema, generate a PostgreSQL schema in a structured JSON format. The response must strictly
 follow this format:
 
 {
     "table_name": "<table_name>",
     "columns": [
-        {"name": "<column_name>", "type": "<SQL_data_type>", "constraints": "<constraints_if_any>"},
+        {"name": "<column_name>", "type": "<SQL_data_type>", "constraints": "<constraints_if_any>", "enum": ["<value>", ...], "check": "<check_expression>"},
         ...
-    ]
+    ],
+    "checks": ["<table_check_expression>", ...]
 }
 
 - Ensure every table has a PRIMARY KEY.
@@ -37,7 +42,14 @@ follow this format:
 - Set NOT NULL for required fields.
 - Use UNIQUE constraints when necessary.
 - Do NOT include CREATE TABLE statements, only structured JSON output.
-- Do NOT add any additional fields that are not present in the OpenAPI spec (e.g., created_at, updated_at).
+- Do NOT add any additional fields that are not present in the API spec (e.g., created_at, updated_at).
+- For enum fields, use TEXT data type and list the allowed values in "enum".
+- For fields with minimum, maximum, minLength, maxLength, or pattern, set "check" to a SQL expression on the column
+  (e.g., "price >= 0", "char_length(name) BETWEEN 1 AND 100", "code ~ '^[A-Z]{3}$'").
+- Use "checks" for invariants involving multiple columns (e.g., "start_date <= end_date").
+- Omit "enum", "check", and "checks" when they don't apply, and don't repeat them in "constraints".
+- When entities changed, store the whole new schema of their tables only. Tables stored before are altered to it, other
+  tables are left as they are.
 `
 )
 
@@ -60,15 +72,15 @@ func (s *Service) GenerateSchemaTool() openai.ChatCompletionToolParam {
 		Type: openai.F(openai.ChatCompletionToolTypeFunction),
 		Function: openai.F(openai.FunctionDefinitionParam{
 			Name:        openai.String(GenerateSchemaToolName),
-			Description: openai.String("Generates a PostgreSQL schema in JSON format based on OpenAPI 3.0 specification."),
+			Description: openai.String("Generates a PostgreSQL schema in JSON format based on OpenAPI 3.0 specification or GraphQL schema."),
 			Parameters: openai.F(openai.FunctionParameters{
 				"type": "object",
 				"properties": map[string]interface{}{
-					"openapi_spec": map[string]string{
+					"api_spec": map[string]string{
 						"type": "string",
 					},
 				},
-				"required": []string{"openapi_spec"},
+				"required": []string{"api_spec"},
 			}),
 		}),
 	}
@@ -81,7 +93,7 @@ func (s *Service) StoreSchemaTool() openai.ChatCompletionToolParam {
 		Type: openai.F(openai.ChatCompletionToolTypeFunction),
 		Function: openai.F(openai.FunctionDefinitionParam{
 			Name:        openai.String("store_schema"),
-			Description: openai.String("Takes generated schema in JSON format and creates a new PostgreSQL table."),
+			Description: openai.String("Takes generated schema in JSON format and creates a new PostgreSQL table, or alters the table to the schema when it was stored before."),
 			Parameters: openai.F(openai.FunctionParameters{
 				"type": "object",
 				"properties": map[string]interface{}{
@@ -98,7 +110,7 @@ func (s *Service) StoreSchemaTool() openai.ChatCompletionToolParam {
 func (s *Service) ListTables(ctx context.Context) string {
 	tables := make([]string, 0)
 	if err := s.DB.SelectContext(ctx, &tables, "SELECT tablename FROM pg_tables WHERE schemaname = 'public'"); err != nil {
-		log.Fatal().Err(err).Msg("Failed to query database")
+		return fmt.Sprintf("Failed to query database: %v", err)
 	}
 
 	return strings.Join(tables, ", ")
@@ -112,9 +124,12 @@ func (s *Service) GenerateSchema(ctx context.Context, multi *pterm.MultiPrinter,
 	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
 		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
 	}
-	openAPISpec := args["openapi_spec"].(string)
+	apiSpec, _ := args["api_spec"].(string)
+	if apiSpec == "" {
+		return "Failed to generate schema: missing api_spec argument"
+	}
 
-	agent := s.Agent(generateSchemaPrompt, openAPISpec).
+	agent := s.Agent(generateSchemaPrompt+s.namingPrompt(), apiSpec).
 		WithTools(s.ListTablesTool(), s.StoreSchemaTool()).
 		WithModel(s.ChatModel)
 
@@ -124,12 +139,32 @@ func (s *Service) GenerateSchema(ctx context.Context, multi *pterm.MultiPrinter,
 type Schema struct {
 	TableName string   `json:"table_name"`
 	Columns   []Column `json:"columns"`
+	Checks    []string `json:"checks,omitempty"`
 }
 
 type Column struct {
-	Name        string `json:"name"`
-	Type        string `json:"type"`
-	Constraints string `json:"constraints"`
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Constraints string   `json:"constraints"`
+	Enum        []string `json:"enum,omitempty"`
+	Check       string   `json:"check,omitempty"`
+}
+
+// definition returns the column definition, with the allowed values of enums and the check enforced by CHECK
+// constraints.
+func (c Column) definition() string {
+	def := fmt.Sprintf("%s %s %s", c.Name, c.Type, c.Constraints)
+	if len(c.Enum) > 0 {
+		values := make([]string, len(c.Enum))
+		for i, value := range c.Enum {
+			values[i] = "'" + strings.ReplaceAll(value, "'", "''") + "'"
+		}
+		def += fmt.Sprintf(" CHECK (%s IN (%s))", c.Name, strings.Join(values, ", "))
+	}
+	if c.Check != "" {
+		def += fmt.Sprintf(" CHECK (%s)", c.Check)
+	}
+	return def
 }
 
 func (s *Service) StoreSchema(ctx context.Context, arguments string) string {
@@ -144,18 +179,138 @@ func (s *Service) StoreSchema(ctx context.Context, arguments string) string {
 		return fmt.Sprintf("Failed to unmarshal json schema: %v", err)
 	}
 
+	for _, check := range schemaObj.Checks {
+		if strings.Contains(check, ";") {
+			return fmt.Sprintf("Invalid check %q, it must be a single SQL expression", check)
+		}
+	}
+	for _, col := range schemaObj.Columns {
+		if strings.Contains(col.Check, ";") {
+			return fmt.Sprintf("Invalid check %q of %s column, it must be a single SQL expression", col.Check, col.Name)
+		}
+	}
+
+	renames, err := s.enforceNaming(&schemaObj)
+	if err != nil {
+		return fmt.Sprintf("Failed to enforce naming conventions: %v", err)
+	}
+	var renamed string
+	if len(renames) > 0 {
+		renamed = fmt.Sprintf(", renamed %s to follow the naming conventions", strings.Join(renames, ", "))
+	}
+
+	var constraints []string
+	var index string
+	if s.MultiTenant {
+		constraints, index = scopeToTenant(&schemaObj)
+	}
+	for _, check := range schemaObj.Checks {
+		constraints = append(constraints, fmt.Sprintf("CHECK (%s)", check))
+	}
+
 	query := fmt.Sprintf("CREATE TABLE %s (", schemaObj.TableName)
 	for i, col := range schemaObj.Columns {
-		query += fmt.Sprintf("%s %s %s", col.Name, col.Type, col.Constraints)
+		query += col.definition()
 		if i < len(schemaObj.Columns)-1 {
 			query += ", "
 		}
 	}
+	for _, constraint := range constraints {
+		query += ", " + constraint
+	}
 	query += ")"
 
-	if _, err := s.DB.ExecContext(ctx, query); err != nil {
-		return fmt.Sprintf("Failed to create table: %v", err)
+	statements := []string{query}
+	if index != "" {
+		statements = append(statements, index)
+	}
+	ddl := strings.Join(statements, ";\n\n")
+
+	// Tables stored before are altered to the changed schema, keeping their data and the migrations applied already.
+	action := "create"
+	var lost []string
+	old, exists, err := trackedArtifact(artifactTable, schemaObj.TableName)
+	if err != nil {
+		return fmt.Sprintf("Failed to read %s: %v", manifestFile, err)
+	}
+	if exists && old.Columns != nil {
+		action = "alter"
+		names, err := s.constraintNames(ctx, schemaObj.TableName)
+		if err != nil {
+			return fmt.Sprintf("Failed to alter table: %v", err)
+		}
+		statements, lost, err = alterTable(schemaObj.TableName, old, schemaObj.Columns, constraints, names)
+		if err != nil {
+			return fmt.Sprintf("Schema rejected: %v", err)
+		}
+		if len(statements) == 0 {
+			return fmt.Sprintf("Table %s is up to date, its schema didn't change%s", schemaObj.TableName, renamed)
+		}
+		if index != "" {
+			statements = append(statements, index)
+		}
+	}
+
+	if err := parseDDL(statements); err != nil {
+		return fmt.Sprintf("Schema rejected, fix the following syntax errors and store it again:\n%v", err)
+	}
+	if err := s.execDDL(ctx, statements, true); err != nil {
+		return fmt.Sprintf("Schema rejected by a dry run in a rolled back transaction, fix it and store it again:\n%v", err)
+	}
+	// Columns which are removed, or whose constraints can't be altered in place, lose their data, which the user has to
+	// approve first.
+	if len(lost) > 0 {
+		question := fmt.Sprintf("Alter table %s, losing the data of columns %s?\n%s", schemaObj.TableName,
+			strings.Join(lost, ", "), strings.Join(statements, ";\n"))
+		if reason := s.confirm(question); reason != "" {
+			return fmt.Sprintf("Table %s wasn't altered, as it loses the data of columns %s and %s", schemaObj.TableName,
+				strings.Join(lost, ", "), reason)
+		}
+	}
+	if err := s.execDDL(ctx, statements, false); err != nil {
+		return fmt.Sprintf("Failed to %s table: %v", action, err)
+	}
+
+	if err := s.writeMigration(schemaObj.TableName, action, strings.Join(statements, ";\n\n")); err != nil {
+		return fmt.Sprintf("Table %sd, but failed to save migration: %v", action, err)
 	}
+	if err := trackArtifacts(artifact{Kind: artifactTable, Name: schemaObj.TableName, DDL: ddl,
+		Columns: schemaObj.Columns, Constraints: constraints}); err != nil {
+		log.Err(err).Msg("Failed to track table")
+	}
+
+	if action == "create" {
+		return fmt.Sprintf("Table %s created successfully%s", schemaObj.TableName, renamed)
+	}
+	if len(lost) > 0 {
+		return fmt.Sprintf("Table %s altered successfully%s, the data of columns %s was lost, as they were removed or re-created",
+			schemaObj.TableName, renamed, strings.Join(lost, ", "))
+	}
+	return fmt.Sprintf("Table %s altered successfully%s", schemaObj.TableName, renamed)
+}
 
-	return "Table created successfully"
+// writeMigration saves applied DDL in the migrations directory of the project, so the schema can be re-created in other
+// environments. The action (create or alter) is part of the name of the migration, which is tracked as an artifact of
+// the table.
+func (s *Service) writeMigration(table, action, query string) error {
+	name := path.Join("migrations", fmt.Sprintf("%s_%s_%s.sql", time.Now().UTC().Format("20060102150405"), action, table))
+	if err := s.writeFile(path.Join(os.Getenv("PROJECT_ROOT"), name), query+";\n"); err != nil {
+		return err
+	}
+	return trackArtifacts(artifact{Kind: artifactFile, Name: name, Table: table})
+}
+
+// writeMigrationOnce saves the migration creating the table unless one was saved already, for tables created with the
+// same DDL every time.
+func (s *Service) writeMigrationOnce(table, query string) error {
+	tracked, err := loadManifest()
+	if err != nil {
+		return err
+	}
+	for _, a := range tracked {
+		if a.Kind == artifactFile && a.Table == table {
+			return nil
+		}
+	}
+	return s.writeMigration(table, "create", query)
 }
@@ -0,0 +1,119 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pterm/pterm"
+)
+
+// maxProgressTrail limits the progress entries added to the response of a tool, the latest ones being kept, and
+// maxProgressMessage the length of every entry.
+const (
+	maxProgressTrail   = 8
+	maxProgressMessage = 200
+)
+
+// contentTools respond with the content they generated, like the spec, which the model passes on to other tools, so
+// their responses are left without progress.
+var contentTools = map[string]bool{
+	GenerateOpenAPISpecToolName:   true,
+	GenerateGraphQLSchemaToolName: true,
+	GenerateSchemaToolName:        true,
+}
+
+// progressKey is the context key of the progress of the tool called by the model.
+type progressKey struct{}
+
+// progress collects what a tool called by the model did so far, like the tools its agent called and the build repair
+// attempts, and shows it while the tool runs.
+type progress struct {
+	tool string
+	// report receives every progress message, e.g. to stream it to a UI. It may be nil.
+	report func(message string)
+	multi  *pterm.MultiPrinter
+
+	mu sync.Mutex
+	// line is the line of the multi printer showing the latest message, added once there's progress to show.
+	line  io.Writer
+	trail []string
+}
+
+// WithProgress returns the context reporting the progress of the tool called with it to report, along with showing it
+// in the terminal. Tools called without it only show their progress.
+func WithProgress(ctx context.Context, report func(message string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, &progress{report: report})
+}
+
+// trackProgress returns the context collecting the progress of the tool called by the model, shown in the multi
+// printer when it's given.
+func trackProgress(ctx context.Context, multi *pterm.MultiPrinter, tool string) (context.Context, *progress) {
+	p := &progress{}
+	if reporting, ok := ctx.Value(progressKey{}).(*progress); ok {
+		p.report = reporting.report
+	}
+	p.tool, p.multi = tool, multi
+	return context.WithValue(ctx, progressKey{}, p), p
+}
+
+// reportProgress records the progress of the tool called by the model with the context. It's added to the response of
+// the tool, so the model knows how the tool got to its result.
+func reportProgress(ctx context.Context, format string, args ...any) {
+	if p, ok := ctx.Value(progressKey{}).(*progress); ok {
+		p.add(fmt.Sprintf(format, args...), true)
+	}
+}
+
+// showProgress shows the current action of the tool called by the model with the context, without recording it.
+func showProgress(ctx context.Context, format string, args ...any) {
+	if p, ok := ctx.Value(progressKey{}).(*progress); ok {
+		p.add(fmt.Sprintf(format, args...), false)
+	}
+}
+
+func (p *progress) add(message string, record bool) {
+	if len(message) > maxProgressMessage {
+		message = message[:maxProgressMessage] + "..."
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if record {
+		p.trail = append(p.trail, message)
+		message = fmt.Sprintf("step %d: %s", len(p.trail), message)
+	}
+	if p.multi != nil {
+		if p.line == nil {
+			p.line = p.multi.NewWriter()
+		}
+		// The multi printer shows the text after the last carriage return of a line.
+		fmt.Fprintf(p.line, "\r  ↳ %s %s", p.tool, message)
+	}
+	if p.report != nil {
+		p.report(message)
+	}
+}
+
+// summary returns the recorded progress condensed to the latest entries, or an empty string without progress. Its
+// lines start with a dash, so failures the tool recovered from don't count as failures of its response.
+func (p *progress) summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.trail) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Progress:\n")
+	start := max(len(p.trail)-maxProgressTrail, 0)
+	if start > 0 {
+		fmt.Fprintf(&sb, "- %d earlier steps\n", start)
+	}
+	for i, message := range p.trail[start:] {
+		fmt.Fprintf(&sb, "- step %d: %s\n", start+i+1, message)
+	}
+	return sb.String()
+}
@@ -0,0 +1,128 @@
+package tooling
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// sessionLockFile guards the project against sessions running concurrently in it, relative to the project root.
+const sessionLockFile = ".doubletab/session.lock"
+
+// lockOwner is the content of the session lock file, telling who holds it.
+type lockOwner struct {
+	PID       int       `json:"pid"`
+	Host      string    `json:"host"`
+	SessionID string    `json:"session_id,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// ProjectLock is held by the session working on the project, so other instances of DoubleTab don't overwrite its files.
+type ProjectLock struct {
+	name  string
+	owner lockOwner
+}
+
+// LockProject locks the project for this process. It fails when another running instance holds the lock, unless force
+// is set, which takes the lock over. Locks of instances which are no longer running are taken over too.
+func LockProject(force bool) (*ProjectLock, error) {
+	name := filepath.Join(os.Getenv("PROJECT_ROOT"), sessionLockFile)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	host, _ := os.Hostname()
+	l := &ProjectLock{
+		name:  name,
+		owner: lockOwner{PID: os.Getpid(), Host: host, StartedAt: time.Now().UTC()},
+	}
+
+	for {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			err = json.NewEncoder(f).Encode(l.owner)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to write lock: %w", err)
+			}
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("failed to lock project: %w", err)
+		}
+
+		held, err := readLockOwner(name)
+		switch {
+		case errors.Is(err, os.ErrNotExist), force:
+		case err != nil:
+			return nil, fmt.Errorf("%w, use --force to take the lock over", err)
+		case held.running(host):
+			session := ""
+			if held.SessionID != "" {
+				session = " in session " + held.SessionID
+			}
+			return nil, fmt.Errorf("the project is used by another DoubleTab instance%s (PID %d on %s, since %s), which would overwrite the files of this one. Close it, or use --force if it isn't running",
+				session, held.PID, held.Host, held.StartedAt.Local().Format(time.DateTime))
+		}
+		// Stale locks and locks taken over are removed, and the lock is created again exclusively.
+		if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale lock: %w", err)
+		}
+		force = false
+	}
+}
+
+// SetSession records the session working on the project in the lock, e.g. once it's branched.
+func (l *ProjectLock) SetSession(sid string) error {
+	l.owner.SessionID = sid
+	return l.save()
+}
+
+// Release unlocks the project, unless another instance took the lock over.
+func (l *ProjectLock) Release() {
+	held, err := readLockOwner(l.name)
+	if err == nil && held.PID == l.owner.PID && held.Host == l.owner.Host {
+		os.Remove(l.name)
+	}
+}
+
+func (l *ProjectLock) save() error {
+	content, err := json.MarshalIndent(l.owner, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(l.name, content, 0644); err != nil {
+		return fmt.Errorf("failed to write lock: %w", err)
+	}
+	return nil
+}
+
+func readLockOwner(name string) (lockOwner, error) {
+	var owner lockOwner
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return owner, err
+	}
+	if err := json.Unmarshal(content, &owner); err != nil {
+		return owner, fmt.Errorf("failed to parse lock %s: %w", name, err)
+	}
+	return owner, nil
+}
+
+// running reports whether the owner of the lock may still be running. Processes of other hosts can't be checked, so
+// they count as running.
+func (o lockOwner) running(host string) bool {
+	if o.Host != host || o.PID == 0 {
+		return true
+	}
+	p, err := os.FindProcess(o.PID)
+	if err != nil {
+		return false
+	}
+	return !errors.Is(p.Signal(syscall.Signal(0)), os.ErrProcessDone)
+}
@@ -0,0 +1,178 @@
+package tooling
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Markers of protected regions, in comments of any syntax, e.g. // doubletab:keep-begin or # doubletab:keep-begin. A
+// name may follow the begin marker, to tell regions apart when the generated code reorders them.
+const (
+	keepBeginMarker = "doubletab:keep-begin"
+	keepEndMarker   = "doubletab:keep-end"
+)
+
+const protectedRegionsPrompt = `
+## Protected regions
+
+Code between "// doubletab:keep-begin" and "// doubletab:keep-end" comments is written by the user, e.g. business
+logic inside handlers. It's restored verbatim whenever the file is saved, so keep the comments where they are and
+don't change the code between them.
+`
+
+// protectedRegion is a part of a file between keep markers, including the markers.
+type protectedRegion struct {
+	name  string
+	lines []string
+	// anchor is the closest line before the region which is unique in the file. A region the generated content lacks
+	// is restored after the same line of the generated content.
+	anchor string
+	// top is set for regions at the beginning of the file, which have no anchor.
+	top bool
+}
+
+// keepProtectedRegions returns the generated content with the protected regions of the current content of the file
+// restored verbatim. Regions are matched by their names, or by their order when they have none. Regions the generated
+// content lacks are restored after the same line they followed in the current content. It fails when a region can't
+// be restored, so user code is never lost silently.
+func keepProtectedRegions(name, content string) (string, error) {
+	current, err := os.ReadFile(name)
+	if err != nil {
+		return content, nil
+	}
+	restored, err := restoreProtectedRegions(string(current), content)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return restored, nil
+}
+
+// restoreProtectedRegions returns the content with the protected regions of the current content restored, like
+// keepProtectedRegions.
+func restoreProtectedRegions(current, content string) (string, error) {
+	if !strings.Contains(current, keepBeginMarker) {
+		return content, nil
+	}
+	regions, err := protectedRegions(splitLines(current))
+	if err != nil {
+		return "", fmt.Errorf("current content: %w", err)
+	}
+	if len(regions) == 0 {
+		return content, nil
+	}
+	generated := splitLines(content)
+	generatedRegions, err := protectedRegions(generated)
+	if err != nil {
+		return "", fmt.Errorf("generated content: %w", err)
+	}
+
+	used := make([]bool, len(regions))
+	// match returns the region of the current content for the region of the generated content, or -1 when it's new.
+	match := func(r protectedRegion) int {
+		for i, cur := range regions {
+			if !used[i] && cur.name == r.name {
+				used[i] = true
+				return i
+			}
+		}
+		return -1
+	}
+
+	var restored []string
+	next := 0
+	for i := 0; i < len(generated); i++ {
+		if next < len(generatedRegions) && isMarker(generated[i], keepBeginMarker) {
+			r := generatedRegions[next]
+			next++
+			if m := match(r); m >= 0 {
+				restored = append(restored, regions[m].lines...)
+			} else {
+				restored = append(restored, r.lines...)
+			}
+			i += len(r.lines) - 1
+			continue
+		}
+		restored = append(restored, generated[i])
+	}
+
+	for i, r := range regions {
+		if used[i] {
+			continue
+		}
+		if r.top {
+			restored = slices.Insert(restored, 0, r.lines...)
+			continue
+		}
+		at := -1
+		if r.anchor != "" {
+			at = slices.IndexFunc(restored, func(line string) bool { return strings.TrimSpace(line) == r.anchor })
+		}
+		if at < 0 {
+			return "", fmt.Errorf("protected region %s can't be restored, as the generated code lacks it and the line it followed. Keep the %s and %s comments and the code between them",
+				regionName(r, i), keepBeginMarker, keepEndMarker)
+		}
+		restored = slices.Insert(restored, at+1, r.lines...)
+	}
+	return strings.Join(restored, "\n") + "\n", nil
+}
+
+// protectedRegions returns the protected regions of the lines, failing on unbalanced markers.
+func protectedRegions(lines []string) ([]protectedRegion, error) {
+	counts := make(map[string]int)
+	for _, line := range lines {
+		counts[strings.TrimSpace(line)]++
+	}
+
+	var regions []protectedRegion
+	begin := -1
+	anchor := ""
+	for i, line := range lines {
+		switch {
+		case isMarker(line, keepBeginMarker):
+			if begin >= 0 {
+				return nil, fmt.Errorf("protected region at line %d begins before the region at line %d ends", i+1, begin+1)
+			}
+			begin = i
+		case isMarker(line, keepEndMarker):
+			if begin < 0 {
+				return nil, fmt.Errorf("protected region ends at line %d without beginning", i+1)
+			}
+			_, name, _ := strings.Cut(lines[begin], keepBeginMarker)
+			regions = append(regions, protectedRegion{
+				name:   strings.TrimSpace(name),
+				lines:  slices.Clone(lines[begin : i+1]),
+				anchor: anchor,
+				top:    begin == 0,
+			})
+			begin = -1
+		case begin < 0 && counts[strings.TrimSpace(line)] == 1:
+			anchor = strings.TrimSpace(line)
+		}
+	}
+	if begin >= 0 {
+		return nil, fmt.Errorf("protected region at line %d doesn't end", begin+1)
+	}
+	return regions, nil
+}
+
+// isMarker reports whether the line is a comment with the marker.
+func isMarker(line, marker string) bool {
+	trimmed := strings.TrimSpace(line)
+	for _, prefix := range []string{"//", "#", "--"} {
+		if rest, ok := strings.CutPrefix(trimmed, prefix); ok {
+			return strings.HasPrefix(strings.TrimSpace(rest), marker)
+		}
+	}
+	return false
+}
+
+// regionName names the region in errors, by its name or by its position.
+func regionName(r protectedRegion, i int) string {
+	if r.name != "" {
+		return fmt.Sprintf("%q", r.name)
+	}
+	return fmt.Sprintf("#%d", i+1)
+}
@@ -0,0 +1,94 @@
+package tooling
+
+import "testing"
+
+func TestRestoreProtectedRegions(t *testing.T) {
+	tests := []struct {
+		name    string
+		current string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "no regions",
+			current: "func a() {\n\treturn 1\n}\n",
+			content: "func a() {\n\treturn 2\n}\n",
+			want:    "func a() {\n\treturn 2\n}\n",
+		},
+		{
+			name: "region kept verbatim",
+			current: "func a() {\n" +
+				"\t// doubletab:keep-begin\n\tcustom()\n\t// doubletab:keep-end\n" +
+				"\treturn 1\n}\n",
+			content: "func a() {\n" +
+				"\t// doubletab:keep-begin\n\t// doubletab:keep-end\n" +
+				"\treturn 2\n}\n",
+			want: "func a() {\n" +
+				"\t// doubletab:keep-begin\n\tcustom()\n\t// doubletab:keep-end\n" +
+				"\treturn 2\n}\n",
+		},
+		{
+			name: "named regions reordered by the generated code",
+			current: "# doubletab:keep-begin first\none\n# doubletab:keep-end\n" +
+				"middle\n" +
+				"# doubletab:keep-begin second\ntwo\n# doubletab:keep-end\n",
+			content: "# doubletab:keep-begin second\n# doubletab:keep-end\n" +
+				"middle\n" +
+				"# doubletab:keep-begin first\n# doubletab:keep-end\n",
+			want: "# doubletab:keep-begin second\ntwo\n# doubletab:keep-end\n" +
+				"middle\n" +
+				"# doubletab:keep-begin first\none\n# doubletab:keep-end\n",
+		},
+		{
+			name: "unnamed regions matched by their order",
+			current: "a\n-- doubletab:keep-begin\none\n-- doubletab:keep-end\n" +
+				"b\n-- doubletab:keep-begin\ntwo\n-- doubletab:keep-end\n",
+			content: "a\n-- doubletab:keep-begin\n-- doubletab:keep-end\n" +
+				"b\n-- doubletab:keep-begin\n-- doubletab:keep-end\n",
+			want: "a\n-- doubletab:keep-begin\none\n-- doubletab:keep-end\n" +
+				"b\n-- doubletab:keep-begin\ntwo\n-- doubletab:keep-end\n",
+		},
+		{
+			name:    "region the generated code lacks restored after its anchor",
+			current: "package api\n\nfunc a() {}\n// doubletab:keep-begin\nfunc custom() {}\n// doubletab:keep-end\n",
+			content: "package api\n\nfunc a() {}\n\nfunc b() {}\n",
+			want:    "package api\n\nfunc a() {}\n// doubletab:keep-begin\nfunc custom() {}\n// doubletab:keep-end\n\nfunc b() {}\n",
+		},
+		{
+			name:    "region at the top restored at the top",
+			current: "// doubletab:keep-begin\n// License\n// doubletab:keep-end\npackage api\n",
+			content: "package api\n\nfunc a() {}\n",
+			want:    "// doubletab:keep-begin\n// License\n// doubletab:keep-end\npackage api\n\nfunc a() {}\n",
+		},
+		{
+			name:    "region without its anchor in the generated code",
+			current: "func a() {}\n// doubletab:keep-begin\ncustom()\n// doubletab:keep-end\n",
+			content: "func b() {}\n",
+			wantErr: true,
+		},
+		{
+			name:    "region of the current content without end",
+			current: "a\n// doubletab:keep-begin\ncustom()\n",
+			content: "a\n",
+			wantErr: true,
+		},
+		{
+			name:    "region of the generated content without end",
+			current: "a\n// doubletab:keep-begin\ncustom()\n// doubletab:keep-end\n",
+			content: "a\n// doubletab:keep-begin\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := restoreProtectedRegions(tt.current, tt.content)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("restoreProtectedRegions() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("restoreProtectedRegions() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+)
+
+const PublishPRToolName = "publish_pr"
+
+// prBranchPrefix starts the names of branches pull requests of sessions are opened from, followed by the session ID.
+const prBranchPrefix = "doubletab/"
+
+func (s *Service) PublishPRTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(PublishPRToolName),
+			Description: openai.String("Pushes the generated code to a branch and opens a pull request (a merge request on GitLab) for review. Use it only when the user asks to publish the changes."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"title": map[string]string{
+						"type":        "string",
+						"description": "Title of the pull request, e.g. Add tasks API.",
+					},
+					"description": map[string]string{
+						"type":        "string",
+						"description": "Summary of the changes in Markdown, added before the generated list of entities, endpoints, and migrations.",
+					},
+				},
+				"required": []string{"title"},
+			}),
+		}),
+	}
+}
+
+// PublishPR commits the generated files to the branch of the session, pushes it to the git remote, and opens a pull
+// request of it on the forge of the remote, describing the entities, endpoints, and migrations of the project. When
+// the pull request is open already, it's updated by the push.
+func (s *Service) PublishPR(ctx context.Context, multi *pterm.MultiPrinter, arguments string) string {
+	var args struct {
+		Title       string `json:"title"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	if args.Title == "" {
+		return "Invalid title: it's empty"
+	}
+
+	spinner := NewSpinner(multi, "Publishing pull request...")
+	prURL, err := s.publishPR(ctx, args.Title, args.Description)
+	if err != nil {
+		spinner.Fail("Publishing pull request failed")
+		return fmt.Sprintf("Failed to publish pull request: %v", err)
+	}
+	spinner.Success("Pull request published")
+	return fmt.Sprintf("Pull request published: %s", prURL)
+}
+
+func (s *Service) publishPR(ctx context.Context, title, description string) (string, error) {
+	root := os.Getenv("PROJECT_ROOT")
+	remoteURL, err := runGit(ctx, root, "remote", "get-url", s.GitRemote)
+	if err != nil {
+		return "", fmt.Errorf("the project has no git remote %s: %w", s.GitRemote, err)
+	}
+	f, err := s.newForge(remoteURL)
+	if err != nil {
+		return "", err
+	}
+
+	tracked, err := loadManifest()
+	if err != nil {
+		return "", err
+	}
+	var files []string
+	for _, a := range tracked {
+		if a.Kind == artifactFile {
+			files = append(files, a.Name)
+		}
+	}
+	branch := prBranchPrefix + s.Mem.SessionID
+	if current, _ := runGit(ctx, root, "branch", "--show-current"); current != branch {
+		// The worktree is kept, so uncommitted generated files are committed to the branch.
+		if _, err := runGit(ctx, root, "checkout", "--quiet", "-B", branch); err != nil {
+			return "", err
+		}
+	}
+	if err := commitFiles(ctx, root, files, nil, fmt.Sprintf("%s\n\nGenerated by DoubleTab in session %s.", title, s.Mem.SessionID)); err != nil {
+		return "", err
+	}
+	if _, err := runGit(ctx, root, "push", "--quiet", "--set-upstream", s.GitRemote, branch); err != nil {
+		return "", err
+	}
+
+	base := s.PRBase
+	if base == "" {
+		if base, err = f.defaultBranch(ctx); err != nil {
+			return "", err
+		}
+	}
+	return f.openPullRequest(ctx, pullRequest{
+		Title: title,
+		Body:  prBody(description, s.Mem.SessionID, tracked),
+		Head:  branch,
+		Base:  base,
+	})
+}
+
+// prBody describes the pull request: the summary of the model, followed by the entities, endpoints, and migrations of
+// the project.
+func prBody(description, sid string, tracked []artifact) string {
+	var sb strings.Builder
+	if description = strings.TrimSpace(description); description != "" {
+		fmt.Fprintf(&sb, "%s\n\n", description)
+	}
+
+	var entities, migrations []string
+	for _, a := range tracked {
+		switch {
+		case a.Kind == artifactTable:
+			entities = append(entities, a.Name)
+		case a.Kind == artifactFile && path.Dir(a.Name) == "migrations":
+			migrations = append(migrations, a.Name)
+		}
+	}
+	slices.Sort(entities)
+	slices.Sort(migrations)
+	writeSection := func(title string, items []string) {
+		if len(items) == 0 {
+			return
+		}
+		fmt.Fprintf(&sb, "## %s\n\n", title)
+		for _, item := range items {
+			fmt.Fprintf(&sb, "- %s\n", item)
+		}
+		sb.WriteString("\n")
+	}
+	writeSection("Entities", entities)
+	writeSection("Endpoints", specEndpoints())
+	writeSection("Migrations", migrations)
+	fmt.Fprintf(&sb, "Generated by DoubleTab in session `%s`.\n", sid)
+	return sb.String()
+}
+
+// specEndpoints returns the operations of the OpenAPI spec of the project as method and path, or nil when it has none.
+func specEndpoints() []string {
+	doc, err := openapi3.NewLoader().LoadFromFile(specPath())
+	if err != nil || doc.Paths == nil {
+		return nil
+	}
+	var endpoints []string
+	for p, item := range doc.Paths.Map() {
+		for method := range item.Operations() {
+			endpoints = append(endpoints, fmt.Sprintf("`%s %s`", method, p))
+		}
+	}
+	slices.Sort(endpoints)
+	return endpoints
+}
@@ -0,0 +1,299 @@
+package tooling
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/openai/openai-go"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+)
+
+const (
+	// defaultQueryRows and maxQueryRows limit the rows of query results shown to the model.
+	defaultQueryRows = 50
+	maxQueryRows     = 500
+	// maxQueryValue limits the length of every value of query results, as text columns may hold whole documents.
+	maxQueryValue = 200
+	// queryTimeout cancels queries running longer, like accidental cross joins of large tables.
+	queryTimeout = 10 * time.Second
+)
+
+// deniedQueryNodes are the nodes of parsed queries which write to the database or lock rows, by their name in the
+// JSON parse tree, with the reason they're rejected.
+var deniedQueryNodes = map[string]string{
+	"InsertStmt":    "data-modifying statements aren't allowed",
+	"UpdateStmt":    "data-modifying statements aren't allowed",
+	"DeleteStmt":    "data-modifying statements aren't allowed",
+	"MergeStmt":     "data-modifying statements aren't allowed",
+	"intoClause":    "SELECT INTO creates a table, which isn't allowed",
+	"lockingClause": "locking rows isn't allowed",
+}
+
+const RunQueryToolName = "run_query"
+
+func (s *Service) RunQueryTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(RunQueryToolName),
+			Description: openai.String("Runs a read-only SQL query (a single SELECT, or EXPLAIN of one) against the project database and returns the rows as a table. Use it to answer questions about the data, like what's in a table."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"query": map[string]string{
+						"type":        "string",
+						"description": "The SELECT query.",
+					},
+					"limit": map[string]interface{}{
+						"type":        "integer",
+						"description": fmt.Sprintf("Maximum number of rows returned, %d by default and at most %d.", defaultQueryRows, maxQueryRows),
+					},
+				},
+				"required": []string{"query"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) RunQuery(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return "No query to run"
+	}
+	limit := defaultQueryRows
+	if l, ok := args["limit"].(float64); ok && l > 0 {
+		limit = min(int(l), maxQueryRows)
+	}
+	functions, err := checkReadOnlyQuery(query)
+	if err != nil {
+		return fmt.Sprintf("Query rejected: %v", err)
+	}
+
+	result, err := s.runReadOnlyQuery(ctx, query, functions, limit)
+	if err != nil {
+		return fmt.Sprintf("Failed to run query: %v", err)
+	}
+	return result
+}
+
+// checkReadOnlyQuery parses the query and rejects anything but a single SELECT, or EXPLAIN of one, that doesn't write
+// or lock rows. It returns the functions the query calls, which are checked against the database before it runs.
+func checkReadOnlyQuery(query string) ([]queryFunction, error) {
+	tree, err := pg_query.ParseToJSON(query)
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		Stmts []struct {
+			Stmt map[string]any `json:"stmt"`
+		} `json:"stmts"`
+	}
+	if err := json.Unmarshal([]byte(tree), &parsed); err != nil {
+		return nil, fmt.Errorf("failed to read parse tree: %w", err)
+	}
+	if len(parsed.Stmts) != 1 {
+		return nil, fmt.Errorf("expected a single statement, got %d", len(parsed.Stmts))
+	}
+	stmt := parsed.Stmts[0].Stmt
+	if explain, ok := stmt["ExplainStmt"].(map[string]any); ok {
+		stmt, _ = explain["query"].(map[string]any)
+	}
+	if _, ok := stmt["SelectStmt"]; !ok {
+		return nil, errors.New("only SELECT queries are allowed")
+	}
+	var functions []queryFunction
+	if err := checkQueryNode(stmt, &functions); err != nil {
+		return nil, err
+	}
+	return functions, nil
+}
+
+// queryFunction is a function called by a query, with the schema it's qualified with, if any.
+type queryFunction struct {
+	schema, name string
+}
+
+func (f queryFunction) String() string {
+	if f.schema != "" {
+		return f.schema + "." + f.name
+	}
+	return f.name
+}
+
+// checkQueryNode walks the JSON parse tree of a query, rejecting denied nodes and collecting the called functions.
+func checkQueryNode(node any, functions *[]queryFunction) error {
+	switch n := node.(type) {
+	case map[string]any:
+		for key, value := range n {
+			if reason, ok := deniedQueryNodes[key]; ok {
+				return errors.New(reason)
+			}
+			if call, ok := value.(map[string]any); ok && key == "FuncCall" {
+				*functions = append(*functions, queryFunctionName(call))
+			}
+			if err := checkQueryNode(value, functions); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, value := range n {
+			if err := checkQueryNode(value, functions); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// queryFunctionName returns the called function.
+func queryFunctionName(call map[string]any) queryFunction {
+	parts, _ := call["funcname"].([]any)
+	var names []string
+	for _, part := range parts {
+		part, _ := part.(map[string]any)
+		str, _ := part["String"].(map[string]any)
+		name, _ := str["sval"].(string)
+		names = append(names, name)
+	}
+	switch len(names) {
+	case 0:
+		return queryFunction{}
+	case 1:
+		return queryFunction{name: names[0]}
+	default:
+		return queryFunction{schema: names[len(names)-2], name: names[len(names)-1]}
+	}
+}
+
+// checkQueryFunctions rejects functions which aren't immutable or stable, in any of their overloads, as volatile
+// functions may have effects outside the query, like sleeping, locking, or reading files, which read-only transactions
+// don't prevent. Unknown functions are rejected as well, so only functions known not to have effects are called.
+func checkQueryFunctions(ctx context.Context, tx *sqlx.Tx, functions []queryFunction) error {
+	if len(functions) == 0 {
+		return nil
+	}
+	names := make([]any, len(functions))
+	placeholders := make([]string, len(functions))
+	for i, f := range functions {
+		names[i] = f.name
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := tx.QueryxContext(ctx, `SELECT n.nspname, p.proname, p.provolatile IN ('i', 's')
+FROM pg_proc p JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE p.proname IN (`+strings.Join(placeholders, ", ")+`)`, names...)
+	if err != nil {
+		return fmt.Errorf("failed to look up functions: %w", err)
+	}
+	defer rows.Close()
+	// volatile has the found functions, unqualified and qualified, and whether any of their overloads is volatile.
+	volatile := make(map[queryFunction]bool)
+	for rows.Next() {
+		var schema, name string
+		var stable bool
+		if err := rows.Scan(&schema, &name, &stable); err != nil {
+			return fmt.Errorf("failed to look up functions: %w", err)
+		}
+		for _, f := range []queryFunction{{name: name}, {schema: schema, name: name}} {
+			volatile[f] = volatile[f] || !stable
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to look up functions: %w", err)
+	}
+
+	for _, f := range functions {
+		if v, ok := volatile[f]; !ok {
+			return fmt.Errorf("query rejected, function %s doesn't exist", f)
+		} else if v {
+			return fmt.Errorf("query rejected, function %s isn't allowed, only immutable and stable functions are", f)
+		}
+	}
+	return nil
+}
+
+// runReadOnlyQuery runs the query in a read-only transaction with a statement timeout, which is always rolled back, and
+// renders up to limit rows of the result as a Markdown table. The functions the query calls are checked first.
+func (s *Service) runReadOnlyQuery(ctx context.Context, query string, functions []queryFunction, limit int) (string, error) {
+	tx, err := s.DB.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", queryTimeout.Milliseconds())); err != nil {
+		return "", err
+	}
+	if err := checkQueryFunctions(ctx, tx, functions); err != nil {
+		return "", err
+	}
+
+	rows, err := tx.QueryxContext(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString("| " + strings.Join(columns, " | ") + " |\n")
+	sb.WriteString(strings.Repeat("| --- ", len(columns)) + "|\n")
+	count, more := 0, false
+	for rows.Next() {
+		if count == limit {
+			more = true
+			break
+		}
+		values, err := rows.SliceScan()
+		if err != nil {
+			return "", err
+		}
+		cells := make([]string, len(values))
+		for i, value := range values {
+			cells[i] = queryValue(value)
+		}
+		sb.WriteString("| " + strings.Join(cells, " | ") + " |\n")
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if count == 0 {
+		return fmt.Sprintf("The query returned no rows, its columns are %s", strings.Join(columns, ", ")), nil
+	}
+	if more {
+		fmt.Fprintf(&sb, "\nShowing the first %d rows, the query returned more.\n", limit)
+	}
+	return sb.String(), nil
+}
+
+// queryValue renders a value of a query result as a table cell.
+func queryValue(value any) string {
+	var text string
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		text = string(v)
+	case time.Time:
+		text = v.Format(time.RFC3339)
+	default:
+		text = fmt.Sprint(v)
+	}
+	if runes := []rune(text); len(runes) > maxQueryValue {
+		text = string(runes[:maxQueryValue]) + "..."
+	}
+	return strings.NewReplacer("|", `\|`, "\n", " ").Replace(text)
+}
@@ -0,0 +1,123 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+)
+
+const (
+	generateReadmePrompt = `You are an AI assistant that writes README.md files for generated Go backend projects.
+
+First, query memory for the requirements the user described during the session (entities, business rules, special
+needs). Then, based on the memory and the project facts provided by the user, write a README.md in Markdown with the
+following sections:
+
+1. Overview: what the service does, in the user's own terms.
+2. Entities: every entity with its fields and their meaning.
+3. Endpoints: every endpoint (or GraphQL query and mutation) with a short description.
+4. Running: environment variables (copy .env.example to .env and fill it in) and how to start the server with
+   "go run .".
+5. Database migrations: how to apply the SQL files from the migrations directory in order (e.g. with psql -f).
+6. Architecture: how the code is organized, describing every package and which parts are generated (and how to
+   regenerate them) versus meant to be edited.
+
+Important notes:
+- Only describe what is present in the project facts and memory, don't invent endpoints, fields, or packages.
+- Output only the Markdown content.
+`
+)
+
+const GenerateReadmeToolName = "generate_readme"
+
+func (s *Service) GenerateReadmeTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateReadmeToolName),
+			Description: openai.String("Generates README.md of the project describing entities, endpoints, migrations, and code organization, based on the session and generated artifacts."),
+		}),
+	}
+}
+
+func (s *Service) GenerateReadme(ctx context.Context, multi *pterm.MultiPrinter) string {
+	spinner := NewSpinner(multi, "Generating README...")
+	defer spinner.Success("README generated")
+
+	facts, err := s.projectFacts()
+	if err != nil {
+		return fmt.Sprintf("Failed to collect project facts: %v", err)
+	}
+
+	agent := s.Agent(generateReadmePrompt, facts).
+		WithTools(s.QueryMemoryTool()).
+		WithModel(s.ChatModel)
+
+	readme := TrimNonCode(agent.Run(ctx), "markdown")
+	if err := s.writeFile(path.Join(os.Getenv("PROJECT_ROOT"), "README.md"), readme); err != nil {
+		return fmt.Sprintf("Failed to save README.md: %v", err)
+	}
+	// The diagram is embedded as it was generated rather than rewritten by the model.
+	if _, err := s.embedERD(); err != nil {
+		return fmt.Sprintf("README.md generated, but embedding the entity-relationship diagram failed: %v", err)
+	}
+
+	return "README.md generated successfully"
+}
+
+// projectFacts describes the generated project: its files, API spec, migrations, and configuration.
+func (s *Service) projectFacts() (string, error) {
+	rootDir := os.Getenv("PROJECT_ROOT")
+	if rootDir == "" {
+		rootDir = "."
+	}
+
+	var sb strings.Builder
+	sb.WriteString("## Files\n\n")
+	err := filepath.WalkDir(rootDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && strings.HasPrefix(d.Name(), ".") && name != rootDir {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			rel, _ := filepath.Rel(rootDir, name)
+			sb.WriteString("- " + rel + "\n")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	specFile := path.Join(rootDir, "pkg", "api", "doc", "openapi.yaml")
+	if s.APIStyle == APIStyleGraphQL {
+		specFile = path.Join(rootDir, "pkg", "graph", "schema.graphqls")
+	}
+	if spec, err := os.ReadFile(specFile); err == nil {
+		fmt.Fprintf(&sb, "\n## API spec (%s)\n\n%s\n", filepath.Base(specFile), spec)
+	}
+
+	migrations, _ := filepath.Glob(path.Join(rootDir, "migrations", "*.sql"))
+	for _, name := range migrations {
+		if ddl, err := os.ReadFile(name); err == nil {
+			fmt.Fprintf(&sb, "\n## Migration %s\n\n%s\n", filepath.Base(name), ddl)
+		}
+	}
+
+	envs, err := s.renderTemplate(envExample)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&sb, "\n## Environment variables (.env.example, loaded by pkg/config)\n\n%s\n", envs)
+
+	return sb.String(), nil
+}
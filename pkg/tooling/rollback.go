@@ -0,0 +1,106 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+const RollbackStepToolName = "rollback_step"
+
+func (s *Service) RollbackStepTool() openai.ChatCompletionToolParam {
+	var steps []string
+	if s.Workflow != nil {
+		for _, step := range s.Workflow.Steps() {
+			steps = append(steps, step.Name)
+		}
+	}
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(RollbackStepToolName),
+			Description: openai.String("Rolls back a whole workflow step: drops the tables and deletes the files it created, and marks it and the steps depending on it as pending. Use it when a step has to be redone from scratch, e.g. after verification failed because of it."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"step": map[string]interface{}{
+						"type":        "string",
+						"description": "Name of the step to roll back.",
+						"enum":        steps,
+					},
+				},
+				"required": []string{"step"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) RollbackStep(ctx context.Context, arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	step, _ := args["step"].(string)
+
+	removed, err := s.Rollback(ctx, step)
+	if err != nil {
+		return fmt.Sprintf("Failed to roll back step %s: %v", step, err)
+	}
+	if len(removed) == 0 {
+		return fmt.Sprintf("Step %s rolled back, it created no tables or files", step)
+	}
+	return fmt.Sprintf("Step %s rolled back, removed:\n%s", step, strings.Join(removed, "\n"))
+}
+
+// Rollback drops the tables and deletes the files created by the workflow step, and resets the step. Tables are found
+// through the migrations the step created, and dropped in reverse order of their migrations, so tables referencing
+// others go first. Files which existed before the step and were only changed by it are kept.
+func (s *Service) Rollback(ctx context.Context, step string) ([]string, error) {
+	if s.Workflow == nil {
+		return nil, fmt.Errorf("no workflow to roll back")
+	}
+	if !slices.ContainsFunc(s.Workflow.Steps(), func(st workflow.Step) bool { return st.Name == step }) {
+		return nil, fmt.Errorf("unknown step %s", step)
+	}
+	tracked, err := loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	created := s.Workflow.Created(step)
+	var tables, files []artifact
+	for _, name := range created {
+		// The lock file is created by the first step generating anything, but it tracks every step.
+		if name == manifestFile {
+			continue
+		}
+		files = append(files, artifact{Kind: artifactFile, Name: name})
+		for _, a := range tracked {
+			// Tables have a migration creating them and one for every change, but they are dropped once.
+			if a.Kind == artifactFile && a.Name == name && a.Table != "" &&
+				!slices.ContainsFunc(tables, func(t artifact) bool { return t.Name == a.Table }) {
+				tables = append(tables, artifact{Kind: artifactTable, Name: a.Table})
+			}
+		}
+	}
+	slices.Reverse(tables)
+
+	if err := s.deleteArtifacts(ctx, append(tables, files...)); err != nil {
+		return nil, err
+	}
+	if err := s.Workflow.Reset(step); err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, a := range append(tables, files...) {
+		removed = append(removed, fmt.Sprintf("%s %s", a.Kind, a.Name))
+	}
+	return removed, nil
+}
@@ -0,0 +1,159 @@
+package tooling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+)
+
+// blockingSecuritySeverities are severities of gosec findings the generated code must be fixed for.
+var blockingSecuritySeverities = map[string]bool{
+	"HIGH":   true,
+	"MEDIUM": true,
+}
+
+// securityIssue is a finding of gosec JSON report.
+type securityIssue struct {
+	Severity   string `json:"severity"`
+	Confidence string `json:"confidence"`
+	RuleID     string `json:"rule_id"`
+	Details    string `json:"details"`
+	File       string `json:"file"`
+	// Line is a line number or a range of lines, e.g. 12-14.
+	Line string `json:"line"`
+	Cwe  *struct {
+		ID string `json:"id"`
+	} `json:"cwe"`
+}
+
+const SecurityScanToolName = "security_scan"
+
+func (s *Service) SecurityScanTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(SecurityScanToolName),
+			Description: openai.String("Runs gosec on the generated Go code and reports security findings, like SQL built from user input or missing TLS settings, telling which of them block the workflow until fixed."),
+		}),
+	}
+}
+
+func (s *Service) SecurityScan(ctx context.Context, multi *pterm.MultiPrinter) string {
+	bin, ok := s.toolPath("gosec")
+	if !ok {
+		return "gosec isn't installed, skip the security scan"
+	}
+	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return fmt.Sprintf("Failed to get absolute path of project root: %v", err)
+	}
+
+	spinner := NewSpinner(multi, "Scanning code for security issues...")
+	cmd := exec.CommandContext(ctx, bin, "-fmt", "json", "-exclude-generated", "-no-fail", "./...")
+	cmd.Dir = absRoot
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		spinner.Fail("Security scan failed")
+		return fmt.Sprintf("gosec failed: %v\n%s", err, stderr.String())
+	}
+	var report struct {
+		Issues []securityIssue
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &report); err != nil {
+		spinner.Fail("Security scan failed")
+		return fmt.Sprintf("Failed to parse gosec report: %v", err)
+	}
+	spinner.Success("Code scanned for security issues")
+
+	var blocking, other []string
+	for _, issue := range report.Issues {
+		if rel, err := filepath.Rel(absRoot, issue.File); err == nil {
+			issue.File = rel
+		}
+		finding := fmt.Sprintf("%s:%s: %s (%s, %s severity)", issue.File, issue.Line, issue.Details, issue.rule(),
+			strings.ToLower(issue.Severity))
+		if blockingSecuritySeverities[issue.Severity] {
+			blocking = append(blocking, finding)
+		} else {
+			other = append(other, finding)
+		}
+	}
+	// Scans of the workflow step are summarized to the user, scans of the code agents only repair the code.
+	if multi != nil {
+		printSecuritySummary(report.Issues)
+	}
+
+	var sb strings.Builder
+	switch {
+	case len(blocking) > 0:
+		sb.WriteString("Security findings blocking the workflow, fix them and re-generate the code:\n")
+		sb.WriteString(strings.Join(blocking, "\n"))
+	case len(other) > 0:
+		sb.WriteString("No blocking security findings")
+	default:
+		return "No security findings"
+	}
+	if len(other) > 0 {
+		sb.WriteString("\nOther findings, fix them only when it's simple:\n")
+		sb.WriteString(strings.Join(other, "\n"))
+	}
+	return sb.String()
+}
+
+// rule returns the gosec rule of the issue with its CWE, e.g. G201/CWE-89.
+func (issue securityIssue) rule() string {
+	if issue.Cwe == nil || issue.Cwe.ID == "" {
+		return issue.RuleID
+	}
+	return issue.RuleID + "/CWE-" + issue.Cwe.ID
+}
+
+// printSecuritySummary prints the number of findings of every rule, the most severe first.
+func printSecuritySummary(issues []securityIssue) {
+	if len(issues) == 0 {
+		pterm.Success.Println("Security scan found no issues")
+		return
+	}
+	type ruleCount struct {
+		severity, rule, details string
+		count                   int
+	}
+	counts := make(map[string]*ruleCount)
+	for _, issue := range issues {
+		key := issue.Severity + issue.rule()
+		if counts[key] == nil {
+			counts[key] = &ruleCount{severity: issue.Severity, rule: issue.rule(), details: issue.Details}
+		}
+		counts[key].count++
+	}
+	rank := map[string]int{"HIGH": 0, "MEDIUM": 1, "LOW": 2}
+	rows := make([]*ruleCount, 0, len(counts))
+	for _, c := range counts {
+		rows = append(rows, c)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rank[rows[i].severity] != rank[rows[j].severity] {
+			return rank[rows[i].severity] < rank[rows[j].severity]
+		}
+		return rows[i].rule < rows[j].rule
+	})
+
+	table := pterm.TableData{{"Severity", "Rule", "Findings", "Details"}}
+	for _, r := range rows {
+		table = append(table, []string{r.severity, r.rule, fmt.Sprint(r.count), r.details})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(table).Render(); err != nil {
+		pterm.Warning.Printfln("Failed to print security summary: %v", err)
+	}
+}
@@ -0,0 +1,316 @@
+package tooling
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+)
+
+// openAPISpec is the part of the generated OpenAPI spec requests to the generated server are made from.
+type openAPISpec struct {
+	Info struct {
+		Title string
+	}
+	Servers    []struct{ URL string }
+	Paths      map[string]pathItem
+	Components struct {
+		Schemas         map[string]*specSchema
+		SecuritySchemes map[string]specSecurityScheme `yaml:"securitySchemes"`
+	}
+}
+
+// specSecurityScheme is an authentication scheme of the spec, e.g. http bearer or apiKey in a header.
+type specSecurityScheme struct {
+	Type   string
+	Scheme string
+	In     string
+	Name   string
+}
+
+type pathItem struct {
+	Parameters []specParameter
+	Get        *specOperation
+	Post       *specOperation
+	Put        *specOperation
+	Patch      *specOperation
+	Delete     *specOperation
+}
+
+// operations returns the operations of the path keyed by their HTTP methods.
+func (item pathItem) operations() map[string]*specOperation {
+	ops := make(map[string]*specOperation)
+	for method, op := range map[string]*specOperation{
+		http.MethodGet:    item.Get,
+		http.MethodPost:   item.Post,
+		http.MethodPut:    item.Put,
+		http.MethodPatch:  item.Patch,
+		http.MethodDelete: item.Delete,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+type specOperation struct {
+	Summary     string
+	Parameters  []specParameter
+	RequestBody *struct {
+		Content map[string]struct {
+			Schema *specSchema
+		}
+	} `yaml:"requestBody"`
+	// Responses are keyed by status codes, e.g. 200, 4XX, or default.
+	Responses map[string]any
+}
+
+type specParameter struct {
+	Name     string
+	In       string
+	Required bool
+	Schema   *specSchema
+}
+
+type specSchema struct {
+	Ref        string `yaml:"$ref"`
+	Type       string
+	Format     string
+	Enum       []any
+	Properties map[string]*specSchema
+	Required   []string
+	Items      *specSchema
+	AllOf      []*specSchema `yaml:"allOf"`
+	ReadOnly   bool          `yaml:"readOnly"`
+	MinLength  *int          `yaml:"minLength"`
+	MaxLength  *int          `yaml:"maxLength"`
+	Minimum    *float64
+	Maximum    *float64
+}
+
+// specResource is a resource with CRUD endpoints in the spec.
+type specResource struct {
+	// Name is the last segment of the collection path, e.g. books.
+	Name string
+	// Path is the collection path, e.g. /books.
+	Path string
+	// ItemPath is the path of a single resource with its id parameter, e.g. /books/{id}.
+	ItemPath string
+	// Schema of the request body creating the resource.
+	Schema *specSchema
+	// References lists resources the create request body refers to by id.
+	References []string
+}
+
+func loadOpenAPISpec() (*openAPISpec, error) {
+	content, err := os.ReadFile(path.Join(os.Getenv("PROJECT_ROOT"), "pkg", "api", "doc", "openapi.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+	var spec openAPISpec
+	if err := yaml.Unmarshal(content, &spec); err != nil {
+		return nil, fmt.Errorf("failed to parse OpenAPI spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// requestSchema returns the JSON request body schema of the operation, or nil when it doesn't have one.
+func (op *specOperation) requestSchema() *specSchema {
+	if op == nil || op.RequestBody == nil {
+		return nil
+	}
+	return op.RequestBody.Content["application/json"].Schema
+}
+
+// resources returns the resources with create and get endpoints, ordered so that resources come after the resources
+// they refer to whenever possible.
+func (spec *openAPISpec) resources() []specResource {
+	var resources []specResource
+	for p, item := range spec.Paths {
+		if item.Post == nil || strings.ContainsAny(p, "{:") {
+			continue
+		}
+		schema := item.Post.requestSchema()
+		if schema == nil {
+			continue
+		}
+		for itemPath, itemItem := range spec.Paths {
+			rest, ok := strings.CutPrefix(itemPath, p+"/{")
+			if ok && itemItem.Get != nil && strings.HasSuffix(rest, "}") && !strings.Contains(rest, "/") {
+				resources = append(resources, specResource{Name: path.Base(p), Path: p, ItemPath: itemPath, Schema: schema})
+				break
+			}
+		}
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Path < resources[j].Path
+	})
+
+	names := make(map[string]bool)
+	for _, r := range resources {
+		names[r.Name] = true
+	}
+	for i, r := range resources {
+		for prop := range spec.resolve(r.Schema).Properties {
+			if ref, ok := referencedResource(prop, names); ok && ref != r.Name {
+				resources[i].References = append(resources[i].References, ref)
+			}
+		}
+	}
+
+	// Resources whose references can't be satisfied (e.g. cycles) are left in their order.
+	var ordered []specResource
+	placed := make(map[string]bool)
+	for len(ordered) < len(resources) {
+		progress := false
+		for _, r := range resources {
+			if placed[r.Name] {
+				continue
+			}
+			ready := true
+			for _, ref := range r.References {
+				ready = ready && placed[ref]
+			}
+			if ready {
+				ordered = append(ordered, r)
+				placed[r.Name] = true
+				progress = true
+			}
+		}
+		if !progress {
+			for _, r := range resources {
+				if !placed[r.Name] {
+					ordered = append(ordered, r)
+					placed[r.Name] = true
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// referencedResource returns the resource a property like author_id or authorId refers to.
+func referencedResource(prop string, names map[string]bool) (string, bool) {
+	singular, ok := strings.CutSuffix(prop, "_id")
+	if !ok {
+		if singular, ok = strings.CutSuffix(prop, "Id"); !ok {
+			return "", false
+		}
+	}
+	singular = strings.ToLower(singular)
+	for _, name := range []string{singular + "s", singular + "es", strings.TrimSuffix(singular, "y") + "ies"} {
+		if names[name] {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// resolve follows the reference of the schema and merges its allOf schemas.
+func (spec *openAPISpec) resolve(schema *specSchema) *specSchema {
+	for depth := 0; schema != nil && schema.Ref != "" && depth < 10; depth++ {
+		schema = spec.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	if schema == nil {
+		return &specSchema{}
+	}
+	if len(schema.AllOf) == 0 {
+		return schema
+	}
+	merged := *schema
+	merged.Properties = make(map[string]*specSchema)
+	for k, v := range schema.Properties {
+		merged.Properties[k] = v
+	}
+	for _, part := range schema.AllOf {
+		part = spec.resolve(part)
+		if merged.Type == "" {
+			merged.Type = part.Type
+		}
+		for k, v := range part.Properties {
+			merged.Properties[k] = v
+		}
+		merged.Required = append(merged.Required, part.Required...)
+	}
+	return &merged
+}
+
+// sampleValue returns a value conforming to the schema. Properties referring to other resources by id are set to the
+// ids from refs, keyed by resource name.
+func (spec *openAPISpec) sampleValue(schema *specSchema, refs map[string]string, depth int) any {
+	schema = spec.resolve(schema)
+	if len(schema.Enum) > 0 {
+		return schema.Enum[0]
+	}
+	switch schema.Type {
+	case "string":
+		return sampleString(schema)
+	case "integer", "number":
+		switch {
+		case schema.Minimum != nil:
+			return *schema.Minimum
+		case schema.Maximum != nil && *schema.Maximum < 1:
+			return *schema.Maximum
+		}
+		return 1
+	case "boolean":
+		return true
+	case "array":
+		if depth > 5 || schema.Items == nil {
+			return []any{}
+		}
+		return []any{spec.sampleValue(schema.Items, refs, depth+1)}
+	}
+	if depth > 5 || len(schema.Properties) == 0 {
+		return map[string]any{}
+	}
+
+	names := make(map[string]bool)
+	for name := range refs {
+		names[name] = true
+	}
+	obj := make(map[string]any)
+	for name, prop := range schema.Properties {
+		if spec.resolve(prop).ReadOnly {
+			continue
+		}
+		if ref, ok := referencedResource(name, names); ok {
+			obj[name] = refs[ref]
+			continue
+		}
+		obj[name] = spec.sampleValue(prop, refs, depth+1)
+	}
+	return obj
+}
+
+func sampleString(schema *specSchema) string {
+	var value string
+	switch schema.Format {
+	case "uuid":
+		return uuid.NewString()
+	case "date-time":
+		return time.Now().UTC().Format(time.RFC3339)
+	case "date":
+		return time.Now().UTC().Format(time.DateOnly)
+	case "email":
+		value = "sample@example.com"
+	case "uri", "url":
+		value = "https://example.com"
+	default:
+		value = "sample"
+	}
+	if schema.MinLength != nil && len(value) < *schema.MinLength {
+		value += strings.Repeat("x", *schema.MinLength-len(value))
+	}
+	if schema.MaxLength != nil && len(value) > *schema.MaxLength {
+		value = value[:*schema.MaxLength]
+	}
+	return value
+}
@@ -0,0 +1,142 @@
+package tooling
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+const sqlGuidelinesPrompt = `
+## SQL guidelines
+
+- Wrap every database call in a context with a timeout (context.WithTimeout) derived from the request context.
+- Use prepared named statements (PrepareNamedContext) for inserts and updates.
+- Always list columns explicitly, never use SELECT *.
+- Scan nullable columns into sql.Null types (sql.NullString, sql.NullInt64, sql.NullTime, ...) and convert them to the
+  API types.
+- Implement batch endpoints in a single transaction with a savepoint per item, so failed items are rolled back and
+  reported while the others are committed, unless the client asked for an atomic batch.
+- Validate enum values and ranges of the input before querying, and still map check constraint violations of the
+  database (pq error code 23514) to 422 Unprocessable Entity instead of 500.
+- Never build SQL with string concatenation or fmt.Sprintf, always pass values as placeholders ($1 or :name). Code
+  building SQL dynamically is rejected when saved.
+`
+
+// sqlQueryArgs maps database methods to the position of their query argument.
+var sqlQueryArgs = map[string]int{
+	"Exec":         0,
+	"MustExec":     0,
+	"Query":        0,
+	"Queryx":       0,
+	"QueryRow":     0,
+	"QueryRowx":    0,
+	"NamedExec":    0,
+	"NamedQuery":   0,
+	"Prepare":      0,
+	"Preparex":     0,
+	"PrepareNamed": 0,
+	"Get":          1,
+	"Select":       1,
+}
+
+// checkSQL runs the checks of database access enabled for the project on generated Go code.
+func (s *Service) checkSQL(code string) error {
+	err := checkSQLConcatenation(code)
+	if s.MultiTenant {
+		err = errors.Join(err, checkTenantScoping(code))
+	}
+	return err
+}
+
+// checkSQLConcatenation rejects Go code passing SQL built with string concatenation or fmt.Sprintf to database
+// methods. Code which doesn't parse is not checked, the build reports it anyway.
+func checkSQLConcatenation(code string) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", code, 0)
+	if err != nil {
+		return nil
+	}
+
+	// Variables assigned a dynamically built string anywhere in the file are treated as dynamic queries.
+	dynamic := make(map[string]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			ident, ok := lhs.(*ast.Ident)
+			if !ok || i >= len(assign.Rhs) {
+				continue
+			}
+			if assign.Tok == token.ADD_ASSIGN || isDynamicString(assign.Rhs[i]) {
+				dynamic[ident.Name] = true
+			}
+		}
+		return true
+	})
+
+	var errs []error
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		method := sel.Sel.Name
+		idx, ok := sqlQueryArgs[strings.TrimSuffix(method, "Context")]
+		if !ok {
+			return true
+		}
+		if strings.HasSuffix(method, "Context") {
+			idx++
+		}
+		if idx >= len(call.Args) {
+			return true
+		}
+		arg := call.Args[idx]
+		if ident, ok := arg.(*ast.Ident); (ok && dynamic[ident.Name]) || isDynamicString(arg) {
+			errs = append(errs, fmt.Errorf("line %d: SQL passed to %s is built dynamically, use placeholders instead",
+				fset.Position(call.Pos()).Line, method))
+		}
+		return true
+	})
+
+	return errors.Join(errs...)
+}
+
+// isDynamicString reports whether the expression builds a string from non-constant parts.
+func isDynamicString(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return isDynamicString(e.X)
+	case *ast.BinaryExpr:
+		return e.Op == token.ADD && !(isStringLiteral(e.X) && isStringLiteral(e.Y))
+	case *ast.CallExpr:
+		sel, ok := e.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return false
+		}
+		pkg, ok := sel.X.(*ast.Ident)
+		return ok && pkg.Name == "fmt" && sel.Sel.Name == "Sprintf"
+	}
+	return false
+}
+
+func isStringLiteral(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		return e.Kind == token.STRING
+	case *ast.ParenExpr:
+		return isStringLiteral(e.X)
+	case *ast.BinaryExpr:
+		return e.Op == token.ADD && isStringLiteral(e.X) && isStringLiteral(e.Y)
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package tooling
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const tenantColumn = "tenant_id"
+
+const (
+	tenantFieldNote = "Don't include tenant_id fields in the types, the tenant is identified from the request."
+
+	multiTenantPrompt = `
+## Multi-tenancy
+
+Every table has a tenant_id column and every request belongs to a tenant, identified by the middleware:
+
+- Get the tenant of the request with tenant.FromContext(ctx) from the tenant package (already generated).
+- Scope every query to the tenant: set tenant_id in every INSERT and filter every SELECT, UPDATE, and DELETE with
+  "tenant_id = $n" (or ":tenant_id"), so tenants never see or change each other's data. Queries without tenant_id are
+  rejected when saved.
+- Never expose tenant_id in the API types, it comes from the request only.
+`
+)
+
+var (
+	uniqueRegexp     = regexp.MustCompile(`(?i)\bUNIQUE\b`)
+	primaryKeyRegexp = regexp.MustCompile(`(?i)\bPRIMARY\s+KEY\b`)
+	// tableQueryRegexp matches queries reading or writing a table, as opposed to e.g. "SELECT 1".
+	tableQueryRegexp = regexp.MustCompile(`(?i)\b(FROM|INTO|UPDATE)\s+\w+`)
+)
+
+// scopeToTenant adds the tenant_id column to the schema and makes its unique constraints unique per tenant. It returns
+// the table constraints to add and the composite index to create along with the table.
+func scopeToTenant(schema *Schema) ([]string, string) {
+	var constraints []string
+	var indexCols []string
+	hasTenant := false
+	for i, col := range schema.Columns {
+		if col.Name == tenantColumn {
+			hasTenant = true
+			continue
+		}
+		if primaryKeyRegexp.MatchString(col.Constraints) {
+			indexCols = append(indexCols, col.Name)
+		}
+		if uniqueRegexp.MatchString(col.Constraints) {
+			schema.Columns[i].Constraints = strings.TrimSpace(uniqueRegexp.ReplaceAllString(col.Constraints, ""))
+			constraints = append(constraints, fmt.Sprintf("UNIQUE (%s, %s)", tenantColumn, col.Name))
+		}
+	}
+	if !hasTenant {
+		schema.Columns = append([]Column{{Name: tenantColumn, Type: "TEXT", Constraints: "NOT NULL"}}, schema.Columns...)
+	}
+
+	index := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %[1]s_tenant_idx ON %[1]s (%[2]s)",
+		schema.TableName, strings.Join(append([]string{tenantColumn}, indexCols...), ", "))
+	return constraints, index
+}
+
+// checkTenantScoping rejects Go code passing queries of tables without tenant_id to database methods. Only queries
+// written as string literals, directly or through constants and variables, are checked.
+func checkTenantScoping(code string) error {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", code, 0)
+	if err != nil {
+		return nil
+	}
+
+	literals := make(map[string]string)
+	ast.Inspect(file, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.ValueSpec:
+			for i, name := range n.Names {
+				if i < len(n.Values) {
+					if value, ok := stringLiteralValue(n.Values[i]); ok {
+						literals[name.Name] = value
+					}
+				}
+			}
+		case *ast.AssignStmt:
+			for i, lhs := range n.Lhs {
+				ident, ok := lhs.(*ast.Ident)
+				if !ok || i >= len(n.Rhs) {
+					continue
+				}
+				if value, ok := stringLiteralValue(n.Rhs[i]); ok {
+					literals[ident.Name] = value
+				}
+			}
+		}
+		return true
+	})
+
+	var errs []error
+	ast.Inspect(file, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		method := sel.Sel.Name
+		idx, ok := sqlQueryArgs[strings.TrimSuffix(method, "Context")]
+		if !ok {
+			return true
+		}
+		if strings.HasSuffix(method, "Context") {
+			idx++
+		}
+		if idx >= len(call.Args) {
+			return true
+		}
+
+		query, ok := stringLiteralValue(call.Args[idx])
+		if ident, isIdent := call.Args[idx].(*ast.Ident); isIdent {
+			query, ok = literals[ident.Name]
+		}
+		if ok && tableQueryRegexp.MatchString(query) && !strings.Contains(query, tenantColumn) {
+			errs = append(errs, fmt.Errorf("line %d: query passed to %s is not scoped to the tenant, filter it by %s",
+				fset.Position(call.Pos()).Line, method, tenantColumn))
+		}
+		return true
+	})
+
+	return errors.Join(errs...)
+}
+
+// stringLiteralValue returns the value of a string literal, or a concatenation of string literals.
+func stringLiteralValue(expr ast.Expr) (string, bool) {
+	switch e := expr.(type) {
+	case *ast.BasicLit:
+		if e.Kind != token.STRING {
+			return "", false
+		}
+		value, err := strconv.Unquote(e.Value)
+		return value, err == nil
+	case *ast.ParenExpr:
+		return stringLiteralValue(e.X)
+	case *ast.BinaryExpr:
+		if e.Op != token.ADD {
+			return "", false
+		}
+		x, ok := stringLiteralValue(e.X)
+		if !ok {
+			return "", false
+		}
+		y, ok := stringLiteralValue(e.Y)
+		return x + y, ok
+	}
+	return "", false
+}
@@ -0,0 +1,401 @@
+package tooling
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const (
+	TerraformAWS = "aws"
+	TerraformGCP = "gcp"
+)
+
+// terraformDir keeps the Terraform configuration of the database, relative to the project root.
+const terraformDir = "deploy/terraform"
+
+// terraformNameRegexp matches names valid for the instances, databases, and users of all cloud providers.
+var terraformNameRegexp = regexp.MustCompile(`^[a-z]([a-z0-9-]{0,38}[a-z0-9])?$`)
+
+// The database credentials are stored in a secret as JSON with the variables the generated app reads them from, so it
+// can be loaded into its environment as it is.
+const (
+	terraformGitignore = `.terraform/
+*.tfstate
+*.tfstate.*
+*.tfvars
+`
+	terraformAWSMainTf = `terraform {
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 5.0"
+    }
+    random = {
+      source  = "hashicorp/random"
+      version = "~> 3.6"
+    }
+    postgresql = {
+      source  = "cyrilgdn/postgresql"
+      version = "~> 1.25"
+    }
+  }
+}
+
+provider "aws" {
+  region = var.region
+}
+
+resource "random_password" "admin" {
+  length  = 32
+  special = false
+}
+
+resource "random_password" "app" {
+  length  = 32
+  special = false
+}
+
+resource "aws_db_instance" "main" {
+  identifier                = var.name
+  engine                    = "postgres"
+  engine_version            = var.postgres_version
+  instance_class            = var.instance_class
+  allocated_storage         = var.allocated_storage
+  storage_encrypted         = true
+  username                  = "dbadmin"
+  password                  = random_password.admin.result
+  db_subnet_group_name      = var.db_subnet_group_name
+  vpc_security_group_ids    = var.vpc_security_group_ids
+  publicly_accessible       = false
+  backup_retention_period   = 7
+  deletion_protection       = true
+  final_snapshot_identifier = "${var.name}-final"
+}
+
+# The database and the user of the app are created with the admin user, which requires network access to the instance,
+# e.g. running Terraform from inside the VPC.
+provider "postgresql" {
+  host      = aws_db_instance.main.address
+  port      = aws_db_instance.main.port
+  username  = aws_db_instance.main.username
+  password  = random_password.admin.result
+  sslmode   = "require"
+  superuser = false
+}
+
+resource "postgresql_role" "app" {
+  name     = var.db_user
+  login    = true
+  password = random_password.app.result
+}
+
+resource "postgresql_database" "app" {
+  name  = var.db_name
+  owner = postgresql_role.app.name
+}
+
+resource "aws_secretsmanager_secret" "database" {
+  name = "${var.name}/database"
+}
+
+resource "aws_secretsmanager_secret_version" "database" {
+  secret_id = aws_secretsmanager_secret.database.id
+  secret_string = jsonencode({
+    PG_HOST     = aws_db_instance.main.address
+    PG_PORT     = tostring(aws_db_instance.main.port)
+    PG_DATABASE = postgresql_database.app.name
+    PG_USER     = postgresql_role.app.name
+    PG_PASSWORD = random_password.app.result
+    PG_SSLMODE  = "require"
+  })
+}
+`
+	terraformAWSVariablesTf = `variable "name" {
+  description = "Identifier of the database instance, prefixing its secret."
+  type        = string
+  default     = "%[1]s"
+}
+
+variable "db_name" {
+  description = "Database of the app (PG_DATABASE)."
+  type        = string
+  default     = "%[1]s"
+}
+
+variable "db_user" {
+  description = "User of the app (PG_USER)."
+  type        = string
+  default     = "%[1]s"
+}
+
+variable "region" {
+  description = "AWS region of the database."
+  type        = string
+}
+
+variable "postgres_version" {
+  description = "PostgreSQL major version."
+  type        = string
+  default     = "17"
+}
+
+variable "instance_class" {
+  description = "RDS instance class."
+  type        = string
+  default     = "db.t4g.micro"
+}
+
+variable "allocated_storage" {
+  description = "Storage of the instance in GiB."
+  type        = number
+  default     = 20
+}
+
+variable "db_subnet_group_name" {
+  description = "DB subnet group of the VPC the app runs in."
+  type        = string
+}
+
+variable "vpc_security_group_ids" {
+  description = "Security groups allowing the app to connect to the instance."
+  type        = list(string)
+}
+`
+	terraformAWSOutputsTf = `output "pg_host" {
+  value = aws_db_instance.main.address
+}
+
+output "pg_port" {
+  value = aws_db_instance.main.port
+}
+
+output "pg_database" {
+  value = postgresql_database.app.name
+}
+
+output "pg_user" {
+  value = postgresql_role.app.name
+}
+
+output "database_secret_arn" {
+  description = "Secret with the PG_* variables of the app, PG_PASSWORD included."
+  value       = aws_secretsmanager_secret.database.arn
+}
+`
+	terraformGCPMainTf = `terraform {
+  required_providers {
+    google = {
+      source  = "hashicorp/google"
+      version = "~> 6.0"
+    }
+    random = {
+      source  = "hashicorp/random"
+      version = "~> 3.6"
+    }
+  }
+}
+
+provider "google" {
+  project = var.project
+  region  = var.region
+}
+
+resource "random_password" "app" {
+  length  = 32
+  special = false
+}
+
+resource "google_sql_database_instance" "main" {
+  name                = var.name
+  database_version    = "POSTGRES_${var.postgres_version}"
+  region              = var.region
+  deletion_protection = true
+
+  settings {
+    tier = var.tier
+
+    ip_configuration {
+      ipv4_enabled    = false
+      private_network = var.network
+      ssl_mode        = "ENCRYPTED_ONLY"
+    }
+
+    backup_configuration {
+      enabled = true
+    }
+  }
+}
+
+resource "google_sql_database" "app" {
+  name     = var.db_name
+  instance = google_sql_database_instance.main.name
+}
+
+resource "google_sql_user" "app" {
+  name     = var.db_user
+  instance = google_sql_database_instance.main.name
+  password = random_password.app.result
+}
+
+resource "google_secret_manager_secret" "database" {
+  secret_id = "${var.name}-database"
+
+  replication {
+    auto {}
+  }
+}
+
+resource "google_secret_manager_secret_version" "database" {
+  secret = google_secret_manager_secret.database.id
+  secret_data = jsonencode({
+    PG_HOST     = google_sql_database_instance.main.private_ip_address
+    PG_PORT     = "5432"
+    PG_DATABASE = google_sql_database.app.name
+    PG_USER     = google_sql_user.app.name
+    PG_PASSWORD = random_password.app.result
+    PG_SSLMODE  = "require"
+  })
+}
+`
+	terraformGCPVariablesTf = `variable "name" {
+  description = "Name of the database instance, prefixing its secret."
+  type        = string
+  default     = "%[1]s"
+}
+
+variable "db_name" {
+  description = "Database of the app (PG_DATABASE)."
+  type        = string
+  default     = "%[1]s"
+}
+
+variable "db_user" {
+  description = "User of the app (PG_USER)."
+  type        = string
+  default     = "%[1]s"
+}
+
+variable "project" {
+  description = "Google Cloud project of the database."
+  type        = string
+}
+
+variable "region" {
+  description = "Region of the database."
+  type        = string
+}
+
+variable "postgres_version" {
+  description = "PostgreSQL major version."
+  type        = string
+  default     = "17"
+}
+
+variable "tier" {
+  description = "Machine tier of the instance."
+  type        = string
+  default     = "db-f1-micro"
+}
+
+variable "network" {
+  description = "Self link of the VPC network the app runs in, with private services access."
+  type        = string
+}
+`
+	terraformGCPOutputsTf = `output "pg_host" {
+  value = google_sql_database_instance.main.private_ip_address
+}
+
+output "pg_port" {
+  value = 5432
+}
+
+output "pg_database" {
+  value = google_sql_database.app.name
+}
+
+output "pg_user" {
+  value = google_sql_user.app.name
+}
+
+output "database_secret_id" {
+  description = "Secret with the PG_* variables of the app, PG_PASSWORD included."
+  value       = google_secret_manager_secret.database.id
+}
+`
+)
+
+// terraformFiles are the files of the Terraform configuration by cloud provider. Variables files are formats of the
+// default name.
+var terraformFiles = map[string]map[string]string{
+	TerraformAWS: {"main.tf": terraformAWSMainTf, "variables.tf": terraformAWSVariablesTf, "outputs.tf": terraformAWSOutputsTf},
+	TerraformGCP: {"main.tf": terraformGCPMainTf, "variables.tf": terraformGCPVariablesTf, "outputs.tf": terraformGCPOutputsTf},
+}
+
+const GenerateTerraformToolName = "generate_terraform"
+
+func (s *Service) GenerateTerraformTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(GenerateTerraformToolName),
+			Description: openai.String("Generates Terraform provisioning a managed PostgreSQL instance (AWS RDS or Google Cloud SQL) with the database and user of the app, and a secret holding the PG_* variables the generated app reads. Use it when the user deploys to managed PostgreSQL."),
+			Parameters: openai.F(openai.FunctionParameters{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"provider": map[string]interface{}{
+						"type": "string",
+						"enum": []string{TerraformAWS, TerraformGCP},
+					},
+					"name": map[string]string{
+						"type":        "string",
+						"description": "Name of the instance, database, and user, e.g. the name of the project in lower case.",
+					},
+				},
+				"required": []string{"provider"},
+			}),
+		}),
+	}
+}
+
+func (s *Service) GenerateTerraform(arguments string) string {
+	var args map[string]interface{}
+	if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+		return fmt.Sprintf("Failed to unmarshal function arguments: %v", err)
+	}
+	provider, _ := args["provider"].(string)
+	files, ok := terraformFiles[provider]
+	if !ok {
+		return fmt.Sprintf("Unsupported provider %q, use %s or %s", provider, TerraformAWS, TerraformGCP)
+	}
+	name, _ := args["name"].(string)
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		name = strings.ToLower(projectModule)
+	}
+	if !terraformNameRegexp.MatchString(name) {
+		return fmt.Sprintf("Invalid name %q: use up to 40 lower case letters, digits, and hyphens, starting with a letter", name)
+	}
+
+	dir := path.Join(os.Getenv("PROJECT_ROOT"), terraformDir)
+	for file, content := range files {
+		if file == "variables.tf" {
+			content = fmt.Sprintf(content, name)
+		}
+		if err := s.writeFile(path.Join(dir, file), content); err != nil {
+			return fmt.Sprintf("Failed to save Terraform %s: %v", file, err)
+		}
+	}
+	if err := s.writeFile(path.Join(dir, ".gitignore"), terraformGitignore); err != nil {
+		return fmt.Sprintf("Failed to save Terraform .gitignore: %v", err)
+	}
+	return fmt.Sprintf("Terraform of the %s database saved to %s. Tell the user to set the variables without defaults in "+
+		"a terraform.tfvars file, run terraform apply there, and load the PG_* variables of the app from the secret "+
+		"it outputs.", provider, terraformDir)
+}
@@ -4,53 +4,301 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/openai/openai-go"
 	"github.com/pterm/pterm"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/redact"
+	"github.com/doubletabai/doubletab/pkg/telemetry"
 	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+const (
+	APIStyleOpenAPI = "openapi"
+	APIStyleGraphQL = "graphql"
 )
 
 type Service struct {
 	DB        *sqlx.DB
 	KS        *vector.KnowledgeService
 	Mem       *vector.MemoryService
-	OpenAICli *openai.Client
+	LLM       llm.Provider
 	ChatModel string
 	CodeModel string
+	APIStyle  string
 	TmpDir    string
+	// ToolsDir is the directory missing code generation and checking tools are installed into.
+	ToolsDir string
+	// ProjectDBEnv connects the generated server, when it's run, to the project database.
+	ProjectDBEnv []string
+	// Workflow tracks the steps of the session, so they can be rolled back.
+	Workflow *workflow.Workflow
+	// History records what was generated for the project in every session.
+	History *vector.HistoryService
+	// Lock guards the project against other instances of DoubleTab while the session works on it.
+	Lock *ProjectLock
+	// Approvals are the approval policies of tools by their names, "*" for all other tools. ProjectApprovals are the
+	// ones of the config file of the project, which Approvals override.
+	Approvals        map[string]string
+	ProjectApprovals map[string]string
+	// Audit records every tool invocation, and AuditFile, when set, is the JSONL file they are appended to as well.
+	Audit     *vector.AuditService
+	AuditFile string
+	// Confirm asks the user to approve a tool call, as the approval policy requires. It's nil in headless runs.
+	Confirm func(question string) bool
+	// ConfirmLater asks the user to approve a tool call without waiting for the answer, used instead of Confirm when
+	// users answer at their own pace, like in chat threads. It reports whether the user approved the same call already.
+	ConfirmLater func(question string) bool
+	// Checkpoints persist the conversation and workflow state of the session after every turn, so it can be resumed
+	// after a crash.
+	Checkpoints *vector.CheckpointService
+	// EditFile, when set, writes the generated files instead of writing them directly, e.g. through the workspace API
+	// of an editor, so its open documents are updated and the edits can be undone there. The file must be saved once
+	// it returns, as tools read it right after.
+	EditFile func(name, content string) error
+
+	RepositoryLayer bool
+	ServiceLayer    bool
+	FileStorage     string
+	BulkEndpoints   bool
+	RateLimit       bool
+	CORS            bool
+	MultiTenant     bool
+	LintSeverity    string
+	// NamingTables and NamingIDColumn are the naming conventions of generated tables and their primary keys.
+	NamingTables   string
+	NamingIDColumn string
+	// PGDriver is the driver (pq, pgx) of the database layer of the generated project.
+	PGDriver string
+	// APICollection is the format (postman, bruno, none) of the collection of example requests written with the spec.
+	APICollection string
+	// StrictVerification adds vetting and tests with the race detector to building of the generated code.
+	StrictVerification bool
+	// PlanFirst adds writing a plan, approved by the user, before anything is generated.
+	PlanFirst bool
+	// GitCommits commits the files of every successful tool run to the git repository of the project.
+	GitCommits bool
+	// GitRemote is the git remote branches of pull requests are pushed to. Pull requests are opened against PRBase, or
+	// the default branch of the repository when it's empty, on Forge, or the forge detected by the host of the remote
+	// when it's empty, via its API with its token. The API URLs default to the host of the remote, or api.github.com for
+	// github.com.
+	GitRemote    string
+	PRBase       string
+	Forge        string
+	GitHubAPIURL string
+	GitHubToken  string
+	GitLabAPIURL string
+	GitLabToken  string
+	GiteaAPIURL  string
+	GiteaToken   string
+
+	// LiveUpdates lists tables with Server-Sent Events endpoints streaming their changes.
+	LiveUpdates []string
+	// FileUploads is set once the storage package for file fields is generated.
+	FileUploads bool
+	// Cache is set once the Redis cache wrapping the repository layer is generated.
+	Cache bool
+	// EventBroker is the message broker events are relayed to from the outbox, set once event publishing is generated.
+	EventBroker string
+	// Idempotency is set once Idempotency-Key handling of POST requests is generated.
+	Idempotency bool
+	// APIVersion is the current version of the API, served at /<version>. It's empty when the API isn't versioned.
+	APIVersion string
+	// FrozenAPIVersions lists previous versions of the API, served alongside the current one.
+	FrozenAPIVersions []FrozenAPIVersion
+	// Hooks are shell commands run after tools complete, e.g. formatters of the saved code.
+	Hooks []hook
+	// Webhooks are notified of workflow events, with payloads signed with WebhookSecret when it's set.
+	Webhooks      []webhook
+	WebhookSecret string
+	// webhookRedactor redacts the secrets of the config from build output sent to webhooks.
+	webhookRedactor *redact.Redactor
+	// completedWorkflows are the sessions whose completed workflows were notified to the webhooks.
+	completedWorkflows map[string]bool
+	// deliveries are the webhooks being delivered.
+	deliveries sync.WaitGroup
+
+	mu sync.Mutex
 }
 
-func New(cfg *config.Config, db *sqlx.DB, ks *vector.KnowledgeService, mem *vector.MemoryService, cli *openai.Client) (*Service, error) {
+func New(cfg *config.Config, db *sqlx.DB, ks *vector.KnowledgeService, mem *vector.MemoryService, provider llm.Provider) (*Service, error) {
 	tmpDir, err := os.MkdirTemp("", "doubletab-*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
 	}
-	return &Service{
+	toolsDir := cfg.ToolsDir
+	if toolsDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get home directory: %w", err)
+		}
+		toolsDir = filepath.Join(home, ".doubletab", "bin")
+	}
+	setGoFormat(cfg.GoFormatter, cfg.GoLocalPrefix)
+	hooks, err := parseHooks(cfg.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	webhooks, err := parseWebhooks(cfg.Webhooks)
+	if err != nil {
+		return nil, err
+	}
+	webhookRedactor, err := redact.New(cfg.Secrets(), cfg.Redact)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkApprovals(cfg.Approvals); err != nil {
+		return nil, err
+	}
+	if err := checkApprovals(cfg.ProjectApprovals); err != nil {
+		return nil, err
+	}
+	var apiVersion string
+	if cfg.APIVersioning {
+		apiVersion = "v1"
+	}
+	s := &Service{
 		DB:        db,
 		KS:        ks,
 		Mem:       mem,
-		OpenAICli: cli,
+		LLM:       provider,
 		ChatModel: cfg.LLMChatModel,
 		CodeModel: cfg.LLMCodeModel,
+		APIStyle:  cfg.APIStyle,
 		TmpDir:    tmpDir,
-	}, nil
+		ToolsDir:  toolsDir,
+		ProjectDBEnv: []string{
+			"PG_HOST=" + cfg.PGHost,
+			fmt.Sprintf("PG_PORT=%d", cfg.PGPort),
+			"PG_DATABASE=" + cfg.PGDatabase,
+			"PG_USER=" + cfg.PGUser,
+			"PG_PASSWORD=" + cfg.PGPassword,
+			"PG_SSLMODE=" + cfg.PGSSLMode,
+		},
+
+		// The service layer is built on top of the repository layer.
+		RepositoryLayer:    cfg.RepositoryLayer || cfg.ServiceLayer,
+		ServiceLayer:       cfg.ServiceLayer,
+		FileStorage:        cfg.FileStorage,
+		BulkEndpoints:      cfg.BulkEndpoints,
+		RateLimit:          cfg.RateLimit,
+		CORS:               cfg.CORS,
+		MultiTenant:        cfg.MultiTenant,
+		LintSeverity:       cfg.LintSeverity,
+		PGDriver:           cfg.PGDriver,
+		NamingTables:       cfg.NamingTables,
+		NamingIDColumn:     cfg.NamingIDColumn,
+		APICollection:      cfg.APICollection,
+		StrictVerification: cfg.StrictVerification,
+		PlanFirst:          cfg.PlanFirst,
+		GitCommits:         cfg.GitCommits,
+		GitRemote:          cfg.GitRemote,
+		PRBase:             cfg.PRBase,
+		Forge:              cfg.Forge,
+		GitHubAPIURL:       cfg.GitHubAPIURL,
+		GitHubToken:        cfg.GitHubToken,
+		GitLabAPIURL:       cfg.GitLabAPIURL,
+		GitLabToken:        cfg.GitLabToken,
+		GiteaAPIURL:        cfg.GiteaAPIURL,
+		GiteaToken:         cfg.GiteaToken,
+		APIVersion:         apiVersion,
+		Hooks:              hooks,
+		Webhooks:           webhooks,
+		webhookRedactor:    webhookRedactor,
+		WebhookSecret:      cfg.WebhookSecret,
+		Approvals:          cfg.Approvals,
+		ProjectApprovals:   cfg.ProjectApprovals,
+		AuditFile:          cfg.AuditFile,
+	}
+	if err := setFileHeader(cfg.FileHeader, cfg.GeneratedMarker, func() string { return s.Mem.SessionID }); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
 func (s *Service) Clear() {
+	s.deliveries.Wait()
 	os.RemoveAll(s.TmpDir)
 }
 
+// HandleToolCall runs the tool, once its approval policy allows it, and then its hooks, when it succeeded. The output
+// of the hooks is added to the response. Every invocation is recorded in the audit log, with the tool whose agent
+// called it as the caller.
 func (s *Service) HandleToolCall(ctx context.Context, multi *pterm.MultiPrinter, tool openai.ChatCompletionMessageToolCallFunction) string {
+	started := time.Now()
+	caller := toolCaller(ctx)
+	ctx, span := telemetry.Tracer().Start(ctx, "tool "+tool.Name, trace.WithAttributes(
+		attribute.String("tool.name", tool.Name),
+		attribute.String("tool.caller", caller),
+	))
+	defer span.End()
+
+	// Tools called by agents of other tools are steps of the progress of the tool the model called.
+	var p *progress
+	if caller == MainCaller {
+		ctx, p = trackProgress(ctx, multi, tool.Name)
+	} else {
+		showProgress(ctx, "running %s", tool.Name)
+	}
+	resp := s.runToolCall(context.WithValue(ctx, callerKey{}, tool.Name), multi, tool)
+	if p != nil {
+		if summary := p.summary(); summary != "" && !contentTools[tool.Name] {
+			resp = strings.TrimRight(resp, "\n") + "\n\n" + summary
+		}
+	} else {
+		result, _, _ := strings.Cut(resp, "\n")
+		reportProgress(ctx, "%s: %s", tool.Name, result)
+	}
+	if !Succeeded(resp) {
+		result, _, _ := strings.Cut(resp, "\n")
+		span.SetStatus(codes.Error, result)
+	}
+	telemetry.RecordContent(span, tool.Arguments, resp)
+	telemetry.ObserveTool(tool.Name, Succeeded(resp), time.Since(started))
+	if err := s.audit(ctx, caller, tool, resp, started); err != nil {
+		log.Err(err).Msgf("Failed to audit tool %s", tool.Name)
+	}
+	return resp
+}
+
+func (s *Service) runToolCall(ctx context.Context, multi *pterm.MultiPrinter, tool openai.ChatCompletionMessageToolCallFunction) string {
+	if reason := s.approve(tool.Name, tool.Arguments); reason != "" {
+		return fmt.Sprintf("Tool %s rejected: %s", tool.Name, reason)
+	}
+	hooks := s.toolHooks(tool.Name)
+	if len(hooks) == 0 {
+		return s.handleToolCall(ctx, multi, tool)
+	}
+	before := TakeSnapshot()
+	resp := s.handleToolCall(ctx, multi, tool)
+	if !Succeeded(resp) {
+		return resp
+	}
+	return resp + s.runHooks(ctx, tool.Name, hooks, before)
+}
+
+func (s *Service) handleToolCall(ctx context.Context, multi *pterm.MultiPrinter, tool openai.ChatCompletionMessageToolCallFunction) string {
 	switch tool.Name {
 	case GenerateOpenAPISpecToolName:
 		return s.GenerateOpenAPISpec(ctx, multi, tool.Arguments)
 	case ListTablesToolName:
 		return s.ListTables(ctx)
+	case AnalyzeProjectToolName:
+		return s.AnalyzeProject(ctx, multi)
+	case PublishPRToolName:
+		return s.PublishPR(ctx, multi, tool.Arguments)
 	case GenerateSchemaToolName:
 		return s.GenerateSchema(ctx, multi, tool.Arguments)
 	case StoreSchemaToolName:
@@ -61,17 +309,102 @@ func (s *Service) HandleToolCall(ctx context.Context, multi *pterm.MultiPrinter,
 		return s.GenerateServerCode(ctx, multi, tool.Arguments)
 	case SaveServerCodeToolName:
 		return s.SaveServerCode(ctx, tool.Arguments)
+	case SaveRepositoryCodeToolName:
+		return s.SaveRepositoryCode(ctx, tool.Arguments)
+	case SaveServiceCodeToolName:
+		return s.SaveServiceCode(ctx, tool.Arguments)
 	case BuildCodeToolName:
 		return s.BuildCode(ctx)
+	case RunTestsToolName:
+		return s.RunTests(ctx)
+	case VetCodeToolName:
+		return s.VetCode(ctx)
+	case LintCodeToolName:
+		return s.LintCode(ctx)
+	case SecurityScanToolName:
+		return s.SecurityScan(ctx, multi)
+	case RunAndVerifyToolName:
+		return s.RunAndVerify(ctx, multi)
+	case EditFunctionToolName:
+		return s.EditFunction(ctx, tool.Arguments)
+	case ApplyPatchToolName:
+		return s.ApplyPatch(ctx, tool.Arguments)
+	case ReconcileArtifactsToolName:
+		return s.ReconcileArtifacts(ctx, tool.Arguments)
+	case RollbackStepToolName:
+		return s.RollbackStep(ctx, tool.Arguments)
+	case ManageDepsToolName:
+		return s.ManageDeps(ctx, tool.Arguments)
+	case FuzzAPIToolName:
+		return s.FuzzAPI(ctx, multi, tool.Arguments)
+	case GenerateGraphQLSchemaToolName:
+		return s.GenerateGraphQLSchema(ctx, multi, tool.Arguments)
+	case GenerateResolversCodeToolName:
+		return s.GenerateResolversCode(ctx, multi)
+	case SaveResolversCodeToolName:
+		return s.SaveResolversCode(ctx, tool.Arguments)
+	case GenerateLiveUpdatesToolName:
+		return s.GenerateLiveUpdates(ctx, tool.Arguments)
+	case GenerateFileStorageToolName:
+		return s.GenerateFileStorage(ctx)
+	case GenerateCacheLayerToolName:
+		return s.GenerateCacheLayer(ctx)
+	case GenerateEventPublishingToolName:
+		return s.GenerateEventPublishing(ctx, tool.Arguments)
+	case GenerateIdempotencyToolName:
+		return s.GenerateIdempotency(ctx)
+	case GenerateTerraformToolName:
+		return s.GenerateTerraform(tool.Arguments)
+	case CreateAPIVersionToolName:
+		return s.CreateAPIVersion()
+	case VerifyConsistencyToolName:
+		return s.VerifyConsistency(ctx)
+	case RunQueryToolName:
+		return s.RunQuery(ctx, tool.Arguments)
+	case GenerateERDToolName:
+		return s.GenerateERD(ctx, tool.Arguments)
+	case GenerateReadmeToolName:
+		return s.GenerateReadme(ctx, multi)
 	case QueryKnowledgeBaseToolName:
 		return s.QueryKnowledgeBase(ctx, tool.Arguments)
 	case QueryMemoryToolName:
 		return s.QueryMemory(ctx, tool.Arguments)
+	case WritePlanToolName:
+		return s.WritePlan(tool.Arguments)
 	default:
 		return fmt.Sprintf("I don't know how to handle this tool call: %s", tool.Name)
 	}
 }
 
+// WorkflowNotes returns additional instructions for the main workflow based on enabled generation options.
+func (s *Service) WorkflowNotes() string {
+	var notes []string
+	if s.ServiceLayer {
+		notes = append(notes, "- While agreeing on the entities, also ask the user about business rules (validations, approvals, limits)\n"+
+			"  for every entity, as they will be stubbed in the generated service layer.")
+	}
+	if !s.BulkEndpoints {
+		notes = append(notes, "- When the user mentions high-volume ingestion or bulk imports of an entity, pass it to the spec\n"+
+			"  generation, so batch endpoints are generated for it.")
+	}
+	if s.APIVersion != "" && s.APIStyle == APIStyleOpenAPI {
+		notes = append(notes, "- The API is versioned. When the user changes entities incompatibly (renamed or removed fields, changed\n"+
+			"  types) after the code is generated, use \"create_api_version\" tool first, then regenerate the spec, schema, and code.")
+	}
+	if s.PlanFirst {
+		notes = append(notes, "- Once the entities are agreed on, write a plan with \"write_plan\" tool. Tools generating the project are\n"+
+			"  rejected until the user approves the plan, so ask them to review PLAN.md and change it until they approve it.")
+	}
+	if s.MultiTenant {
+		notes = append(notes, "- The project is multi-tenant: tenant_id columns and tenant scoping of queries are added automatically,\n"+
+			"  so don't add tenant fields to the entities.")
+	}
+	if len(notes) == 0 {
+		return ""
+	}
+	return strings.Join(notes, "\n") + "\n"
+}
+
 type Agent struct {
 	ts     *Service
 	params openai.ChatCompletionNewParams
@@ -101,27 +434,34 @@ func (a *Agent) WithModel(model string) *Agent {
 }
 
 func (a *Agent) Run(ctx context.Context) string {
+	ctx, span := telemetry.Tracer().Start(ctx, "agent", trace.WithAttributes(
+		attribute.String("agent.tool", toolCaller(ctx)),
+		attribute.String("agent.model", a.params.Model.Value),
+	))
+	defer span.End()
+
 	if len(a.params.Tools.Value) == 0 {
-		completion, err := a.ts.OpenAICli.Chat.Completions.New(ctx, a.params)
+		completion, err := a.ts.LLM.Complete(ctx, a.params)
 		if err != nil {
 			return fmt.Sprintf("Failed to get completion: %v", err)
 		}
+		a.params.Messages.Value = append(a.params.Messages.Value, completion.Choices[0].Message)
 		return completion.Choices[0].Message.Content
 	}
 
 	var finalMessage string
 	for {
-		completion, err := a.ts.OpenAICli.Chat.Completions.New(ctx, a.params)
+		completion, err := a.ts.LLM.Complete(ctx, a.params)
 		if err != nil {
 			return fmt.Sprintf("Failed to get completion: %v", err)
 		}
 		toolCalls := completion.Choices[0].Message.ToolCalls
+		a.params.Messages.Value = append(a.params.Messages.Value, completion.Choices[0].Message)
 		if len(toolCalls) == 0 && completion.Choices[0].FinishReason == "stop" {
 			finalMessage = completion.Choices[0].Message.Content
 			break
 		}
 
-		a.params.Messages.Value = append(a.params.Messages.Value, completion.Choices[0].Message)
 		for _, toolCall := range toolCalls {
 			if ctx.Err() != nil {
 				return "Context canceled"
@@ -142,6 +482,12 @@ func (a *Agent) Run(ctx context.Context) string {
 	return finalMessage
 }
 
+// Continue adds the input to the conversation of the agent, which was run before, and runs it again.
+func (a *Agent) Continue(ctx context.Context, userInput string) string {
+	a.params.Messages.Value = append(a.params.Messages.Value, openai.UserMessage(userInput))
+	return a.Run(ctx)
+}
+
 func TrimNonCode(text, typ string) string {
 	parts := strings.Split(text, "```"+typ)
 	if len(parts) == 1 {
@@ -0,0 +1,288 @@
+package tooling
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+)
+
+const (
+	// serverStartTimeout limits how long the generated server may take to start accepting requests.
+	serverStartTimeout = 15 * time.Second
+	// verifyTenant is the tenant requests verifying multi-tenant servers are made for.
+	verifyTenant = "doubletab-verify"
+)
+
+const RunAndVerifyToolName = "run_and_verify"
+
+func (s *Service) RunAndVerifyTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(RunAndVerifyToolName),
+			Description: openai.String("Builds and starts the generated server against the project database and smoke-tests it over HTTP, running the create, get, list, update, and delete cycle of every resource. Reports failures to fix before the workflow is done."),
+		}),
+	}
+}
+
+func (s *Service) RunAndVerify(ctx context.Context, multi *pterm.MultiPrinter) string {
+	spinner := NewSpinner(multi, "Verifying the server...")
+	defer spinner.Success("Server verified")
+
+	srv, err := s.startServer(ctx)
+	if err != nil {
+		return fmt.Sprintf("Failed to start the server: %v", err)
+	}
+
+	var verified string
+	var failures []string
+	if s.APIStyle == APIStyleGraphQL {
+		verified = "GraphQL endpoint"
+		failures = srv.verifyGraphQL(ctx, s.BasePath())
+	} else {
+		spec, err := loadOpenAPISpec()
+		if err != nil {
+			srv.stop()
+			return err.Error()
+		}
+		var names []string
+		for _, r := range spec.resources() {
+			names = append(names, r.Name)
+		}
+		verified = "CRUD cycle of " + strings.Join(names, ", ")
+		failures = srv.verifyCRUD(ctx, spec, s.BasePath())
+	}
+	output := srv.stop()
+
+	if len(failures) > 0 {
+		return fmt.Sprintf("Server verification failed, fix the code and verify it again:\n%s\n\nServer output:\n%s",
+			strings.Join(failures, "\n"), output)
+	}
+	return fmt.Sprintf("Server started and verified: %s", verified)
+}
+
+// runningServer is the generated server started for verification.
+type runningServer struct {
+	cmd     *exec.Cmd
+	baseURL string
+	headers http.Header
+	output  bytes.Buffer
+	done    chan error
+}
+
+// startServer builds the generated server and starts it on a free port, waiting until it accepts requests.
+func (s *Service) startServer(ctx context.Context) (*runningServer, error) {
+	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of project root: %w", err)
+	}
+	binary := filepath.Join(s.TmpDir, "server")
+	cmd := exec.CommandContext(ctx, "go", "build", "-o", binary, ".")
+	cmd.Dir = absRoot
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("go build failed: %w\n%s", err, output)
+	}
+
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find a free port: %w", err)
+	}
+	port := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+	l.Close()
+
+	srv := &runningServer{
+		cmd:     exec.CommandContext(ctx, binary),
+		baseURL: "http://localhost:" + port,
+		headers: make(http.Header),
+		done:    make(chan error, 1),
+	}
+	srv.cmd.Dir = absRoot
+	srv.cmd.Env = append(os.Environ(), s.ProjectDBEnv...)
+	srv.cmd.Env = append(srv.cmd.Env, "PORT="+port)
+	if s.MultiTenant {
+		// Tenants are identified by the header, as tokens of the JWT secret from .env can't be issued.
+		srv.cmd.Env = append(srv.cmd.Env, "JWT_SECRET=", "TENANT_HEADER=X-Tenant-ID")
+		srv.headers.Set("X-Tenant-ID", verifyTenant)
+	}
+	srv.cmd.Stdout = &srv.output
+	srv.cmd.Stderr = &srv.output
+	if err := srv.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start the server: %w", err)
+	}
+	go func() {
+		srv.done <- srv.cmd.Wait()
+	}()
+
+	deadline := time.After(serverStartTimeout)
+	for {
+		select {
+		case err := <-srv.done:
+			return nil, fmt.Errorf("server exited: %v\n%s", err, srv.output.String())
+		case <-deadline:
+			return nil, fmt.Errorf("server didn't accept requests within %s\n%s", serverStartTimeout, srv.stop())
+		case <-time.After(200 * time.Millisecond):
+		}
+		if conn, err := net.Dial("tcp", "localhost:"+port); err == nil {
+			conn.Close()
+			return srv, nil
+		}
+	}
+}
+
+// stop stops the server and returns its output.
+func (srv *runningServer) stop() string {
+	_ = srv.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-srv.done:
+	case <-time.After(5 * time.Second):
+		_ = srv.cmd.Process.Kill()
+		<-srv.done
+	}
+	return srv.output.String()
+}
+
+// request sends the request with a JSON body, if not nil, and returns the response status and body.
+func (srv *runningServer) request(ctx context.Context, method, path string, body any) (int, []byte, error) {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return 0, nil, err
+		}
+	}
+	return srv.send(ctx, method, path, data)
+}
+
+// send sends the request with the JSON encoded body, if not nil, and returns the response status and body.
+func (srv *runningServer) send(ctx context.Context, method, path string, data []byte) (int, []byte, error) {
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, srv.baseURL+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	for k, v := range srv.headers {
+		req.Header[k] = v
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return resp.StatusCode, respBody, err
+}
+
+// expect sends the request and returns a failure when the response status isn't one of the expected ones.
+func (srv *runningServer) expect(ctx context.Context, method, path string, body any, statuses ...int) ([]byte, error) {
+	status, respBody, err := srv.request(ctx, method, path, body)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", method, path, err)
+	}
+	for _, expected := range statuses {
+		if status == expected {
+			return respBody, nil
+		}
+	}
+	if len(respBody) > 500 {
+		respBody = respBody[:500]
+	}
+	return nil, fmt.Errorf("%s %s: expected status %v, got %d: %s", method, path, statuses, status, respBody)
+}
+
+// verifyCRUD creates every resource, gets, lists, and updates it, and deletes the created resources in reverse order.
+func (srv *runningServer) verifyCRUD(ctx context.Context, spec *openAPISpec, basePath string) []string {
+	type created struct {
+		resource specResource
+		path     string
+	}
+	var failures []string
+	var deletes []created
+	ids := make(map[string]string)
+	for _, r := range spec.resources() {
+		body, err := srv.expect(ctx, http.MethodPost, basePath+r.Path, spec.sampleValue(r.Schema, ids, 0),
+			http.StatusOK, http.StatusCreated)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		var resource map[string]any
+		if err := json.Unmarshal(body, &resource); err != nil {
+			failures = append(failures, fmt.Sprintf("POST %s: invalid JSON response: %v", r.Path, err))
+			continue
+		}
+		id, ok := resource["id"].(string)
+		if !ok {
+			failures = append(failures, fmt.Sprintf("POST %s: response has no id: %s", r.Path, body))
+			continue
+		}
+		ids[r.Name] = id
+
+		itemPath := basePath + r.ItemPath[:strings.LastIndex(r.ItemPath, "{")] + id
+		deletes = append(deletes, created{resource: r, path: itemPath})
+		if _, err := srv.expect(ctx, http.MethodGet, itemPath, nil, http.StatusOK); err != nil {
+			failures = append(failures, err.Error())
+		}
+		if _, err := srv.expect(ctx, http.MethodGet, basePath+r.Path, nil, http.StatusOK); err != nil {
+			failures = append(failures, err.Error())
+		}
+		if put := spec.Paths[r.ItemPath].Put.requestSchema(); put != nil {
+			if _, err := srv.expect(ctx, http.MethodPut, itemPath, spec.sampleValue(put, ids, 0),
+				http.StatusOK, http.StatusNoContent); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+
+	for i := len(deletes) - 1; i >= 0; i-- {
+		d := deletes[i]
+		if spec.Paths[d.resource.ItemPath].Delete == nil {
+			continue
+		}
+		if _, err := srv.expect(ctx, http.MethodDelete, d.path, nil, http.StatusOK, http.StatusNoContent); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if _, err := srv.expect(ctx, http.MethodGet, d.path, nil, http.StatusNotFound); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	return failures
+}
+
+// verifyGraphQL sends a query every GraphQL server answers.
+func (srv *runningServer) verifyGraphQL(ctx context.Context, basePath string) []string {
+	body, err := srv.expect(ctx, http.MethodPost, basePath+"/query", map[string]string{"query": "{ __typename }"},
+		http.StatusOK)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var resp struct {
+		Errors []json.RawMessage
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return []string{fmt.Sprintf("POST %s/query: invalid JSON response: %v", basePath, err)}
+	}
+	if len(resp.Errors) > 0 {
+		return []string{fmt.Sprintf("POST %s/query: query failed: %s", basePath, body)}
+	}
+	return nil
+}
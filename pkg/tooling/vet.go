@@ -0,0 +1,51 @@
+package tooling
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/openai/openai-go"
+)
+
+const VetCodeToolName = "vet_code"
+
+func (s *Service) VetCodeTool() openai.ChatCompletionToolParam {
+	return openai.ChatCompletionToolParam{
+		Type: openai.F(openai.ChatCompletionToolTypeFunction),
+		Function: openai.F(openai.FunctionDefinitionParam{
+			Name:        openai.String(VetCodeToolName),
+			Description: openai.String("Runs go vet, and staticcheck when installed, on the generated Go code and reports issues like nil dereferences, shadowed or unused variables."),
+		}),
+	}
+}
+
+func (s *Service) VetCode(ctx context.Context) string {
+	absRoot, err := filepath.Abs(os.Getenv("PROJECT_ROOT"))
+	if err != nil {
+		return fmt.Sprintf("Failed to get absolute path of project root: %v", err)
+	}
+
+	var reports []string
+	linters := [][]string{{"go", "vet", "./..."}}
+	// staticcheck is optional, it's run only when it's installed.
+	if bin, ok := s.toolPath("staticcheck"); ok {
+		linters = append(linters, []string{bin, "./..."})
+	}
+	for _, args := range linters {
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = absRoot
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			reports = append(reports, fmt.Sprintf("%s failed: %v\n%s", strings.Join(args, " "), err, output))
+		}
+	}
+	if len(reports) > 0 {
+		return strings.Join(reports, "\n") + "\nFix the reported issues and re-generate the code."
+	}
+
+	return "No issues found"
+}
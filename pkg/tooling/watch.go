@@ -0,0 +1,103 @@
+package tooling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pterm/pterm"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+)
+
+const watchedSpecPrompt = `
+
+The spec was edited by hand, it's the source of truth now. The changes since the project was generated from it last are:
+` + "```diff\n%s```"
+
+// Watch regenerates the handlers, the PostgreSQL schema, and the server code whenever the OpenAPI spec of the project is
+// edited by hand, treating the spec as the source of truth, until the context is done. The spec is checked every
+// interval. Changes are validated and previewed as a diff, and the code is only regenerated once confirm accepts the
+// preview. Only OpenAPI projects can be watched.
+func (s *Service) Watch(ctx context.Context, interval time.Duration, confirm func(preview string) bool) error {
+	if s.APIStyle != APIStyleOpenAPI {
+		return fmt.Errorf("only OpenAPI projects can be watched, the project uses %s", s.APIStyle)
+	}
+	last, err := os.ReadFile(specPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read OpenAPI spec: %w", err)
+	}
+	pterm.DefaultBasicText.Printfln("Watching %s for changes, press Ctrl+C to stop", specPath())
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var invalid []byte
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		spec, err := os.ReadFile(specPath())
+		if err != nil {
+			// Editors replace files on save, so the spec may be missing for a moment.
+			continue
+		}
+		if string(spec) == string(last) || string(spec) == string(invalid) {
+			continue
+		}
+		// Invalid specs are reported once and the baseline is kept, so the preview of the fixed spec shows all changes.
+		if err := validateOpenAPISpec(ctx, string(spec)); err != nil {
+			pterm.Error.Printfln("The edited spec is invalid, fix it to regenerate the code:\n%v", err)
+			invalid = spec
+			continue
+		}
+		diff := lineDiff("pkg/api/doc/openapi.yaml", string(last), string(spec))
+		// Declined changes become the baseline too, the next preview only shows the changes made after them.
+		if confirm(diff) {
+			s.regenerateFromSpec(ctx, string(spec), diff)
+		}
+		last = spec
+	}
+}
+
+// regenerateFromSpec regenerates the handlers, the schema, and the server code from the edited spec, printing the
+// results of the steps.
+func (s *Service) regenerateFromSpec(ctx context.Context, spec, diff string) {
+	before := TakeSnapshot()
+	defer func() {
+		if err := s.RecordHistory(ctx, "", config.CommandWatch, before); err != nil {
+			pterm.Warning.Printfln("Failed to record history: %v", err)
+		}
+	}()
+
+	if err := trackFile(specPath(), spec); err != nil {
+		pterm.Warning.Printfln("Failed to track the edited spec: %v", err)
+	}
+	if err := s.writeAPICollection(); err != nil {
+		pterm.Warning.Printfln("Failed to write API collection: %v", err)
+	}
+
+	resp := s.GenerateHandlersCode(ctx, nil)
+	pterm.DefaultBasicText.Println(resp)
+	if !Succeeded(resp) {
+		return
+	}
+
+	input := spec + fmt.Sprintf(watchedSpecPrompt, diff)
+	arguments, _ := json.Marshal(map[string]string{"api_spec": input})
+	pterm.DefaultBasicText.Println(s.GenerateSchema(ctx, nil, string(arguments)))
+
+	shared, err := s.sharedServerFiles()
+	if err != nil {
+		pterm.Error.Printfln("Failed to read the server code: %v", err)
+		return
+	}
+	input += "\n\nUpdate the server code to implement the changed operations, keep the code of the others." + shared
+	arguments, _ = json.Marshal(map[string]string{"openapi_spec": input})
+	pterm.DefaultBasicText.Println(s.GenerateServerCode(ctx, nil, string(arguments)))
+}
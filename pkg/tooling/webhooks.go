@@ -0,0 +1,193 @@
+package tooling
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// Events webhooks are notified of.
+const (
+	WebhookStepCompleted     = "step.completed"
+	WebhookBuildFailed       = "build.failed"
+	WebhookWorkflowCompleted = "workflow.completed"
+)
+
+var webhookEvents = []string{WebhookStepCompleted, WebhookBuildFailed, WebhookWorkflowCompleted}
+
+const (
+	// webhookTimeout limits every delivery attempt of a webhook.
+	webhookTimeout = 10 * time.Second
+	// webhookAttempts is the number of attempts to deliver a webhook which failed or responded with a server error.
+	webhookAttempts = 3
+	// maxWebhookError limits the build output sent with build failures.
+	maxWebhookError = 4000
+)
+
+// webhook is a URL notified of workflow events, of all events when event is empty.
+type webhook struct {
+	event string
+	url   string
+}
+
+// webhookPayload is the JSON body webhooks are POSTed.
+type webhookPayload struct {
+	Event   string `json:"event"`
+	Session string `json:"session"`
+	// Step is the step completed, or of the failed build, empty for builds of no step and completed workflows.
+	Step string `json:"step,omitempty"`
+	// Artifacts are the files matching the artifact patterns of the completed step, or of all steps of the completed
+	// workflow.
+	Artifacts []string `json:"artifacts,omitempty"`
+	// Error is the output of the failed build.
+	Error     string    `json:"error,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// parseWebhooks parses webhooks given as URLs, or as event=URL for webhooks of one event.
+func parseWebhooks(specs []string) ([]webhook, error) {
+	var webhooks []webhook
+	for _, spec := range specs {
+		var h webhook
+		h.url = strings.TrimSpace(spec)
+		if event, rawURL, ok := strings.Cut(h.url, "="); ok && slices.Contains(webhookEvents, event) {
+			h.event, h.url = event, rawURL
+		}
+		if u, err := url.Parse(h.url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid webhook %q, it must be [<event>=]<http(s) URL> (events: %s)", spec,
+				strings.Join(webhookEvents, ", "))
+		}
+		webhooks = append(webhooks, h)
+	}
+	return webhooks, nil
+}
+
+// NotifyToolRun notifies the webhooks of the events of the tool run of the workflow step, or of no step when it's
+// nil: the failure of the build, or the completion of the step, unless it was completed before the run already. The
+// build output is redacted, as it may contain secrets, like connection strings of failing tests.
+func (s *Service) NotifyToolRun(wf *workflow.Workflow, step *workflow.Step, completed bool, tool, resp string) {
+	if len(s.Webhooks) == 0 {
+		return
+	}
+	if tool == BuildCodeToolName && !Succeeded(resp) {
+		if s.webhookRedactor != nil {
+			resp = s.webhookRedactor.Redact(resp)
+		}
+		if runes := []rune(resp); len(runes) > maxWebhookError {
+			resp = string(runes[:maxWebhookError])
+		}
+		p := webhookPayload{Event: WebhookBuildFailed, Session: wf.SessionID(), Error: resp}
+		if step != nil {
+			p.Step = step.Name
+		}
+		s.notify(p)
+	}
+	if step != nil && !completed && wf.Status(step.Name) == workflow.StatusCompleted {
+		s.NotifyStepCompleted(wf, step)
+	}
+}
+
+// NotifyStepCompleted notifies the webhooks of the completed step, and of the completed workflow, once no step is left.
+func (s *Service) NotifyStepCompleted(wf *workflow.Workflow, step *workflow.Step) {
+	if len(s.Webhooks) == 0 {
+		return
+	}
+	s.notify(webhookPayload{Event: WebhookStepCompleted, Session: wf.SessionID(), Step: step.Name,
+		Artifacts: wf.Artifacts(step.Name)})
+	if wf.Next() != nil {
+		return
+	}
+	// Steps completing at the same time may both be the last one.
+	s.mu.Lock()
+	if s.completedWorkflows == nil {
+		s.completedWorkflows = make(map[string]bool)
+	}
+	notified := s.completedWorkflows[wf.SessionID()]
+	s.completedWorkflows[wf.SessionID()] = true
+	s.mu.Unlock()
+	if notified {
+		return
+	}
+	var artifacts []string
+	for _, st := range wf.Steps() {
+		artifacts = append(artifacts, wf.Artifacts(st.Name)...)
+	}
+	s.notify(webhookPayload{Event: WebhookWorkflowCompleted, Session: wf.SessionID(), Artifacts: artifacts})
+}
+
+// notify delivers the payload to the webhooks of its event in the background. Clear waits for the deliveries.
+func (s *Service) notify(p webhookPayload) {
+	p.Timestamp = time.Now().UTC()
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Err(err).Msgf("Failed to encode %s webhook", p.Event)
+		return
+	}
+	for _, h := range s.Webhooks {
+		if h.event != "" && h.event != p.Event {
+			continue
+		}
+		s.deliveries.Add(1)
+		go func() {
+			defer s.deliveries.Done()
+			if err := s.deliver(h.url, p.Event, body); err != nil {
+				log.Err(err).Msgf("Failed to deliver %s webhook", p.Event)
+			}
+		}()
+	}
+}
+
+// deliver POSTs the body to the URL, signed with the webhook secret, retrying failures and server errors.
+func (s *Service) deliver(rawURL, event string, body []byte) error {
+	var err error
+	for attempt := range webhookAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		if err = s.post(rawURL, event, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *Service) post(rawURL, event string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-DoubleTab-Event", event)
+	if s.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(s.WebhookSecret))
+		mac.Write(body)
+		req.Header.Set("X-DoubleTab-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Warn().Msgf("Webhook %s rejected %s event with %s", rawURL, event, resp.Status)
+	}
+	return nil
+}
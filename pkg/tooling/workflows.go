@@ -0,0 +1,163 @@
+package tooling
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/openai/openai-go"
+
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// failurePrefixes start the first lines of tool responses reporting failures, which the model has to fix and call the
+// tool again for.
+var failurePrefixes = []string{
+	"Failed ",
+	"Invalid ",
+	"The code still doesn't build",
+	"The generated OpenAPI spec is still invalid",
+	"Server verification failed",
+	"Fuzzing found issues",
+	"Security findings blocking",
+	"Inconsistent spec and schema",
+	"go generate failed",
+	"gosec failed",
+}
+
+// Succeeded reports whether the tool response reports a success, so the workflow step of the tool is completed. Only
+// its first line is checked, as later lines may quote outputs of the tool, like build logs, which aren't failures.
+func Succeeded(resp string) bool {
+	first, _, _ := strings.Cut(resp, "\n")
+	if strings.Contains(first, " rejected") {
+		return false
+	}
+	for _, prefix := range failurePrefixes {
+		if strings.HasPrefix(first, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// WorkflowDefinition returns the steps of building a project of the API style.
+func (s *Service) WorkflowDefinition() workflow.Definition {
+	def := s.apiWorkflowDefinition()
+	if s.PlanFirst {
+		def = withPlan(def)
+	}
+	return def
+}
+
+func (s *Service) apiWorkflowDefinition() workflow.Definition {
+	if s.APIStyle == APIStyleGraphQL {
+		return workflow.Definition{
+			Name: APIStyleGraphQL,
+			Steps: []workflow.Step{
+				{Name: "entities", Description: "Agree with user on the entities and fields."},
+				{Name: "graphql_schema", Description: "Generate a GraphQL schema (SDL).",
+					Tools: []string{GenerateGraphQLSchemaToolName}, Requires: []string{"entities"},
+					Artifacts: []string{"pkg/graph/schema.graphqls"}},
+				{Name: "schema", Description: "Generate PostgreSQL schema for the GraphQL schema.",
+					Tools: []string{GenerateSchemaToolName, StoreSchemaToolName}, Requires: []string{"graphql_schema"},
+					Artifacts: []string{"migrations/*.sql"}, Changed: true},
+				{Name: "resolvers", Description: "Generate Go code implementing resolvers.",
+					Tools: []string{GenerateResolversCodeToolName}, Requires: []string{"graphql_schema", "schema"},
+					Artifacts: []string{"pkg/graph/schema.resolvers.go"}},
+				{Name: "verify", Description: "Run and verify the server. If it fails, fix the code and verify it again.",
+					Tools: []string{RunAndVerifyToolName}, Requires: []string{"resolvers"}},
+				{Name: "security", Description: "Scan the code for security issues. If there are blocking findings, fix the code and scan it again.",
+					Tools: []string{SecurityScanToolName}, Requires: []string{"resolvers"}},
+				{Name: "readme", Description: "Generate README of the project.",
+					Tools: []string{GenerateReadmeToolName}, Requires: []string{"resolvers"}, Artifacts: []string{"README.md"}},
+			},
+		}
+	}
+	return workflow.Definition{
+		Name: APIStyleOpenAPI,
+		Steps: []workflow.Step{
+			{Name: "entities", Description: "Agree with user on the entities and fields."},
+			{Name: "spec", Description: "Generate an OpenAPI 3.0 yaml specification.",
+				Tools: []string{GenerateOpenAPISpecToolName}, Requires: []string{"entities"},
+				Artifacts: []string{"pkg/api/doc/openapi.yaml"}},
+			{Name: "schema", Description: "Generate PostgreSQL schema for the OpenAPI spec.",
+				Tools: []string{GenerateSchemaToolName, StoreSchemaToolName}, Requires: []string{"spec"},
+				Artifacts: []string{"migrations/*.sql"}, Changed: true},
+			{Name: "handlers", Description: "Generate Go code implementing handlers.",
+				Tools: []string{GenerateHandlersCodeToolName}, Requires: []string{"spec"},
+				Artifacts: []string{"pkg/api/handlers.gen.go"}},
+			{Name: "server", Description: "Generate Go code implementing server.",
+				Tools: []string{GenerateServerCodeToolName}, Requires: []string{"handlers", "schema"},
+				Artifacts: []string{"pkg/api/server.go"}},
+			{Name: "verify", Description: "Run and verify the server. If it fails, fix the code and verify it again.",
+				Tools: []string{RunAndVerifyToolName}, Requires: []string{"server"}},
+			{Name: "fuzz", Description: "Fuzz the API. If it finds issues, fix the code and fuzz it again with the same seed.",
+				Tools: []string{FuzzAPIToolName}, Requires: []string{"verify"}},
+			{Name: "security", Description: "Scan the code for security issues. If there are blocking findings, fix the code and scan it again.",
+				Tools: []string{SecurityScanToolName}, Requires: []string{"server"}},
+			{Name: "readme", Description: "Generate README of the project.",
+				Tools: []string{GenerateReadmeToolName}, Requires: []string{"server"}, Artifacts: []string{"README.md"}},
+		},
+	}
+}
+
+// WorkflowTools returns the tools available in every step of the workflow, followed by the tools completing its steps.
+// Custom workflows can use any tool of the built-in workflows, as well as the tools checking the generated code.
+func (s *Service) WorkflowTools(def workflow.Definition) ([]openai.ChatCompletionToolParam, error) {
+	known := make(map[string]openai.ChatCompletionToolParam)
+	for _, tool := range []openai.ChatCompletionToolParam{
+		s.ListTablesTool(),
+		s.AnalyzeProjectTool(),
+		s.GenerateOpenAPISpecTool(),
+		s.GenerateGraphQLSchemaTool(),
+		s.GenerateSchemaTool(),
+		s.StoreSchemaTool(),
+		s.GenerateHandlersCodeTool(),
+		s.GenerateServerCodeTool(),
+		s.GenerateResolversCodeTool(),
+		s.GenerateFileStorageTool(),
+		s.GenerateCacheLayerTool(),
+		s.GenerateEventPublishingTool(),
+		s.GenerateIdempotencyTool(),
+		s.GenerateTerraformTool(),
+		s.GenerateLiveUpdatesTool(),
+		s.CreateAPIVersionTool(),
+		s.BuildCodeTool(),
+		s.RunTestsTool(),
+		s.VetCodeTool(),
+		s.LintCodeTool(),
+		s.RunAndVerifyTool(),
+		s.FuzzAPITool(),
+		s.SecurityScanTool(),
+		s.ReconcileArtifactsTool(),
+		s.RollbackStepTool(),
+		s.VerifyConsistencyTool(),
+		s.RunQueryTool(),
+		s.GenerateERDTool(),
+		s.GenerateReadmeTool(),
+		s.QueryKnowledgeBaseTool(),
+		s.QueryMemoryTool(),
+		s.WritePlanTool(),
+		s.PublishPRTool(),
+	} {
+		known[tool.Function.Value.Name.Value] = tool
+	}
+
+	names := slices.Clone(def.Tools)
+	for _, step := range def.Steps {
+		names = append(names, step.Tools...)
+	}
+	var tools []openai.ChatCompletionToolParam
+	var added []string
+	for _, name := range names {
+		tool, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown tool %s", name)
+		}
+		if !slices.Contains(added, name) {
+			tools = append(tools, tool)
+			added = append(added, name)
+		}
+	}
+	return tools, nil
+}
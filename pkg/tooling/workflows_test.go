@@ -0,0 +1,25 @@
+package tooling
+
+import "testing"
+
+func TestSucceeded(t *testing.T) {
+	tests := []struct {
+		name string
+		resp string
+		want bool
+	}{
+		{"success", "Server code saved successfully", true},
+		{"failure", "Failed to save server.go file: permission denied", false},
+		{"rejection", "Server code rejected, fix the following issues and save it again:\nundefined: x", false},
+		{"failure after the output of the agent",
+			"The code still doesn't build after 3 repair attempts:\nundefined: x\n\nSaved the handlers.", false},
+		{"output quoting failures", "Tests passed:\nFailed requests are retried\nInvalid input returns 400", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Succeeded(tt.resp); got != tt.want {
+				t.Errorf("Succeeded(%q) = %v, want %v", tt.resp, got, tt.want)
+			}
+		})
+	}
+}
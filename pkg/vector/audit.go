@@ -0,0 +1,78 @@
+package vector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// AuditService keeps the audit log of every tool invocation of a project: which tool was called by whom, with what
+// arguments, and how it ended.
+type AuditService struct {
+	V *Service
+	// Project identifies the project entries belong to, by the absolute path of its root.
+	Project string
+}
+
+func NewAudit(ctx context.Context, v *Service, project string) (*AuditService, error) {
+	if _, err := v.DB.ExecContext(ctx, auditSchemaSQL); err != nil {
+		return nil, fmt.Errorf("failed to create audit schema: %w", err)
+	}
+	return &AuditService{
+		V:       v,
+		Project: project,
+	}, nil
+}
+
+// AuditEntry is a tool invocation, as stored in the audit table and written to audit files.
+type AuditEntry struct {
+	SessionID string `db:"session_id" json:"session_id"`
+	// Caller is the agent which called the tool: main for the assistant of the session, or the tool whose agent called
+	// it, e.g. generate_server_code calling save_server_code.
+	Caller    string `db:"caller" json:"caller"`
+	Tool      string `db:"tool" json:"tool"`
+	Arguments string `db:"arguments" json:"arguments"`
+	// Result summarizes the response of the tool by its first line.
+	Result     string    `db:"result" json:"result"`
+	Succeeded  bool      `db:"succeeded" json:"succeeded"`
+	DurationMS int64     `db:"duration_ms" json:"duration_ms"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+// AuditFilter selects audit entries. Empty fields select entries of all sessions or tools.
+type AuditFilter struct {
+	SessionID string
+	Tool      string
+	// Limit is the number of the latest entries selected.
+	Limit int
+}
+
+// Append adds the entry to the audit log of the project.
+func (s *AuditService) Append(ctx context.Context, e AuditEntry) error {
+	args := map[string]interface{}{
+		"project":     s.Project,
+		"session_id":  e.SessionID,
+		"caller":      e.Caller,
+		"tool":        e.Tool,
+		"arguments":   e.Arguments,
+		"result":      e.Result,
+		"succeeded":   e.Succeeded,
+		"duration_ms": e.DurationMS,
+		"created_at":  e.CreatedAt.UTC(),
+	}
+	if _, err := s.V.DB.NamedExecContext(ctx, appendAuditSQL, args); err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return nil
+}
+
+// Query returns the latest entries of the project selected by the filter, in chronological order.
+func (s *AuditService) Query(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	if err := s.V.DB.SelectContext(ctx, &entries, queryAuditSQL, s.Project, filter.SessionID, filter.Tool, filter.Limit); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
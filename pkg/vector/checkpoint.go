@@ -0,0 +1,60 @@
+package vector
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CheckpointService persists the in-flight state of sessions after every turn: the messages of the conversation and
+// the workflow state. A session interrupted by a crash, an OOM kill, or a laptop going to sleep is resumed from its
+// last checkpoint instead of being lost.
+type CheckpointService struct {
+	V *Service
+}
+
+func NewCheckpoints(ctx context.Context, v *Service) (*CheckpointService, error) {
+	if _, err := v.DB.ExecContext(ctx, checkpointSchemaSQL); err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint schema: %w", err)
+	}
+	return &CheckpointService{V: v}, nil
+}
+
+// Checkpoint is the state of a session after its last turn.
+type Checkpoint struct {
+	SessionID string `db:"session_id"`
+	// Messages are the messages of the conversation as JSON, as sent to the model.
+	Messages []byte `db:"messages"`
+	// Workflow is the workflow state of the session as JSON.
+	Workflow  []byte    `db:"workflow"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// Save replaces the checkpoint of the session.
+func (s *CheckpointService) Save(ctx context.Context, c Checkpoint) error {
+	args := map[string]interface{}{
+		"session_id": c.SessionID,
+		"messages":   string(c.Messages),
+		"workflow":   string(c.Workflow),
+		"updated_at": c.UpdatedAt.UTC(),
+	}
+	if _, err := s.V.DB.NamedExecContext(ctx, saveCheckpointSQL, args); err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Load returns the checkpoint of the session, or nil when there is none.
+func (s *CheckpointService) Load(ctx context.Context, sid string) (*Checkpoint, error) {
+	var c Checkpoint
+	err := s.V.DB.GetContext(ctx, &c, loadCheckpointSQL, sid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load checkpoint: %w", err)
+	}
+	return &c, nil
+}
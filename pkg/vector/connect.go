@@ -0,0 +1,94 @@
+package vector
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/jmoiron/sqlx"
+	pgxvec "github.com/pgvector/pgvector-go/pgx"
+)
+
+// Drivers of the PostgreSQL connections.
+const (
+	DriverPQ  = "pq"
+	DriverPGX = "pgx"
+)
+
+// Pool is the connection pool of a database.
+type Pool struct {
+	MaxOpenConns int
+	// MaxIdleConns applies to lib/pq only, pgx pools close connections idle for 30 minutes.
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+// Connect connects to the PostgreSQL database with the driver, lib/pq or pgx with a pgxpool pool. Pools of pgx are
+// used through database/sql as well, so the queries are the same for both drivers. afterConnect, when it's set, runs on
+// every new connection of pgx pools, e.g. to register types, and is ignored by lib/pq.
+func Connect(ctx context.Context, drv, conn string, pool Pool, afterConnect func(context.Context, *pgx.Conn) error) (*sqlx.DB, error) {
+	if drv != DriverPGX {
+		db, err := sqlx.ConnectContext(ctx, "postgres", conn)
+		if err != nil {
+			return nil, err
+		}
+		db.SetMaxOpenConns(pool.MaxOpenConns)
+		db.SetMaxIdleConns(pool.MaxIdleConns)
+		db.SetConnMaxLifetime(pool.ConnMaxLifetime)
+		return db, nil
+	}
+
+	cfg, err := pgxpool.ParseConfig(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	// Unlimited pools of lib/pq are 0, pgxpool doesn't have them.
+	cfg.MaxConns = math.MaxInt32
+	if pool.MaxOpenConns > 0 {
+		cfg.MaxConns = int32(min(pool.MaxOpenConns, math.MaxInt32))
+	}
+	cfg.MaxConnLifetime = time.Duration(math.MaxInt64)
+	if pool.ConnMaxLifetime > 0 {
+		cfg.MaxConnLifetime = pool.ConnMaxLifetime
+	}
+	cfg.AfterConnect = afterConnect
+	p, err := pgxpool.NewWithConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// Idle connections are kept by the pgx pool, so they aren't taken from it for good by database/sql.
+	sqlDB := sql.OpenDB(poolConnector{Connector: stdlib.GetPoolConnector(p), pool: p})
+	sqlDB.SetMaxIdleConns(0)
+	db := sqlx.NewDb(sqlDB, "pgx")
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// poolConnector closes the pgx pool along with the database it's used by.
+type poolConnector struct {
+	driver.Connector
+	pool *pgxpool.Pool
+}
+
+func (c poolConnector) Close() error {
+	c.pool.Close()
+	return nil
+}
+
+// registerVectorTypes registers the pgvector types with new pgx connections, so embeddings are sent in the binary
+// format. The types don't exist before the extension, which is created first.
+func registerVectorTypes(ctx context.Context, conn *pgx.Conn) error {
+	if _, err := conn.Exec(ctx, createVectorExtensionSQL); err != nil {
+		return fmt.Errorf("failed to create vector extension: %w", err)
+	}
+	return pgxvec.RegisterTypes(ctx, conn)
+}
@@ -0,0 +1,68 @@
+package vector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// HistoryService keeps the append-only history of what was generated for a project, in which session and step, and by
+// which models, so teams can audit it. Entries are never changed or removed.
+type HistoryService struct {
+	V *Service
+	// Project identifies the project entries belong to, by the absolute path of its root.
+	Project string
+}
+
+func NewHistory(ctx context.Context, v *Service, project string) (*HistoryService, error) {
+	if _, err := v.DB.ExecContext(ctx, historySchemaSQL); err != nil {
+		return nil, fmt.Errorf("failed to create history schema: %w", err)
+	}
+	return &HistoryService{
+		V:       v,
+		Project: project,
+	}, nil
+}
+
+// Entry is a tool run of a session which generated artifacts.
+type Entry struct {
+	SessionID string `db:"session_id"`
+	// Step is the workflow step the tool completed, empty for tools of no step.
+	Step string `db:"step"`
+	Tool string `db:"tool"`
+	// Artifacts are the files and tables the tool generated, changed, or removed.
+	Artifacts pq.StringArray `db:"artifacts"`
+	ChatModel string         `db:"chat_model"`
+	CodeModel string         `db:"code_model"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+// Append adds the entry to the history of the project.
+func (s *HistoryService) Append(ctx context.Context, e Entry) error {
+	args := map[string]interface{}{
+		"project":    s.Project,
+		"session_id": e.SessionID,
+		"step":       e.Step,
+		"tool":       e.Tool,
+		// Nil arrays are stored as NULL, entries without artifacts get an empty array.
+		"artifacts":  pq.StringArray(append([]string{}, e.Artifacts...)),
+		"chat_model": e.ChatModel,
+		"code_model": e.CodeModel,
+		"created_at": e.CreatedAt.UTC(),
+	}
+	if _, err := s.V.DB.NamedExecContext(ctx, appendHistorySQL, args); err != nil {
+		return fmt.Errorf("failed to append history: %w", err)
+	}
+	return nil
+}
+
+// List returns the history of the project in chronological order.
+func (s *HistoryService) List(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+	if err := s.V.DB.SelectContext(ctx, &entries, listHistorySQL, s.Project); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
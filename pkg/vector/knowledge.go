@@ -3,24 +3,67 @@ package vector
 import (
 	"context"
 	"fmt"
+	"math"
+	"sync"
+	"time"
 
+	"github.com/lib/pq"
 	"github.com/pgvector/pgvector-go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/telemetry"
+)
+
+// knowledgeBatchSize is the number of entries embedded in one request, and knowledgeWorkers the number of batches
+// embedded and stored at once.
+const (
+	knowledgeBatchSize = 16
+	knowledgeWorkers   = 4
 )
 
 type KnowledgeService struct {
 	V *Service
+
+	// cache holds the entries of small knowledge bases loaded by LoadCache, which are searched in memory, without a round
+	// trip to the database per query. It's nil when the knowledge base is searched in the database. Without a database,
+	// it holds the whole knowledge base.
+	cache   []knowledgeEntry
+	cacheMu sync.RWMutex
 }
 
+// knowledgeEntry is a cached entry with the norm of its embedding, computed once for cosine similarities.
+type knowledgeEntry struct {
+	Content   string          `db:"content"`
+	Embedding pgvector.Vector `db:"embedding"`
+	norm      float64
+}
+
+// NewKnowledge creates the knowledge schema. Entries stored before are kept, StoreAll only embeds the missing ones.
+// When the embedding model returns embeddings of other dimensions than the stored ones, the entries are removed, to be
+// embedded again. Without a database, when the DB of the service is nil, the knowledge base is kept and searched in
+// memory only, and embedded again by every process.
 func NewKnowledge(ctx context.Context, v *Service) (*KnowledgeService, error) {
-	_, err := v.DB.ExecContext(ctx, fmt.Sprintf(knowledgeSchemaSQL, v.Dimensions))
+	if err := v.detectDimensions(ctx); err != nil {
+		return nil, err
+	}
+	if v.DB == nil {
+		return &KnowledgeService{V: v, cache: []knowledgeEntry{}}, nil
+	}
+	dims, err := v.columnDimensions(ctx, "knowledge")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create knowledge schema: %w", err)
+		return nil, err
+	}
+	if dims > 0 && dims != v.Dimensions {
+		log.Info().Msgf("Knowledge base has embeddings of %d dimensions, embedding it again with %d", dims, v.Dimensions)
+		if _, err := v.DB.ExecContext(ctx, fmt.Sprintf(resizeKnowledgeSQL, v.Dimensions)); err != nil {
+			return nil, fmt.Errorf("failed to resize knowledge embeddings: %w", err)
+		}
 	}
-	s := &KnowledgeService{V: v}
-	if err := s.Truncate(ctx); err != nil {
-		return nil, fmt.Errorf("failed to truncate knowledge: %w", err)
+	_, err = v.DB.ExecContext(ctx, fmt.Sprintf(knowledgeSchemaSQL, v.Dimensions))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create knowledge schema: %w", err)
 	}
-	return s, nil
+	return &KnowledgeService{V: v}, nil
 }
 
 func (s *KnowledgeService) Store(ctx context.Context, content string) error {
@@ -32,15 +75,169 @@ func (s *KnowledgeService) Store(ctx context.Context, content string) error {
 }
 
 func (s *KnowledgeService) StoreEmbedding(ctx context.Context, content string, embedding []float32) error {
-	_, err := s.V.DB.ExecContext(ctx, storeKnowledgeSQL, content, pgvector.NewVector(embedding))
-	return err
+	if s.V.DB != nil {
+		if _, err := s.V.DB.ExecContext(ctx, storeKnowledgeSQL, content, s.V.Model, pgvector.NewVector(embedding)); err != nil {
+			return err
+		}
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if s.cache != nil {
+		s.cache = append(s.cache, knowledgeEntry{Content: content, Embedding: pgvector.NewVector(embedding), norm: norm(embedding)})
+	}
+	return nil
+}
+
+// StoreAll makes the contents the whole knowledge. Entries stored before with the embedding model are kept, other
+// entries are removed, and the missing contents are embedded in batches, which are embedded and stored concurrently.
+func (s *KnowledgeService) StoreAll(ctx context.Context, contents []string) error {
+	var stored []string
+	if s.V.DB == nil {
+		stored = s.pruneCache(contents)
+	} else {
+		if _, err := s.V.DB.ExecContext(ctx, pruneKnowledgeSQL, s.V.Model, pq.StringArray(contents)); err != nil {
+			return fmt.Errorf("failed to remove outdated knowledge: %w", err)
+		}
+		if err := s.V.DB.SelectContext(ctx, &stored, listKnowledgeSQL, s.V.Model); err != nil {
+			return fmt.Errorf("failed to read knowledge: %w", err)
+		}
+	}
+	present := make(map[string]bool, len(stored))
+	for _, content := range stored {
+		present[content] = true
+	}
+	var missing []string
+	for _, content := range contents {
+		if !present[content] {
+			present[content] = true
+			missing = append(missing, content)
+		}
+	}
+
+	batches := make(chan []string)
+	errs := make(chan error, knowledgeWorkers)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for range knowledgeWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				if err := s.storeBatch(ctx, batch); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	for start := 0; start < len(missing) && ctx.Err() == nil; start += knowledgeBatchSize {
+		select {
+		case batches <- missing[start:min(start+knowledgeBatchSize, len(missing))]:
+		case <-ctx.Done():
+		}
+	}
+	close(batches)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// pruneCache removes the entries of other contents from the knowledge base kept in memory and returns the contents of
+// the remaining ones.
+func (s *KnowledgeService) pruneCache(contents []string) []string {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	wanted := make(map[string]bool, len(contents))
+	for _, content := range contents {
+		wanted[content] = true
+	}
+	kept := s.cache[:0]
+	var stored []string
+	for _, entry := range s.cache {
+		if wanted[entry.Content] {
+			kept = append(kept, entry)
+			stored = append(stored, entry.Content)
+		}
+	}
+	s.cache = kept
+	return stored
+}
+
+// storeBatch embeds the contents in one request and stores them in one transaction, or in memory without a database.
+func (s *KnowledgeService) storeBatch(ctx context.Context, contents []string) error {
+	embeddings, err := s.V.GenerateEmbeddingsBatch(ctx, contents)
+	if err != nil {
+		return fmt.Errorf("failed to embed knowledge: %w", err)
+	}
+	if s.V.DB == nil {
+		s.cacheMu.Lock()
+		defer s.cacheMu.Unlock()
+		for i, content := range contents {
+			s.cache = append(s.cache, knowledgeEntry{Content: content, Embedding: pgvector.NewVector(embeddings[i]), norm: norm(embeddings[i])})
+		}
+		return nil
+	}
+	tx, err := s.V.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for i, content := range contents {
+		if _, err := tx.ExecContext(ctx, storeKnowledgeSQL, content, s.V.Model, pgvector.NewVector(embeddings[i])); err != nil {
+			return fmt.Errorf("failed to store knowledge: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadCache loads the entries into memory when the knowledge base has at most limit entries, so Query searches them
+// in memory. Larger knowledge bases, or a limit of 0, keep being searched in the database. It reports whether the
+// entries were loaded. Without a database, the knowledge base is in memory already, whatever the limit.
+func (s *KnowledgeService) LoadCache(ctx context.Context, limit int) (bool, error) {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	if s.V.DB == nil {
+		return true, nil
+	}
+	s.cache = nil
+	if limit <= 0 {
+		return false, nil
+	}
+	var count int
+	if err := s.V.DB.GetContext(ctx, &count, countKnowledgeSQL); err != nil {
+		return false, fmt.Errorf("failed to count knowledge: %w", err)
+	}
+	if count > limit {
+		return false, nil
+	}
+	var entries []knowledgeEntry
+	if err := s.V.DB.SelectContext(ctx, &entries, loadKnowledgeSQL); err != nil {
+		return false, fmt.Errorf("failed to load knowledge: %w", err)
+	}
+	for i := range entries {
+		entries[i].norm = norm(entries[i].Embedding.Slice())
+	}
+	s.cache = entries
+	return true, nil
 }
 
 func (s *KnowledgeService) Query(ctx context.Context, query string) ([]string, error) {
+	defer func(started time.Time) { telemetry.ObserveRetrieval(telemetry.SourceKnowledge, time.Since(started)) }(time.Now())
+
 	embedding, err := s.V.GenerateEmbeddings(ctx, query)
 	if err != nil {
 		return nil, err
 	}
+	if rows, ok := s.queryCache(embedding); ok {
+		return rows, nil
+	}
 	embs32 := make([]float32, len(embedding))
 	for i, v := range embedding {
 		embs32[i] = float32(v)
@@ -54,7 +251,53 @@ func (s *KnowledgeService) Query(ctx context.Context, query string) ([]string, e
 	return rows, nil
 }
 
+// queryCache returns the cached entry most similar to the embedding by cosine similarity, like the database returns
+// the nearest one. It reports false when the knowledge base isn't cached.
+func (s *KnowledgeService) queryCache(embedding []float32) ([]string, bool) {
+	s.cacheMu.RLock()
+	defer s.cacheMu.RUnlock()
+
+	if s.cache == nil {
+		return nil, false
+	}
+	queryNorm := norm(embedding)
+	best, bestScore := -1, math.Inf(-1)
+	for i, entry := range s.cache {
+		values := entry.Embedding.Slice()
+		if len(values) != len(embedding) || entry.norm == 0 || queryNorm == 0 {
+			continue
+		}
+		var dot float64
+		for j, v := range values {
+			dot += float64(v) * float64(embedding[j])
+		}
+		if score := dot / (entry.norm * queryNorm); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return []string{}, true
+	}
+	return []string{s.cache[best].Content}, true
+}
+
+func norm(values []float32) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum)
+}
+
 func (s *KnowledgeService) Truncate(ctx context.Context) error {
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+
+	if s.V.DB == nil {
+		s.cache = []knowledgeEntry{}
+		return nil
+	}
+	s.cache = nil
 	_, err := s.V.DB.ExecContext(ctx, truncateKnowledgeSQL)
 	return err
 }
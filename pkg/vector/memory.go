@@ -2,12 +2,17 @@ package vector
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"slices"
 	"strings"
 	"time"
 
 	"github.com/pgvector/pgvector-go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/telemetry"
 )
 
 const (
@@ -17,13 +22,48 @@ const (
 	RoleTool      = "tool"
 )
 
+const (
+	// memoryQueueSize is the number of memories queued for the background writer before Store blocks.
+	memoryQueueSize = 256
+	// memoryWriteAttempts is the number of times storing a memory is attempted, waiting memoryRetryDelay times the
+	// attempt in between.
+	memoryWriteAttempts = 3
+	memoryRetryDelay    = time.Second
+	// memoryCloseTimeout bounds storing the queued memories when the service is closed.
+	memoryCloseTimeout = 30 * time.Second
+)
+
+// memoryWrite is a memory queued for the background writer.
+type memoryWrite struct {
+	ctx       context.Context
+	sessionID string
+	role      string
+	content   string
+	createdAt time.Time
+	// flushed is closed once the writer reaches the write, which carries no memory then.
+	flushed chan struct{}
+}
+
 type MemoryService struct {
 	V         *Service
 	SessionID string
 }
 
+// NewMemory creates the memory schema. Memories can't be embedded again, so it fails when the embedding model returns
+// embeddings of other dimensions than the stored ones, rather than failing to store every memory later.
 func NewMemory(ctx context.Context, v *Service, sid string) (*MemoryService, error) {
-	_, err := v.DB.ExecContext(ctx, fmt.Sprintf(memorySchemaSQL, v.Dimensions))
+	if err := v.detectDimensions(ctx); err != nil {
+		return nil, err
+	}
+	dims, err := v.columnDimensions(ctx, "memory")
+	if err != nil {
+		return nil, err
+	}
+	if dims > 0 && dims != v.Dimensions {
+		return nil, fmt.Errorf("memory has embeddings of %d dimensions, but %s returns %d; use the embedding model the memory was stored with, or another DoubleTab database",
+			dims, v.Model, v.Dimensions)
+	}
+	_, err = v.DB.ExecContext(ctx, fmt.Sprintf(memorySchemaSQL, v.Dimensions))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create memory schema: %w", err)
 	}
@@ -33,32 +73,228 @@ func NewMemory(ctx context.Context, v *Service, sid string) (*MemoryService, err
 	}, nil
 }
 
+// Store queues the memory to be embedded and stored in the background, so the conversation doesn't wait for the
+// embeddings API. Failures are retried and logged. Reading the memory waits for the queued memories first. Once the
+// vector service is closed, the memory is stored right away.
 func (s *MemoryService) Store(ctx context.Context, role, content string) error {
-	embedding, err := s.V.GenerateEmbeddings(ctx, content)
+	w := memoryWrite{
+		// Memories are stored even when the request that made them is canceled.
+		ctx:       context.WithoutCancel(ctx),
+		sessionID: s.SessionID,
+		role:      role,
+		content:   content,
+		createdAt: time.Now().UTC(),
+	}
+	s.V.mu.RLock()
+	defer s.V.mu.RUnlock()
+	if s.V.closed {
+		return s.V.storeMemory(w)
+	}
+	s.V.writes <- w
+	return nil
+}
+
+func (s *MemoryService) StoreEmbedding(ctx context.Context, role, content string, embedding []float32) error {
+	w := memoryWrite{sessionID: s.SessionID, role: role, content: content, createdAt: time.Now().UTC()}
+	return s.V.storeMemoryEmbedding(ctx, w, embedding)
+}
+
+// Flush waits until the memories queued before are stored.
+func (s *Service) Flush(ctx context.Context) error {
+	flushed := make(chan struct{})
+	s.mu.RLock()
+	if s.closed {
+		s.mu.RUnlock()
+		return nil
+	}
+	select {
+	case s.writes <- memoryWrite{flushed: flushed}:
+	case <-ctx.Done():
+		s.mu.RUnlock()
+		return ctx.Err()
+	}
+	s.mu.RUnlock()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// writeMemories stores the queued memories in order, until the queue is closed.
+func (s *Service) writeMemories() {
+	defer close(s.writerDone)
+	for w := range s.writes {
+		if w.flushed != nil {
+			close(w.flushed)
+			continue
+		}
+		var err error
+		for attempt := 1; attempt <= memoryWriteAttempts; attempt++ {
+			if err = s.storeMemory(w); err == nil {
+				break
+			}
+			if attempt < memoryWriteAttempts {
+				time.Sleep(time.Duration(attempt) * memoryRetryDelay)
+			}
+		}
+		if err != nil {
+			log.Err(err).Msgf("Failed to store %s memory of session %s", w.role, w.sessionID)
+		}
+	}
+}
+
+// storeMemory embeds and stores the memory. Tool results and system messages repeating a memory of the session only
+// count its occurrences instead, as they don't move the conversation on. Repeated messages of the user and the
+// assistant are stored again, so the conversation restored from memory keeps them in order, with the embedding of the
+// first occurrence.
+func (s *Service) storeMemory(w memoryWrite) error {
+	query, args := repeatMemorySQL, []any{w.sessionID, w.role, contentHash(w.content)}
+	if w.role != RoleTool && w.role != RoleSystem {
+		query, args = copyMemorySQL, append(args, w.createdAt)
+	}
+	res, err := s.DB.ExecContext(w.ctx, query, args...)
 	if err != nil {
 		return err
 	}
-	return s.StoreEmbedding(ctx, role, content, embedding)
+	if repeated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if repeated > 0 {
+		return nil
+	}
+	embedding, err := s.GenerateEmbeddings(w.ctx, w.content)
+	if err != nil {
+		return err
+	}
+	return s.storeMemoryEmbedding(w.ctx, w, embedding)
 }
 
-func (s *MemoryService) StoreEmbedding(ctx context.Context, role, content string, embedding []float32) error {
+func (s *Service) storeMemoryEmbedding(ctx context.Context, w memoryWrite, embedding []float32) error {
 	args := map[string]interface{}{
-		"session_id": s.SessionID,
-		"role":       role,
-		"content":    content,
-		"created_at": time.Now().UTC(),
-		"embedding":  pgvector.NewVector(embedding),
+		"session_id":   w.sessionID,
+		"role":         w.role,
+		"content":      w.content,
+		"content_hash": contentHash(w.content),
+		"occurrences":  1,
+		"created_at":   w.createdAt,
+		"embedding":    pgvector.NewVector(embedding),
 	}
-	_, err := s.V.DB.NamedExecContext(ctx, storeMemorySQL, args)
+	_, err := s.DB.NamedExecContext(ctx, storeMemorySQL, args)
 	return err
 }
 
+// contentHash identifies repeated memories of a session.
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// Branch copies the memory of the session into a new session, which is returned, so both sessions continue from the
+// same memories independently.
+func (s *MemoryService) Branch(ctx context.Context, sid string) (*MemoryService, error) {
+	if err := s.V.Flush(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := s.V.DB.ExecContext(ctx, branchMemorySQL, s.SessionID, sid); err != nil {
+		return nil, fmt.Errorf("failed to copy memory: %w", err)
+	}
+	return &MemoryService{
+		V:         s.V,
+		SessionID: sid,
+	}, nil
+}
+
+// Record is a memory of the session together with its embedding, as exported in session bundles.
+type Record struct {
+	Role      string          `db:"role" json:"role"`
+	Content   string          `db:"content" json:"content"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+	Embedding pgvector.Vector `db:"embedding" json:"embedding"`
+	// Occurrences is the number of times the memory was stored in the session, zero in bundles of older versions.
+	Occurrences int `db:"occurrences" json:"occurrences,omitempty"`
+}
+
+// Export returns all memories of the session in chronological order.
+func (s *MemoryService) Export(ctx context.Context) ([]Record, error) {
+	if err := s.V.Flush(ctx); err != nil {
+		return nil, err
+	}
+	var records []Record
+	if err := s.V.DB.SelectContext(ctx, &records, exportMemorySQL, s.SessionID); err != nil {
+		return nil, fmt.Errorf("failed to read memory: %w", err)
+	}
+	return records, nil
+}
+
+// Import stores the exported memories in the session, which must have no memories yet. Either all memories are stored
+// or none.
+func (s *MemoryService) Import(ctx context.Context, records []Record) error {
+	if err := s.V.Flush(ctx); err != nil {
+		return err
+	}
+	if err := s.V.detectDimensions(ctx); err != nil {
+		return err
+	}
+	var count int
+	if err := s.V.DB.GetContext(ctx, &count, countMemorySQL, s.SessionID); err != nil {
+		return fmt.Errorf("failed to read memory: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("session %s has memories already", s.SessionID)
+	}
+
+	tx, err := s.V.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, r := range records {
+		// Embeddings of other dimensions can't be stored, the embedding model must match.
+		if dims := len(r.Embedding.Slice()); int64(dims) != s.V.Dimensions {
+			return fmt.Errorf("memory has embeddings of %d dimensions, but %s returns %d", dims, s.V.Model, s.V.Dimensions)
+		}
+		args := map[string]interface{}{
+			"session_id":   s.SessionID,
+			"role":         r.Role,
+			"content":      r.Content,
+			"content_hash": contentHash(r.Content),
+			"occurrences":  max(r.Occurrences, 1),
+			"created_at":   r.CreatedAt,
+			"embedding":    r.Embedding,
+		}
+		if _, err := tx.NamedExecContext(ctx, storeMemorySQL, args); err != nil {
+			return fmt.Errorf("failed to store memory: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
 type Memory struct {
 	Role    string `db:"role"`
 	Content string `db:"content"`
 }
 
+// Messages returns the messages of the session in chronological order, to reconstruct its conversation. Messages of
+// the user and the assistant are returned at every occurrence, repeated tool results and system messages once, at their
+// first occurrence.
+func (s *MemoryService) Messages(ctx context.Context) ([]Memory, error) {
+	if err := s.V.Flush(ctx); err != nil {
+		return nil, err
+	}
+	var mem []Memory
+	if err := s.V.DB.SelectContext(ctx, &mem, listMemorySQL, s.SessionID); err != nil {
+		return nil, fmt.Errorf("failed to read memory: %w", err)
+	}
+	return mem, nil
+}
+
 func (s *MemoryService) Query(ctx context.Context, query string) (string, error) {
+	defer func(started time.Time) { telemetry.ObserveRetrieval(telemetry.SourceMemory, time.Since(started)) }(time.Now())
+
+	if err := s.V.Flush(ctx); err != nil {
+		return "", err
+	}
 	embedding, err := s.V.GenerateEmbeddings(ctx, query)
 	if err != nil {
 		return "", err
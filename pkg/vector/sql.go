@@ -1,18 +1,51 @@
 package vector
 
 const (
+	createVectorExtensionSQL = `CREATE EXTENSION IF NOT EXISTS vector`
+
 	knowledgeSchemaSQL = `
 CREATE TABLE IF NOT EXISTS knowledge (
 	id SERIAL PRIMARY KEY,
 	content TEXT NOT NULL,
 	embedding VECTOR(%d) NOT NULL
-)
+);
+ALTER TABLE knowledge ADD COLUMN IF NOT EXISTS model TEXT NOT NULL DEFAULT ''
+`
+	resizeKnowledgeSQL = `
+DELETE FROM knowledge;
+ALTER TABLE knowledge ALTER COLUMN embedding TYPE VECTOR(%d)
 `
 	storeKnowledgeSQL = `
 INSERT INTO knowledge
-	(content, embedding)
+	(content, model, embedding)
 VALUES
-    ($1, $2)
+	($1, $2, $3)
+`
+	listKnowledgeSQL = `
+SELECT
+	content
+FROM knowledge
+WHERE
+	model = $1
+`
+	pruneKnowledgeSQL = `
+DELETE FROM knowledge
+WHERE
+	model <> $1
+	OR NOT (content = ANY($2))
+`
+	countKnowledgeSQL = `
+SELECT
+	COUNT(*)
+FROM knowledge
+`
+	loadKnowledgeSQL = `
+SELECT
+	content,
+	embedding
+FROM knowledge
+ORDER BY
+	id
 `
 	queryKnowledgeSQL = `
 SELECT
@@ -33,13 +66,70 @@ CREATE TABLE IF NOT EXISTS memory (
 	content TEXT NOT NULL,
 	created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL,
 	embedding VECTOR(%d) NOT NULL
-)
+);
+ALTER TABLE memory ADD COLUMN IF NOT EXISTS content_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE memory ADD COLUMN IF NOT EXISTS occurrences INT NOT NULL DEFAULT 1;
+CREATE INDEX IF NOT EXISTS memory_session_id_content_hash_idx ON memory (session_id, content_hash)
 `
 	storeMemorySQL = `
 INSERT INTO memory
-	(session_id, role, content, created_at, embedding)
+	(session_id, role, content, content_hash, occurrences, created_at, embedding)
 VALUES
-	(:session_id, :role, :content, :created_at, :embedding)
+	(:session_id, :role, :content, :content_hash, :occurrences, :created_at, :embedding)
+`
+	repeatMemorySQL = `
+UPDATE memory SET
+	occurrences = occurrences + 1
+WHERE
+	session_id = $1
+	AND role = $2
+	AND content_hash = $3
+`
+	copyMemorySQL = `
+INSERT INTO memory
+	(session_id, role, content, content_hash, occurrences, created_at, embedding)
+SELECT
+	session_id, role, content, content_hash, 1, $4, embedding
+FROM memory
+WHERE
+	session_id = $1
+	AND role = $2
+	AND content_hash = $3
+LIMIT 1
+`
+	branchMemorySQL = `
+INSERT INTO memory
+	(session_id, role, content, content_hash, occurrences, created_at, embedding)
+SELECT
+	$2, role, content, content_hash, occurrences, created_at, embedding
+FROM memory
+WHERE
+	session_id = $1
+`
+	exportMemorySQL = `
+SELECT
+	role, content, occurrences, created_at, embedding
+FROM memory
+WHERE
+	session_id = $1
+ORDER BY
+	created_at, id
+`
+	listMemorySQL = `
+SELECT
+	role, content
+FROM memory
+WHERE
+	session_id = $1
+ORDER BY
+	created_at, id
+`
+	countMemorySQL = `
+SELECT
+	count(*)
+FROM memory
+WHERE
+	session_id = $1
 `
 	queryMemorySQL = `
 SELECT
@@ -51,5 +141,101 @@ ORDER BY
 	created_at DESC,
 	embedding <-> $2
 LIMIT 5
+`
+	historySchemaSQL = `
+CREATE TABLE IF NOT EXISTS history (
+	id SERIAL PRIMARY KEY,
+	project TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	step TEXT NOT NULL,
+	tool TEXT NOT NULL,
+	artifacts TEXT[] NOT NULL,
+	chat_model TEXT NOT NULL,
+	code_model TEXT NOT NULL,
+	created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+)
+`
+	appendHistorySQL = `
+INSERT INTO history
+	(project, session_id, step, tool, artifacts, chat_model, code_model, created_at)
+VALUES
+	(:project, :session_id, :step, :tool, :artifacts, :chat_model, :code_model, :created_at)
+`
+	listHistorySQL = `
+SELECT
+	session_id, step, tool, artifacts, chat_model, code_model, created_at
+FROM history
+WHERE
+	project = $1
+ORDER BY
+	created_at, id
+`
+	auditSchemaSQL = `
+CREATE TABLE IF NOT EXISTS audit (
+	id SERIAL PRIMARY KEY,
+	project TEXT NOT NULL,
+	session_id TEXT NOT NULL,
+	caller TEXT NOT NULL,
+	tool TEXT NOT NULL,
+	arguments TEXT NOT NULL,
+	result TEXT NOT NULL,
+	succeeded BOOLEAN NOT NULL,
+	duration_ms BIGINT NOT NULL,
+	created_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+)
+`
+	appendAuditSQL = `
+INSERT INTO audit
+	(project, session_id, caller, tool, arguments, result, succeeded, duration_ms, created_at)
+VALUES
+	(:project, :session_id, :caller, :tool, :arguments, :result, :succeeded, :duration_ms, :created_at)
+`
+	queryAuditSQL = `
+SELECT
+	session_id, caller, tool, arguments, result, succeeded, duration_ms, created_at
+FROM audit
+WHERE
+	project = $1
+	AND ($2 = '' OR session_id = $2)
+	AND ($3 = '' OR tool = $3)
+ORDER BY
+	created_at DESC, id DESC
+LIMIT $4
+`
+	embeddingDimensionsSQL = `
+SELECT
+	atttypmod
+FROM pg_attribute
+WHERE
+	attrelid = to_regclass($1)
+	AND attname = 'embedding'
+`
+	versionSQL = `
+SELECT version()
+`
+	checkpointSchemaSQL = `
+CREATE TABLE IF NOT EXISTS checkpoints (
+	session_id TEXT PRIMARY KEY,
+	messages JSONB NOT NULL,
+	workflow JSONB NOT NULL,
+	updated_at TIMESTAMP WITHOUT TIME ZONE NOT NULL
+)
+`
+	saveCheckpointSQL = `
+INSERT INTO checkpoints
+	(session_id, messages, workflow, updated_at)
+VALUES
+	(:session_id, :messages, :workflow, :updated_at)
+ON CONFLICT (session_id) DO UPDATE SET
+	messages = EXCLUDED.messages,
+	workflow = EXCLUDED.workflow,
+	updated_at = EXCLUDED.updated_at
+`
+	loadCheckpointSQL = `
+SELECT
+	session_id, messages, workflow, updated_at
+FROM checkpoints
+WHERE
+	session_id = $1
 `
 )
@@ -3,60 +3,130 @@ package vector
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/jmoiron/sqlx"
-	"github.com/openai/openai-go"
-	"github.com/openai/openai-go/shared"
 	"github.com/rs/zerolog/log"
 
 	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
 )
 
 type Service struct {
 	DB         *sqlx.DB
-	OpenAICli  *openai.Client
+	LLM        llm.Provider
 	Model      string
 	Dimensions int64
+
+	// writes are the memories queued for the background writer, which closes writerDone once it stopped. Memories are
+	// stored synchronously once closed is set.
+	writes     chan memoryWrite
+	writerDone chan struct{}
+	closed     bool
+	mu         sync.RWMutex
+
+	// dimensionsOnce detects the dimensions of the embedding model before the first table with embeddings is created.
+	dimensionsOnce sync.Once
+	dimensionsErr  error
 }
 
-func New(ctx context.Context, cfg *config.Config, cli *openai.Client) (*Service, error) {
+func New(ctx context.Context, cfg *config.Config, provider llm.Provider) (*Service, error) {
 	conn := fmt.Sprintf("host='%s' port='%d' dbname='%s' user='%s' password='%s' sslmode='%s'",
 		cfg.DTPGHost, cfg.DTPGPort, cfg.DTPGDatabase, cfg.DTPGUser, cfg.DTPGPassword, cfg.DTPGSSLMode)
 
-	db, err := sqlx.ConnectContext(ctx, "postgres", conn)
+	pool := Pool{MaxOpenConns: cfg.DTPGMaxOpenConns, MaxIdleConns: cfg.DTPGMaxIdleConns, ConnMaxLifetime: cfg.DTPGConnMaxLifetime}
+	db, err := Connect(ctx, cfg.PGDriver, conn, pool, registerVectorTypes)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to connect to doubletab database")
 	}
 
-	_, err = db.Exec("CREATE EXTENSION IF NOT EXISTS vector")
+	_, err = db.Exec(createVectorExtensionSQL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create embeddings table: %w", err)
 	}
 
-	return &Service{
+	s := &Service{
 		DB:         db,
-		OpenAICli:  cli,
+		LLM:        provider,
 		Model:      cfg.LLMEmbeddingModel,
 		Dimensions: cfg.LLMEmbeddingDimensions,
-	}, nil
+		writes:     make(chan memoryWrite, memoryQueueSize),
+		writerDone: make(chan struct{}),
+	}
+	go s.writeMemories()
+	return s, nil
 }
 
+// Close stores the queued memories and closes the database.
 func (s *Service) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.writes)
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.writerDone:
+	case <-time.After(memoryCloseTimeout):
+		log.Error().Msg("Timed out storing queued memories")
+	}
 	s.DB.Close()
 }
 
-func (s *Service) GenerateEmbeddings(ctx context.Context, text string) ([]float32, error) {
-	resp, err := s.OpenAICli.Embeddings.New(ctx, openai.EmbeddingNewParams{
-		Input:          openai.F[openai.EmbeddingNewParamsInputUnion](shared.UnionString(text)),
-		Model:          openai.String(s.Model),
-		EncodingFormat: openai.F(openai.EmbeddingNewParamsEncodingFormatFloat),
+// detectDimensions sets the dimensions of embeddings to those the model returns, embedding a probe text once, so
+// tables are created with the dimensions of the configured model, like the 768 of nomic-embed-text of Ollama, whatever
+// --llm-embedding-dimensions says. When the model can't be reached, the configured dimensions are used, if any.
+func (s *Service) detectDimensions(ctx context.Context) error {
+	s.dimensionsOnce.Do(func() {
+		embedding, err := s.GenerateEmbeddings(ctx, "dimensions")
+		if err != nil {
+			if s.Dimensions <= 0 {
+				s.dimensionsErr = fmt.Errorf("failed to detect embedding dimensions of %s: %w", s.Model, err)
+				return
+			}
+			log.Warn().Err(err).Msgf("Failed to detect embedding dimensions of %s, using the configured %d", s.Model, s.Dimensions)
+			return
+		}
+		if s.Dimensions > 0 && int64(len(embedding)) != s.Dimensions {
+			log.Warn().Msgf("%s returns embeddings of %d dimensions, not the configured %d, using %d",
+				s.Model, len(embedding), s.Dimensions, len(embedding))
+		}
+		s.Dimensions = int64(len(embedding))
 	})
+	return s.dimensionsErr
+}
+
+// columnDimensions returns the dimensions of the embedding column of the table, or 0 when the table doesn't exist.
+func (s *Service) columnDimensions(ctx context.Context, table string) (int64, error) {
+	var dims []int64
+	if err := s.DB.SelectContext(ctx, &dims, embeddingDimensionsSQL, table); err != nil {
+		return 0, fmt.Errorf("failed to read embedding dimensions of %s: %w", table, err)
+	}
+	if len(dims) == 0 {
+		return 0, nil
+	}
+	return dims[0], nil
+}
+
+// Version returns the version of the PostgreSQL server of the DoubleTab database.
+func (s *Service) Version(ctx context.Context) (string, error) {
+	var version string
+	if err := s.DB.GetContext(ctx, &version, versionSQL); err != nil {
+		return "", fmt.Errorf("failed to read PostgreSQL version: %w", err)
+	}
+	return version, nil
+}
+
+func (s *Service) GenerateEmbeddings(ctx context.Context, text string) ([]float32, error) {
+	embeddings, err := s.LLM.Embed(ctx, s.Model, []string{text})
 	if err != nil {
 		return nil, err
 	}
-	embedding := make([]float32, len(resp.Data[0].Embedding))
-	for i, v := range resp.Data[0].Embedding {
-		embedding[i] = float32(v)
-	}
-	return embedding, nil
+	return embeddings[0], nil
+}
+
+// GenerateEmbeddingsBatch embeds the texts in one request, returning their embeddings in the order of the texts.
+func (s *Service) GenerateEmbeddingsBatch(ctx context.Context, texts []string) ([][]float32, error) {
+	return s.LLM.Embed(ctx, s.Model, texts)
 }
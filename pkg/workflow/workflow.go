@@ -0,0 +1,841 @@
+// Package workflow tracks the steps of building a project: which steps are done, what they produced, and which steps
+// may run next. The state is persisted in the project, so it doesn't depend on the model remembering where it is.
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// stateDir is the directory workflow states of sessions are persisted in, relative to the project root.
+	stateDir = ".doubletab/workflows"
+	// maxErrorLength limits the failure reasons kept in the state, as tools report failures with long outputs.
+	maxErrorLength = 500
+)
+
+// Step is a named step of a workflow, completed by calling one of its tools.
+type Step struct {
+	Name string `json:"name" yaml:"name"`
+	// Description tells the model what to do in the step.
+	Description string `json:"description" yaml:"description"`
+	// Tools complete the step. Steps without tools, like agreeing on the entities with the user, are completed once a
+	// step requiring them starts.
+	Tools []string `json:"tools,omitempty" yaml:"tools,omitempty"`
+	// Requires lists steps which must be completed before the step starts.
+	Requires []string `json:"requires,omitempty" yaml:"requires,omitempty"`
+	// Artifacts are glob patterns, relative to the project root, of files which must exist for the step to be completed.
+	Artifacts []string `json:"artifacts,omitempty" yaml:"artifacts,omitempty"`
+	// Changed counts only artifacts created or modified since the step started, for patterns matching files of earlier
+	// runs as well, like migrations, which would complete the step even when it produced nothing.
+	Changed bool `json:"changed,omitempty" yaml:"changed,omitempty"`
+	// Approval makes the step wait for the user to approve its result, once its tool succeeded, before it's completed.
+	Approval bool `json:"approval,omitempty" yaml:"approval,omitempty"`
+}
+
+// Definition is a named sequence of steps.
+type Definition struct {
+	Name string `json:"name" yaml:"name"`
+	// Prompt introduces the workflow to the model, before its steps. The built-in prompt is used when it's empty.
+	Prompt string `json:"prompt,omitempty" yaml:"prompt,omitempty"`
+	// Tools are available in every step, in addition to the tools completing the steps, e.g. querying the knowledge base.
+	Tools []string `json:"tools,omitempty" yaml:"tools,omitempty"`
+	// ChatModel and CodeModel override the models configured for the session, when set.
+	ChatModel string `json:"chat_model,omitempty" yaml:"chat_model,omitempty"`
+	CodeModel string `json:"code_model,omitempty" yaml:"code_model,omitempty"`
+	Steps     []Step `json:"steps" yaml:"steps"`
+}
+
+// LoadDefinition reads a workflow definition from the YAML file.
+func LoadDefinition(name string) (Definition, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return Definition{}, fmt.Errorf("failed to open workflow definition: %w", err)
+	}
+	defer f.Close()
+
+	var def Definition
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(&def); err != nil {
+		return Definition{}, fmt.Errorf("failed to parse workflow definition %s: %w", name, err)
+	}
+	if def.Name == "" {
+		return Definition{}, fmt.Errorf("workflow definition %s has no name", name)
+	}
+	if len(def.Steps) == 0 {
+		return Definition{}, fmt.Errorf("workflow %s has no steps", def.Name)
+	}
+	if err := def.Validate(); err != nil {
+		return Definition{}, fmt.Errorf("invalid workflow %s: %w", def.Name, err)
+	}
+	return def, nil
+}
+
+// Validate checks that step names and tools are unique and that steps require only steps defined before them, so the
+// steps can't depend on each other in cycles.
+func (d Definition) Validate() error {
+	seen := make(map[string]bool)
+	tools := make(map[string]string)
+	for _, step := range d.Steps {
+		if step.Name == "" {
+			return errors.New("step without name")
+		}
+		if seen[step.Name] {
+			return fmt.Errorf("duplicate step %s", step.Name)
+		}
+		if step.Description == "" {
+			return fmt.Errorf("step %s without description", step.Name)
+		}
+		for _, req := range step.Requires {
+			if !seen[req] {
+				return fmt.Errorf("step %s requires %s, which isn't defined before it", step.Name, req)
+			}
+		}
+		for _, tool := range step.Tools {
+			if other, ok := tools[tool]; ok {
+				return fmt.Errorf("tool %s completes both %s and %s steps", tool, other, step.Name)
+			}
+			tools[tool] = step.Name
+		}
+		seen[step.Name] = true
+	}
+	return nil
+}
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusCompleted Status = "completed"
+	StatusFailed    Status = "failed"
+	// StatusAwaitingApproval is the status of a step requiring approval whose tool succeeded.
+	StatusAwaitingApproval Status = "awaiting_approval"
+)
+
+// StepState is the persisted state of a step.
+type StepState struct {
+	Status    Status    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Artifacts are the files matching artifact patterns of the step when it was completed.
+	Artifacts []string `json:"artifacts,omitempty"`
+	// Created are the files which didn't exist before the step ran, so rolling the step back deletes them.
+	Created []string `json:"created,omitempty"`
+	// Error is the reason the step failed.
+	Error string `json:"error,omitempty"`
+}
+
+// State is the persisted state of a workflow run in a session.
+type State struct {
+	SessionID string                `json:"session_id"`
+	Workflow  string                `json:"workflow"`
+	Steps     map[string]*StepState `json:"steps"`
+	// Branches are the sessions branched from the session, which share its project directory and database.
+	Branches []string `json:"branches,omitempty"`
+}
+
+// Workflow is a definition together with the state of its steps in a session.
+type Workflow struct {
+	def     Definition
+	rootDir string
+	path    string
+	state   State
+	// snapshots are the project files existing when running steps started, with their modification times, by step name.
+	snapshots map[string]map[string]time.Time
+
+	mu sync.Mutex
+}
+
+// StateFile returns the path of the file the workflow state of the session is persisted in.
+func StateFile(rootDir, sid string) string {
+	if rootDir == "" {
+		rootDir = "."
+	}
+	return filepath.Join(rootDir, stateDir, sid+".json")
+}
+
+// New returns the workflow of the session in the project, loading its state when it was persisted before.
+func New(def Definition, rootDir, sid string) (*Workflow, error) {
+	if err := def.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid workflow %s: %w", def.Name, err)
+	}
+	if rootDir == "" {
+		rootDir = "."
+	}
+	w := &Workflow{
+		def:     def,
+		rootDir: rootDir,
+		path:    StateFile(rootDir, sid),
+		state:   State{SessionID: sid, Workflow: def.Name, Steps: make(map[string]*StepState)},
+
+		snapshots: make(map[string]map[string]time.Time),
+	}
+
+	content, err := os.ReadFile(w.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read workflow state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(content, &w.state); err != nil {
+			return nil, fmt.Errorf("failed to parse workflow state: %w", err)
+		}
+		if w.state.Workflow != def.Name {
+			return nil, fmt.Errorf("session %s runs %s workflow, not %s", sid, w.state.Workflow, def.Name)
+		}
+	}
+	for _, step := range def.Steps {
+		if w.state.Steps[step.Name] == nil {
+			w.state.Steps[step.Name] = &StepState{Status: StatusPending}
+		}
+	}
+	return w, nil
+}
+
+// Load returns the workflow of the session in the project, failing when no state of the session was persisted.
+func Load(def Definition, rootDir, sid string) (*Workflow, error) {
+	w, err := New(def, rootDir, sid)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(w.path); err != nil {
+		return nil, fmt.Errorf("no workflow state of session %s: %w", sid, err)
+	}
+	return w, nil
+}
+
+// Restore writes the workflow state of the session from its checkpoint, unless the project has its own state of the
+// session, which is never older than the checkpoint. It reports whether the state was restored.
+func Restore(rootDir, sid string, state []byte) (bool, error) {
+	path := StateFile(rootDir, sid)
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	}
+	var st State
+	if err := json.Unmarshal(state, &st); err != nil {
+		return false, fmt.Errorf("failed to parse workflow state: %w", err)
+	}
+	if st.SessionID != sid {
+		return false, fmt.Errorf("workflow state belongs to session %s, not %s", st.SessionID, sid)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return false, fmt.Errorf("failed to create workflow state directory: %w", err)
+	}
+	if err := os.WriteFile(path, state, 0644); err != nil {
+		return false, fmt.Errorf("failed to write workflow state: %w", err)
+	}
+	return true, nil
+}
+
+// Checkpoint returns the workflow state as JSON, for persisting it with the rest of the session.
+func (w *Workflow) Checkpoint() ([]byte, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	content, err := json.Marshal(w.state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode workflow state: %w", err)
+	}
+	return content, nil
+}
+
+// Verify checks that artifacts of the completed steps still exist, e.g. after a crash or after the files were removed
+// by hand. Steps whose artifacts are missing are reset to pending together with the steps depending on them, and
+// their names are returned. Steps left running by a crash are reset to pending as well.
+func (w *Workflow) Verify() ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var reset []string
+	for _, step := range w.def.Steps {
+		state := w.state.Steps[step.Name]
+		if state.Status == StatusRunning {
+			w.setStatus(step.Name, StatusPending, "")
+			continue
+		}
+		if state.Status != StatusCompleted && state.Status != StatusAwaitingApproval {
+			continue
+		}
+		if _, err := w.artifacts(&step, nil); err == nil {
+			continue
+		}
+		for _, name := range append([]string{step.Name}, w.dependents(step.Name)...) {
+			if status := w.state.Steps[name].Status; status == StatusCompleted || status == StatusAwaitingApproval {
+				w.setStatus(name, StatusPending, "")
+				reset = append(reset, name)
+			}
+		}
+	}
+	return reset, w.save()
+}
+
+// Branch returns a copy of the workflow in a new session, persisting its state, so both sessions continue from the same
+// steps. The branch is recorded in the state of the workflow, as both sessions change the same project.
+func (w *Workflow) Branch(sid string) (*Workflow, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	branch := &Workflow{
+		def:     w.def,
+		rootDir: w.rootDir,
+		path:    StateFile(w.rootDir, sid),
+		state:   State{SessionID: sid, Workflow: w.state.Workflow, Steps: make(map[string]*StepState, len(w.state.Steps))},
+
+		snapshots: make(map[string]map[string]time.Time),
+	}
+	for name, state := range w.state.Steps {
+		copied := *state
+		copied.Artifacts = slices.Clone(state.Artifacts)
+		copied.Created = slices.Clone(state.Created)
+		branch.state.Steps[name] = &copied
+	}
+	if err := branch.save(); err != nil {
+		return nil, err
+	}
+	w.state.Branches = append(w.state.Branches, sid)
+	return branch, w.save()
+}
+
+// ChangedBranches returns the sessions branched from the session whose workflow state changed after the session's,
+// so they may have changed the project the session left.
+func (w *Workflow) ChangedBranches() ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	info, err := os.Stat(w.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workflow state: %w", err)
+	}
+	var changed []string
+	for _, sid := range w.state.Branches {
+		branch, err := os.Stat(StateFile(w.rootDir, sid))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read workflow state of branch %s: %w", sid, err)
+		}
+		if err == nil && branch.ModTime().After(info.ModTime()) {
+			changed = append(changed, sid)
+		}
+	}
+	return changed, nil
+}
+
+// Start marks the step completed by the tool as running. It fails when steps the step requires aren't completed yet.
+// Tools of no step, like querying the knowledge base, return a nil step.
+func (w *Workflow) Start(tool string) (*Step, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	step := w.stepOf(tool)
+	if step == nil {
+		return nil, nil
+	}
+	var missing []string
+	for _, req := range step.Requires {
+		reqStep := w.step(req)
+		if len(reqStep.Tools) == 0 && w.requirementsMet(reqStep) {
+			w.setStatus(req, StatusCompleted, "")
+		}
+		if w.state.Steps[req].Status != StatusCompleted {
+			missing = append(missing, fmt.Sprintf("%q", reqStep.Description))
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("step %q can't start before %s completed", step.Description, strings.Join(missing, ", "))
+	}
+	// Steps run concurrently with other steps, and their tools with each other, so only the files existing before the
+	// first of them started count.
+	if w.snapshots[step.Name] == nil {
+		files, err := w.files()
+		if err != nil {
+			return nil, err
+		}
+		w.snapshots[step.Name] = files
+	}
+	w.setStatus(step.Name, StatusRunning, "")
+	return step, w.save()
+}
+
+// Finish records the outcome of the tool completing a step. The step is completed when the tool succeeded and the
+// artifacts of the step exist. Completing a step again, e.g. when the spec is regenerated, resets the steps depending
+// on it, as their results are outdated.
+func (w *Workflow) Finish(tool string, succeeded bool, result string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	step := w.stepOf(tool)
+	if step == nil {
+		return nil
+	}
+	before := w.snapshots[step.Name]
+	if err := w.recordCreated(step); err != nil {
+		return err
+	}
+	if !succeeded {
+		if runes := []rune(result); len(runes) > maxErrorLength {
+			result = string(runes[:maxErrorLength])
+		}
+		w.setStatus(step.Name, StatusFailed, result)
+		return w.save()
+	}
+	artifacts, err := w.artifacts(step, before)
+	if err != nil {
+		w.setStatus(step.Name, StatusFailed, err.Error())
+		return w.save()
+	}
+
+	status := StatusCompleted
+	if step.Approval {
+		status = StatusAwaitingApproval
+	}
+	w.setStatus(step.Name, status, "")
+	w.state.Steps[step.Name].Artifacts = artifacts
+	for _, dependent := range w.dependents(step.Name) {
+		if w.state.Steps[dependent].Status == StatusCompleted {
+			w.setStatus(dependent, StatusPending, "")
+		}
+	}
+	return w.save()
+}
+
+// Schedule orders tool calls of a turn, made at the same time, by the steps they complete. It returns, for every call,
+// the indexes of calls it must wait for: calls completing steps its step requires, directly or through other steps,
+// and calls of the same step or of steps with the same artifacts, which write the same files. Calls wait only for
+// calls of steps defined before their step, or made before them in the same step, so they can't wait for each other.
+// Calls of tools without a step may write any file, like the main.go of the project, so they run one at a time after
+// the calls of steps. A call waiting for a failed call still runs, and is rejected by Start with the step it requires.
+func (w *Workflow) Schedule(tools []string) [][]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	type call struct {
+		step  *Step
+		index int
+	}
+	calls := make([]call, len(tools))
+	for i, tool := range tools {
+		calls[i] = call{step: w.stepOf(tool), index: len(w.def.Steps)}
+		if calls[i].step != nil {
+			calls[i].index = slices.IndexFunc(w.def.Steps, func(s Step) bool { return s.Name == calls[i].step.Name })
+		}
+	}
+	before := func(j, i int) bool {
+		return calls[j].index < calls[i].index || calls[j].index == calls[i].index && j < i
+	}
+
+	waits := make([][]int, len(tools))
+	for i, c := range calls {
+		var required []string
+		if c.step != nil {
+			required = w.required(c.step.Name)
+		}
+		for j, other := range calls {
+			if j == i || !before(j, i) {
+				continue
+			}
+			if c.step == nil || slices.Contains(required, other.step.Name) || other.step.Name == c.step.Name ||
+				w.sharesArtifacts(c.step, other.step) {
+				waits[i] = append(waits[i], j)
+			}
+		}
+	}
+	return waits
+}
+
+// Approve completes the step awaiting approval and returns it. It fails when no step is awaiting approval.
+func (w *Workflow) Approve() (*Step, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, step := range w.def.Steps {
+		state := w.state.Steps[step.Name]
+		if state.Status != StatusAwaitingApproval {
+			continue
+		}
+		artifacts := state.Artifacts
+		w.setStatus(step.Name, StatusCompleted, "")
+		w.state.Steps[step.Name].Artifacts = artifacts
+		return &w.def.Steps[i], w.save()
+	}
+	return nil, errors.New("no step is awaiting approval")
+}
+
+// Created returns the files created by the step.
+func (w *Workflow) Created(name string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if state := w.state.Steps[name]; state != nil {
+		return slices.Clone(state.Created)
+	}
+	return nil
+}
+
+// Artifacts returns the files matching the artifact patterns of the step when it was completed.
+func (w *Workflow) Artifacts(name string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if state := w.state.Steps[name]; state != nil {
+		return slices.Clone(state.Artifacts)
+	}
+	return nil
+}
+
+// SessionID returns the session the workflow runs in.
+func (w *Workflow) SessionID() string {
+	return w.state.SessionID
+}
+
+// Reset marks the step, once rolled back, as pending together with the completed steps depending on it, and forgets
+// the files it created.
+func (w *Workflow) Reset(name string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.step(name) == nil {
+		return fmt.Errorf("unknown step %s", name)
+	}
+	w.setStatus(name, StatusPending, "")
+	w.state.Steps[name].Created = nil
+	for _, dependent := range w.dependents(name) {
+		if w.state.Steps[dependent].Status == StatusCompleted {
+			w.setStatus(dependent, StatusPending, "")
+		}
+	}
+	return w.save()
+}
+
+// Steps returns the steps of the workflow.
+func (w *Workflow) Steps() []Step {
+	return slices.Clone(w.def.Steps)
+}
+
+// Status returns the status of the step.
+func (w *Workflow) Status(name string) Status {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if state := w.state.Steps[name]; state != nil {
+		return state.Status
+	}
+	return ""
+}
+
+// Completed reports whether the step completed by the tool is completed, false for tools of no step.
+func (w *Workflow) Completed(tool string) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	step := w.stepOf(tool)
+	return step != nil && w.state.Steps[step.Name].Status == StatusCompleted
+}
+
+// Next returns the first step which isn't completed, or nil when the workflow is done.
+func (w *Workflow) Next() *Step {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.next()
+}
+
+// Prompt describes the steps with their statuses and the next step, for the model to continue the workflow from.
+func (w *Workflow) Prompt() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var sb strings.Builder
+	for i, step := range w.def.Steps {
+		fmt.Fprintf(&sb, "%d. %s", i+1, step.Description)
+		if state := w.state.Steps[step.Name]; state.Status != StatusPending {
+			fmt.Fprintf(&sb, " [%s]", state.Status)
+		}
+		sb.WriteString("\n")
+	}
+	if next := w.next(); next != nil {
+		i := slices.IndexFunc(w.def.Steps, func(s Step) bool { return s.Name == next.Name }) + 1
+		if w.state.Steps[next.Name].Status == StatusAwaitingApproval {
+			fmt.Fprintf(&sb, "\nStep %d is waiting for the user to approve its result with /approve. Don't use other tools until then.\n", i)
+		} else {
+			fmt.Fprintf(&sb, "\nThe next step is %d. %s\n", i, next.Description)
+		}
+	} else {
+		sb.WriteString("\nAll steps are completed.\n")
+	}
+	return sb.String()
+}
+
+func (w *Workflow) next() *Step {
+	for i, step := range w.def.Steps {
+		if w.state.Steps[step.Name].Status != StatusCompleted {
+			return &w.def.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) step(name string) *Step {
+	for i := range w.def.Steps {
+		if w.def.Steps[i].Name == name {
+			return &w.def.Steps[i]
+		}
+	}
+	return nil
+}
+
+func (w *Workflow) stepOf(tool string) *Step {
+	for i, step := range w.def.Steps {
+		if slices.Contains(step.Tools, tool) {
+			return &w.def.Steps[i]
+		}
+	}
+	return nil
+}
+
+// requirementsMet reports whether the steps the step requires are completed.
+func (w *Workflow) requirementsMet(step *Step) bool {
+	for _, req := range step.Requires {
+		if w.state.Steps[req].Status != StatusCompleted {
+			return false
+		}
+	}
+	return true
+}
+
+// dependents returns the steps requiring the step, directly or through other steps.
+func (w *Workflow) dependents(name string) []string {
+	deps := []string{name}
+	for _, step := range w.def.Steps {
+		for _, req := range step.Requires {
+			if slices.Contains(deps, req) && !slices.Contains(deps, step.Name) {
+				deps = append(deps, step.Name)
+			}
+		}
+	}
+	return deps[1:]
+}
+
+// required returns the steps the step requires, directly or through other steps.
+func (w *Workflow) required(name string) []string {
+	var reqs []string
+	pending := []string{name}
+	for len(pending) > 0 {
+		step := w.step(pending[0])
+		pending = pending[1:]
+		for _, req := range step.Requires {
+			if !slices.Contains(reqs, req) {
+				reqs = append(reqs, req)
+				pending = append(pending, req)
+			}
+		}
+	}
+	return reqs
+}
+
+// sharesArtifacts reports whether artifact patterns of the steps can match the same files: patterns which are equal,
+// match each other, like pkg/api/*.go and pkg/api/server.go, or match the same files of the project.
+func (w *Workflow) sharesArtifacts(a, b *Step) bool {
+	for _, p := range a.Artifacts {
+		for _, q := range b.Artifacts {
+			if p == q || artifactMatch(p, q) || artifactMatch(q, p) {
+				return true
+			}
+			matches, _ := filepath.Glob(filepath.Join(w.rootDir, p))
+			for _, m := range matches {
+				if ok, _ := filepath.Match(filepath.Join(w.rootDir, q), m); ok {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// artifactMatch reports whether the artifact pattern matches the name, which may be a pattern itself.
+func artifactMatch(pattern, name string) bool {
+	ok, err := path.Match(pattern, name)
+	return err == nil && ok
+}
+
+// artifacts returns the files matching the artifact patterns of the step, failing when a pattern matches none. For
+// steps counting only changed artifacts, files of the snapshot taken when the step started count only when they were
+// modified since; without a snapshot, all files count.
+func (w *Workflow) artifacts(step *Step, before map[string]time.Time) ([]string, error) {
+	var files []string
+	for _, pattern := range step.Artifacts {
+		matches, err := filepath.Glob(filepath.Join(w.rootDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifact pattern %s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("step produced no %s", pattern)
+		}
+		var changed []string
+		for _, m := range matches {
+			if step.Changed && before != nil && !w.changedSince(m, before) {
+				continue
+			}
+			if rel, err := filepath.Rel(w.rootDir, m); err == nil {
+				m = rel
+			}
+			changed = append(changed, filepath.ToSlash(m))
+		}
+		if len(changed) == 0 {
+			return nil, fmt.Errorf("step changed no %s", pattern)
+		}
+		files = append(files, changed...)
+	}
+	return files, nil
+}
+
+// changedSince reports whether the file was created or modified since the snapshot was taken.
+func (w *Workflow) changedSince(name string, snapshot map[string]time.Time) bool {
+	rel, err := filepath.Rel(w.rootDir, name)
+	if err != nil {
+		return true
+	}
+	modified, ok := snapshot[filepath.ToSlash(rel)]
+	if !ok {
+		return true
+	}
+	info, err := os.Stat(name)
+	return err != nil || !info.ModTime().Equal(modified)
+}
+
+// recordCreated adds the files created since the step started to the files created by the step. Files matching
+// artifact patterns of other steps, or created by them, are left out. While other steps run at the same time, a new
+// file may be theirs as well, so it's only added when it matches artifact patterns of the step; rolling back should
+// rather leave a file behind than delete a file of another step.
+func (w *Workflow) recordCreated(step *Step) error {
+	before := w.snapshots[step.Name]
+	if before == nil {
+		return nil
+	}
+	delete(w.snapshots, step.Name)
+	after, err := w.files()
+	if err != nil {
+		return err
+	}
+	state := w.state.Steps[step.Name]
+	for name := range after {
+		if _, existed := before[name]; existed || slices.Contains(state.Created, name) || w.otherArtifact(step, name) || w.createdByOther(step, name) {
+			continue
+		}
+		if w.concurrent(name) && !matchesAny(step.Artifacts, name) {
+			continue
+		}
+		state.Created = append(state.Created, name)
+	}
+	slices.Sort(state.Created)
+	return nil
+}
+
+// concurrent reports whether the file was created while another step was running.
+func (w *Workflow) concurrent(name string) bool {
+	for _, snapshot := range w.snapshots {
+		if _, ok := snapshot[name]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// createdByOther reports whether the file was created by any other step.
+func (w *Workflow) createdByOther(step *Step, name string) bool {
+	for other, state := range w.state.Steps {
+		if other != step.Name && slices.Contains(state.Created, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// otherArtifact reports whether the file matches an artifact pattern of any other step.
+func (w *Workflow) otherArtifact(step *Step, name string) bool {
+	for _, other := range w.def.Steps {
+		if other.Name == step.Name {
+			continue
+		}
+		if matchesAny(other.Artifacts, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// files returns the files of the project, relative to the project root, with their modification times. Hidden
+// directories, like .git and the state of workflows itself, are skipped.
+func (w *Workflow) files() (map[string]time.Time, error) {
+	files := make(map[string]time.Time)
+	err := filepath.WalkDir(w.rootDir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if name != w.rootDir && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(w.rootDir, name)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = info.ModTime()
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list project files: %w", err)
+	}
+	return files, nil
+}
+
+func (w *Workflow) setStatus(name string, status Status, reason string) {
+	var created []string
+	if prev := w.state.Steps[name]; prev != nil {
+		created = prev.Created
+	}
+	w.state.Steps[name] = &StepState{Status: status, UpdatedAt: time.Now().UTC(), Error: reason, Created: created}
+}
+
+// save persists the state, replacing the previous state only once the new one is completely written.
+func (w *Workflow) save() error {
+	content, err := json.MarshalIndent(w.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode workflow state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0755); err != nil {
+		return fmt.Errorf("failed to create workflow state directory: %w", err)
+	}
+	tmp := w.path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return fmt.Errorf("failed to write workflow state: %w", err)
+	}
+	if err := os.Rename(tmp, w.path); err != nil {
+		return fmt.Errorf("failed to write workflow state: %w", err)
+	}
+	return nil
+}
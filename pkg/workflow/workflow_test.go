@@ -0,0 +1,72 @@
+package workflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchedule(t *testing.T) {
+	def := Definition{
+		Name: "test",
+		Steps: []Step{
+			{Name: "entities", Description: "Agree on the entities."},
+			{Name: "spec", Description: "Generate the spec.", Tools: []string{"spec"}, Requires: []string{"entities"},
+				Artifacts: []string{"openapi.yaml"}},
+			{Name: "schema", Description: "Store the schema.", Tools: []string{"schema"}, Requires: []string{"spec"},
+				Artifacts: []string{"migrations/*.sql"}},
+			{Name: "handlers", Description: "Generate the handlers.", Tools: []string{"handlers"}, Requires: []string{"spec"},
+				Artifacts: []string{"handlers.go"}},
+			{Name: "server", Description: "Generate the server.", Tools: []string{"server"},
+				Requires: []string{"handlers", "schema"}, Artifacts: []string{"server.go"}},
+			{Name: "tweaks", Description: "Tweak the handlers.", Tools: []string{"tweaks"},
+				Artifacts: []string{"*.go"}},
+		},
+	}
+	tests := []struct {
+		name  string
+		tools []string
+		want  [][]int
+	}{
+		{
+			name:  "independent steps",
+			tools: []string{"schema", "handlers"},
+			want:  [][]int{nil, nil},
+		},
+		{
+			name:  "required step called after its dependent",
+			tools: []string{"server", "handlers", "spec"},
+			want:  [][]int{{1, 2}, {2}, nil},
+		},
+		{
+			name:  "calls of the same step",
+			tools: []string{"schema", "schema"},
+			want:  [][]int{nil, {0}},
+		},
+		{
+			name:  "steps with overlapping artifacts",
+			tools: []string{"tweaks", "handlers", "schema"},
+			want:  [][]int{{1}, nil, nil},
+		},
+		{
+			name:  "step-less calls after all others",
+			tools: []string{"build", "schema", "build", "handlers"},
+			want:  [][]int{{1, 3}, nil, {0, 1, 3}, nil},
+		},
+		{
+			name:  "step-less calls only",
+			tools: []string{"build", "lint", "test"},
+			want:  [][]int{nil, {0}, {0, 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := New(def, t.TempDir(), "session")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.Schedule(tt.tools); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Schedule(%q) = %v, want %v", tt.tools, got, tt.want)
+			}
+		})
+	}
+}
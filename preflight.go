@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+)
+
+// preflightTimeout limits every request of the preflight check, so an unreachable endpoint fails fast.
+const preflightTimeout = 30 * time.Second
+
+// preflightLLM checks that the LLM API is reachable, accepts the API key, and serves the chat, code, and embedding
+// models, so misconfigurations fail at startup rather than on the first turn. Models are looked up in the model list
+// of the endpoint. Models it doesn't list, e.g. aliases of local models, and endpoints without a model list are probed
+// with a request of a single token instead.
+func preflightLLM(ctx context.Context, cfg *config.Config, provider llm.Provider) error {
+	endpoint := llm.BaseURL(cfg.LLMProvider, cfg.LLMBaseURL)
+	ctx = llm.WithoutRetries(ctx)
+	request := func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(ctx, preflightTimeout)
+	}
+
+	listed := make(map[string]bool)
+	reqCtx, cancel := request()
+	models, err := provider.Models(reqCtx)
+	cancel()
+	// Endpoints without a model list are probed only.
+	if err != nil && !errors.Is(err, llm.ErrNoModelList) {
+		return preflightError(endpoint, "", err)
+	}
+	for _, m := range models {
+		listed[m] = true
+		// Local models are listed with their tag, but used without it.
+		listed[strings.TrimSuffix(m, ":latest")] = true
+	}
+
+	for _, model := range []string{cfg.LLMChatModel, cfg.LLMCodeModel} {
+		if listed[model] {
+			continue
+		}
+		reqCtx, cancel := request()
+		_, err := provider.Complete(reqCtx, openai.ChatCompletionNewParams{
+			Model:     openai.String(model),
+			Messages:  openai.F([]openai.ChatCompletionMessageParamUnion{openai.UserMessage("ping")}),
+			MaxTokens: openai.Int(1),
+		})
+		cancel()
+		if err != nil {
+			return preflightError(endpoint, model, err)
+		}
+		listed[model] = true
+	}
+	if !listed[cfg.LLMEmbeddingModel] {
+		reqCtx, cancel := request()
+		_, err := provider.Embed(reqCtx, cfg.LLMEmbeddingModel, []string{"ping"})
+		cancel()
+		if err != nil {
+			return preflightError(endpoint, cfg.LLMEmbeddingModel, err)
+		}
+	}
+	return nil
+}
+
+// preflightError explains the failed request of the preflight check for the model, or for the endpoint when the model
+// is empty.
+func preflightError(endpoint, model string, err error) error {
+	var apiErr *llm.APIError
+	if !errors.As(err, &apiErr) {
+		return fmt.Errorf("LLM API at %s is unreachable, check --llm-base-url: %w", endpoint, err)
+	}
+	switch {
+	case apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("LLM API at %s rejected the API key, check --openai-api-key: %w", endpoint, err)
+	case model != "" && apiErr.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("model %s doesn't exist at %s, check the --llm-*-model flags: %w", model, endpoint, err)
+	case model != "":
+		return fmt.Errorf("model %s at %s failed: %w", model, endpoint, err)
+	default:
+		return fmt.Errorf("LLM API at %s failed: %w", endpoint, err)
+	}
+}
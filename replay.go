@@ -0,0 +1,99 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/vector"
+)
+
+const (
+	// maxReplayToolCalls limits the tool invocations of the audit log replayed with the session.
+	maxReplayToolCalls = 10000
+	// maxReplayPause limits pauses of replays at the original timing, e.g. while the user was away.
+	maxReplayPause = time.Minute
+)
+
+// replayEvent is a message of the transcript of a session, or a tool invocation from its audit log.
+type replayEvent struct {
+	at     time.Time
+	record *vector.Record
+	call   *vector.AuditEntry
+}
+
+// replaySession plays the transcript of the session back in the terminal, with the tools called by the assistant and
+// their agents, at the original timing scaled by --replay-speed, or at once without it.
+func replaySession(ctx context.Context, cfg *config.Config, vs *vector.Service, audit *vector.AuditService, sid string) {
+	mem := &vector.MemoryService{V: vs, SessionID: sid}
+	records, err := mem.Export(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read transcript")
+	}
+	if len(records) == 0 {
+		log.Fatal().Msgf("Session %s has no transcript", sid)
+	}
+	calls, err := audit.Query(ctx, vector.AuditFilter{SessionID: sid, Limit: maxReplayToolCalls})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to read audit log")
+	}
+
+	events := make([]replayEvent, 0, len(records)+len(calls))
+	for i, r := range records {
+		// Tool responses are rendered by their invocations, unless the session predates the audit log.
+		if r.Role == vector.RoleSystem || (r.Role == vector.RoleTool && len(calls) > 0) {
+			continue
+		}
+		events = append(events, replayEvent{at: r.CreatedAt, record: &records[i]})
+	}
+	for i, c := range calls {
+		events = append(events, replayEvent{at: c.CreatedAt, call: &calls[i]})
+	}
+	slices.SortStableFunc(events, func(a, b replayEvent) int { return a.at.Compare(b.at) })
+
+	pterm.DefaultSection.Printfln("Session %s, %s", sid, records[0].CreatedAt.Local().Format(time.DateTime))
+	for i, e := range events {
+		if i > 0 && cfg.ReplaySpeed > 0 {
+			pause := time.Duration(float64(e.at.Sub(events[i-1].at)) / cfg.ReplaySpeed)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(min(pause, maxReplayPause)):
+			}
+		}
+		switch {
+		case e.call != nil:
+			printReplayedCall(e.call)
+		case e.record.Role == vector.RoleUser:
+			pterm.DefaultBasicText.Println(pterm.LightCyan("> ") + e.record.Content)
+		case e.record.Role == vector.RoleAssistant:
+			pterm.DefaultBasicText.Println(pterm.LightMagenta("DoubleTab: ") + e.record.Content)
+		default:
+			pterm.DefaultBasicText.Println(pterm.Gray(truncate(e.record.Content, 200)))
+		}
+	}
+}
+
+// printReplayedCall prints the tool invocation with its arguments, its result, and how long it took. Tools called by
+// agents of other tools are indented under them.
+func printReplayedCall(call *vector.AuditEntry) {
+	indent := ""
+	if call.Caller != tooling.MainCaller {
+		indent = "  "
+	}
+	duration := (time.Duration(call.DurationMS) * time.Millisecond).String()
+	pterm.DefaultBasicText.Printfln("%s%s %s(%s)", indent, pterm.LightBlue("⚙"), call.Tool, truncate(call.Arguments, 120))
+	result := cmp.Or(strings.TrimSpace(call.Result), "done")
+	if call.Succeeded {
+		pterm.DefaultBasicText.Printfln("%s  %s %s %s", indent, pterm.Green("✓"), truncate(result, 160), pterm.Gray(duration))
+	} else {
+		pterm.DefaultBasicText.Printfln("%s  %s %s %s", indent, pterm.Red("✗"), truncate(result, 160), pterm.Gray(duration))
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/pterm/pterm"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/websocket"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/llm"
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/vector"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+// maxMessageBytes limits the body of the messages sent to sessions.
+const maxMessageBytes = 1 << 20
+
+// Types of the events of streamed turns.
+const (
+	eventDelta        = "delta"
+	eventToolStarted  = "tool_started"
+	eventToolFinished = "tool_finished"
+	eventToolProgress = "tool_progress"
+	eventFileChanged  = "file_changed"
+	eventReply        = "reply"
+	eventError        = "error"
+)
+
+// apiServer serves the REST API of the serve command, driving sessions of the session host.
+type apiServer struct {
+	host  *sessionHost
+	token string
+	// addr is the address the API is served at, the only origin of browsers allowed to stream sessions.
+	addr string
+	// ctx is canceled once the server shuts down. Turns run in it rather than in the context of their request, so a
+	// client disconnecting doesn't interrupt a tool halfway.
+	ctx context.Context
+}
+
+// stepView is a step of a session as the API returns it.
+type stepView struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Status      workflow.Status `json:"status"`
+}
+
+// sessionView is a session as the API returns it.
+type sessionView struct {
+	ID    string     `json:"id"`
+	Steps []stepView `json:"steps"`
+}
+
+// toolCallView is a tool call of a turn as the API returns it.
+type toolCallView struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Result    string `json:"result,omitempty"`
+}
+
+// apiEvent is an event of a streamed turn, sent as the data of a server-sent event named after its type, or as a
+// WebSocket message. The fields set depend on the type:
+//   - delta: content, a chunk of the text of the reply as it's generated.
+//   - tool_started and tool_finished: tool_call, the latter with the result of the tool.
+//   - file_changed: file, a file the tools added, changed, or removed, with the diff of the change.
+//   - reply: content, the whole reply, ending the turn.
+//   - error: error, why the turn failed, ending it.
+type apiEvent struct {
+	Type     string              `json:"type"`
+	Content  string              `json:"content,omitempty"`
+	ToolCall *toolCallView       `json:"tool_call,omitempty"`
+	File     *tooling.FileChange `json:"file,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+// artifactView is a file or table generated in a session as the API returns it.
+type artifactView struct {
+	Path      string    `json:"path"`
+	Step      string    `json:"step,omitempty"`
+	Tool      string    `json:"tool"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// serveAPI serves the REST API of the project at the address of the config until interrupted.
+func serveAPI(ctx context.Context, cfg *config.Config, ts *tooling.Service, def workflow.Definition, vs *vector.Service, provider llm.Provider) {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	s := &apiServer{host: newSessionHost(cfg, ts, def, vs, provider), token: cfg.ServeToken, addr: cfg.ServeAddr, ctx: ctx}
+	api := http.NewServeMux()
+	api.HandleFunc("POST /v1/sessions", s.createSession)
+	api.HandleFunc("GET /v1/sessions/{id}", s.getSession)
+	api.HandleFunc("POST /v1/sessions/{id}/messages", s.sendMessage)
+	api.HandleFunc("GET /v1/sessions/{id}/artifacts", s.listArtifacts)
+	api.HandleFunc("GET /v1/sessions/{id}/ws", s.streamSession)
+	mux := http.NewServeMux()
+	mux.Handle("/v1/", s.authenticate(api))
+	// Requests of Slack are verified by their signatures rather than the token.
+	if cfg.SlackBotToken != "" {
+		bot, err := newSlackBot(ctx, cfg, s.host)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to connect Slack app")
+		}
+		mux.HandleFunc("POST /slack/events", bot.handleEvents)
+		mux.HandleFunc("POST /slack/interactions", bot.handleInteractions)
+	}
+	server := &http.Server{Addr: cfg.ServeAddr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		// Turns in progress are interrupted, their sessions are checkpointed.
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Err(err).Msg("Failed to shut down API server")
+		}
+	}()
+	if cfg.ServeToken == "" {
+		log.Warn().Msg("The API is served without authentication to localhost, set --serve-token to authenticate requests")
+	}
+	pterm.Info.Printfln("Serving the DoubleTab API at http://%s/v1", cfg.ServeAddr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal().Err(err).Msgf("Failed to serve API at %s", cfg.ServeAddr)
+	}
+}
+
+// authenticate requires the bearer token of the server in all requests, when it has one. Browsers can't set headers of
+// WebSocket requests, so the token is accepted in the access_token query parameter as well.
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok {
+				token = r.URL.Query().Get("access_token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+				writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *apiServer) createSession(w http.ResponseWriter, r *http.Request) {
+	sess, err := s.host.create(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("failed to create session: %w", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, viewSession(sess))
+}
+
+func (s *apiServer) getSession(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, viewSession(sess))
+}
+
+// sendMessage sends the message to the session and responds with the reply, and the tool calls which led to it. With
+// stream set, the progress of the turn is streamed as server-sent events instead.
+func (s *apiServer) sendMessage(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+	var req struct {
+		Content string `json:"content"`
+		Stream  bool   `json:"stream"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxMessageBytes)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid message: %w", err))
+		return
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		writeError(w, http.StatusBadRequest, errors.New("the message has no content"))
+		return
+	}
+	if sess.busy.Load() {
+		writeError(w, http.StatusConflict, errSessionBusy)
+		return
+	}
+	if req.Stream {
+		s.streamMessage(w, sess, req.Content)
+		return
+	}
+
+	var mu sync.Mutex
+	var calls []toolCallView
+	reply, err := s.host.send(s.ctx, sess, req.Content, turnEvents{
+		toolFinished: func(call openai.ChatCompletionMessageToolCall, resp string) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, viewToolCall(call, resp))
+		},
+	})
+	if err != nil {
+		writeError(w, turnErrorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"reply": reply, "tool_calls": calls})
+}
+
+// streamMessage sends the message to the session, streaming the events of the turn as server-sent events.
+func (s *apiServer) streamMessage(w http.ResponseWriter, sess *hostedSession, message string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming isn't supported by the connection"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Tools run in parallel, events are written one at a time.
+	var mu sync.Mutex
+	s.streamTurn(sess, message, func(e apiEvent) {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			log.Err(err).Msgf("Failed to marshal %s event", e.Type)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		// Writes fail once the client disconnected, the turn goes on regardless.
+		_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, payload)
+		flusher.Flush()
+	})
+}
+
+// streamSession streams turns of the session over a WebSocket. Every message received, e.g. {"content": "..."}, is
+// sent to the session, and the events of its turn are sent back as they happen. Messages received during a turn are
+// sent once it ended.
+func (s *apiServer) streamSession(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+	// Browsers let any page open WebSockets, so the streams of browsers are only served to pages of the API itself.
+	// Other clients send no origin.
+	websocket.Server{Handshake: func(_ *websocket.Config, r *http.Request) error {
+		if origin := r.Header.Get("Origin"); origin != "" && !sameOrigin(origin, s.addr) {
+			return fmt.Errorf("origin %s isn't allowed", origin)
+		}
+		return nil
+	}, Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		var mu sync.Mutex
+		emit := func(e apiEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err := websocket.JSON.Send(ws, e); err != nil {
+				log.Debug().Err(err).Msgf("Failed to send %s event", e.Type)
+			}
+		}
+		for {
+			var req struct {
+				Content string `json:"content"`
+			}
+			if err := websocket.JSON.Receive(ws, &req); err != nil {
+				var syntaxErr *json.SyntaxError
+				if !errors.As(err, &syntaxErr) {
+					// The client closed the connection.
+					return
+				}
+				emit(apiEvent{Type: eventError, Error: fmt.Sprintf("invalid message: %v", err)})
+				continue
+			}
+			if strings.TrimSpace(req.Content) == "" {
+				emit(apiEvent{Type: eventError, Error: "the message has no content"})
+				continue
+			}
+			s.streamTurn(sess, req.Content, emit)
+		}
+	}}.ServeHTTP(w, r)
+}
+
+// sameOrigin reports whether the origin of a browser request is the address the API is served at. Loopback addresses
+// of the same port are the same origin, as the API is reached at localhost as well as 127.0.0.1.
+func sameOrigin(origin, addr string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == addr {
+		return true
+	}
+	_, originPort, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return false
+	}
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && originPort == port && config.LoopbackAddr(u.Host) && config.LoopbackAddr(addr)
+}
+
+// streamTurn sends the message to the session, emitting the events of the turn, the last one being reply or error.
+// Events are emitted concurrently, as tools run in parallel.
+func (s *apiServer) streamTurn(sess *hostedSession, message string, emit func(apiEvent)) {
+	reply, err := s.host.send(s.ctx, sess, message, streamEvents(emit))
+	if err != nil {
+		emit(apiEvent{Type: eventError, Error: err.Error()})
+		return
+	}
+	emit(apiEvent{Type: eventReply, Content: reply})
+}
+
+// streamEvents returns the events of a turn emitting its progress as API events. The reply and failure of the turn
+// are left to the caller.
+func streamEvents(emit func(apiEvent)) turnEvents {
+	return turnEvents{
+		delta: func(text string) {
+			emit(apiEvent{Type: eventDelta, Content: text})
+		},
+		toolStarted: func(call openai.ChatCompletionMessageToolCall) {
+			v := viewToolCall(call, "")
+			emit(apiEvent{Type: eventToolStarted, ToolCall: &v})
+		},
+		toolProgress: func(call openai.ChatCompletionMessageToolCall, message string) {
+			v := viewToolCall(call, "")
+			emit(apiEvent{Type: eventToolProgress, ToolCall: &v, Content: message})
+		},
+		toolFinished: func(call openai.ChatCompletionMessageToolCall, resp string) {
+			v := viewToolCall(call, resp)
+			emit(apiEvent{Type: eventToolFinished, ToolCall: &v})
+		},
+		filesChanged: func(changes []tooling.FileChange) {
+			for _, change := range changes {
+				emit(apiEvent{Type: eventFileChanged, File: &change})
+			}
+		},
+	}
+}
+
+// listArtifacts responds with the files and tables generated in the session, oldest first.
+func (s *apiServer) listArtifacts(w http.ResponseWriter, r *http.Request) {
+	sess := s.lookup(w, r)
+	if sess == nil {
+		return
+	}
+	entries, err := s.host.ts.History.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	artifacts := []artifactView{}
+	for _, e := range entries {
+		if e.SessionID != sess.id {
+			continue
+		}
+		for _, a := range e.Artifacts {
+			artifacts = append(artifacts, artifactView{Path: a, Step: e.Step, Tool: e.Tool, CreatedAt: e.CreatedAt})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"artifacts": artifacts})
+}
+
+// lookup returns the session of the request, or responds with 404 and returns nil when there's none.
+func (s *apiServer) lookup(w http.ResponseWriter, r *http.Request) *hostedSession {
+	sess := s.host.session(r.PathValue("id"))
+	if sess == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("session %s doesn't exist", r.PathValue("id")))
+	}
+	return sess
+}
+
+func viewSession(sess *hostedSession) sessionView {
+	v := sessionView{ID: sess.id, Steps: []stepView{}}
+	for _, step := range sess.wf.Steps() {
+		status := sess.wf.Status(step.Name)
+		if status == "" {
+			status = workflow.StatusPending
+		}
+		v.Steps = append(v.Steps, stepView{Name: step.Name, Description: step.Description, Status: status})
+	}
+	return v
+}
+
+func viewToolCall(call openai.ChatCompletionMessageToolCall, resp string) toolCallView {
+	return toolCallView{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments, Result: resp}
+}
+
+// turnErrorStatus returns the status of the response to a failed turn.
+func turnErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errSessionBusy):
+		return http.StatusConflict
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Err(err).Msg("Failed to write API response")
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
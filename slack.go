@@ -0,0 +1,515 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+
+	"github.com/doubletabai/doubletab/pkg/config"
+	"github.com/doubletabai/doubletab/pkg/tooling"
+	"github.com/doubletabai/doubletab/pkg/workflow"
+)
+
+const (
+	slackAPIURL = "https://slack.com/api"
+	// slackMaxSkew limits the age of requests of Slack, so captured requests can't be replayed.
+	slackMaxSkew = 5 * time.Minute
+	// slackMaxSnippets limits the snippets posted per turn, further changed files are only listed.
+	slackMaxSnippets = 10
+)
+
+// Actions of the buttons of approval gates.
+const (
+	slackActionAllow   = "allow_tool"
+	slackActionDeny    = "deny_tool"
+	slackActionApprove = "approve_step"
+)
+
+// slackBot drives sessions of the session host from Slack. Every thread the bot is mentioned in is a session: the
+// messages of the thread are sent to it, changed files are posted to it as snippets, and tools and steps requiring
+// approval are approved with buttons, by the user who started the thread.
+type slackBot struct {
+	host          *sessionHost
+	token         string
+	signingSecret string
+	// ctx is canceled once the server shuts down, turns run in it.
+	ctx context.Context
+	// userID is the Slack user of the bot, which messages mention.
+	userID string
+
+	mu sync.Mutex
+	// threads are the sessions of the threads, by channel and timestamp of the thread, e.g. C0123/1700000000.000100.
+	threads map[string]*hostedSession
+	// owners are the users who started the threads, who alone approve their tools and steps, by thread.
+	owners map[string]string
+	// approvals are the tool approvals waiting for a button to be clicked, by ID.
+	approvals sync.Map
+	// granted are the tool calls approved in threads, which run once they're called again, by thread and question.
+	granted sync.Map
+}
+
+// slackApproval is a tool approval waiting for a button to be clicked.
+type slackApproval struct {
+	question string
+	channel  string
+	thread   string
+}
+
+// slackEvent is a message event of the Events API.
+type slackEvent struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	BotID    string `json:"bot_id"`
+	Text     string `json:"text"`
+	Channel  string `json:"channel"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+}
+
+// newSlackBot returns the bot of the Slack app of the config, checking its bot token.
+func newSlackBot(ctx context.Context, cfg *config.Config, host *sessionHost) (*slackBot, error) {
+	b := &slackBot{
+		host:          host,
+		token:         cfg.SlackBotToken,
+		signingSecret: cfg.SlackSigningSecret,
+		ctx:           ctx,
+		threads:       make(map[string]*hostedSession),
+		owners:        make(map[string]string),
+	}
+	var auth struct {
+		UserID string `json:"user_id"`
+	}
+	if err := b.call("auth.test", nil, &auth); err != nil {
+		return nil, fmt.Errorf("failed to authenticate Slack app, check --slack-bot-token: %w", err)
+	}
+	b.userID = auth.UserID
+	return b, nil
+}
+
+// handleEvents receives the events of the Events API the app is subscribed to, app_mention and message.
+func (b *slackBot) handleEvents(w http.ResponseWriter, r *http.Request) {
+	body, err := b.verify(w, r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	var payload struct {
+		Type      string     `json:"type"`
+		Challenge string     `json:"challenge"`
+		Event     slackEvent `json:"event"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid event: %w", err))
+		return
+	}
+	switch payload.Type {
+	case "url_verification":
+		writeJSON(w, http.StatusOK, map[string]string{"challenge": payload.Challenge})
+	case "event_callback":
+		w.WriteHeader(http.StatusOK)
+		// Events not acknowledged in 3 seconds are sent again, but turns take longer. They run in the background, and
+		// the events sent again are ignored.
+		if r.Header.Get("X-Slack-Retry-Num") == "" {
+			go b.handleMessage(payload.Event)
+		}
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handleMessage sends the message to the session of its thread. Mentions of the bot start sessions, other messages
+// of threads are only sent to sessions started already.
+func (b *slackBot) handleMessage(e slackEvent) {
+	if e.BotID != "" || e.Subtype != "" || e.User == b.userID {
+		return
+	}
+	mention := "<@" + b.userID + ">"
+	thread := e.ThreadTS
+	if thread == "" {
+		thread = e.TS
+	}
+	key := e.Channel + "/" + thread
+	switch e.Type {
+	case "app_mention":
+	case "message":
+		// Messages mentioning the bot are handled as app_mention events.
+		if e.ThreadTS == "" || strings.Contains(e.Text, mention) || b.thread(key) == nil {
+			return
+		}
+	default:
+		return
+	}
+	text := strings.TrimSpace(strings.ReplaceAll(e.Text, mention, ""))
+	if text == "" {
+		return
+	}
+
+	b.mu.Lock()
+	sess := b.threads[key]
+	if sess == nil {
+		var err error
+		if sess, err = b.host.create(b.ctx); err != nil {
+			b.mu.Unlock()
+			log.Err(err).Msg("Failed to create session of Slack thread")
+			b.post(e.Channel, thread, fmt.Sprintf(":x: Failed to start a session: %v", err), nil)
+			return
+		}
+		b.threads[key] = sess
+		b.owners[key] = e.User
+	}
+	b.mu.Unlock()
+	b.runTurn(sess, e.Channel, thread, text)
+}
+
+// handleInteractions receives the clicks of the buttons of approval gates.
+func (b *slackBot) handleInteractions(w http.ResponseWriter, r *http.Request) {
+	body, err := b.verify(w, r)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid interaction: %w", err))
+		return
+	}
+	var payload struct {
+		Type        string `json:"type"`
+		ResponseURL string `json:"response_url"`
+		User        struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+	}
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid interaction: %w", err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	if payload.Type != "block_actions" {
+		return
+	}
+
+	for _, action := range payload.Actions {
+		switch action.ActionID {
+		case slackActionAllow, slackActionDeny:
+			allowed := action.ActionID == slackActionAllow
+			pending, ok := b.approvals.Load(action.Value)
+			if !ok {
+				b.respond(payload.ResponseURL, "The tool was decided on already.")
+				continue
+			}
+			approval := pending.(*slackApproval)
+			key := approval.channel + "/" + approval.thread
+			if owner := b.owner(key); payload.User.ID != owner {
+				b.respond(payload.ResponseURL, fmt.Sprintf("%s\nOnly <@%s>, who started the thread, can decide on it.", approval.question, owner))
+				continue
+			}
+			if _, ok := b.approvals.LoadAndDelete(action.Value); !ok {
+				continue
+			}
+			sess := b.thread(key)
+			if sess == nil {
+				b.respond(payload.ResponseURL, "The session of the thread is gone, the tool can't be approved.")
+				continue
+			}
+			decision, message := "Denied", "I denied the tool call, don't call it again:\n"+approval.question
+			if allowed {
+				b.granted.Store(key+"\x00"+approval.question, true)
+				decision, message = "Allowed", "I allowed the tool call, call it again with the same arguments:\n"+approval.question
+			}
+			b.respond(payload.ResponseURL, fmt.Sprintf("%s\n%s by <@%s>", approval.question, decision, payload.User.ID))
+			// The model continues with the decision, as if the user told it.
+			go b.runTurn(sess, approval.channel, approval.thread, message)
+		case slackActionApprove:
+			channel, thread, _ := strings.Cut(action.Value, "/")
+			sess := b.thread(action.Value)
+			if sess == nil {
+				b.respond(payload.ResponseURL, "The session of the thread is gone, the step can't be approved.")
+				continue
+			}
+			if owner := b.owner(action.Value); payload.User.ID != owner {
+				b.respond(payload.ResponseURL, fmt.Sprintf("Only <@%s>, who started the thread, can approve the step.", owner))
+				continue
+			}
+			step, err := sess.wf.Approve()
+			if err != nil {
+				b.respond(payload.ResponseURL, fmt.Sprintf("The step can't be approved: %v", err))
+				continue
+			}
+			b.host.ts.NotifyStepCompleted(sess.wf, step)
+			b.respond(payload.ResponseURL, fmt.Sprintf("Step %q approved by <@%s>", step.Description, payload.User.ID))
+			// The model continues with the next step, as if the user told it.
+			go b.runTurn(sess, channel, thread,
+				fmt.Sprintf("I approve the result of step %q, continue with the next step.", step.Description))
+		}
+	}
+}
+
+// runTurn sends the message to the session and posts the files the tools changed, the reply, and the approval gate of
+// the step awaiting approval, if any, to the thread.
+func (b *slackBot) runTurn(sess *hostedSession, channel, thread, message string) {
+	var changes []tooling.FileChange
+	reply, err := b.host.send(b.ctx, sess, message, turnEvents{
+		filesChanged: func(c []tooling.FileChange) {
+			changes = append(changes, c...)
+		},
+		confirmLater: func(question string) bool {
+			return b.confirmLater(channel, thread, question)
+		},
+	})
+	b.postChanges(channel, thread, changes)
+	switch {
+	case errors.Is(err, errSessionBusy):
+		b.post(channel, thread, "I'm still answering the previous message, send it again once I replied.", nil)
+		return
+	case err != nil:
+		b.post(channel, thread, fmt.Sprintf(":x: The turn failed: %v", err), nil)
+		return
+	}
+	b.post(channel, thread, reply, nil)
+
+	if next := sess.wf.Next(); next != nil && sess.wf.Status(next.Name) == workflow.StatusAwaitingApproval {
+		text := fmt.Sprintf("Step %q awaits approval.", next.Description)
+		b.post(channel, thread, text, []any{
+			slackSection(text),
+			map[string]any{"type": "actions", "elements": []any{
+				slackButton("Approve", slackActionApprove, channel+"/"+thread, "primary"),
+			}},
+		})
+	}
+}
+
+// confirmLater reports whether the tool call was allowed in the thread already, which allows it once. Otherwise, it
+// asks the thread to approve it with buttons, without waiting for them to be clicked, so the turns of other sessions
+// don't wait for the user. Clicking a button continues the session with the decision.
+func (b *slackBot) confirmLater(channel, thread, question string) bool {
+	key := channel + "/" + thread
+	if _, ok := b.granted.LoadAndDelete(key + "\x00" + question); ok {
+		return true
+	}
+	id := uuid.NewString()
+	b.approvals.Store(id, &slackApproval{question: question, channel: channel, thread: thread})
+	err := b.post(channel, thread, question, []any{
+		slackSection(question),
+		map[string]any{"type": "actions", "elements": []any{
+			slackButton("Allow", slackActionAllow, id, "primary"),
+			slackButton("Deny", slackActionDeny, id, "danger"),
+		}},
+	})
+	if err != nil {
+		b.approvals.Delete(id)
+	}
+	return false
+}
+
+// postChanges posts the files the tools changed to the thread as snippets: added files with their content, changed
+// files with their diff. Removed files and files beyond slackMaxSnippets are only listed.
+func (b *slackBot) postChanges(channel, thread string, changes []tooling.FileChange) {
+	var listed []string
+	snippets := 0
+	for _, c := range changes {
+		switch {
+		case c.Removed:
+			listed = append(listed, fmt.Sprintf("`%s` (removed)", c.Path))
+		case snippets >= slackMaxSnippets:
+			listed = append(listed, fmt.Sprintf("`%s`", c.Path))
+		case c.Added:
+			content, err := os.ReadFile(filepath.Join(os.Getenv("PROJECT_ROOT"), filepath.FromSlash(c.Path)))
+			if err != nil {
+				log.Err(err).Msgf("Failed to read %s", c.Path)
+				continue
+			}
+			snippets++
+			b.upload(channel, thread, path.Base(c.Path), c.Path, content)
+		default:
+			snippets++
+			b.upload(channel, thread, path.Base(c.Path)+".diff", c.Path, []byte(c.Diff))
+		}
+	}
+	if len(listed) > 0 {
+		b.post(channel, thread, "Also changed: "+strings.Join(listed, ", "), nil)
+	}
+}
+
+// post posts the message to the thread, with the blocks when given. Failures are logged and returned.
+func (b *slackBot) post(channel, thread, text string, blocks []any) error {
+	params := url.Values{"channel": {channel}, "thread_ts": {thread}, "text": {text}}
+	if blocks != nil {
+		encoded, err := json.Marshal(blocks)
+		if err != nil {
+			return err
+		}
+		params.Set("blocks", string(encoded))
+	}
+	err := b.call("chat.postMessage", params, nil)
+	if err != nil {
+		log.Err(err).Msg("Failed to post Slack message")
+	}
+	return err
+}
+
+// upload posts the content to the thread as a snippet with the file name and title.
+func (b *slackBot) upload(channel, thread, name, title string, content []byte) {
+	err := func() error {
+		var upload struct {
+			UploadURL string `json:"upload_url"`
+			FileID    string `json:"file_id"`
+		}
+		params := url.Values{"filename": {name}, "length": {strconv.Itoa(len(content))}}
+		if err := b.call("files.getUploadURLExternal", params, &upload); err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(b.ctx, http.MethodPost, upload.UploadURL, bytes.NewReader(content))
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("upload responded with %s", resp.Status)
+		}
+		files, err := json.Marshal([]map[string]string{{"id": upload.FileID, "title": title}})
+		if err != nil {
+			return err
+		}
+		return b.call("files.completeUploadExternal",
+			url.Values{"files": {string(files)}, "channel_id": {channel}, "thread_ts": {thread}}, nil)
+	}()
+	if err != nil {
+		log.Err(err).Msgf("Failed to upload %s to Slack", title)
+	}
+}
+
+// respond replaces the message of the clicked button with the text.
+func (b *slackBot) respond(responseURL, text string) {
+	body, err := json.Marshal(map[string]any{"replace_original": true, "text": text})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(b.ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		log.Err(err).Msg("Failed to respond to Slack interaction")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Err(err).Msg("Failed to respond to Slack interaction")
+		return
+	}
+	resp.Body.Close()
+}
+
+// call calls the method of the Slack Web API with the form-encoded parameters, decoding the response into the result
+// when given.
+func (b *slackBot) call(method string, params url.Values, result any) error {
+	req, err := http.NewRequestWithContext(b.ctx, http.MethodPost, slackAPIURL+"/"+method, strings.NewReader(params.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("slack %s failed: %w", method, err)
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read Slack response: %w", err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(content, &status); err != nil {
+		return fmt.Errorf("slack %s responded with %s: %s", method, resp.Status, content)
+	}
+	if !status.OK {
+		return fmt.Errorf("slack %s failed: %s", method, status.Error)
+	}
+	if result != nil {
+		if err := json.Unmarshal(content, result); err != nil {
+			return fmt.Errorf("failed to parse Slack response: %w", err)
+		}
+	}
+	return nil
+}
+
+// verify checks the signature of the request of Slack, returning its body.
+func (b *slackBot) verify(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxMessageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
+	}
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("missing request timestamp")
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > slackMaxSkew || age < -slackMaxSkew {
+		return nil, errors.New("request timestamp is too old")
+	}
+	mac := hmac.New(sha256.New, []byte(b.signingSecret))
+	mac.Write([]byte("v0:" + timestamp + ":"))
+	mac.Write(body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(r.Header.Get("X-Slack-Signature"))) {
+		return nil, errors.New("invalid request signature")
+	}
+	return body, nil
+}
+
+// thread returns the session of the thread, or nil when it has none.
+func (b *slackBot) thread(key string) *hostedSession {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.threads[key]
+}
+
+// owner returns the user who started the thread.
+func (b *slackBot) owner(key string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.owners[key]
+}
+
+func slackSection(text string) map[string]any {
+	return map[string]any{"type": "section", "text": map[string]string{"type": "mrkdwn", "text": text}}
+}
+
+func slackButton(text, actionID, value, style string) map[string]any {
+	return map[string]any{
+		"type":      "button",
+		"text":      map[string]string{"type": "plain_text", "text": text},
+		"action_id": actionID,
+		"value":     value,
+		"style":     style,
+	}
+}